@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().String("listen", ":"+types.AgentMetadataPort, "the address to serve agent metadata")
+	serveCmd.Flags().String("ssh-port", "22", "the port users must use to connect on sshd, as seen from outside")
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves agent metadata used by gsh host-connect --discover",
+	Long: `
+ Serves agent metadata over HTTP, letting gsh discover how to connect on this host (as the ssh port)
+ without users informing it manually.
+ 	`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Out = os.Stdout
+
+		listen, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"event":  "reading flag parameter",
+				"topic":  "listen not informed",
+				"key":    "listen",
+				"result": "fail",
+			}).Fatal("Failed to read listen address")
+		}
+		sshPort, err := cmd.Flags().GetString("ssh-port")
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"event":  "reading flag parameter",
+				"topic":  "ssh-port not informed",
+				"key":    "ssh-port",
+				"result": "fail",
+			}).Fatal("Failed to read ssh port")
+		}
+		if p, err := strconv.Atoi(sshPort); err != nil || p < 1 || p > 65535 {
+			log.WithFields(logrus.Fields{
+				"event":    "reading flag parameter",
+				"topic":    "ssh-port is not a valid port",
+				"key":      "ssh-port",
+				"ssh_port": sshPort,
+				"result":   "fail",
+			}).Fatal("Invalid ssh port")
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(types.AgentMetadataPath, metadataHandler(types.AgentMetadata{SSHPort: sshPort}))
+		server := &http.Server{
+			Addr:         listen,
+			Handler:      mux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		log.WithFields(logrus.Fields{
+			"event":    "serve metadata",
+			"listen":   listen,
+			"ssh_port": sshPort,
+		}).Info("Serving agent metadata")
+		if err := server.ListenAndServe(); err != nil {
+			log.WithFields(logrus.Fields{
+				"event":  "serve metadata",
+				"result": "fail",
+				"error":  err.Error(),
+			}).Fatal("Failed to serve agent metadata")
+		}
+	},
+}
+
+// metadataHandler answers GET requests with metadata as JSON
+func metadataHandler(metadata types.AgentMetadata) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(metadata); err != nil {
+			log.WithFields(logrus.Fields{
+				"event":  "serve metadata",
+				"result": "fail",
+				"error":  err.Error(),
+			}).Error("Failed to write agent metadata")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package approvals
+
+import (
+	"errors"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+var (
+	// ErrNotPending is returned when a decision is taken over a request that is not pending
+	ErrNotPending = errors.New("approvals: certificate request is not pending")
+
+	// ErrSelfApproval is returned when the requester tries to decide his own request
+	ErrSelfApproval = errors.New("approvals: requester can't decide his own certificate request")
+
+	// ErrExpired is returned when a decision is taken over an expired request
+	ErrExpired = errors.New("approvals: certificate request is expired")
+)
+
+// RequiresApproval checks if a certificate authorized by approvedRoles must wait for approval.
+// Approval is required only if every role that authorized the request is flagged.
+func RequiresApproval(approvedRoles []string, flaggedRoles []string) bool {
+	if len(approvedRoles) == 0 {
+		return false
+	}
+	for _, role := range approvedRoles {
+		if !contains(flaggedRoles, role) {
+			return false
+		}
+	}
+	return true
+}
+
+// StillAuthorizing returns the roles of approvedRoles (authorizing the request when it was made)
+// that still authorize it, currentRoles being the roles authorizing it under the current policy.
+// A role removed, unassigned or changed while the request waited for approval doesn't count.
+func StillAuthorizing(approvedRoles []string, currentRoles []string) []string {
+	var roles []string
+	for _, role := range approvedRoles {
+		if contains(currentRoles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Expire marks a pending or approved request as expired if its deadline has passed, returning true if it changed
+func Expire(approval *types.CertApproval, now time.Time) bool {
+	if approval.Status != types.ApprovalPending && approval.Status != types.ApprovalApproved {
+		return false
+	}
+	if !now.After(approval.ExpiresAt) {
+		return false
+	}
+	approval.Status = types.ApprovalExpired
+	approval.ModifiedAt = now
+	return true
+}
+
+// Approve marks a pending request as approved by approver
+func Approve(approval *types.CertApproval, approver string, now time.Time) error {
+	return decide(approval, approver, types.ApprovalApproved, now)
+}
+
+// Deny marks a pending request as denied by approver
+func Deny(approval *types.CertApproval, approver string, now time.Time) error {
+	return decide(approval, approver, types.ApprovalDenied, now)
+}
+
+// decide applies a decision (approved or denied) to a pending request
+func decide(approval *types.CertApproval, approver string, status string, now time.Time) error {
+	if Expire(approval, now) {
+		return ErrExpired
+	}
+	if approval.Status != types.ApprovalPending {
+		return ErrNotPending
+	}
+	if approval.Requester == approver {
+		return ErrSelfApproval
+	}
+	approval.Status = status
+	approval.Approver = approver
+	approval.DecidedAt = now
+	approval.ModifiedAt = now
+	return nil
+}
+
+// contains tells whether a contains x.
+func contains(a []string, x string) bool {
+	for _, n := range a {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
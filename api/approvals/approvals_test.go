@@ -0,0 +1,168 @@
+package approvals
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+func newPending(now time.Time) *types.CertApproval {
+	return &types.CertApproval{
+		Status:    types.ApprovalPending,
+		Requester: "alice",
+		ExpiresAt: now.Add(10 * time.Minute),
+	}
+}
+
+func TestStillAuthorizing(t *testing.T) {
+	t.Run(
+		"Roles still authorizing",
+		func(t *testing.T) {
+			roles := StillAuthorizing([]string{"prod-ca", "prod-db"}, []string{"dev", "prod-ca", "prod-db"})
+			if len(roles) != 2 || roles[0] != "prod-ca" || roles[1] != "prod-db" {
+				t.Fatalf("StillAuthorizing: check fail with roles still authorizing (%v)", roles)
+			}
+		})
+	t.Run(
+		"Role removed while pending",
+		func(t *testing.T) {
+			roles := StillAuthorizing([]string{"prod-ca", "prod-db"}, []string{"prod-db"})
+			if len(roles) != 1 || roles[0] != "prod-db" {
+				t.Fatalf("StillAuthorizing: check fail with a removed role (%v)", roles)
+			}
+		})
+	t.Run(
+		"No role authorizes anymore",
+		func(t *testing.T) {
+			if roles := StillAuthorizing([]string{"prod-db"}, []string{"dev"}); len(roles) != 0 {
+				t.Fatalf("StillAuthorizing: check fail without authorizing roles (%v)", roles)
+			}
+		})
+}
+
+func TestRequiresApproval(t *testing.T) {
+	t.Run(
+		"Only flagged roles",
+		func(t *testing.T) {
+			if !RequiresApproval([]string{"prod-db"}, []string{"prod-db", "prod-ca"}) {
+				t.Fatalf("RequiresApproval: check fail with only flagged roles")
+			}
+		})
+	t.Run(
+		"Any non flagged role",
+		func(t *testing.T) {
+			if RequiresApproval([]string{"prod-db", "dev"}, []string{"prod-db"}) {
+				t.Fatalf("RequiresApproval: check fail with a non flagged role")
+			}
+		})
+	t.Run(
+		"Without roles",
+		func(t *testing.T) {
+			if RequiresApproval([]string{}, []string{"prod-db"}) {
+				t.Fatalf("RequiresApproval: check fail without roles")
+			}
+		})
+}
+
+func TestApprove(t *testing.T) {
+	now := time.Now()
+	t.Run(
+		"Approve pending request",
+		func(t *testing.T) {
+			approval := newPending(now)
+			err := Approve(approval, "bob", now)
+			if err != nil {
+				t.Fatalf("Approve: check fail approving pending request (%v)", err)
+			}
+			if approval.Status != types.ApprovalApproved || approval.Approver != "bob" {
+				t.Fatalf("Approve: check fail with status %s approver %s", approval.Status, approval.Approver)
+			}
+		})
+	t.Run(
+		"Self approval",
+		func(t *testing.T) {
+			approval := newPending(now)
+			err := Approve(approval, "alice", now)
+			if err != ErrSelfApproval {
+				t.Fatalf("Approve: check fail with self approval (%v)", err)
+			}
+			if approval.Status != types.ApprovalPending {
+				t.Fatalf("Approve: check fail with self approval status %s", approval.Status)
+			}
+		})
+	t.Run(
+		"Approve denied request",
+		func(t *testing.T) {
+			approval := newPending(now)
+			approval.Status = types.ApprovalDenied
+			err := Approve(approval, "bob", now)
+			if err != ErrNotPending {
+				t.Fatalf("Approve: check fail approving denied request (%v)", err)
+			}
+		})
+}
+
+func TestDeny(t *testing.T) {
+	now := time.Now()
+	t.Run(
+		"Deny pending request",
+		func(t *testing.T) {
+			approval := newPending(now)
+			err := Deny(approval, "bob", now)
+			if err != nil {
+				t.Fatalf("Deny: check fail denying pending request (%v)", err)
+			}
+			if approval.Status != types.ApprovalDenied {
+				t.Fatalf("Deny: check fail with status %s", approval.Status)
+			}
+		})
+	t.Run(
+		"Deny approved request",
+		func(t *testing.T) {
+			approval := newPending(now)
+			approval.Status = types.ApprovalApproved
+			err := Deny(approval, "bob", now)
+			if err != ErrNotPending {
+				t.Fatalf("Deny: check fail denying approved request (%v)", err)
+			}
+		})
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	t.Run(
+		"Pending request before deadline",
+		func(t *testing.T) {
+			approval := newPending(now)
+			if Expire(approval, now) {
+				t.Fatalf("Expire: check fail with request before deadline")
+			}
+		})
+	t.Run(
+		"Pending request after deadline",
+		func(t *testing.T) {
+			approval := newPending(now)
+			if !Expire(approval, now.Add(11*time.Minute)) || approval.Status != types.ApprovalExpired {
+				t.Fatalf("Expire: check fail with request after deadline (%s)", approval.Status)
+			}
+		})
+	t.Run(
+		"Approve after deadline",
+		func(t *testing.T) {
+			approval := newPending(now)
+			err := Approve(approval, "bob", now.Add(11*time.Minute))
+			if err != ErrExpired {
+				t.Fatalf("Expire: check fail approving expired request (%v)", err)
+			}
+		})
+	t.Run(
+		"Denied request after deadline",
+		func(t *testing.T) {
+			approval := newPending(now)
+			approval.Status = types.ApprovalDenied
+			if Expire(approval, now.Add(11*time.Minute)) {
+				t.Fatalf("Expire: check fail with denied request after deadline")
+			}
+		})
+}
@@ -59,10 +59,16 @@ func (ca OpenIDCAuth) Authenticate(c echo.Context, config viper.Viper) (string,
 	if err != nil {
 		return "", fmt.Errorf("OpenID Authenticate: %v", err.Error())
 	}
-	err = ca.verifyExpiry(token)
+	err = ca.verifyTokenUse(jwt, token, config.GetString("token_validation_mode"), config.GetString("oidc_audience"))
 	if err != nil {
 		return "", fmt.Errorf("OpenID Authenticate: %v", err.Error())
 	}
+	err = ca.verifyTimestamps(token, time.Now(), config.GetDuration("token_clock_skew"))
+	if err != nil {
+		return "", fmt.Errorf("OpenID Authenticate: %v", err.Error())
+	}
+	tokenExp, _ := token["exp"].(float64)
+	c.Set("token_expiry", time.Unix(int64(tokenExp), 0))
 
 	err = ca.getSignatureKeys(config)
 	if err != nil {
@@ -92,6 +98,18 @@ func (ca OpenIDCAuth) Authenticate(c echo.Context, config viper.Viper) (string,
 	}
 	c.Set("JTI", jti)
 
+	// Principal claim is optional, the unix account of the identity when it differs from the username
+	if principalClaim := config.GetString("principal_claim"); len(principalClaim) > 0 {
+		if principal, err := ca.getField(token, principalClaim); err == nil {
+			c.Set("principal", principal)
+		}
+	}
+
+	// Groups are optional, used to authorize roles assigned to groups
+	if groupsClaim := config.GetString("oidc_groups_claim"); len(groupsClaim) > 0 {
+		c.Set("groups", ca.getGroups(token, groupsClaim))
+	}
+
 	return username, nil
 }
 
@@ -162,16 +180,24 @@ func (ca OpenIDCAuth) verifySignature(jwt string, config viper.Viper) error {
 	return nil
 }
 
-func (ca OpenIDCAuth) verifyExpiry(token map[string]interface{}) error {
+// verifyTimestamps checks exp, nbf and iat of token against now, tolerating skew between the
+// clocks of this host and the OIDC provider (nbf and iat are optional)
+func (ca OpenIDCAuth) verifyTimestamps(token map[string]interface{}, now time.Time, skew time.Duration) error {
 
 	// get exp value
 	tokenExp, ok := token["exp"].(float64)
 	if !ok {
-		return fmt.Errorf("verifyExpiry: IDToken issued without exp (%v)", token["exp"])
+		return fmt.Errorf("verifyTimestamps: IDToken issued without exp (%v)", token["exp"])
 	}
 
-	if time.Time(time.Unix(int64(tokenExp), 0)).Before(time.Now()) {
-		return fmt.Errorf("verifyExpiry: Token is expired (%v)", tokenExp)
+	if time.Unix(int64(tokenExp), 0).Add(skew).Before(now) {
+		return fmt.Errorf("verifyTimestamps: Token is expired (%v)", tokenExp)
+	}
+	if tokenNbf, ok := token["nbf"].(float64); ok && time.Unix(int64(tokenNbf), 0).After(now.Add(skew)) {
+		return fmt.Errorf("verifyTimestamps: Token is not valid yet (%v)", tokenNbf)
+	}
+	if tokenIat, ok := token["iat"].(float64); ok && time.Unix(int64(tokenIat), 0).After(now.Add(skew)) {
+		return fmt.Errorf("verifyTimestamps: Token used before issued (%v)", tokenIat)
 	}
 	return nil
 }
@@ -231,6 +257,38 @@ func (ca OpenIDCAuth) verifyAuthorizedParty(token map[string]interface{}, azp st
 	return nil
 }
 
+// verifyTokenUse checks token, the claims of jwt, is the kind validated by token_validation_mode. Mode
+// access accepts JWT access tokens (and ID tokens, sent by clients before the mode existed); mode id
+// accepts only ID tokens of clientID (oidc_audience): access tokens typed at+jwt (RFC 9068) or by
+// IdP typ are refused, and the authorized party (azp), required with many audiences, must be clientID.
+func (ca OpenIDCAuth) verifyTokenUse(jwt string, token map[string]interface{}, mode string, clientID string) error {
+	if mode != "id" {
+		return nil
+	}
+
+	header, err := ca.parseHeader(jwt)
+	if err != nil {
+		return err
+	}
+	if headerTyp, ok := header["typ"].(string); ok {
+		if typ := strings.ToLower(headerTyp); typ == "at+jwt" || typ == "application/at+jwt" {
+			return fmt.Errorf("verifyTokenUse: ID token expected, got access token (typ %s)", headerTyp)
+		}
+	}
+	// typ is not standard, but IdPs as Keycloak use it to tell ID tokens from access tokens
+	if tokenTyp, ok := token["typ"].(string); ok && tokenTyp != "ID" {
+		return fmt.Errorf("verifyTokenUse: ID token expected, got token of type %s", tokenTyp)
+	}
+	tokenAzp, _ := token["azp"].(string)
+	if tokenAudiences, ok := token["aud"].([]interface{}); ok && len(tokenAudiences) > 1 && len(tokenAzp) == 0 {
+		return errors.New("verifyTokenUse: ID token issued to many audiences without azp")
+	}
+	if len(tokenAzp) > 0 && tokenAzp != clientID {
+		return fmt.Errorf("verifyTokenUse: ID token expected, got token of authorized party %s", tokenAzp)
+	}
+	return nil
+}
+
 func (ca OpenIDCAuth) verifyIssuer(token map[string]interface{}, issuer string) error {
 
 	tokenIss, ok := token["iss"].(string)
@@ -244,6 +302,20 @@ func (ca OpenIDCAuth) verifyIssuer(token map[string]interface{}, issuer string)
 	return nil
 }
 
+// parseHeader returns the JOSE header of jwt
+func (ca OpenIDCAuth) parseHeader(jwt string) (map[string]interface{}, error) {
+	var header map[string]interface{}
+	parts := strings.Split(strings.TrimSpace(jwt), ".")
+	parsedHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return header, fmt.Errorf("parseHeader: Malformed JWT header (%v)", err)
+	}
+	if err := json.Unmarshal(parsedHeader, &header); err != nil {
+		return header, fmt.Errorf("parseHeader: Failed to unmarshal header (%v)", err)
+	}
+	return header, nil
+}
+
 func (ca OpenIDCAuth) parseIDToken(jwt string) (map[string]interface{}, error) {
 	var token map[string]interface{}
 	parts := strings.Split(jwt, ".")
@@ -272,3 +344,21 @@ func (ca OpenIDCAuth) getField(token map[string]interface{}, field string) (stri
 
 	return tokenField, nil
 }
+
+// getGroups returns the groups at claim field of a token, which can be a list or a single string
+func (ca OpenIDCAuth) getGroups(token map[string]interface{}, field string) []string {
+	var groups []string
+	switch tokenGroups := token[field].(type) {
+	case []interface{}:
+		for _, group := range tokenGroups {
+			if groupString, ok := group.(string); ok && len(groupString) > 0 {
+				groups = append(groups, groupString)
+			}
+		}
+	case string:
+		if len(tokenGroups) > 0 {
+			groups = append(groups, tokenGroups)
+		}
+	}
+	return groups
+}
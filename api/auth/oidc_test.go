@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/spf13/viper"
@@ -306,4 +308,215 @@ func TestAuthenticate(t *testing.T) {
 				t.Fatalf("OIDC: invalid JTI at JWT with JTI (%v)", jti)
 			}
 		})
+	t.Run(
+		"JWT valid at ID token validation mode",
+		func(t *testing.T) {
+			ca := OpenIDCAuth{}
+			// same JWT, signed ID token of audience gsh, request and keys set by the previous test
+			config.Set("token_validation_mode", "id")
+			defer config.Set("token_validation_mode", "access")
+
+			if _, err := ca.Authenticate(ctx, *config); err != nil {
+				t.Fatalf("OIDC: check fail with JWT valid at id mode (%v)", err)
+			}
+			config.Set("oidc_audience", "gsh-dev")
+			defer config.Set("oidc_audience", "gsh")
+			if _, err := ca.Authenticate(ctx, *config); err == nil {
+				t.Fatalf("OIDC: check fail with ID token of another audience at id mode")
+			}
+		})
+	t.Run(
+		"Principal claim distinct from username claim",
+		func(t *testing.T) {
+			ca := OpenIDCAuth{}
+			// same JWT of the previous tests, with email and jti claims
+			config.Set("principal_claim", "unix_username")
+			defer config.Set("principal_claim", "")
+			if _, err := ca.Authenticate(ctx, *config); err != nil || ctx.Get("principal") != nil {
+				t.Fatalf("OIDC: check fail with principal claim missing at JWT (%v, %v)", err, ctx.Get("principal"))
+			}
+
+			config.Set("principal_claim", "jti")
+			username, err := ca.Authenticate(ctx, *config)
+			if err != nil || username != "gsh@accounts.example.org" || ctx.Get("principal") != "jti-value" {
+				t.Fatalf("OIDC: check fail with principal claim (%v, %s, %v)", err, username, ctx.Get("principal"))
+			}
+		})
+}
+
+func TestVerifyTokenUse(t *testing.T) {
+	ca := OpenIDCAuth{}
+	// jwtWithHeader returns a JWT of header, claims are passed apart as parsed by Authenticate
+	jwtWithHeader := func(header string) string {
+		return " " + base64.RawURLEncoding.EncodeToString([]byte(header)) + ".e30.signature"
+	}
+	jwt := jwtWithHeader(`{"alg":"RS256","typ":"JWT"}`)
+	idToken := map[string]interface{}{"typ": "ID", "aud": []interface{}{"gsh"}, "azp": "gsh"}
+	accessToken := map[string]interface{}{"typ": "Bearer", "aud": []interface{}{"gsh", "account"}, "azp": "gsh"}
+	t.Run(
+		"Access mode",
+		func(t *testing.T) {
+			if err := ca.verifyTokenUse(jwt, accessToken, "access", "gsh"); err != nil {
+				t.Fatalf("verifyTokenUse: check fail with access token at access mode (%v)", err)
+			}
+			// ID tokens were sent by clients before modes existed
+			if err := ca.verifyTokenUse(jwt, idToken, "access", "gsh"); err != nil {
+				t.Fatalf("verifyTokenUse: check fail with ID token at access mode (%v)", err)
+			}
+		})
+	t.Run(
+		"ID mode",
+		func(t *testing.T) {
+			if err := ca.verifyTokenUse(jwt, idToken, "id", "gsh"); err != nil {
+				t.Fatalf("verifyTokenUse: check fail with ID token at id mode (%v)", err)
+			}
+			if err := ca.verifyTokenUse(jwt, map[string]interface{}{"aud": "gsh"}, "id", "gsh"); err != nil {
+				t.Fatalf("verifyTokenUse: check fail with ID token without typ at id mode (%v)", err)
+			}
+			if err := ca.verifyTokenUse(jwt, accessToken, "id", "gsh"); err == nil {
+				t.Fatalf("verifyTokenUse: check fail with access token at id mode")
+			}
+			if err := ca.verifyTokenUse(jwt, map[string]interface{}{"aud": []interface{}{"gsh", "account"}}, "id", "gsh"); err == nil {
+				t.Fatalf("verifyTokenUse: check fail with ID token of many audiences without azp")
+			}
+		})
+	t.Run(
+		"Access token refused at ID mode",
+		func(t *testing.T) {
+			// RFC 9068 access tokens are typed at the JOSE header, their claims have no typ
+			claims := map[string]interface{}{"aud": "gsh", "client_id": "gsh", "scope": "openid"}
+			for _, header := range []string{`{"alg":"RS256","typ":"at+jwt"}`, `{"alg":"RS256","typ":"application/at+JWT"}`} {
+				if err := ca.verifyTokenUse(jwtWithHeader(header), claims, "id", "gsh"); err == nil {
+					t.Fatalf("verifyTokenUse: check fail with access token typed %s at id mode", header)
+				}
+			}
+			// access tokens requested by another client name it as authorized party
+			if err := ca.verifyTokenUse(jwt, map[string]interface{}{"aud": "gsh", "azp": "gsh-web"}, "id", "gsh"); err == nil {
+				t.Fatalf("verifyTokenUse: check fail with token of another authorized party at id mode")
+			}
+			if err := ca.verifyTokenUse(jwtWithHeader("not json"), idToken, "id", "gsh"); err == nil {
+				t.Fatalf("verifyTokenUse: check fail with malformed header at id mode")
+			}
+		})
+}
+
+func TestGetGroups(t *testing.T) {
+	ca := OpenIDCAuth{}
+	t.Run(
+		"Groups list",
+		func(t *testing.T) {
+			token := map[string]interface{}{"groups": []interface{}{"ops", "dba", 1, ""}}
+			groups := ca.getGroups(token, "groups")
+			if len(groups) != 2 || groups[0] != "ops" || groups[1] != "dba" {
+				t.Fatalf("OIDC: check fail with groups list (%v)", groups)
+			}
+		})
+	t.Run(
+		"Single group",
+		func(t *testing.T) {
+			token := map[string]interface{}{"groups": "ops"}
+			groups := ca.getGroups(token, "groups")
+			if len(groups) != 1 || groups[0] != "ops" {
+				t.Fatalf("OIDC: check fail with single group (%v)", groups)
+			}
+		})
+	t.Run(
+		"Without groups",
+		func(t *testing.T) {
+			groups := ca.getGroups(map[string]interface{}{}, "groups")
+			if len(groups) != 0 {
+				t.Fatalf("OIDC: check fail without groups (%v)", groups)
+			}
+		})
+}
+
+func TestVerifyTimestamps(t *testing.T) {
+	ca := OpenIDCAuth{}
+	now := time.Unix(1500000000, 0)
+	skew := 60 * time.Second
+	unix := func(d time.Duration) float64 { return float64(now.Add(d).Unix()) }
+
+	t.Run(
+		"Valid token",
+		func(t *testing.T) {
+			token := map[string]interface{}{"exp": unix(time.Hour), "iat": unix(-time.Minute), "nbf": unix(-time.Minute)}
+			if err := ca.verifyTimestamps(token, now, skew); err != nil {
+				t.Fatalf("verifyTimestamps: check fail with valid token (%v)", err)
+			}
+		})
+	t.Run(
+		"Without exp",
+		func(t *testing.T) {
+			if err := ca.verifyTimestamps(map[string]interface{}{}, now, skew); err == nil {
+				t.Fatalf("verifyTimestamps: check fail with token without exp")
+			}
+		})
+	t.Run(
+		"Expired inside skew",
+		func(t *testing.T) {
+			token := map[string]interface{}{"exp": unix(-59 * time.Second)}
+			if err := ca.verifyTimestamps(token, now, skew); err != nil {
+				t.Fatalf("verifyTimestamps: check fail with token expired inside skew (%v)", err)
+			}
+		})
+	t.Run(
+		"Expired outside skew",
+		func(t *testing.T) {
+			token := map[string]interface{}{"exp": unix(-61 * time.Second)}
+			if err := ca.verifyTimestamps(token, now, skew); err == nil {
+				t.Fatalf("verifyTimestamps: check fail with token expired outside skew")
+			}
+		})
+	t.Run(
+		"Issued in the future inside skew",
+		func(t *testing.T) {
+			token := map[string]interface{}{"exp": unix(time.Hour), "iat": unix(59 * time.Second), "nbf": unix(59 * time.Second)}
+			if err := ca.verifyTimestamps(token, now, skew); err != nil {
+				t.Fatalf("verifyTimestamps: check fail with token issued inside skew (%v)", err)
+			}
+		})
+	t.Run(
+		"Used before issued outside skew",
+		func(t *testing.T) {
+			token := map[string]interface{}{"exp": unix(time.Hour), "iat": unix(61 * time.Second)}
+			if err := ca.verifyTimestamps(token, now, skew); err == nil {
+				t.Fatalf("verifyTimestamps: check fail with token issued outside skew")
+			}
+		})
+	t.Run(
+		"Not valid yet outside skew",
+		func(t *testing.T) {
+			token := map[string]interface{}{"exp": unix(time.Hour), "nbf": unix(61 * time.Second)}
+			if err := ca.verifyTimestamps(token, now, skew); err == nil {
+				t.Fatalf("verifyTimestamps: check fail with token not valid yet outside skew")
+			}
+		})
+	t.Run(
+		"Without skew",
+		func(t *testing.T) {
+			token := map[string]interface{}{"exp": unix(-time.Second)}
+			if err := ca.verifyTimestamps(token, now, 0); err == nil {
+				t.Fatalf("verifyTimestamps: check fail with expired token without skew")
+			}
+		})
+}
+
+func TestVerifyAudience(t *testing.T) {
+	ca := OpenIDCAuth{}
+	// token requested by a target with its own audience, among audiences added by the IdP
+	token := map[string]interface{}{"aud": []interface{}{"gsh-prod", "account"}}
+	t.Run(
+		"Audience of this GSH API",
+		func(t *testing.T) {
+			if err := ca.verifyAudience(token, "gsh-prod"); err != nil {
+				t.Fatalf("verifyAudience: check fail with target audience (%v)", err)
+			}
+		})
+	t.Run(
+		"Audience of another GSH API",
+		func(t *testing.T) {
+			if err := ca.verifyAudience(token, "gsh-dev"); err == nil {
+				t.Fatalf("verifyAudience: check fail with audience of another target")
+			}
+		})
 }
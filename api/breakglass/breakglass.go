@@ -0,0 +1,97 @@
+package breakglass
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrReasonRequired is returned when a break-glass request has no reason
+	ErrReasonRequired = errors.New("breakglass: reason is required to use break-glass roles")
+
+	// ErrWebhookNotSet is returned when there is no webhook to alert about break-glass requests
+	ErrWebhookNotSet = errors.New("breakglass: webhook (breakglass_webhook_url) not set")
+)
+
+// Alert is the message sent to security when a certificate is requested using break-glass roles
+type Alert struct {
+	Event      string    `json:"event"`
+	Requester  string    `json:"requester"`
+	Roles      []string  `json:"roles"`
+	RemoteUser string    `json:"remote_user"`
+	RemoteHost string    `json:"remote_host"`
+	UserIP     string    `json:"user_ip"`
+	RealIP     string    `json:"real_ip"`
+	Reason     string    `json:"reason"`
+	JTI        string    `json:"jti"`
+	Time       time.Time `json:"time"`
+}
+
+// Notifier delivers break-glass alerts
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// Webhook is a Notifier that posts alerts as JSON to URL
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify posts alert to the webhook, failing on any non 2xx response
+func (w Webhook) Notify(alert Alert) error {
+	if w.URL == "" {
+		return ErrWebhookNotSet
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("breakglass: error posting alert (%s)", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("breakglass: webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Roles returns the roles of myRoles flagged as break-glass
+func Roles(myRoles []string, breakGlassRoles []string) []string {
+	roles := []string{}
+	for _, role := range myRoles {
+		if contains(breakGlassRoles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Authorize checks the requirements of a break-glass request and alerts security with notifier.
+// A certificate must only be issued if Authorize returns nil, so every issuance is alerted.
+func Authorize(alert Alert, notifier Notifier) error {
+	if alert.Reason == "" {
+		return ErrReasonRequired
+	}
+	return notifier.Notify(alert)
+}
+
+// contains tells whether a contains x.
+func contains(a []string, x string) bool {
+	for _, n := range a {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
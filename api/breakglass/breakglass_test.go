@@ -0,0 +1,108 @@
+package breakglass
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recorder is a Notifier that keeps sent alerts
+type recorder struct {
+	alerts []Alert
+}
+
+func (r *recorder) Notify(alert Alert) error {
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func TestRoles(t *testing.T) {
+	t.Run(
+		"Only break-glass roles",
+		func(t *testing.T) {
+			roles := Roles([]string{"dev", "emergency", "prod"}, []string{"emergency"})
+			if len(roles) != 1 || roles[0] != "emergency" {
+				t.Fatalf("Roles: check fail filtering break-glass roles (%v)", roles)
+			}
+		})
+	t.Run(
+		"Without break-glass roles",
+		func(t *testing.T) {
+			roles := Roles([]string{"dev"}, []string{})
+			if len(roles) != 0 {
+				t.Fatalf("Roles: check fail without break-glass roles (%v)", roles)
+			}
+		})
+}
+
+func TestAuthorize(t *testing.T) {
+	t.Run(
+		"Reason is required",
+		func(t *testing.T) {
+			notifier := &recorder{}
+			err := Authorize(Alert{Requester: "alice", Roles: []string{"emergency"}}, notifier)
+			if err != ErrReasonRequired {
+				t.Fatalf("Authorize: check fail without reason (%v)", err)
+			}
+			if len(notifier.alerts) != 0 {
+				t.Fatalf("Authorize: check fail alerting request without reason (%v)", notifier.alerts)
+			}
+		})
+	t.Run(
+		"Alert is sent",
+		func(t *testing.T) {
+			notifier := &recorder{}
+			err := Authorize(Alert{Requester: "alice", Roles: []string{"emergency"}, Reason: "INC-42"}, notifier)
+			if err != nil {
+				t.Fatalf("Authorize: check fail with reason (%v)", err)
+			}
+			if len(notifier.alerts) != 1 || notifier.alerts[0].Reason != "INC-42" {
+				t.Fatalf("Authorize: check fail sending alert (%v)", notifier.alerts)
+			}
+		})
+	t.Run(
+		"Webhook not set",
+		func(t *testing.T) {
+			err := Authorize(Alert{Requester: "alice", Reason: "INC-42"}, Webhook{})
+			if err != ErrWebhookNotSet {
+				t.Fatalf("Authorize: check fail without webhook (%v)", err)
+			}
+		})
+}
+
+func TestWebhookNotify(t *testing.T) {
+	t.Run(
+		"Webhook receives alert",
+		func(t *testing.T) {
+			var received Alert
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+					t.Errorf("Notify: check fail decoding alert (%v)", err)
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			err := Webhook{URL: server.URL}.Notify(Alert{Event: "cert.breakglass", Requester: "alice", Reason: "INC-42"})
+			if err != nil {
+				t.Fatalf("Notify: check fail posting alert (%v)", err)
+			}
+			if received.Requester != "alice" || received.Event != "cert.breakglass" {
+				t.Fatalf("Notify: check fail with received alert (%v)", received)
+			}
+		})
+	t.Run(
+		"Webhook fails",
+		func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer server.Close()
+
+			err := Webhook{URL: server.URL}.Notify(Alert{Requester: "alice", Reason: "INC-42"})
+			if err == nil {
+				t.Fatalf("Notify: check fail with webhook error")
+			}
+		})
+}
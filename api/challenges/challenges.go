@@ -0,0 +1,53 @@
+package challenges
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+var (
+	// ErrExpired is returned when an expired nonce is used
+	ErrExpired = errors.New("challenges: nonce is expired")
+
+	// ErrConsumed is returned when a nonce is used again
+	ErrConsumed = errors.New("challenges: nonce was already used")
+
+	// ErrOwner is returned when a nonce is used by another user than the one it was issued to
+	ErrOwner = errors.New("challenges: nonce was issued to another user")
+)
+
+// nonceSize is the number of random bytes of a nonce
+const nonceSize = 32
+
+// New returns a challenge issued to owner, valid for ttl after now
+func New(owner string, now time.Time, ttl time.Duration) (types.CertChallenge, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return types.CertChallenge{}, err
+	}
+	return types.CertChallenge{
+		Nonce:     base64.RawURLEncoding.EncodeToString(nonce),
+		Owner:     owner,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// Consume marks challenge as used by owner at now. It fails if the challenge belongs to another
+// user, was already used or is expired.
+func Consume(challenge *types.CertChallenge, owner string, now time.Time) error {
+	if challenge.Owner != owner {
+		return ErrOwner
+	}
+	if challenge.ConsumedAt != nil {
+		return ErrConsumed
+	}
+	if now.After(challenge.ExpiresAt) {
+		return ErrExpired
+	}
+	challenge.ConsumedAt = &now
+	return nil
+}
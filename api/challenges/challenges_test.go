@@ -0,0 +1,69 @@
+package challenges
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	now := time.Now()
+	first, err := New("alice", now, time.Minute)
+	if err != nil {
+		t.Fatalf("New: check fail issuing challenge (%v)", err)
+	}
+	second, err := New("alice", now, time.Minute)
+	if err != nil {
+		t.Fatalf("New: check fail issuing challenge (%v)", err)
+	}
+	if first.Nonce == "" || first.Nonce == second.Nonce {
+		t.Fatalf("New: check fail with nonces %q and %q", first.Nonce, second.Nonce)
+	}
+	if first.Owner != "alice" || !first.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("New: check fail with owner and expiration (%v)", first)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	now := time.Now()
+	t.Run(
+		"Valid nonce",
+		func(t *testing.T) {
+			challenge, _ := New("alice", now, time.Minute)
+			if err := Consume(&challenge, "alice", now); err != nil {
+				t.Fatalf("Consume: check fail with valid nonce (%v)", err)
+			}
+			if challenge.ConsumedAt == nil || !challenge.ConsumedAt.Equal(now) {
+				t.Fatalf("Consume: check fail marking nonce as used (%v)", challenge.ConsumedAt)
+			}
+		})
+	t.Run(
+		"Expired nonce",
+		func(t *testing.T) {
+			challenge, _ := New("alice", now, time.Minute)
+			if err := Consume(&challenge, "alice", now.Add(2*time.Minute)); err != ErrExpired {
+				t.Fatalf("Consume: check fail with expired nonce (%v)", err)
+			}
+		})
+	t.Run(
+		"Reused nonce",
+		func(t *testing.T) {
+			challenge, _ := New("alice", now, time.Minute)
+			if err := Consume(&challenge, "alice", now); err != nil {
+				t.Fatalf("Consume: check fail with valid nonce (%v)", err)
+			}
+			if err := Consume(&challenge, "alice", now); err != ErrConsumed {
+				t.Fatalf("Consume: check fail with reused nonce (%v)", err)
+			}
+		})
+	t.Run(
+		"Nonce of another user",
+		func(t *testing.T) {
+			challenge, _ := New("alice", now, time.Minute)
+			if err := Consume(&challenge, "bob", now); err != ErrOwner {
+				t.Fatalf("Consume: check fail with nonce of another user (%v)", err)
+			}
+			if challenge.ConsumedAt != nil {
+				t.Fatalf("Consume: check fail, nonce of another user was marked as used")
+			}
+		})
+}
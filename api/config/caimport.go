@@ -0,0 +1,79 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultFile is the configuration file written when GSH API was started without one
+const DefaultFile = "config.json"
+
+// CAKeyPair validates an existing OpenSSH CA key pair to be used by the internal signer. The
+// private key must be unencrypted, as ca_private_key, and match the public key. It returns the
+// public key in authorized_keys format, as ca_public_key.
+func CAKeyPair(privateKey []byte, publicKey []byte) (string, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return "", errors.New("CAKeyPair: private key is invalid or encrypted, use an unencrypted key (" + err.Error() + ")")
+	}
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return "", errors.New("CAKeyPair: public key is invalid (" + err.Error() + ")")
+	}
+	if _, ok := caPublicKey.(*ssh.Certificate); ok {
+		return "", errors.New("CAKeyPair: public key is a certificate, use the CA public key")
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), caPublicKey.Marshal()) {
+		return "", errors.New("CAKeyPair: public key does not match private key (" +
+			ssh.FingerprintSHA256(caPublicKey) + " != " + ssh.FingerprintSHA256(signer.PublicKey()) + ")")
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(caPublicKey))), nil
+}
+
+// ImportCA sets ca_private_key and ca_public_key at the JSON configuration file, keeping its other
+// keys. The file is created if needed and replaced atomically, readable only by its owner.
+func ImportCA(file string, privateKey string, publicKey string) error {
+	values := map[string]interface{}{}
+	content, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.New("ImportCA: reading configuration file (" + err.Error() + ")")
+	}
+	if len(bytes.TrimSpace(content)) > 0 {
+		if err := json.Unmarshal(content, &values); err != nil {
+			return errors.New("ImportCA: parsing configuration file (" + err.Error() + ")")
+		}
+	}
+	values["ca_private_key"] = privateKey
+	values["ca_public_key"] = publicKey
+	content, err = json.MarshalIndent(values, "", "    ")
+	if err != nil {
+		return errors.New("ImportCA: formatting configuration file (" + err.Error() + ")")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".config-")
+	if err != nil {
+		return errors.New("ImportCA: writing configuration file (" + err.Error() + ")")
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return errors.New("ImportCA: writing configuration file (" + err.Error() + ")")
+	}
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		return errors.New("ImportCA: writing configuration file (" + err.Error() + ")")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.New("ImportCA: writing configuration file (" + err.Error() + ")")
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return errors.New("ImportCA: writing configuration file (" + err.Error() + ")")
+	}
+	return nil
+}
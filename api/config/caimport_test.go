@@ -0,0 +1,92 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newCAKeyPair returns a PEM private key and its public key in authorized_keys format
+func newCAKeyPair(t *testing.T) ([]byte, []byte) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("CAKeyPair: fail generating key (%v)", err)
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("CAKeyPair: fail marshaling key (%v)", err)
+	}
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("CAKeyPair: fail converting key (%v)", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), ssh.MarshalAuthorizedKey(publicKey)
+}
+
+func TestCAKeyPair(t *testing.T) {
+	privateKey, publicKey := newCAKeyPair(t)
+	_, otherPublicKey := newCAKeyPair(t)
+
+	t.Run(
+		"Matched key pair",
+		func(t *testing.T) {
+			caPublicKey, err := CAKeyPair(privateKey, append([]byte("  "), publicKey...))
+			if err != nil {
+				t.Fatalf("CAKeyPair: check fail with matched key pair (%v)", err)
+			}
+			if caPublicKey+"\n" != string(publicKey) {
+				t.Fatalf("CAKeyPair: check fail formatting public key (%s)", caPublicKey)
+			}
+		})
+	t.Run(
+		"Mismatched key pair",
+		func(t *testing.T) {
+			if _, err := CAKeyPair(privateKey, otherPublicKey); err == nil {
+				t.Fatalf("CAKeyPair: check fail with mismatched key pair")
+			}
+		})
+	t.Run(
+		"Invalid keys",
+		func(t *testing.T) {
+			if _, err := CAKeyPair(publicKey, publicKey); err == nil {
+				t.Fatalf("CAKeyPair: check fail with invalid private key")
+			}
+			if _, err := CAKeyPair(privateKey, privateKey); err == nil {
+				t.Fatalf("CAKeyPair: check fail with invalid public key")
+			}
+		})
+}
+
+func TestImportCA(t *testing.T) {
+	file := filepath.Join(t.TempDir(), DefaultFile)
+	if err := os.WriteFile(file, []byte(`{"port": 8000, "ca_public_key": "old"}`), 0644); err != nil {
+		t.Fatalf("ImportCA: fail writing configuration (%v)", err)
+	}
+
+	if err := ImportCA(file, "private", "public"); err != nil {
+		t.Fatalf("ImportCA: check fail importing CA (%v)", err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ImportCA: fail reading configuration (%v)", err)
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(content, &values); err != nil {
+		t.Fatalf("ImportCA: check fail parsing configuration (%v)", err)
+	}
+	if values["ca_private_key"] != "private" || values["ca_public_key"] != "public" || values["port"] != float64(8000) {
+		t.Fatalf("ImportCA: check fail keeping configuration (%v)", values)
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("ImportCA: check fail with configuration permissions (%v, %v)", info.Mode(), err)
+	}
+}
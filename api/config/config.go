@@ -1,13 +1,70 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"strings"
 
+	"github.com/globocom/gsh/api/eventlog"
+	"github.com/globocom/gsh/api/principal"
+	"github.com/globocom/gsh/types"
+	"github.com/go-sql-driver/mysql"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
 )
 
+// keyIDEnvironment matches valid ca_key_id_environment labels
+var keyIDEnvironment = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// DefaultRemoteUserPattern matches POSIX portable usernames, as principals of certificates
+const DefaultRemoteUserPattern = `[A-Za-z0-9._][A-Za-z0-9._-]{0,31}`
+
+// HSMPin returns the PIN of the CA HSM token, read from ca_pkcs11_pin_file when it is set
+func HSMPin(config viper.Viper) (string, error) {
+	file := config.GetString("ca_pkcs11_pin_file")
+	if file == "" {
+		if config.GetString("ca_pkcs11_pin") == "" {
+			return "", errors.New("CA PKCS#11 PIN (ca_pkcs11_pin or ca_pkcs11_pin_file) not set")
+		}
+		return config.GetString("ca_pkcs11_pin"), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.New("CA PKCS#11 PIN file (ca_pkcs11_pin_file) can't be read (" + err.Error() + ")")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// checkFallbackCA checks the internal CA keys used by fallback_internal_ca, returning the number of fails
+func checkFallbackCA(config viper.Viper) uint {
+	hsm := len(config.GetString("ca_pkcs11_module")) > 0
+	if (len(config.GetString("ca_private_key")) == 0 && !hsm) || len(config.GetString("ca_public_key")) == 0 {
+		fmt.Println("Fallback to internal CA (fallback_internal_ca) needs CA private and public keys (ca_private_key or ca_pkcs11_module, and ca_public_key)")
+		return 1
+	}
+	if _, err := ssh.ParsePrivateKey([]byte(config.GetString("ca_private_key"))); err != nil && !hsm {
+		fmt.Printf("Fallback to internal CA (fallback_internal_ca): CA private key (ca_private_key) is invalid (%s)\n", err.Error())
+		return 1
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(config.GetString("ca_public_key")))
+	if err != nil {
+		fmt.Printf("Fallback to internal CA (fallback_internal_ca): CA public key (ca_public_key) is invalid (%s)\n", err.Error())
+		return 1
+	}
+	for _, key := range config.GetStringSlice("ca_chain_public_keys") {
+		if chainKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err == nil &&
+			ssh.FingerprintSHA256(chainKey) == ssh.FingerprintSHA256(publicKey) {
+			return 0
+		}
+	}
+	fmt.Println("Fallback to internal CA (fallback_internal_ca): CA public key (ca_public_key) must be at CA chain public keys (ca_chain_public_keys), trusted by hosts")
+	return 1
+}
+
 // Init configure and check environment configuration
 func Init() viper.Viper {
 	// Configure defaults
@@ -27,6 +84,52 @@ func Init() viper.Viper {
 	}
 	config.SetDefault("storage_uri", "user:pass@tcp(localhost:3306)/gsh?charset=utf8&parseTime=True&multiStatements=true")
 	config.SetDefault("oidc_callback_port", "30000")
+	config.SetDefault("approval_expiration", "15m")
+	config.SetDefault("role_retention", "720h")
+	config.SetDefault("impersonation_limit", 5)
+	config.SetDefault("impersonation_window", "1h")
+	config.SetDefault("cert_batch_limit", 20)
+	config.SetDefault("cert_quota", 0)
+	config.SetDefault("cert_quota_window", "24h")
+	config.SetDefault("min_token_remaining", "0s")
+	config.SetDefault("token_clock_skew", "60s")
+	config.SetDefault("token_validation_mode", "access")
+	config.SetDefault("fallback_internal_ca", false)
+	config.SetDefault("principal_claim", "")
+	config.SetDefault("ca_pkcs11_timeout", "10s")
+	config.SetDefault("syslog_enabled", false)
+	config.SetDefault("syslog_address", "")
+	config.SetDefault("syslog_facility", "auth")
+	config.SetDefault("syslog_severity", "info")
+	config.SetDefault("syslog_denial_severity", "warning")
+	config.SetDefault("syslog_stdout", true)
+	config.SetDefault("syslog_timeout", "2s")
+	config.SetDefault("syslog_queue_size", 1000)
+	config.SetDefault("max_concurrent_signs", 0)
+	config.SetDefault("sign_queue_timeout", "5s")
+	config.SetDefault("ca_key_id_format", "{user}-{nonce}")
+	config.SetDefault("cert_min_ttl", "1m")
+	config.SetDefault("cert_clamp_token_expiry", false)
+	config.SetDefault("ca_require_key_proof", false)
+	config.SetDefault("ca_key_proof_challenge_ttl", "1m")
+	config.SetDefault("principal_template", principal.DefaultTemplate)
+	config.SetDefault("principal_lowercase", false)
+	config.SetDefault("remote_user_pattern", DefaultRemoteUserPattern)
+	config.SetDefault("breakglass_webhook_timeout", "5s")
+	config.SetDefault("http_body_limit", 16384)
+	config.SetDefault("read_only", false)
+	config.SetDefault("min_tls_version", types.DefaultMinTLSVersion)
+	if hostname, err := os.Hostname(); err == nil {
+		config.SetDefault("instance_name", hostname)
+	}
+	config.SetDefault("read_only_file", "")
+	config.SetDefault("audit_export_enabled", false)
+	config.SetDefault("audit_export_region", "us-east-1")
+	config.SetDefault("audit_export_prefix", "audit")
+	config.SetDefault("audit_export_interval", "1h")
+	config.SetDefault("audit_export_delay", "5m")
+	config.SetDefault("audit_stream_interval", "1s")
+	config.SetDefault("audit_stream_overlap", "30s")
 	config.SetEnvPrefix("GSH")
 	config.AutomaticEnv()
 	return *config
@@ -41,6 +144,19 @@ func Check(config viper.Viper) error {
 		fmt.Printf("Environment variable PORT not defined\n")
 		fails++
 	}
+	if _, err := types.ParseTLSVersion(config.GetString("min_tls_version")); err != nil {
+		fmt.Printf("Minimum TLS version (min_tls_version) is invalid (%s)\n", err.Error())
+		fails++
+	}
+	if (config.GetString("tls_cert_file") == "") != (config.GetString("tls_key_file") == "") {
+		fmt.Println("TLS certificate and key (tls_cert_file, tls_key_file) must be set together")
+		fails++
+	}
+	if config.GetInt64("http_body_limit") <= 0 {
+		fmt.Println("HTTP body limit (http_body_limit) must be greater than zero")
+		fails++
+	}
+
 	// Check Storage (MySQL)
 	if len(config.GetString("storage_driver")) == 0 {
 		fmt.Println("Storage driver (storage_driver) not set")
@@ -69,13 +185,17 @@ func Check(config viper.Viper) error {
 			fmt.Println("CA role ID (ca_role_id) not set")
 			fails++
 		}
-		if len(config.GetString("ca_external_secret_id")) == 0 {
-			fmt.Println("CA external (Vault) secret ID (ca_external_secret_id) not set")
+		if len(config.GetString("ca_external_secret_id")) == 0 && len(config.GetString("ca_external_secret_id_file")) == 0 {
+			fmt.Println("CA external (Vault) secret ID (ca_external_secret_id or ca_external_secret_id_file) not set")
 			fails++
 		}
+		// internal CA signs while the external CA fails, hosts must trust it at ca_chain_public_keys
+		if config.GetBool("fallback_internal_ca") {
+			fails += checkFallbackCA(config)
+		}
 	} else {
-		if len(config.GetString("ca_private_key")) == 0 {
-			fmt.Println("CA private key (ca_private_key) not set")
+		if len(config.GetString("ca_private_key")) == 0 && len(config.GetString("ca_pkcs11_module")) == 0 {
+			fmt.Println("CA private key (ca_private_key or ca_pkcs11_module) not set")
 			fails++
 		}
 		if len(config.GetString("ca_public_key")) == 0 {
@@ -84,6 +204,30 @@ func Check(config viper.Viper) error {
 		}
 	}
 
+	// Check CA key at an HSM (optional), used through PKCS#11 instead of ca_private_key
+	if module := config.GetString("ca_pkcs11_module"); module != "" {
+		if _, err := os.Stat(module); err != nil {
+			fmt.Printf("CA PKCS#11 module (ca_pkcs11_module) not found (%s)\n", err.Error())
+			fails++
+		}
+		if len(config.GetString("ca_private_key")) > 0 {
+			fmt.Println("CA private key (ca_private_key) must not be set with the CA key at an HSM (ca_pkcs11_module)")
+			fails++
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(config.GetString("ca_public_key"))); err != nil {
+			fmt.Println("CA public key (ca_public_key) of the HSM key (ca_pkcs11_module) not set or invalid")
+			fails++
+		}
+		if _, err := HSMPin(config); err != nil {
+			fmt.Println(err.Error())
+			fails++
+		}
+		if config.GetDuration("ca_pkcs11_timeout") <= 0 {
+			fmt.Println("CA PKCS#11 timeout (ca_pkcs11_timeout) must be positive")
+			fails++
+		}
+	}
+
 	// Check OIDC
 	if len(config.GetString("oidc_base_url")) == 0 {
 		fmt.Println("OIDC base URL (oidc_base_url) not set")
@@ -105,6 +249,12 @@ func Check(config viper.Viper) error {
 		fmt.Println("OIDC claim (oidc_claim) not set")
 		fails++
 	}
+	if len(config.GetString("storage_uri_replica")) > 0 {
+		if _, err := mysql.ParseDSN(config.GetString("storage_uri_replica")); err != nil {
+			fmt.Printf("Storage replica (storage_uri_replica) is not a valid DSN (%s)\n", err.Error())
+			fails++
+		}
+	}
 	if len(config.GetString("oidc_claim_name")) == 0 {
 		fmt.Println("OIDC claim name (oidc_claim_name) not set")
 		fails++
@@ -118,6 +268,178 @@ func Check(config viper.Viper) error {
 		fails++
 	}
 
+	// Check certificate validity floor, it can't be longer than the validity of certificates
+	if config.GetDuration("cert_min_ttl") < 0 {
+		fmt.Println("Minimum certificate validity (cert_min_ttl) must not be negative")
+		fails++
+	}
+	if config.IsSet("ca_signed_cert_duration") && config.GetDuration("cert_min_ttl") > config.GetDuration("ca_signed_cert_duration") {
+		fmt.Println("Minimum certificate validity (cert_min_ttl) must not be longer than ca_signed_cert_duration")
+		fails++
+	}
+
+	// Check token headroom (optional), zero accepts tokens until they expire
+	if config.GetDuration("min_token_remaining") < 0 {
+		fmt.Println("Minimum token remaining validity (min_token_remaining) must not be negative")
+		fails++
+	}
+
+	// Check clock skew tolerated validating token timestamps
+	if config.GetDuration("token_clock_skew") < 0 {
+		fmt.Println("Token clock skew (token_clock_skew) must not be negative")
+		fails++
+	}
+
+	// Check kind of token validated, access tokens or ID tokens
+	if mode := config.GetString("token_validation_mode"); mode != "access" && mode != "id" {
+		fmt.Printf("Token validation mode (token_validation_mode) must be access or id (%s)\n", mode)
+		fails++
+	}
+
+	// Check retention of removed roles, restored with gsh role-restore
+	if config.GetDuration("role_retention") <= 0 {
+		fmt.Println("Retention of removed roles (role_retention) must be positive")
+		fails++
+	}
+
+	// Check impersonation limit, zero disables impersonation
+	if config.GetInt("impersonation_limit") < 0 {
+		fmt.Println("Impersonation limit (impersonation_limit) must not be negative")
+		fails++
+	}
+	if config.GetDuration("impersonation_window") <= 0 {
+		fmt.Println("Impersonation window (impersonation_window) must be positive")
+		fails++
+	}
+
+	// Check certificate quota (optional), zero issues certificates without limit
+	if config.GetInt("cert_quota") < 0 {
+		fmt.Println("Certificate quota (cert_quota) must not be negative")
+		fails++
+	}
+	if config.GetDuration("cert_quota_window") <= 0 {
+		fmt.Println("Certificate quota window (cert_quota_window) must be positive")
+		fails++
+	}
+
+	// Check batch size, each batch has at least one certificate request
+	if config.GetInt("cert_batch_limit") < 1 {
+		fmt.Println("Certificate batch limit (cert_batch_limit) must be positive")
+		fails++
+	}
+
+	// Check signing concurrency limit (optional), zero signs without limit
+	if config.GetInt("max_concurrent_signs") < 0 {
+		fmt.Println("Maximum concurrent signatures (max_concurrent_signs) must not be negative")
+		fails++
+	}
+	if config.GetDuration("sign_queue_timeout") < 0 {
+		fmt.Println("Signature queue timeout (sign_queue_timeout) must not be negative")
+		fails++
+	}
+
+	// Check lifetime of key proof challenges, the client signs the nonce right after it is issued
+	if config.GetDuration("ca_key_proof_challenge_ttl") <= 0 {
+		fmt.Println("Key proof challenge lifetime (ca_key_proof_challenge_ttl) must be positive")
+		fails++
+	}
+
+	// Check principal transformation
+	if _, err := principal.New(config.GetString("principal_template"), config.GetString("principal_pattern"),
+		config.GetString("principal_replacement"), principal.EffectiveCase(config.GetString("principal_case"), config.GetBool("principal_lowercase"))); err != nil {
+		fmt.Printf("Principal transformation (principal_template, principal_pattern, principal_case) is invalid (%s)\n", err.Error())
+		fails++
+	}
+
+	// Check audit export (optional)
+	if config.GetBool("audit_export_enabled") {
+		if len(config.GetString("audit_export_endpoint")) == 0 {
+			fmt.Println("Audit export endpoint (audit_export_endpoint) not set")
+			fails++
+		}
+		if len(config.GetString("audit_export_bucket")) == 0 {
+			fmt.Println("Audit export bucket (audit_export_bucket) not set")
+			fails++
+		}
+		if len(config.GetString("audit_export_access_key")) == 0 || len(config.GetString("audit_export_secret_key")) == 0 {
+			fmt.Println("Audit export credentials (audit_export_access_key, audit_export_secret_key) not set")
+			fails++
+		}
+		if config.GetDuration("audit_export_interval") <= 0 {
+			fmt.Println("Audit export interval (audit_export_interval) must be greater than zero")
+			fails++
+		}
+	}
+
+	// Check break-glass roles (optional), their use must always alert security
+	if len(config.GetStringSlice("breakglass_roles")) > 0 && len(config.GetString("breakglass_webhook_url")) == 0 {
+		fmt.Println("Break-glass webhook (breakglass_webhook_url) not set")
+		fails++
+	}
+
+	// Check admin source networks and trusted proxies (optional), used to find client ip
+	for _, key := range []string{"admin_source_cidrs", "trusted_proxies"} {
+		for _, cidr := range config.GetStringSlice(key) {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+				fmt.Printf("Network %q at %s is not a valid CIDR (%s)\n", cidr, key, err.Error())
+				fails++
+			}
+		}
+	}
+
+	// Check host CA keys (optional) informed to clients, old and new keys while rotating
+	for _, key := range config.GetStringSlice("host_ca_public_keys") {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+			fmt.Printf("Host CA public key (host_ca_public_keys) %q is invalid (%s)\n", key, err.Error())
+			fails++
+		}
+	}
+
+	// Check user CA chain keys (optional), trusted by hosts besides the signer key
+	for _, key := range config.GetStringSlice("ca_chain_public_keys") {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+			fmt.Printf("CA chain public key (ca_chain_public_keys) %q is invalid (%s)\n", key, err.Error())
+			fails++
+		}
+	}
+
+	// Check environment label (optional) of certificate key ids, as "gsh:<environment>:..."
+	if environment := config.GetString("ca_key_id_environment"); environment != "" && !keyIDEnvironment.MatchString(environment) {
+		fmt.Printf("Key id environment (ca_key_id_environment) %q must have up to 32 letters, digits, '.', '_' or '-'\n", environment)
+		fails++
+	}
+
+	// Check syslog, receiving issuance and denial events (optional)
+	if config.GetBool("syslog_enabled") {
+		if _, _, err := eventlog.ParseAddress(config.GetString("syslog_address")); err != nil {
+			fmt.Printf("Syslog address (syslog_address) is not valid (%s)\n", err.Error())
+			fails++
+		}
+		if _, err := eventlog.ParseFacility(config.GetString("syslog_facility")); err != nil {
+			fmt.Printf("Syslog facility (syslog_facility) is not valid (%s)\n", err.Error())
+			fails++
+		}
+		for _, key := range []string{"syslog_severity", "syslog_denial_severity"} {
+			if _, err := eventlog.ParseSeverity(config.GetString(key)); err != nil {
+				fmt.Printf("Syslog severity (%s) is not valid (%s)\n", key, err.Error())
+				fails++
+			}
+		}
+		if config.GetDuration("syslog_timeout") <= 0 || config.GetInt("syslog_queue_size") < 1 {
+			fmt.Println("Syslog timeout (syslog_timeout) and queue size (syslog_queue_size) must be positive")
+			fails++
+		}
+	}
+
+	// Check remote user pattern, requests with other remote users are refused
+	if len(config.GetString("remote_user_pattern")) == 0 {
+		fmt.Println("Remote user pattern (remote_user_pattern) not set")
+		fails++
+	} else if _, err := regexp.Compile(config.GetString("remote_user_pattern")); err != nil {
+		fmt.Printf("Remote user pattern (remote_user_pattern) is not a valid regular expression (%s)\n", err.Error())
+		fails++
+	}
+
 	// Check for admins
 	if len(config.GetStringSlice("perm_admin")) == 0 {
 		fmt.Println("Admin users (perm_admin) not configured")
@@ -130,3 +452,21 @@ func Check(config viper.Viper) error {
 
 	return nil
 }
+
+// TLSConfig returns the TLS configuration of GSH API, as server (when tls_cert_file is set) and as
+// client of Vault and OIDC provider, with the minimum version set by min_tls_version
+func TLSConfig(config viper.Viper) (*tls.Config, error) {
+	version, err := types.ParseTLSVersion(config.GetString("min_tls_version"))
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{MinVersion: version}
+	if config.GetString("tls_cert_file") != "" {
+		cert, err := tls.LoadX509KeyPair(config.GetString("tls_cert_file"), config.GetString("tls_key_file"))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+
+	"github.com/spf13/viper"
 )
 
 func TestInit(t *testing.T) {
@@ -369,4 +374,333 @@ func TestCheck(t *testing.T) {
 				t.Fatalf("CONFIG: fail to check app perm_admin (%v)", err)
 			}
 		})
+	t.Run(
+		"Test Check(): audit_export_bucket",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_AUDIT_EXPORT_ENABLED", "true")
+			os.Setenv("GSH_AUDIT_EXPORT_ENDPOINT", "https://storage.googleapis.com")
+			os.Setenv("GSH_AUDIT_EXPORT_ACCESS_KEY", "access")
+			os.Setenv("GSH_AUDIT_EXPORT_SECRET_KEY", "secret")
+			defer os.Unsetenv("GSH_AUDIT_EXPORT_ENABLED")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app audit_export_bucket (%v)", err)
+			}
+
+			os.Setenv("GSH_AUDIT_EXPORT_BUCKET", "gsh-audit")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app audit export (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): breakglass_webhook_url",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_BREAKGLASS_ROLES", "emergency")
+			defer os.Unsetenv("GSH_BREAKGLASS_ROLES")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app breakglass_webhook_url (%v)", err)
+			}
+
+			os.Setenv("GSH_BREAKGLASS_WEBHOOK_URL", "https://alerts.example.com/gsh")
+			defer os.Unsetenv("GSH_BREAKGLASS_WEBHOOK_URL")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app break-glass (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): storage_uri_replica",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_STORAGE_URI_REPLICA", "user:pass@tcp(localhost:3306")
+			defer os.Unsetenv("GSH_STORAGE_URI_REPLICA")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app storage_uri_replica (%v)", err)
+			}
+
+			os.Setenv("GSH_STORAGE_URI_REPLICA", "user:pass@tcp(replica:3306)/gsh?charset=utf8&parseTime=True")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app storage replica (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): principal_template",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_PRINCIPAL_TEMPLATE", "{local-part}")
+			defer os.Unsetenv("GSH_PRINCIPAL_TEMPLATE")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app principal_template (%v)", err)
+			}
+
+			os.Setenv("GSH_PRINCIPAL_TEMPLATE", "{local}")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app principal template (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): admin_source_cidrs",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_ADMIN_SOURCE_CIDRS", "10.0.0.0/8 10.0.0.1")
+			defer os.Unsetenv("GSH_ADMIN_SOURCE_CIDRS")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app admin_source_cidrs (%v)", err)
+			}
+
+			os.Setenv("GSH_ADMIN_SOURCE_CIDRS", "10.0.0.0/8 2001:db8::/32")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app admin source cidrs (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): trusted_proxies",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_TRUSTED_PROXIES", "192.168.0.0/33")
+			defer os.Unsetenv("GSH_TRUSTED_PROXIES")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app trusted_proxies (%v)", err)
+			}
+
+			os.Setenv("GSH_TRUSTED_PROXIES", "192.168.0.0/24")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app trusted proxies (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): cert_min_ttl",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_CA_SIGNED_CERT_DURATION", "10m")
+			defer os.Unsetenv("GSH_CA_SIGNED_CERT_DURATION")
+			os.Setenv("GSH_CERT_MIN_TTL", "15m")
+			defer os.Unsetenv("GSH_CERT_MIN_TTL")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app cert_min_ttl (%v)", err)
+			}
+
+			os.Setenv("GSH_CERT_MIN_TTL", "2m")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app cert min ttl (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): cert_quota",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_CERT_QUOTA", "-1")
+			defer os.Unsetenv("GSH_CERT_QUOTA")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app cert_quota (%v)", err)
+			}
+
+			os.Setenv("GSH_CERT_QUOTA", "50")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app cert quota (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): token_validation_mode",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_TOKEN_VALIDATION_MODE", "introspection")
+			defer os.Unsetenv("GSH_TOKEN_VALIDATION_MODE")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app token_validation_mode (%v)", err)
+			}
+
+			os.Setenv("GSH_TOKEN_VALIDATION_MODE", "id")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app token validation mode (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): remote_user_pattern",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_REMOTE_USER_PATTERN", "[a-z")
+			defer os.Unsetenv("GSH_REMOTE_USER_PATTERN")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app remote_user_pattern (%v)", err)
+			}
+
+			os.Setenv("GSH_REMOTE_USER_PATTERN", "[a-z_][a-z0-9_-]*")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app remote user pattern (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): syslog",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_SYSLOG_ENABLED", "true")
+			os.Setenv("GSH_SYSLOG_FACILITY", "security")
+			defer os.Unsetenv("GSH_SYSLOG_ENABLED")
+			defer os.Unsetenv("GSH_SYSLOG_FACILITY")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app syslog_facility (%v)", err)
+			}
+
+			os.Setenv("GSH_SYSLOG_FACILITY", "local0")
+			os.Setenv("GSH_SYSLOG_ADDRESS", "tcp://syslog.example.org:6514")
+			defer os.Unsetenv("GSH_SYSLOG_ADDRESS")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app syslog (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): principal_case",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_PRINCIPAL_CASE", "title")
+			defer os.Unsetenv("GSH_PRINCIPAL_CASE")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app principal_case (%v)", err)
+			}
+
+			os.Setenv("GSH_PRINCIPAL_CASE", "lower")
+			config = Init()
+			err = Check(config)
+			if err != nil {
+				t.Fatalf("CONFIG: fail to check app principal case (%v)", err)
+			}
+		})
+	t.Run(
+		"Test Check(): ca_pkcs11_module",
+		func(t *testing.T) {
+
+			os.Setenv("GSH_CA_PKCS11_MODULE", "/nonexistent/libsofthsm2.so")
+			os.Setenv("GSH_CA_PKCS11_PIN", "1234")
+			defer os.Unsetenv("GSH_CA_PKCS11_MODULE")
+			defer os.Unsetenv("GSH_CA_PKCS11_PIN")
+			config := Init()
+			err := Check(config)
+			if err == nil {
+				t.Fatalf("CONFIG: fail to check app ca_pkcs11_module (%v)", err)
+			}
+		})
+}
+
+func TestTLSConfig(t *testing.T) {
+	t.Run(
+		"Invalid version",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("min_tls_version", "1.1")
+			if _, err := TLSConfig(*config); err == nil {
+				t.Fatalf("CONFIG: fail to refuse min_tls_version 1.1")
+			}
+		})
+	t.Run(
+		"TLS 1.1 handshake rejected",
+		func(t *testing.T) {
+			tlsConfig, err := TLSConfig(Init())
+			if err != nil {
+				t.Fatalf("CONFIG: fail to build TLS config (%v)", err)
+			}
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.TLS = tlsConfig
+			server.StartTLS()
+			defer server.Close()
+
+			dial := func(maxVersion uint16) error {
+				conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+					InsecureSkipVerify: true, // #nosec test server certificate
+					MinVersion:         tls.VersionTLS10,
+					MaxVersion:         maxVersion,
+				})
+				if err == nil {
+					conn.Close()
+				}
+				return err
+			}
+			if err := dial(tls.VersionTLS11); err == nil {
+				t.Fatalf("CONFIG: fail to reject TLS 1.1 handshake")
+			}
+			if err := dial(tls.VersionTLS12); err != nil {
+				t.Fatalf("CONFIG: fail with TLS 1.2 handshake (%v)", err)
+			}
+		})
+}
+
+func TestCheckFallbackCA(t *testing.T) {
+	privateKey, publicKey := newCAKeyPair(t)
+	_, otherPublicKey := newCAKeyPair(t)
+
+	t.Run(
+		"Internal CA keys not set",
+		func(t *testing.T) {
+			if fails := checkFallbackCA(*viper.New()); fails != 1 {
+				t.Fatalf("CONFIG: fail to refuse fallback_internal_ca without CA keys (%d)", fails)
+			}
+		})
+	t.Run(
+		"Internal CA not trusted by hosts",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("ca_private_key", string(privateKey))
+			config.Set("ca_public_key", string(publicKey))
+			config.Set("ca_chain_public_keys", []string{string(otherPublicKey)})
+			if fails := checkFallbackCA(*config); fails != 1 {
+				t.Fatalf("CONFIG: fail to refuse internal CA out of ca_chain_public_keys (%d)", fails)
+			}
+		})
+	t.Run(
+		"Internal CA at chain",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("ca_private_key", string(privateKey))
+			config.Set("ca_public_key", string(publicKey))
+			config.Set("ca_chain_public_keys", []string{string(otherPublicKey), string(publicKey)})
+			if fails := checkFallbackCA(*config); fails != 0 {
+				t.Fatalf("CONFIG: fail to check fallback_internal_ca (%d)", fails)
+			}
+		})
 }
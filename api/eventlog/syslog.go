@@ -0,0 +1,194 @@
+package eventlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+// facilities are the syslog facilities by name (RFC 5424, section 6.2.1)
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// severities are the syslog severities by name (RFC 5424, section 6.2.1)
+var severities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// localSockets are the paths of the local syslog socket, by platform
+var localSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// ParseFacility returns the syslog facility named name, as auth or local0
+func ParseFacility(name string) (int, error) {
+	facility, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("ParseFacility: unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// ParseSeverity returns the syslog severity named name, as info or warning
+func ParseSeverity(name string) (int, error) {
+	severity, ok := severities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("ParseSeverity: unknown syslog severity %q", name)
+	}
+	return severity, nil
+}
+
+// ParseAddress returns network and address of a syslog server at udp://host:port, tcp://host:port
+// or unix:///path. An empty address is the local syslog socket.
+func ParseAddress(address string) (string, string, error) {
+	if address == "" {
+		return "unixgram", "", nil
+	}
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("ParseAddress: invalid syslog address (%v)", err)
+	}
+	switch parsed.Scheme {
+	case "udp", "tcp":
+		if _, _, err := net.SplitHostPort(parsed.Host); err != nil {
+			return "", "", fmt.Errorf("ParseAddress: syslog address must have host and port (%v)", err)
+		}
+		return parsed.Scheme, parsed.Host, nil
+	case "unix":
+		return "unixgram", parsed.Path, nil
+	}
+	return "", "", fmt.Errorf("ParseAddress: syslog address must be udp://, tcp:// or unix:// (%s)", address)
+}
+
+// Syslog sends events to a syslog server as RFC 5424 messages. Events are queued and written by a
+// goroutine, so an unavailable server never blocks who logs them: events are dropped while the queue
+// is full and the connection is retried at the next event.
+type Syslog struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	timeout  time.Duration
+
+	queue chan []byte
+	conn  net.Conn
+	// failing is set after a write failure is reported, reporting only the first one
+	failing bool
+}
+
+// New returns a Syslog sending events of facility to address (see ParseAddress), waiting up to
+// timeout for each write and queueing up to queueSize events
+func New(address string, facility int, timeout time.Duration, queueSize int) (*Syslog, error) {
+	network, address, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	s := &Syslog{
+		network:  network,
+		address:  address,
+		facility: facility,
+		hostname: hostname,
+		timeout:  timeout,
+		queue:    make(chan []byte, queueSize),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Log queues an event of severity, returning false if it was dropped with the queue full
+func (s *Syslog) Log(severity int, msgID string, message string, now time.Time) bool {
+	select {
+	case s.queue <- format(s.facility, severity, now, s.hostname, msgID, message):
+		return true
+	default:
+		return false
+	}
+}
+
+// run writes queued events, reporting failures once until a write succeeds again
+func (s *Syslog) run() {
+	for message := range s.queue {
+		err := s.write(message)
+		if err != nil && !s.failing {
+			fmt.Printf("Syslog error, events are dropped while it is unavailable: %s\n", err.Error())
+		}
+		s.failing = err != nil
+	}
+}
+
+// write sends message, connecting first if needed. A failed connection is closed, to connect again
+// at the next message.
+func (s *Syslog) write(message []byte) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	// stream transports frame messages by octet counting (RFC 6587, section 3.4.1)
+	if s.network == "tcp" {
+		message = append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := s.conn.Write(message); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial connects to the syslog server, or to the first local syslog socket found
+func (s *Syslog) dial() (net.Conn, error) {
+	if s.address != "" {
+		return net.DialTimeout(s.network, s.address, s.timeout)
+	}
+	var err error
+	for _, socket := range localSockets {
+		var conn net.Conn
+		if conn, err = net.DialTimeout(s.network, socket, s.timeout); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("local syslog unavailable (%v)", err)
+}
+
+// format returns an RFC 5424 message, without structured data
+func format(facility int, severity int, now time.Time, hostname string, msgID string, message string) []byte {
+	if hostname == "" {
+		hostname = "-"
+	}
+	if msgID == "" {
+		msgID = "-"
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s gsh-api %d %s - %s", facility*8+severity,
+		now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), hostname, os.Getpid(), msgID, message))
+}
+
+// IsCertEvent checks if an audit record is an issuance or denial event of certificates
+func IsCertEvent(record types.AuditRecord) bool {
+	return strings.HasPrefix(record.Kind, "cert.")
+}
+
+// CertEvent returns the message of a certificate audit record, as JSON
+func CertEvent(record types.AuditRecord) string {
+	event, _ := json.Marshal(map[string]interface{}{
+		"uid":       record.UID,
+		"kind":      record.Kind,
+		"owner":     record.Owner,
+		"jti":       record.JTI,
+		"target_id": record.TargetID,
+		"error":     record.Error,
+		"log":       record.Log,
+	})
+	return string(event)
+}
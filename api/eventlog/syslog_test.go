@@ -0,0 +1,156 @@
+package eventlog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestParseAddress(t *testing.T) {
+	t.Run(
+		"Valid addresses",
+		func(t *testing.T) {
+			for address, expected := range map[string]string{
+				"":                        "unixgram ",
+				"udp://10.0.0.1:514":      "udp 10.0.0.1:514",
+				"tcp://syslog.local:6514": "tcp syslog.local:6514",
+				"unix:///dev/log":         "unixgram /dev/log",
+			} {
+				network, parsed, err := ParseAddress(address)
+				if err != nil || network+" "+parsed != expected {
+					t.Fatalf("ParseAddress: check fail with %q (%s %s, %v)", address, network, parsed, err)
+				}
+			}
+		})
+	t.Run(
+		"Invalid addresses",
+		func(t *testing.T) {
+			for _, address := range []string{"udp://10.0.0.1", "http://10.0.0.1:514", "10.0.0.1:514"} {
+				if _, _, err := ParseAddress(address); err == nil {
+					t.Fatalf("ParseAddress: check fail with %q", address)
+				}
+			}
+		})
+}
+
+func TestSyslog(t *testing.T) {
+	record := types.AuditRecord{Kind: "cert.create", Owner: "alice", Log: "INC-1234"}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	facility, _ := ParseFacility("auth")
+	severity, _ := ParseSeverity("warning")
+
+	t.Run(
+		"Local syslog listener over udp",
+		func(t *testing.T) {
+			listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Syslog: fail listening (%v)", err)
+			}
+			defer listener.Close()
+			s, err := New("udp://"+listener.LocalAddr().String(), facility, time.Second, 10)
+			if err != nil {
+				t.Fatalf("Syslog: check fail creating (%v)", err)
+			}
+			if !s.Log(severity, record.Kind, CertEvent(record), now) {
+				t.Fatalf("Syslog: check fail queueing event")
+			}
+
+			buffer := make([]byte, 2048)
+			_ = listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, _, err := listener.ReadFrom(buffer)
+			if err != nil {
+				t.Fatalf("Syslog: check fail receiving event (%v)", err)
+			}
+			message := string(buffer[:n])
+			// auth (4) * 8 + warning (4)
+			if !strings.HasPrefix(message, "<36>1 2026-10-16T12:00:00.000000Z ") {
+				t.Fatalf("Syslog: check fail with RFC 5424 header (%s)", message)
+			}
+			if !strings.Contains(message, " gsh-api ") || !strings.Contains(message, " cert.create - {") || !strings.Contains(message, `"owner":"alice"`) {
+				t.Fatalf("Syslog: check fail with event (%s)", message)
+			}
+		})
+	t.Run(
+		"Local syslog listener over tcp",
+		func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Syslog: fail listening (%v)", err)
+			}
+			defer listener.Close()
+			s, err := New("tcp://"+listener.Addr().String(), facility, time.Second, 10)
+			if err != nil {
+				t.Fatalf("Syslog: check fail creating (%v)", err)
+			}
+			s.Log(severity, "first", "one", now)
+			s.Log(severity, "second", "two", now)
+
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("Syslog: check fail accepting (%v)", err)
+			}
+			defer conn.Close()
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+			for _, expected := range []string{"first - one", "second - two"} {
+				message, err := readFrame(reader)
+				if err != nil {
+					t.Fatalf("Syslog: check fail reading framed event (%v)", err)
+				}
+				if !strings.HasPrefix(message, "<36>1 ") || !strings.HasSuffix(message, expected) {
+					t.Fatalf("Syslog: check fail with framed event (%s)", message)
+				}
+			}
+		})
+	t.Run(
+		"Syslog unavailable",
+		func(t *testing.T) {
+			listener, _ := net.Listen("tcp", "127.0.0.1:0")
+			address := listener.Addr().String()
+			listener.Close()
+			s, err := New("tcp://"+address, facility, 100*time.Millisecond, 1)
+			if err != nil {
+				t.Fatalf("Syslog: check fail creating (%v)", err)
+			}
+			start := time.Now()
+			for i := 0; i < 100; i++ {
+				s.Log(severity, record.Kind, CertEvent(record), now)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("Syslog: check fail, logging blocked with syslog unavailable (%s)", elapsed)
+			}
+		})
+}
+
+// readFrame reads an octet counted message (RFC 6587) from reader
+func readFrame(reader *bufio.Reader) (string, error) {
+	header, err := reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil {
+		return "", err
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(reader, message); err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
+
+func TestCertEvent(t *testing.T) {
+	if !IsCertEvent(types.AuditRecord{Kind: "cert.breakglass"}) || IsCertEvent(types.AuditRecord{Kind: "role.add"}) {
+		t.Fatalf("IsCertEvent: check fail with audit kinds")
+	}
+	event := CertEvent(types.AuditRecord{Kind: "cert.create", Owner: "alice", Error: "You don't have permission to request this certificate"})
+	if !strings.Contains(event, `"error":"You don't have permission`) {
+		t.Fatalf("CertEvent: check fail with denial (%s)", event)
+	}
+}
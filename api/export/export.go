@@ -0,0 +1,91 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+// ObjectStore is the interface of object storages receiving exported audit records
+type ObjectStore interface {
+	Put(key string, body []byte) error
+}
+
+// Source is the interface used to read audit records and export checkpoints
+type Source interface {
+	// Checkpoint returns the time until which audit records were already exported
+	Checkpoint() (time.Time, error)
+	// Records returns audit records that ended after since and until (inclusive)
+	Records(since time.Time, until time.Time) ([]types.AuditRecord, error)
+	// SaveCheckpoint stores a finished export
+	SaveCheckpoint(export types.AuditExport) error
+}
+
+// Exporter periodically copies audit records to an object storage as newline-delimited JSON
+type Exporter struct {
+	Source Source
+	Store  ObjectStore
+	Prefix string
+
+	// Delay keeps the most recent audit records out of the export, as they can still be
+	// waiting at audit channel to be written
+	Delay time.Duration
+}
+
+// Export sends audit records ended since last checkpoint to the object storage, advancing the
+// checkpoint only after the object is stored. It returns the export done.
+func (e *Exporter) Export(now time.Time) (types.AuditExport, error) {
+	since, err := e.Source.Checkpoint()
+	if err != nil {
+		return types.AuditExport{}, fmt.Errorf("export: error reading checkpoint (%v)", err)
+	}
+	until := now.Add(-e.Delay).UTC()
+	if !until.After(since) {
+		return types.AuditExport{Since: since, Until: since}, nil
+	}
+
+	records, err := e.Source.Records(since, until)
+	if err != nil {
+		return types.AuditExport{}, fmt.Errorf("export: error reading audit records (%v)", err)
+	}
+
+	export := types.AuditExport{Since: since, Until: until, Records: len(records)}
+	if len(records) > 0 {
+		body, err := NDJSON(records)
+		if err != nil {
+			return types.AuditExport{}, fmt.Errorf("export: error formatting audit records (%v)", err)
+		}
+		export.ObjectKey = ObjectKey(e.Prefix, since, until)
+		if err := e.Store.Put(export.ObjectKey, body); err != nil {
+			return types.AuditExport{}, fmt.Errorf("export: error storing %s (%v)", export.ObjectKey, err)
+		}
+	}
+
+	if err := e.Source.SaveCheckpoint(export); err != nil {
+		return types.AuditExport{}, fmt.Errorf("export: error saving checkpoint (%v)", err)
+	}
+	return export, nil
+}
+
+// NDJSON formats audit records as newline-delimited JSON, one record per line
+func NDJSON(records []types.AuditRecord) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// ObjectKey returns the object key of audit records exported between since and until,
+// partitioned by day of until
+func ObjectKey(prefix string, since time.Time, until time.Time) string {
+	const layout = "20060102T150405Z"
+	return fmt.Sprintf("%s/%s/%s-%s.ndjson", prefix, until.UTC().Format("2006/01/02"),
+		since.UTC().Format(layout), until.UTC().Format(layout))
+}
@@ -0,0 +1,143 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+// memorySource keeps audit records and exports in memory
+type memorySource struct {
+	records []types.AuditRecord
+	exports []types.AuditExport
+}
+
+func (s *memorySource) Checkpoint() (time.Time, error) {
+	if len(s.exports) == 0 {
+		return time.Time{}, nil
+	}
+	return s.exports[len(s.exports)-1].Until, nil
+}
+
+func (s *memorySource) Records(since time.Time, until time.Time) ([]types.AuditRecord, error) {
+	var records []types.AuditRecord
+	for _, record := range s.records {
+		if record.EndTime.After(since) && !record.EndTime.After(until) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *memorySource) SaveCheckpoint(export types.AuditExport) error {
+	s.exports = append(s.exports, export)
+	return nil
+}
+
+// memoryStore keeps objects in memory, failing every Put when err is set
+type memoryStore struct {
+	objects map[string][]byte
+	err     error
+}
+
+func (s *memoryStore) Put(key string, body []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.objects[key] = body
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	source := &memorySource{records: []types.AuditRecord{
+		{Kind: "cert.create", Owner: "alice", EndTime: now.Add(-3 * time.Hour)},
+		{Kind: "cert.create", Owner: "bob", EndTime: now.Add(-2 * time.Hour)},
+		{Kind: "role.add", Owner: "carol", EndTime: now.Add(-time.Minute)},
+	}}
+	store := &memoryStore{objects: map[string][]byte{}}
+	exporter := Exporter{Source: source, Store: store, Prefix: "audit", Delay: 5 * time.Minute}
+
+	t.Run(
+		"Export up to delay",
+		func(t *testing.T) {
+			export, err := exporter.Export(now)
+			if err != nil {
+				t.Fatalf("Export: check fail exporting (%v)", err)
+			}
+			if export.Records != 2 || len(store.objects) != 1 {
+				t.Fatalf("Export: check fail with %d records and %d objects", export.Records, len(store.objects))
+			}
+			if !export.Until.Equal(now.Add(-5 * time.Minute)) {
+				t.Fatalf("Export: check fail with checkpoint %v", export.Until)
+			}
+		})
+	t.Run(
+		"Checkpoint avoids re-export",
+		func(t *testing.T) {
+			export, err := exporter.Export(now.Add(time.Minute))
+			if err != nil {
+				t.Fatalf("Export: check fail exporting again (%v)", err)
+			}
+			if export.Records != 0 || len(store.objects) != 1 {
+				t.Fatalf("Export: check fail re-exporting %d records", export.Records)
+			}
+		})
+	t.Run(
+		"Export records after checkpoint",
+		func(t *testing.T) {
+			export, err := exporter.Export(now.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("Export: check fail exporting new records (%v)", err)
+			}
+			if export.Records != 1 || len(store.objects) != 2 {
+				t.Fatalf("Export: check fail with %d new records and %d objects", export.Records, len(store.objects))
+			}
+		})
+	t.Run(
+		"Store failure keeps checkpoint",
+		func(t *testing.T) {
+			source.records = append(source.records, types.AuditRecord{Kind: "role.remove", EndTime: now.Add(90 * time.Minute)})
+			checkpoint, _ := source.Checkpoint()
+			store.err = errors.New("unavailable")
+			if _, err := exporter.Export(now.Add(2 * time.Hour)); err == nil {
+				t.Fatalf("Export: check fail with store failure")
+			}
+			if after, _ := source.Checkpoint(); !after.Equal(checkpoint) {
+				t.Fatalf("Export: check fail advancing checkpoint after store failure (%v)", after)
+			}
+		})
+}
+
+func TestNDJSON(t *testing.T) {
+	records := []types.AuditRecord{{Kind: "cert.create", Owner: "alice"}, {Kind: "role.add", Owner: "bob"}}
+	body, err := NDJSON(records)
+	if err != nil {
+		t.Fatalf("NDJSON: check fail formatting (%v)", err)
+	}
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		t.Fatalf("NDJSON: check fail without trailing newline")
+	}
+	lines := bytes.Split(bytes.TrimSuffix(body, []byte("\n")), []byte("\n"))
+	if len(lines) != len(records) {
+		t.Fatalf("NDJSON: check fail with %d lines", len(lines))
+	}
+	for i, line := range lines {
+		record := new(types.AuditRecord)
+		if err := json.Unmarshal(line, record); err != nil || record.Owner != records[i].Owner {
+			t.Fatalf("NDJSON: check fail parsing line %d (%v)", i, err)
+		}
+	}
+}
+
+func TestObjectKey(t *testing.T) {
+	since := time.Date(2019, 6, 1, 11, 0, 0, 0, time.UTC)
+	until := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	if key := ObjectKey("audit", since, until); key != "audit/2019/06/01/20190601T110000Z-20190601T120000Z.ndjson" {
+		t.Fatalf("ObjectKey: check fail (%s)", key)
+	}
+}
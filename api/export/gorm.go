@@ -0,0 +1,44 @@
+package export
+
+import (
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"github.com/jinzhu/gorm"
+)
+
+// GormSource reads audit records and export checkpoints from GSH database. When Replica is
+// set, audit records are read from it, while checkpoints always use DB.
+type GormSource struct {
+	DB      *gorm.DB
+	Replica *gorm.DB
+}
+
+// Checkpoint returns the end of the latest export, or zero time if nothing was exported
+func (s GormSource) Checkpoint() (time.Time, error) {
+	last := new(types.AuditExport)
+	dbc := s.DB.Order("until desc").First(last)
+	if dbc.RecordNotFound() {
+		return time.Time{}, nil
+	}
+	if dbc.Error != nil {
+		return time.Time{}, dbc.Error
+	}
+	return last.Until, nil
+}
+
+// Records returns audit records with end time after since and until (inclusive)
+func (s GormSource) Records(since time.Time, until time.Time) ([]types.AuditRecord, error) {
+	var records []types.AuditRecord
+	db := s.DB
+	if s.Replica != nil {
+		db = s.Replica
+	}
+	dbc := db.Where("end_time > ? AND end_time <= ?", since, until).Order("end_time").Find(&records)
+	return records, dbc.Error
+}
+
+// SaveCheckpoint stores a finished export
+func (s GormSource) SaveCheckpoint(export types.AuditExport) error {
+	return s.DB.Create(&export).Error
+}
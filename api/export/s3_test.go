@@ -0,0 +1,63 @@
+package export
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3StorePut(t *testing.T) {
+	var method, path, authorization, payloadHash string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.EscapedPath()
+		authorization = r.Header.Get("Authorization")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	store := S3Store{
+		Endpoint:  server.URL,
+		Bucket:    "gsh-audit",
+		Region:    "us-east-1",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "secret",
+		now:       func() time.Time { return time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC) },
+	}
+	err := store.Put("audit/2019/06/01/records.ndjson", []byte("{}\n"))
+	if err != nil {
+		t.Fatalf("S3Store: check fail putting object (%v)", err)
+	}
+	if method != "PUT" || path != "/gsh-audit/audit/2019/06/01/records.ndjson" {
+		t.Fatalf("S3Store: check fail with request %s %s", method, path)
+	}
+	if string(body) != "{}\n" || payloadHash != sha256Hex([]byte("{}\n")) {
+		t.Fatalf("S3Store: check fail with body %q (%s)", body, payloadHash)
+	}
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20190601/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Fatalf("S3Store: check fail with authorization %s", authorization)
+	}
+}
+
+func TestS3StorePutError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	store := S3Store{Endpoint: server.URL, Bucket: "gsh-audit", Region: "us-east-1"}
+	if err := store.Put("audit/records.ndjson", []byte("{}\n")); err == nil {
+		t.Fatalf("S3Store: check fail with forbidden response")
+	}
+}
+
+func TestEscapeKey(t *testing.T) {
+	if key := escapeKey("audit/a b:c~d.ndjson"); key != "audit/a%20b%3Ac~d.ndjson" {
+		t.Fatalf("escapeKey: check fail (%s)", key)
+	}
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/globocom/gsh/api/approvals"
+	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/api/permissions"
+	"github.com/globocom/gsh/types"
+	"github.com/gofrs/uuid"
+	"github.com/labstack/echo"
+)
+
+// createApproval stores a certificate request that must wait for approval and responds 202 with its ID
+func (h AppHandler) createApproval(c echo.Context, certRequest *types.CertRequest, username string, jti string, approvedRoles []string, initTime time.Time) error {
+	approval := types.CertApproval{
+		UID:        uuid.Must(uuid.NewV4()),
+		Status:     types.ApprovalPending,
+		Requester:  username,
+		Roles:      strings.Join(approvedRoles, ","),
+		ExpiresAt:  initTime.Add(h.config.GetDuration("approval_expiration")),
+		JTI:        jti,
+		Command:    certRequest.Command,
+		Key:        certRequest.Key,
+		RemoteUser: certRequest.RemoteUser,
+		RemoteHost: certRequest.RemoteHost,
+		RemotePort: certRequest.RemotePort,
+		UserIP:     certRequest.UserIP,
+		Reason:     certRequest.Reason,
+		ModifiedAt: initTime,
+	}
+	dbc := h.db.Create(&approval)
+	if h.db.NewRecord(&approval) {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error storing certificate request", "details": dbc.Error.Error()})
+	}
+
+	finishTime := time.Now()
+	go func() {
+		h.auditChannel <- types.AuditRecord{
+			UID:       uuid.Must(uuid.NewV4()),
+			StartTime: initTime,
+			EndTime:   finishTime,
+			Kind:      "cert.request",
+			TargetUID: approval.UID,
+			TargetID:  approval.ID,
+			Owner:     username,
+			JTI:       jti,
+			Log:       fmt.Sprintf("Waiting approval for roles: %s", approval.Roles),
+		}
+	}()
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"result":     "pending",
+		"message":    "Certificate request is waiting for approval",
+		"request_id": approval.UID.String(),
+		"status":     approval.Status,
+		"expires_at": approval.ExpiresAt.Format(time.RFC3339),
+	})
+}
+
+// GetApprovals lists certificate requests waiting for approval
+func (h AppHandler) GetApprovals(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user listing requests has permission to do so
+	if !h.isApprover(username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't list certificate requests"})
+	}
+
+	var pending []types.CertApproval
+	dbc := h.reader().Where("status = ?", types.ApprovalPending).Order("created_at").Find(&pending)
+	if dbc.Error != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading certificate requests", "details": dbc.Error.Error()})
+	}
+
+	now := time.Now()
+	requests := []types.CertApproval{}
+	for i := range pending {
+		if approvals.Expire(&pending[i], now) {
+			h.db.Save(&pending[i])
+			continue
+		}
+		requests = append(requests, pending[i])
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"result": "success", "requests": requests})
+}
+
+// GetApproval returns the status of a certificate request. When the requester asks for an
+// approved request, the certificate is issued and returned.
+func (h AppHandler) GetApproval(c echo.Context) error {
+	initTime := time.Now()
+
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+	jti := c.Get("JTI").(string)
+
+	approval, httpErr := h.findApproval(c.Param("id"))
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, httpErr.Message)
+	}
+
+	// Only the requester receives the certificate, approvers can check the request
+	if approval.Requester != username {
+		if !h.isApprover(username) {
+			return c.JSON(http.StatusForbidden,
+				map[string]string{"result": "fail", "message": "This user can't read this certificate request"})
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"result": "success", "request": approval})
+	}
+
+	switch approval.Status {
+	case types.ApprovalPending:
+		return c.JSON(http.StatusAccepted, map[string]string{
+			"result":     "pending",
+			"message":    "Certificate request is waiting for approval",
+			"request_id": approval.UID.String(),
+			"status":     approval.Status,
+			"expires_at": approval.ExpiresAt.Format(time.RFC3339),
+		})
+	case types.ApprovalDenied:
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"result":     "fail",
+			"message":    fmt.Sprintf("Certificate request denied by %s", approval.Approver),
+			"request_id": approval.UID.String(),
+			"status":     approval.Status,
+		})
+	case types.ApprovalExpired:
+		return c.JSON(http.StatusGone, map[string]string{
+			"result":     "fail",
+			"message":    "Certificate request expired",
+			"request_id": approval.UID.String(),
+			"status":     approval.Status,
+		})
+	case types.ApprovalIssued:
+		return c.JSON(http.StatusConflict, map[string]string{
+			"result":     "fail",
+			"message":    "Certificate already issued for this request",
+			"request_id": approval.UID.String(),
+			"status":     approval.Status,
+		})
+	}
+
+	localUser, err := h.identityPrincipal(c, username)
+	if err != nil {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "Error transforming identity into principal", "details": err.Error()})
+	}
+
+	// Roles are authorized again under the current policy: a role removed or unassigned while
+	// the request waited for approval doesn't issue the certificate
+	certRequest := &types.CertRequest{
+		Command:    approval.Command,
+		Key:        approval.Key,
+		RemoteUser: approval.RemoteUser,
+		RemoteHost: approval.RemoteHost,
+		RemotePort: approval.RemotePort,
+		UserIP:     approval.UserIP,
+		Reason:     approval.Reason,
+	}
+	if err := h.permEnforcer.LoadPolicy(); err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
+	}
+	currentRoles, err := h.authorizingRoles(h.effectiveRoles(c, username), certRequest, localUser)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error using enforcer to authorize certificate", "details": err.Error()})
+	}
+	approvedRoles := approvals.StillAuthorizing(strings.Split(approval.Roles, ","), currentRoles)
+	if len(approvedRoles) == 0 {
+		finishTime := time.Now()
+		go func() {
+			h.auditChannel <- types.AuditRecord{
+				UID:       uuid.Must(uuid.NewV4()),
+				StartTime: initTime,
+				EndTime:   finishTime,
+				Kind:      "cert.create",
+				TargetUID: approval.UID,
+				TargetID:  approval.ID,
+				Owner:     username,
+				JTI:       jti,
+				Error:     "You don't have permission to request this certificate",
+				Log:       fmt.Sprintf("Roles approved at request %s no longer authorize it: %s", approval.UID.String(), approval.Roles),
+			}
+		}()
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"result":     "fail",
+			"message":    "Roles approved for this request no longer authorize it",
+			"details":    fmt.Sprintf("Approved roles: %s", approval.Roles),
+			"request_id": approval.UID.String(),
+		})
+	}
+
+	// Certificate extensions and principals are granted by the roles that authorized the request
+	extensions, err := h.certExtensions(approvedRoles)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading role extensions", "details": err.Error()})
+	}
+
+	// Approved certificates count on the quota as any other. The request is marked as issued before
+	// signing, together with the quota check, so only one certificate is issued and within the quota.
+	release, httpErr := h.reserveIssuance(username, initTime, func() *echo.HTTPError {
+		dbc := h.db.Model(&types.CertApproval{}).
+			Where("id = ? AND status = ?", approval.ID, types.ApprovalApproved).
+			Updates(map[string]interface{}{"status": types.ApprovalIssued, "modified_at": time.Now()})
+		if dbc.Error != nil || dbc.RowsAffected != 1 {
+			return echo.NewHTTPError(http.StatusConflict, map[string]string{
+				"result":     "fail",
+				"message":    "Certificate already issued for this request",
+				"request_id": approval.UID.String(),
+			})
+		}
+		return nil
+	})
+	if httpErr != nil {
+		if httpErr.Code == http.StatusTooManyRequests {
+			finishTime := time.Now()
+			go func() {
+				h.auditChannel <- types.AuditRecord{
+					UID:       uuid.Must(uuid.NewV4()),
+					StartTime: initTime,
+					EndTime:   finishTime,
+					Kind:      "cert.create",
+					TargetUID: approval.UID,
+					TargetID:  approval.ID,
+					Owner:     username,
+					JTI:       jti,
+					Error:     errQuotaExceeded.Error(),
+					Log:       fmt.Sprintf("Certificate of request %s refused by quota", approval.UID.String()),
+				}
+			}()
+		}
+		return c.JSON(httpErr.Code, httpErr.Message)
+	}
+	defer release()
+
+	certRequest.Extensions = extensions
+	certRequest.Principals = permissions.CertPrincipals(approvedRoles, h.policyFor, approval.RemoteUser, localUser)
+	certRequest.Renewable = certRenewable(map[string][]string{approval.RemoteUser: approvedRoles}, h.config.GetStringSlice("renewable_roles"))
+	if expiry, ok := c.Get("token_expiry").(time.Time); ok {
+		certRequest.TokenExpiry = expiry
+	}
+	signedKey, httpErr := h.signCertificate(certRequest, username)
+	if httpErr != nil {
+		// rollback to approved, allowing the requester to try again
+		h.db.Model(&types.CertApproval{}).Where("id = ?", approval.ID).Update("status", types.ApprovalApproved)
+		return c.JSON(httpErr.Code, httpErr.Message)
+	}
+	h.db.Model(&types.CertApproval{}).Where("id = ?", approval.ID).Update("cert_request_id", certRequest.ID)
+
+	// sending auditRecord
+	finishTime := time.Now()
+	go func() {
+		h.auditChannel <- types.AuditRecord{
+			UID:       uuid.Must(uuid.NewV4()),
+			StartTime: initTime,
+			EndTime:   finishTime,
+			Kind:      "cert.create",
+			TargetUID: certRequest.UID,
+			TargetID:  certRequest.ID,
+			Owner:     username,
+			JTI:       jti,
+			Roles:     strings.Join(approvedRoles, ","),
+			Log:       authorizedLog(fmt.Sprintf("Request %s approved by %s", approval.UID.String(), approval.Approver), approvedRoles),
+		}
+	}()
+	return c.JSON(http.StatusOK, types.CertResponse{
+		Result:      "success",
+		Message:     certRequest.ValidityWarning,
+		Certificate: signedKey,
+		Roles:       approvedRoles,
+		Renewable:   certRequest.Renewable,
+		RemoteUser:  approval.RemoteUser,
+		RemoteHost:  approval.RemoteHost,
+		ValidAfter:  certRequest.ValidAfter,
+		ValidBefore: certRequest.ValidBefore,
+	})
+}
+
+// ApproveApproval approves a pending certificate request
+func (h AppHandler) ApproveApproval(c echo.Context) error {
+	return h.decideApproval(c, "cert.approve", approvals.Approve)
+}
+
+// DenyApproval denies a pending certificate request
+func (h AppHandler) DenyApproval(c echo.Context) error {
+	return h.decideApproval(c, "cert.deny", approvals.Deny)
+}
+
+// decideApproval applies decide (approve or deny) over the certificate request at :id
+func (h AppHandler) decideApproval(c echo.Context, kind string, decide func(*types.CertApproval, string, time.Time) error) error {
+	initTime := time.Now()
+
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+	jti := c.Get("JTI").(string)
+
+	// Validates if the user deciding the request has permission to do so
+	if !h.isApprover(username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't decide certificate requests"})
+	}
+
+	approval, httpErr := h.findApproval(c.Param("id"))
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, httpErr.Message)
+	}
+
+	err = decide(approval, username, time.Now())
+	switch err {
+	case nil:
+	case approvals.ErrExpired:
+		h.db.Save(approval)
+		return c.JSON(http.StatusGone,
+			map[string]string{"result": "fail", "message": "Certificate request expired", "details": err.Error()})
+	case approvals.ErrSelfApproval:
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "Requester can't decide his own certificate request", "details": err.Error()})
+	default:
+		return c.JSON(http.StatusConflict,
+			map[string]string{"result": "fail", "message": fmt.Sprintf("Certificate request is %s", approval.Status), "details": err.Error()})
+	}
+
+	// only a pending request is decided, two approvers acting at the same time can't both succeed
+	dbc := h.db.Model(&types.CertApproval{}).
+		Where("id = ? AND status = ?", approval.ID, types.ApprovalPending).
+		Updates(map[string]interface{}{"status": approval.Status, "approver": approval.Approver,
+			"decided_at": approval.DecidedAt, "modified_at": approval.ModifiedAt})
+	if dbc.Error != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error storing certificate request", "details": dbc.Error.Error()})
+	}
+	if dbc.RowsAffected != 1 {
+		return c.JSON(http.StatusConflict,
+			map[string]string{"result": "fail", "message": "Certificate request was already decided", "details": approvals.ErrNotPending.Error()})
+	}
+
+	finishTime := time.Now()
+	go func() {
+		h.auditChannel <- types.AuditRecord{
+			UID:       uuid.Must(uuid.NewV4()),
+			StartTime: initTime,
+			EndTime:   finishTime,
+			Kind:      kind,
+			TargetUID: approval.UID,
+			TargetID:  approval.ID,
+			Owner:     username,
+			JTI:       jti,
+			Log:       fmt.Sprintf("Request from %s to %s@%s", approval.Requester, approval.RemoteUser, approval.RemoteHost),
+		}
+	}()
+	return c.JSON(http.StatusOK, map[string]string{
+		"result":     "success",
+		"message":    fmt.Sprintf("Certificate request %s", approval.Status),
+		"request_id": approval.UID.String(),
+		"status":     approval.Status,
+	})
+}
+
+// findApproval reads the certificate request with UID id, expiring it if needed
+func (h AppHandler) findApproval(id string) (*types.CertApproval, *echo.HTTPError) {
+	uid, err := uuid.FromString(id)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid request ID", "details": err.Error()})
+	}
+
+	approval := new(types.CertApproval)
+	if h.db.Where("uid = ?", uid.String()).First(approval).RecordNotFound() {
+		return nil, echo.NewHTTPError(http.StatusNotFound,
+			map[string]string{"result": "fail", "message": "Certificate request not found"})
+	}
+
+	if approvals.Expire(approval, time.Now()) {
+		h.db.Save(approval)
+	}
+	return approval, nil
+}
+
+// isApprover tells whether username can decide certificate requests
+func (h AppHandler) isApprover(username string) bool {
+	return contains(h.config.GetStringSlice("perm_admin"), username) ||
+		contains(h.config.GetStringSlice("perm_approver"), username)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/api/stream"
+	"github.com/labstack/echo"
+)
+
+// auditStreamKeepAlive is the interval between comments sent to keep idle streams open
+const auditStreamKeepAlive = 15 * time.Second
+
+// AuditStream streams certificate issuances as server-sent events, each one with the event time
+// as id. Clients reconnecting with Last-Event-ID receive the events after it.
+//
+// - Query parameters: user (owner of the certificate) and host (remote host)
+//
+// - Output sample
+//
+//	id: 2019-01-01T10:00:01.123456789Z
+//	event: issuance
+//	data: {"audit_uid":"...","time":"2019-01-01T10:00:01.123456789Z","kind":"cert.create","owner":"alice","cert_uid":"...","key_id":"alice-...","remote_user":"alice","remote_host":"198.51.100.10","user_ip":"192.0.2.10"}
+func (h AppHandler) AuditStream(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user streaming audit has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't stream audit records"})
+	}
+
+	since := time.Now()
+	if lastEventID := c.Request().Header.Get("Last-Event-ID"); lastEventID != "" {
+		since, err = time.Parse(time.RFC3339Nano, lastEventID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest,
+				map[string]string{"result": "fail", "message": "Invalid Last-Event-ID", "details": err.Error()})
+		}
+	}
+	filter := stream.Filter{User: c.QueryParam("user"), Host: c.QueryParam("host")}
+	tail := stream.NewTail(stream.GormSource{DB: h.reader()}, filter, since, h.config.GetDuration("audit_stream_overlap"))
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	poll := time.NewTicker(h.config.GetDuration("audit_stream_interval"))
+	defer poll.Stop()
+	keepAlive := time.NewTicker(auditStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-poll.C:
+			events, err := tail.Poll()
+			if err != nil {
+				fmt.Fprintf(res, "event: error\ndata: %s\n\n", "Error reading audit records")
+				res.Flush()
+				continue
+			}
+			for _, event := range events {
+				data, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(res, "id: %s\nevent: issuance\ndata: %s\n\n", event.Time.UTC().Format(time.RFC3339Nano), data); err != nil {
+					return nil
+				}
+			}
+			res.Flush()
+		}
+	}
+}
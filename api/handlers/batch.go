@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/types"
+	"github.com/labstack/echo"
+)
+
+// batchContextKeys are the values set by authentication that items of a batch read from context
+var batchContextKeys = []string{"JTI", "token_expiry", "principal", "groups"}
+
+// CertBatch issues certificates for an array of types.CertRequest in one round trip. Each item is
+// authorized and issued on its own, as by CertCreate, so results are per item: the status code and
+// response CertCreate would return for it, in the order of the request.
+func (h AppHandler) CertBatch(c echo.Context) error {
+	// echo binds only structs, the array is decoded as it is
+	var certRequests []types.CertRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&certRequests); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Importing data requested in []types.CertRequest struct", "details": err.Error()})
+	}
+	limit := h.config.GetInt("cert_batch_limit")
+	if len(certRequests) == 0 || len(certRequests) > limit {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid number of certificate requests", "details": "A batch has from 1 to " + strconv.Itoa(limit) + " requests"})
+	}
+
+	// Validating JWT before any other action, once for the whole batch
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Authentication failed", "details": err.Error()})
+	}
+	jti := c.Get("JTI").(string)
+
+	// items are issued one after the other, limits (signers, impersonation) apply to each of them
+	results := make([]types.CertBatchResult, len(certRequests))
+	for i := range certRequests {
+		results[i] = h.batchItem(c, &certRequests[i], username, jti)
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// batchItem issues the certificate of one item of a batch, recording the response of
+// createCertificate instead of writing it at c
+func (h AppHandler) batchItem(c echo.Context, certRequest *types.CertRequest, username string, jti string) types.CertBatchResult {
+	recorder := &batchRecorder{header: http.Header{}}
+	item := c.Echo().NewContext(c.Request(), recorder)
+	for _, key := range batchContextKeys {
+		item.Set(key, c.Get(key))
+	}
+	item.Response().Header().Set(echo.HeaderXRequestID, c.Response().Header().Get(echo.HeaderXRequestID))
+
+	if err := h.createCertificate(item, certRequest, username, jti, time.Now()); err != nil {
+		body, _ := json.Marshal(map[string]string{"result": "fail", "message": "Error issuing certificate", "details": err.Error()})
+		return types.CertBatchResult{Status: http.StatusInternalServerError, Response: body}
+	}
+	return types.CertBatchResult{Status: recorder.status, Response: bytes.TrimSpace(recorder.body.Bytes())}
+}
+
+// batchRecorder is the http.ResponseWriter of batch items, keeping status and body of the response
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *batchRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *batchRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *batchRecorder) WriteHeader(status int) {
+	r.status = status
+}
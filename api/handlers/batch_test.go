@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin"
+	fileadapter "github.com/casbin/casbin/persist/file-adapter"
+	"github.com/globocom/gsh/api/permissions"
+	"github.com/globocom/gsh/types"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+func TestCertBatch(t *testing.T) {
+	t.Run(
+		"Invalid number of requests",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("cert_batch_limit", 2)
+			h := AppHandler{config: *config}
+			for _, body := range []string{`[]`, `[{}, {}, {}]`} {
+				req := httptest.NewRequest(http.MethodPost, "/certificates/batch", strings.NewReader(body))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				rec := httptest.NewRecorder()
+				if err := h.CertBatch(echo.New().NewContext(req, rec)); err != nil || rec.Code != http.StatusBadRequest {
+					t.Fatalf("CertBatch: check fail with batch %s (%d, %v)", body, rec.Code, err)
+				}
+			}
+		})
+}
+
+func TestBatchItem(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	if err := os.WriteFile(policy, []byte("p, web, alice, 0.0.0.0/0, 10.0.0.1, permit-pty\ng, alice, web\n"), 0600); err != nil {
+		t.Fatalf("batchItem: check fail writing policy (%v)", err)
+	}
+	e := casbin.NewEnforcer(permissions.Model(), fileadapter.NewAdapter(policy))
+	e.AddFunction("ipMultipleMatch", permissions.IPMultipleMatchFunc)
+	config := viper.New()
+	config.Set("min_token_remaining", time.Minute)
+	h := AppHandler{config: *config, permEnforcer: e, auditChannel: make(chan types.AuditRecord, 10), logChannel: make(chan map[string]interface{}, 10)}
+	newContext := func() echo.Context {
+		req := httptest.NewRequest(http.MethodPost, "/certificates/batch", nil)
+		return echo.New().NewContext(req, httptest.NewRecorder())
+	}
+
+	t.Run(
+		"Mixed results",
+		func(t *testing.T) {
+			c := newContext()
+			items := []types.CertRequest{
+				{RemoteUser: "alice", RemoteHost: "10.0.0.1", RemotePort: "http", UserIP: "10.1.0.1"},
+				{RemoteUser: "alice", RemoteHost: "10.0.0.1", UserIP: "10.1.0.1", Reason: "<script>"},
+				{RemoteUser: "alice", RemoteHost: "10.0.0.2", UserIP: "10.1.0.1"},
+			}
+			expected := []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusForbidden}
+			for i := range items {
+				result := h.batchItem(c, &items[i], "alice", "jti")
+				response := map[string]string{}
+				if err := json.Unmarshal(result.Response, &response); err != nil {
+					t.Fatalf("batchItem: check fail parsing response %d (%v)", i, err)
+				}
+				if result.Status != expected[i] || response["result"] != "fail" {
+					t.Fatalf("batchItem: check fail with item %d (%d, %s)", i, result.Status, result.Response)
+				}
+			}
+			if rec := c.Response().Writer.(*httptest.ResponseRecorder); rec.Body.Len() != 0 {
+				t.Fatalf("batchItem: check fail, item written at batch response (%s)", rec.Body.String())
+			}
+		})
+	t.Run(
+		"Authentication values of batch",
+		func(t *testing.T) {
+			c := newContext()
+			c.Set("token_expiry", time.Now().Add(time.Second))
+			result := h.batchItem(c, &types.CertRequest{RemoteUser: "alice", RemoteHost: "10.0.0.1", UserIP: "10.1.0.1"}, "alice", "jti")
+			if result.Status != http.StatusUnauthorized {
+				t.Fatalf("batchItem: check fail with token expiring (%d, %s)", result.Status, result.Response)
+			}
+		})
+	t.Run(
+		"Principal claim of batch",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("principal_claim", "uid")
+			h := AppHandler{config: *config, permEnforcer: e, auditChannel: make(chan types.AuditRecord, 10), logChannel: make(chan map[string]interface{}, 10)}
+			c := newContext()
+			c.Set("principal", "alice")
+			result := h.batchItem(c, &types.CertRequest{RemoteUser: "alice", RemoteHost: "10.0.0.2", UserIP: "10.1.0.1"}, "alice", "jti")
+			response := map[string]string{}
+			if err := json.Unmarshal(result.Response, &response); err != nil {
+				t.Fatalf("batchItem: check fail parsing response (%v)", err)
+			}
+			if result.Status != http.StatusForbidden || response["message"] != "You don't have permission to request this certificate" {
+				t.Fatalf("batchItem: check fail with principal_claim (%d, %s)", result.Status, result.Response)
+			}
+		})
+	t.Run(
+		"Remote user folded before validation",
+		func(t *testing.T) {
+			for principalCase, expected := range map[string]int{"lower": http.StatusForbidden, "upper": http.StatusBadRequest, "preserve": http.StatusBadRequest} {
+				config := viper.New()
+				config.Set("remote_user_pattern", "[a-z]+")
+				config.Set("principal_case", principalCase)
+				h := AppHandler{config: *config, permEnforcer: e, auditChannel: make(chan types.AuditRecord, 10), logChannel: make(chan map[string]interface{}, 10)}
+				result := h.batchItem(newContext(), &types.CertRequest{RemoteUser: "Alice", RemoteHost: "10.0.0.2", UserIP: "10.1.0.1"}, "alice", "jti")
+				if result.Status != expected {
+					t.Fatalf("batchItem: check fail with principal_case %s (%d, %s)", principalCase, result.Status, result.Response)
+				}
+			}
+		})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/globocom/gsh/api/breakglass"
+	"github.com/globocom/gsh/api/permissions"
+	"github.com/globocom/gsh/types"
+	"github.com/gofrs/uuid"
+	"github.com/labstack/echo"
+)
+
+// createBreakGlass issues a certificate using break-glass roles. These roles ignore source ip and
+// remote host restrictions, but security is alerted before the certificate is signed.
+func (h AppHandler) createBreakGlass(c echo.Context, certRequest *types.CertRequest, username string, localUser string, jti string, myRoles []string, initTime time.Time) error {
+	var approvedRoles []string
+	for _, role := range breakglass.Roles(myRoles, h.config.GetStringSlice("breakglass_roles")) {
+		for _, policy := range h.permEnforcer.GetFilteredPolicy(0, role) {
+			if allowed, _ := permissions.ExplainBreakGlass(policy, certRequest.RemoteUser, "permit-pty", localUser); allowed {
+				approvedRoles = append(approvedRoles, role)
+				break
+			}
+		}
+	}
+	if len(approvedRoles) == 0 {
+		finishTime := time.Now()
+		go func() {
+			h.auditChannel <- types.AuditRecord{
+				UID:       uuid.Must(uuid.NewV4()),
+				StartTime: initTime,
+				EndTime:   finishTime,
+				Kind:      "cert.breakglass",
+				Owner:     username,
+				JTI:       jti,
+				Error:     "You don't have break-glass permission to request this certificate",
+				Log:       fmt.Sprintf("Break-glass to %s@%s from %s (real ip %s), your roles are: %v", certRequest.RemoteUser, certRequest.RemoteHost, certRequest.UserIP, c.RealIP(), myRoles),
+			}
+		}()
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "You don't have break-glass permission to request this certificate", "details": fmt.Sprintf("Your roles are: %v", myRoles)})
+	}
+
+	// Certificate extensions are granted by the break-glass roles that authorized the request
+	extensions, err := h.certExtensions(approvedRoles)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading role extensions", "details": err.Error()})
+	}
+	certRequest.Extensions = extensions
+	certRequest.Principals = permissions.CertPrincipals(approvedRoles, h.policyFor, certRequest.RemoteUser, localUser)
+
+	// Security must be alerted before issuing, a certificate is never issued without alert
+	alert := breakglass.Alert{
+		Event:      "cert.breakglass",
+		Requester:  username,
+		Roles:      approvedRoles,
+		RemoteUser: certRequest.RemoteUser,
+		RemoteHost: certRequest.RemoteHost,
+		UserIP:     certRequest.UserIP,
+		RealIP:     c.RealIP(),
+		Reason:     certRequest.Reason,
+		JTI:        jti,
+		Time:       initTime,
+	}
+	webhook := breakglass.Webhook{
+		URL:    h.config.GetString("breakglass_webhook_url"),
+		Client: &http.Client{Timeout: h.config.GetDuration("breakglass_webhook_timeout")},
+	}
+	err = breakglass.Authorize(alert, webhook)
+	if err == breakglass.ErrReasonRequired {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Reason is required to use break-glass roles", "details": err.Error()})
+	}
+	if err != nil {
+		finishTime := time.Now()
+		go func() {
+			h.auditChannel <- types.AuditRecord{
+				UID:       uuid.Must(uuid.NewV4()),
+				StartTime: initTime,
+				EndTime:   finishTime,
+				Kind:      "cert.breakglass",
+				Owner:     username,
+				JTI:       jti,
+				Error:     err.Error(),
+				Log:       fmt.Sprintf("Break-glass alert failed, certificate not issued for roles: %s", strings.Join(approvedRoles, ",")),
+			}
+		}()
+		return c.JSON(http.StatusServiceUnavailable,
+			map[string]string{"result": "fail", "message": "Error alerting security, break-glass certificate not issued", "details": err.Error()})
+	}
+
+	signedKey, httpErr := h.signCertificate(certRequest, username)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, httpErr.Message)
+	}
+
+	// sending a detailed auditRecord
+	finishTime := time.Now()
+	go func() {
+		h.auditChannel <- types.AuditRecord{
+			UID:       uuid.Must(uuid.NewV4()),
+			StartTime: initTime,
+			EndTime:   finishTime,
+			Kind:      "cert.breakglass",
+			TargetUID: certRequest.UID,
+			TargetID:  certRequest.ID,
+			Owner:     username,
+			JTI:       jti,
+			Roles:     strings.Join(approvedRoles, ","),
+			Log: fmt.Sprintf("Break-glass roles [%s] to %s@%s from %s (real ip %s) key id [%s] key [%s] certificate [%s] valid before [%s] reason: %s",
+				strings.Join(approvedRoles, ","), certRequest.RemoteUser, certRequest.RemoteHost, certRequest.UserIP, c.RealIP(),
+				certRequest.KeyID, certRequest.KeyFingerprint, certRequest.CertFingerprint, certRequest.ValidBefore.Format(time.RFC3339), certRequest.Reason),
+		}
+	}()
+	return c.JSON(http.StatusOK, types.CertResponse{
+		Result:      "success",
+		Message:     certRequest.ValidityWarning,
+		Certificate: signedKey,
+		BreakGlass:  strings.Join(approvedRoles, ","),
+		ValidAfter:  certRequest.ValidAfter,
+		ValidBefore: certRequest.ValidBefore,
+	})
+}
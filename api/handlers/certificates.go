@@ -1,16 +1,23 @@
 package handlers
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/globocom/gsh/api/approvals"
 	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/api/permissions"
+	"github.com/globocom/gsh/api/principal"
 	"github.com/globocom/gsh/types"
 	"github.com/gofrs/uuid"
 	"github.com/labstack/echo"
@@ -54,7 +61,9 @@ import (
 // 5XU0V/vVeucS12UF06HG9r+J51u0KMA/3dN4WNG6GKDrzY5M5Uad7lWnDNtbjRnhPVPCxHgV5YQL
 // O6k94+kaPZbR+bVWb5tAOMoC1XHBwwDNLDqUKs2C8lvEpJY0Mf7ag9SNSep0Q5isq97zY3CWwPCt
 // pYTN9tkQpfn+Noe4H7yOP2mkpAs3i7j/u0+Zz6SHejy4A7HlGHfJvWrOyg8J0ZzBSl5ho5eAw4Lr
-// t+xcTVkFgWWPcml7CFiGwFhbui4w==
+// t+xcTVkFgWWPcml7CFiGwFhbui4w==",
+// "valid_after": "2018-12-09T21:06:05-02:00",
+// "valid_before": "2018-12-09T21:16:35-02:00"
 // }
 func (h AppHandler) CertCreate(c echo.Context) error {
 	initTime := time.Now()
@@ -88,27 +97,134 @@ func (h AppHandler) CertCreate(c echo.Context) error {
 	}
 	jti := c.Get("JTI").(string)
 
+	return h.createCertificate(c, certRequest, username, jti, initTime)
+}
+
+// createCertificate authorizes and issues the certificate of certRequest, requested by the
+// authenticated username, responding at c. It is used by CertCreate and each item of CertBatch.
+func (h AppHandler) createCertificate(c echo.Context, certRequest *types.CertRequest, username string, jti string, initTime time.Time) error {
+	var err error
+
+	// Client must prove it holds the private key of the submitted public key
+	if err := verifyKeyProof(certRequest, time.Now(), h.config.GetBool("ca_require_key_proof")); err != nil {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "Invalid proof of possession of the key", "details": err.Error()})
+	}
+	if certRequest.KeyProof != nil && certRequest.KeyProof.Nonce != "" {
+		if err := h.consumeChallenge(certRequest.KeyProof.Nonce, username, time.Now()); err != nil {
+			return c.JSON(http.StatusForbidden,
+				map[string]string{"result": "fail", "message": "Invalid challenge of the key proof", "details": err.Error()})
+		}
+	}
+
+	// Certificates are not issued to tokens about to expire, user would be unauthenticated moments later
+	if expiry, ok := c.Get("token_expiry").(time.Time); ok {
+		certRequest.TokenExpiry = expiry
+		if err := checkTokenRemaining(expiry, time.Now(), h.config.GetDuration("min_token_remaining")); err != nil {
+			return c.JSON(http.StatusUnauthorized,
+				map[string]string{"result": "fail", "message": "Token expires too soon, run gsh login", "details": err.Error()})
+		}
+	}
+
+	// Validating reason, it will be logged and can be embedded in certificate
+	certRequest.Reason = strings.TrimSpace(certRequest.Reason)
+	if err := validateReason(certRequest.Reason); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid reason", "details": err.Error()})
+	}
+
+	// Requested principals are folded as the principal of the identity (principal_case) before
+	// being validated and authorized
+	certRequest.RemoteUser = principal.Fold(certRequest.RemoteUser, h.principalCase())
+	for i := range certRequest.ExtraPrincipals {
+		certRequest.ExtraPrincipals[i] = principal.Fold(certRequest.ExtraPrincipals[i], h.principalCase())
+	}
+
+	// Validating remote user, the principal of the certificate, against remote_user_pattern
+	if err := validateRemoteUser(certRequest.RemoteUser, h.config.GetString("remote_user_pattern")); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid remote user", "details": err.Error()})
+	}
+
+	// Admins request certificates on behalf of a user (impersonation) only with the user's roles
+	impersonating := certRequest.Impersonate != ""
+	if impersonating {
+		if certRequest.BreakGlass || certRequest.Reason == "" {
+			return c.JSON(http.StatusBadRequest,
+				map[string]string{"result": "fail", "message": "Invalid impersonation", "details": "Impersonation requires a reason and can't use break-glass"})
+		}
+		if httpErr := h.impersonate(certRequest, username, jti, initTime); httpErr != nil {
+			return c.JSON(httpErr.Code, httpErr.Message)
+		}
+		username = certRequest.Impersonate
+	}
+
+	// Extra principals are only issued by regular requests, never by break-glass
+	certRequest.ExtraPrincipals, err = validateExtraPrincipals(certRequest.RemoteUser, certRequest.ExtraPrincipals, h.config.GetString("remote_user_pattern"))
+	if err == nil && certRequest.BreakGlass && len(certRequest.ExtraPrincipals) > 0 {
+		err = errors.New("break-glass certificates can't have extra principals")
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid principals", "details": err.Error()})
+	}
+
+	// Without user ip informed, the client ip seen by API is used (see trusted_proxies)
+	if certRequest.UserIP == "" {
+		certRequest.UserIP = c.RealIP()
+	}
+
+	// Destination port is checked against roles with destination ports (default is 22)
+	if err := validatePort(certRequest.RemotePort); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid remote port", "details": err.Error()})
+	}
+
 	// Get user roles
 	err = h.permEnforcer.LoadPolicy()
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError,
 			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
 	}
-	myRoles := h.permEnforcer.GetRolesForUser(username)
+	myRoles := h.effectiveRoles(c, username)
+	if impersonating {
+		// groups at token are the admin's, only roles assigned to the impersonated user count
+		myRoles = permissions.EffectiveRoles(h.permEnforcer.GetRolesForUser, username, nil)
+	}
 
-	// Check permissions
-	var approved bool
-	for _, role := range myRoles {
-		result, err := h.permEnforcer.EnforceSafe(role, certRequest.RemoteUser, certRequest.UserIP, certRequest.RemoteHost, "permit-pty", username)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError,
-				map[string]string{"result": "fail", "message": "Error using enforcer to authorize certificate", "details": err.Error()})
-		}
-		if result {
-			approved = true
-		}
+	// Principal of the authenticated identity, matched by roles allowing the user's own login (".").
+	// The token is the admin's while impersonating, so the impersonated username is used.
+	var localUser string
+	if impersonating {
+		localUser, err = h.principalFor(username)
+	} else {
+		localUser, err = h.identityPrincipal(c, username)
+	}
+	if err != nil {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "Error transforming identity into principal", "details": err.Error()})
+	}
+
+	// Break-glass requests use only roles flagged with breakglass_roles
+	if certRequest.BreakGlass {
+		return h.createBreakGlass(c, certRequest, username, localUser, jti, myRoles, initTime)
+	}
+
+	// Check permissions, break-glass roles never authorize regular requests
+	approvedRoles, err := h.authorizingRoles(myRoles, certRequest, localUser)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error using enforcer to authorize certificate", "details": err.Error()})
 	}
-	if !approved {
+	if len(approvedRoles) == 0 {
+		// logging why each role denied the request, correlated by request id
+		requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+		decisions := permissions.Decide(myRoles, h.policyFor, certRequest.RemoteUser, certRequest.UserIP, certRequest.RemoteHost, certRequest.RemotePort, "permit-pty", localUser)
+		logRecord := authzDenialLog(username, requestID, c.RealIP(), certRequest, decisions)
+		go func() {
+			h.logChannel <- logRecord
+		}()
+
 		finishTime := time.Now()
 		go func() {
 			h.auditChannel <- types.AuditRecord{
@@ -123,111 +239,231 @@ func (h AppHandler) CertCreate(c echo.Context) error {
 			}
 		}()
 		return c.JSON(http.StatusForbidden,
-			map[string]string{"result": "fail", "message": "You don't have permission to request this certificate", "details": fmt.Sprintf("Your roles are: %v", myRoles)})
+			map[string]string{"result": "fail", "message": "You don't have permission to request this certificate", "details": fmt.Sprintf("Your roles are: %v", myRoles), "request_id": requestID})
 	}
 
-	// Initializing vault
-	v := Vault{h.config.GetString("ca_role_id"), h.config.GetString("ca_external_secret_id"), h.config, ""}
-	// Set our certificate validity times
-	certRequest.ValidAfter = time.Now().Add(-30 * time.Second)
-	certRequest.ModifiedAt = time.Now()
-	certRequest.ValidBefore = time.Now().Add(h.config.GetDuration("ca_signed_cert_duration"))
-	// Parse user key
-	certRequest.PublicKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(certRequest.Key))
-	if err != nil {
-		return c.JSON(http.StatusBadRequest,
-			map[string]string{"result": "fail", "message": "Parse user key", "details": err.Error()})
+	// Each extra principal must be authorized by roles too, denying the whole request otherwise
+	grants := map[string][]string{certRequest.RemoteUser: approvedRoles}
+	if len(certRequest.ExtraPrincipals) > 0 {
+		var regularRoles []string
+		for _, role := range myRoles {
+			if !contains(h.config.GetStringSlice("breakglass_roles"), role) {
+				regularRoles = append(regularRoles, role)
+			}
+		}
+		extraGrants, denied, err := permissions.AuthorizePrincipals(certRequest.ExtraPrincipals, regularRoles, func(role string, principal string) (bool, error) {
+			return h.enforce(role, principal, certRequest.UserIP, certRequest.RemoteHost, certRequest.RemotePort, localUser)
+		})
+		if err != nil && denied == "" {
+			return c.JSON(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Error using enforcer to authorize certificate", "details": err.Error()})
+		}
+		if err != nil {
+			finishTime := time.Now()
+			go func() {
+				h.auditChannel <- types.AuditRecord{
+					UID:       uuid.Must(uuid.NewV4()),
+					StartTime: initTime,
+					EndTime:   finishTime,
+					Kind:      "cert.create",
+					Owner:     username,
+					JTI:       jti,
+					Error:     "You don't have permission to request this certificate",
+					Log:       fmt.Sprintf("No role authorizes principal %s", denied),
+				}
+			}()
+			return c.JSON(http.StatusForbidden,
+				map[string]string{"result": "fail", "message": "You don't have permission to request this certificate", "details": fmt.Sprintf("No role authorizes principal %s", denied)})
+		}
+		for principal, roles := range extraGrants {
+			grants[principal] = roles
+		}
 	}
 
-	// here is where differs from an external signer and a local signer
-	if h.config.GetBool("ca_external") {
-		externalPubKey, err := v.GetExternalPublicKey()
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError,
-				map[string]string{"result": "fail", "message": "Error getting ssh ca public key", "details": err.Error()})
+	// Roles flagged with approval_roles only issue certificates after a second person approval
+	grantingRoles := unionRoles(grants)
+	if approvals.RequiresApproval(grantingRoles, h.config.GetStringSlice("approval_roles")) {
+		if len(certRequest.ExtraPrincipals) > 0 {
+			return c.JSON(http.StatusForbidden,
+				map[string]string{"result": "fail", "message": "Certificates requiring approval can't have extra principals", "details": "Request each principal on its own"})
+		}
+		if impersonating {
+			return c.JSON(http.StatusForbidden,
+				map[string]string{"result": "fail", "message": "Certificates requiring approval can't be requested on behalf of other users"})
+		}
+		return h.createApproval(c, certRequest, username, jti, approvedRoles, initTime)
+	}
+
+	// Certificates issued at the last cert_quota_window are limited, catching runaway scripts and
+	// compromised accounts. Break-glass is exempt, it is used when everything else failed.
+	release, httpErr := h.reserveIssuance(username, initTime, nil)
+	if httpErr != nil {
+		if httpErr.Code == http.StatusTooManyRequests {
+			finishTime := time.Now()
+			go func() {
+				h.auditChannel <- types.AuditRecord{
+					UID:       uuid.Must(uuid.NewV4()),
+					StartTime: initTime,
+					EndTime:   finishTime,
+					Kind:      "cert.create",
+					Owner:     username,
+					JTI:       jti,
+					Error:     errQuotaExceeded.Error(),
+					Log:       fmt.Sprintf("Certificate to %s@%s refused by quota", certRequest.RemoteUser, certRequest.RemoteHost),
+				}
+			}()
 		}
+		return c.JSON(httpErr.Code, httpErr.Message)
+	}
+	defer release()
 
-		certRequest.CAPublicKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(externalPubKey))
+	// Certificate extensions are granted by the roles that authorized each principal, a
+	// certificate for several principals has only the extensions granted for all of them
+	var extensionSets [][]string
+	for principal, roles := range grants {
+		extensions, err := h.certExtensions(roles)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest,
-				map[string]string{"result": "fail", "message": "Parse the ssh ca public key", "details": err.Error()})
+			return c.JSON(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Error reading role extensions", "details": err.Error()})
 		}
+		extensionSets = append(extensionSets, extensions)
+		certRequest.Principals = append(certRequest.Principals, permissions.CertPrincipals(roles, h.policyFor, principal, localUser)...)
+	}
+	certRequest.Extensions = permissions.CommonExtensions(extensionSets)
+	sort.Strings(certRequest.Principals)
 
-		certRequest.CAFingerprint = ssh.FingerprintSHA256(certRequest.CAPublicKey)
+	// Renewability is informational, clients use it to choose between renewal and a fresh request
+	certRequest.Renewable = !impersonating && certRenewable(grants, h.config.GetStringSlice("renewable_roles"))
 
-	} else {
-		// Parse the public key
-		certRequest.CAPublicKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(h.config.GetString("ca_public_key")))
-		if err != nil {
-			return c.JSON(http.StatusBadRequest,
-				map[string]string{"result": "fail", "message": "Parse the public key", "details": err.Error()})
+	signedKey, httpErr := h.signCertificate(certRequest, username)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, httpErr.Message)
+	}
+
+	// sending auditRecord with the roles authorizing any of its principals, impersonated certificates
+	// record the admin too
+	auditLog := certRequest.Reason
+	if impersonating {
+		auditLog = fmt.Sprintf("%s (impersonated by %s)", certRequest.Reason, certRequest.ImpersonatedBy)
+	}
+	auditLog = authorizedLog(auditLog, grantingRoles)
+	finishTime := time.Now()
+	go func() {
+		h.auditChannel <- types.AuditRecord{
+			UID:       uuid.Must(uuid.NewV4()),
+			StartTime: initTime,
+			EndTime:   finishTime,
+			Kind:      "cert.create",
+			TargetUID: certRequest.UID,
+			TargetID:  certRequest.ID,
+			Owner:     username,
+			JTI:       jti,
+			Roles:     strings.Join(grantingRoles, ","),
+			Log:       auditLog,
 		}
+	}()
+	return c.JSON(http.StatusOK, types.CertResponse{
+		Result:      "success",
+		Message:     certRequest.ValidityWarning,
+		Certificate: signedKey,
+		Roles:       grantingRoles,
+		Renewable:   certRequest.Renewable,
+		ValidAfter:  certRequest.ValidAfter,
+		ValidBefore: certRequest.ValidBefore,
+	})
+}
 
-		// Get the key's fingerprint for logging
-		certRequest.CAFingerprint = ssh.FingerprintSHA256(certRequest.CAPublicKey)
+// signCertificate signs the key at certRequest, requested by username, and stores the issued certificate.
+// On failure it returns an *echo.HTTPError with the status code and JSON message to respond.
+func (h AppHandler) signCertificate(certRequest *types.CertRequest, username string) (string, *echo.HTTPError) {
+	var err error
 
-		// Generate our key_id for the certificate
-		// TODO: verify to log user thats requested certificate (not RemoteUser)
-		certRequest.KeyID = uuid.Must(uuid.NewV4()).String()
+	// approved requests are issued at GET /approvals/:id, so maintenance mode is checked here too
+	if h.ReadOnly() {
+		return "", echo.NewHTTPError(http.StatusServiceUnavailable,
+			map[string]string{"result": "fail", "message": "GSH is in maintenance mode (read only)", "details": "Certificates can't be issued now, try again later"})
 	}
 
-	// Get/update our ssh cert serial number
-	criticalOptions := make(map[string]string)
-	if certRequest.Command != "" {
-		criticalOptions["force-command"] = certRequest.Command
+	// principals are granted by the roles that authorized the request, never signing without them
+	if len(certRequest.Principals) == 0 {
+		return "", echo.NewHTTPError(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "No principal granted by roles", "details": fmt.Sprintf("Roles don't grant login as %s", certRequest.RemoteUser)})
 	}
-	criticalOptions["source-address"] = certRequest.UserIP
 
-	perms := ssh.Permissions{
-		CriticalOptions: criticalOptions,
-		Extensions:      map[string]string{"permit-pty": ""},
+	// certRequest.UID is the nonce of this issuance, it is part of key id and audit records
+	certRequest.UID = uuid.Must(uuid.NewV4())
+	certRequest.Owner = username
+	certRequest.KeyID = keyID(h.config.GetString("ca_key_id_format"), h.config.GetString("ca_key_id_environment"), username, certRequest.RemoteUser, certRequest.UID)
+
+	// Set our certificate validity times
+	now := time.Now()
+	clamp := h.config.GetBool("cert_clamp_token_expiry")
+	validity, warning := certValidity(now, h.config.GetDuration("ca_signed_cert_duration"), h.config.GetDuration("cert_min_ttl"),
+		certRequest.TokenExpiry, clamp)
+	// clamped to a token that already expired, the certificate would never be valid
+	if clamp && !certRequest.TokenExpiry.IsZero() && validity <= 0 {
+		return "", echo.NewHTTPError(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Token expired, run gsh login"})
+	}
+	certRequest.ValidAfter = now.Add(-30 * time.Second)
+	certRequest.ModifiedAt = now
+	certRequest.ValidBefore = now.Add(validity)
+	certRequest.ValidityWarning = warning
+	// Parse user key
+	certRequest.PublicKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(certRequest.Key))
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Parse user key", "details": err.Error()})
+	}
+
+	// concurrent signatures are limited by max_concurrent_signs, protecting the signer (as Vault)
+	if !h.signLimiter.Acquire() {
+		return "", echo.NewHTTPError(http.StatusServiceUnavailable,
+			map[string]string{"result": "fail", "message": "Too many certificates being signed", "details": "Try again later",
+				"retry_after": strconv.Itoa(h.signLimiter.RetryAfter())})
 	}
+	defer h.signLimiter.Release()
+
+	// Get/update our ssh cert serial number
+	perms := h.certPermissions(certRequest)
 
 	// Make a cert from our pubkey
-	certRequest.UID = uuid.Must(uuid.NewV4())
 	cert := &ssh.Certificate{
 		Nonce:           certRequest.UID.Bytes(),
 		Key:             certRequest.PublicKey,
 		Serial:          0,
 		CertType:        ssh.UserCert,
 		KeyId:           certRequest.KeyID,
-		ValidPrincipals: []string{certRequest.RemoteUser},
+		ValidPrincipals: certRequest.Principals,
 		ValidAfter:      uint64(certRequest.ValidAfter.Unix()),
 		ValidBefore:     uint64(certRequest.ValidBefore.Unix()),
 		Permissions:     perms,
 	}
-	var signedKey string
-	// Sign user key
-	if h.config.GetBool("ca_external") {
-		signedKey, err = v.SignUserSSHCertificate(cert)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest,
-				map[string]string{"result": "fail", "message": "Sign user key", "details": err.Error()})
-		}
-	} else {
-		// Parse the private key
-		sshCASigner, err := ssh.ParsePrivateKey([]byte(h.config.GetString("ca_private_key")))
-		if err != nil {
-			return c.JSON(http.StatusBadRequest,
-				map[string]string{"result": "fail", "message": "Parse private ca key", "details": err.Error()})
-		}
-		err = cert.SignCert(rand.Reader, sshCASigner)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest,
-				map[string]string{"result": "fail", "message": "Sign user key", "details": err.Error()})
-		}
-		signedKey = string(ssh.MarshalAuthorizedKey(cert))
+	signedKey, httpErr := h.sign(cert, certRequest, username)
+	if httpErr != nil {
+		return "", httpErr
 	}
+
 	//parsing the returned certificat to extract the new keyid generated
 	k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signedKey))
 	if err != nil {
-		return c.JSON(http.StatusBadRequest,
+		return "", echo.NewHTTPError(http.StatusBadRequest,
 			map[string]string{"result": "fail", "message": "Failed parsing signed key", "details": err.Error()})
 	}
 	signedCert := k.(*ssh.Certificate)
 
+	// both signers must keep the key id, it ties logs at remote hosts to audit records
+	if signedCert.KeyId != certRequest.KeyID {
+		return "", echo.NewHTTPError(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Signer did not keep the certificate key id", "details": signedCert.KeyId})
+	}
+
 	//assigning the new key id to store the new value into db
 	certRequest.CertKeyID = signedCert.KeyId
+
+	// external signers can change the validity window, the signed certificate is the reference
+	certRequest.ValidAfter = time.Unix(int64(signedCert.ValidAfter), 0)
+	certRequest.ValidBefore = time.Unix(int64(signedCert.ValidBefore), 0)
 	certRequest.SerialNumber = strconv.FormatUint(signedCert.Serial, 10)
 
 	// generate key fingerprint
@@ -245,33 +481,334 @@ func (h AppHandler) CertCreate(c echo.Context) error {
 	// storing certificate in database
 	dbc := h.db.Create(certRequest)
 	if h.db.NewRecord(certRequest) {
-		return c.JSON(http.StatusBadRequest,
+		return "", echo.NewHTTPError(http.StatusBadRequest,
 			map[string]string{"result": "fail", "details": dbc.Error.Error()})
 	}
 
-	// sending auditRecord
-	finishTime := time.Now()
-	go func() {
-		h.auditChannel <- types.AuditRecord{
-			UID:       uuid.Must(uuid.NewV4()),
-			StartTime: initTime,
-			EndTime:   finishTime,
-			Kind:      "cert.create",
-			TargetUID: certRequest.UID,
-			TargetID:  certRequest.ID,
-			Owner:     username,
-			JTI:       jti,
+	return signedKey, nil
+}
+
+// policyFor returns the policy of role, or nil when it does not exist
+func (h AppHandler) policyFor(role string) []string {
+	for _, policy := range h.permEnforcer.GetFilteredPolicy(0, role) {
+		if policy[0] == role {
+			return policy
 		}
-	}()
-	return c.JSON(http.StatusOK, map[string]string{"result": "success", "certificate": signedKey})
+	}
+	return nil
+}
+
+// authorizingRoles returns the roles of myRoles authorizing certRequest to localUser, sorted. Break-glass
+// roles are skipped, they never authorize regular requests.
+func (h AppHandler) authorizingRoles(myRoles []string, certRequest *types.CertRequest, localUser string) ([]string, error) {
+	var approvedRoles []string
+	for _, role := range myRoles {
+		if contains(h.config.GetStringSlice("breakglass_roles"), role) {
+			continue
+		}
+		result, err := h.enforce(role, certRequest.RemoteUser, certRequest.UserIP, certRequest.RemoteHost, certRequest.RemotePort, localUser)
+		if err != nil {
+			return nil, err
+		}
+		if result {
+			approvedRoles = append(approvedRoles, role)
+		}
+	}
+	sort.Strings(approvedRoles)
+	return approvedRoles, nil
+}
+
+// enforce authorizes a certificate request with role, using the enforcer matcher and the
+// destination ports of the role, that are not part of the matcher (see permissions.Init)
+func (h AppHandler) enforce(role string, remoteUser string, sourceIP string, targetIP string, destPort string, currentUser string) (bool, error) {
+	allowed, err := h.permEnforcer.EnforceSafe(role, remoteUser, sourceIP, targetIP, "permit-pty", currentUser)
+	if err != nil || !allowed {
+		return false, err
+	}
+	return permissions.DestPortMatch(destPort, permissions.PolicyDestPorts(h.policyFor(role)))
+}
+
+// validatePort checks a destination port informed at a request, empty is the default port
+func validatePort(port string) error {
+	if port == "" {
+		return nil
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("port %q is not a number between 1 and 65535", port)
+	}
+	return nil
+}
+
+// authzDenialLog returns the log record of a denied certificate request, with the decision of
+// each evaluated role. Only the request fields are logged, never the token or the user key.
+func authzDenialLog(username string, requestID string, realIP string, certRequest *types.CertRequest, decisions []permissions.Decision) map[string]interface{} {
+	return map[string]interface{}{
+		"_owner":        username,
+		"_rid":          requestID,
+		"_real-ip":      realIP,
+		"_action":       "cert.create",
+		"_result":       "deny",
+		"_remote_user":  certRequest.RemoteUser,
+		"_remote_host":  certRequest.RemoteHost,
+		"_user_ip":      certRequest.UserIP,
+		"_decisions":    decisions,
+		"short_message": "Certificate request denied by every role",
+	}
+}
+
+// keyID returns the certificate key id from format, replacing {user}, {remote_user} and {nonce}.
+// The nonce is appended when format does not include it, so key ids are unique per issuance.
+// With environment (ca_key_id_environment) the key id starts with "gsh:<environment>:", so logs
+// at hosts reachable from many GSH instances tell which one issued the certificate.
+func keyID(format string, environment string, username string, remoteUser string, nonce uuid.UUID) string {
+	if !strings.Contains(format, "{nonce}") {
+		if format == "" {
+			format = "{nonce}"
+		} else {
+			format += "-{nonce}"
+		}
+	}
+	id := strings.NewReplacer("{user}", username, "{remote_user}", remoteUser, "{nonce}", nonce.String()).Replace(format)
+	if environment != "" {
+		id = "gsh:" + environment + ":" + id
+	}
+	return id
+}
+
+// reasonExtension is the certificate extension used to embed the reason of a certificate request
+const reasonExtension = "gsh-reason@gsh"
+
+// renewableExtension is the certificate extension used to flag certificates that can be renewed
+const renewableExtension = "gsh-renewable@gsh"
+
+// unionRoles returns the roles of grants (roles by principal) authorizing any principal, sorted and once each
+func unionRoles(grants map[string][]string) []string {
+	var roles []string
+	for _, granted := range grants {
+		for _, role := range granted {
+			if !contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// certRenewable checks if a certificate authorized by grants (roles by principal) can be renewed,
+// which requires every principal to be authorized by at least one of renewableRoles
+func certRenewable(grants map[string][]string, renewableRoles []string) bool {
+	if len(grants) == 0 {
+		return false
+	}
+	for _, roles := range grants {
+		renewable := false
+		for _, role := range roles {
+			if contains(renewableRoles, role) {
+				renewable = true
+			}
+		}
+		if !renewable {
+			return false
+		}
+	}
+	return true
+}
+
+// certExtensions returns the extensions of a certificate authorized by approvedRoles, the union
+// of the extensions granted by each role (see roleExtensions)
+func (h AppHandler) certExtensions(approvedRoles []string) ([]string, error) {
+	stored := []types.RoleExtensions{}
+	if err := h.db.Where("role_id IN (?)", approvedRoles).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	storedByRole := map[string]string{}
+	for _, roleExtensions := range stored {
+		storedByRole[roleExtensions.RoleID] = roleExtensions.Extensions
+	}
+	return permissions.CertExtensions(approvedRoles, func(role string) []string {
+		return roleExtensions(h.policyFor(role), storedByRole[role], h.config.GetBool("ca_port_forwarding"))
+	}), nil
+}
+
+// roleExtensions returns the extensions granted by a role policy. Roles created with extensions
+// (stored) grant exactly them. Others grant the default extensions and, enabled by portForwarding
+// (ca_port_forwarding), port forwarding when the role has no destination ports: OpenSSH
+// certificates can't restrict forwarding destinations.
+func roleExtensions(policy []string, stored string, portForwarding bool) []string {
+	if stored != "" {
+		return strings.Split(stored, ";")
+	}
+	extensions := append([]string{}, permissions.DefaultExtensions...)
+	if portForwarding && policy != nil && permissions.PolicyDestPorts(policy) == "" {
+		extensions = append(extensions, permissions.PortForwardingExtension)
+	}
+	return extensions
+}
+
+// keyProofMaxSkew is the maximum difference between the time of a key proof and the API clock
+const keyProofMaxSkew = 5 * time.Minute
+
+// verifyKeyProof checks the key proof at certRequest was signed around now by the private key of
+// certRequest.Key. Requests without proof are accepted unless required (ca_require_key_proof),
+// when the proof must also sign a challenge nonce.
+func verifyKeyProof(certRequest *types.CertRequest, now time.Time, required bool) error {
+	proof := certRequest.KeyProof
+	if proof == nil {
+		if required {
+			return errors.New("verifyKeyProof: proof of possession of the key is required, update gsh client")
+		}
+		return nil
+	}
+	if required && proof.Nonce == "" {
+		return errors.New("verifyKeyProof: proof must sign a nonce of /certificates/challenge, update gsh client")
+	}
+	skew := now.Sub(proof.Timestamp)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > keyProofMaxSkew {
+		return fmt.Errorf("verifyKeyProof: proof time differs from API clock by more than %s", keyProofMaxSkew)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certRequest.Key))
+	if err != nil {
+		return fmt.Errorf("verifyKeyProof: error parsing key (%s)", err.Error())
+	}
+	blob, err := base64.StdEncoding.DecodeString(proof.Signature)
+	if err != nil {
+		return fmt.Errorf("verifyKeyProof: error decoding signature (%s)", err.Error())
+	}
+	signature := new(ssh.Signature)
+	if err := ssh.Unmarshal(blob, signature); err != nil {
+		return fmt.Errorf("verifyKeyProof: error parsing signature (%s)", err.Error())
+	}
+	if err := publicKey.Verify(certRequest.KeyProofMessage(proof.Timestamp, proof.Nonce), signature); err != nil {
+		return errors.New("verifyKeyProof: signature was not made by the private key of the request")
+	}
+	return nil
+}
+
+// maxExtraPrincipals is the maximum number of principals requested besides the remote user
+const maxExtraPrincipals = 8
+
+// validateExtraPrincipals checks principals requested besides remoteUser against pattern
+// (remote_user_pattern) as remoteUser, returning them without duplicates and without remoteUser itself
+func validateExtraPrincipals(remoteUser string, principals []string, pattern string) ([]string, error) {
+	var extra []string
+	for _, principal := range principals {
+		if err := validateRemoteUser(principal, pattern); err != nil {
+			return nil, fmt.Errorf("validateExtraPrincipals: principal %q is not a valid remote user (%v)", principal, err)
+		}
+		if principal != remoteUser && !contains(extra, principal) {
+			extra = append(extra, principal)
+		}
+	}
+	if len(extra) > maxExtraPrincipals {
+		return nil, fmt.Errorf("validateExtraPrincipals: at most %d extra principals are allowed", maxExtraPrincipals)
+	}
+	return extra, nil
+}
+
+// validateRemoteUser checks if remoteUser matches pattern as a whole (remote_user_pattern). An empty
+// pattern, refused by config.Check, accepts any remote user.
+func validateRemoteUser(remoteUser string, pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	remoteUserFormat, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return fmt.Errorf("validateRemoteUser: invalid remote_user_pattern (%v)", err)
+	}
+	if !remoteUserFormat.MatchString(remoteUser) {
+		return fmt.Errorf("validateRemoteUser: remote user %q doesn't match %s", remoteUser, pattern)
+	}
+	return nil
+}
+
+// reasonMaxLength is the maximum length of the reason of a certificate request
+const reasonMaxLength = 128
+
+// reasonFormat is the set of characters allowed at the reason of a certificate request
+var reasonFormat = regexp.MustCompile(`^[\w .,:;#/@()+-]*$`)
+
+// validateReason checks if reason is safe to be logged and embedded in certificates
+func validateReason(reason string) error {
+	if len(reason) > reasonMaxLength {
+		return fmt.Errorf("validateReason: reason must have at most %d characters", reasonMaxLength)
+	}
+	if !reasonFormat.MatchString(reason) {
+		return errors.New("validateReason: reason must have only letters, numbers, spaces and .,:;#/@()+-_")
+	}
+	return nil
+}
+
+// certValidity returns how long a certificate issued at now is valid: maxTTL (ca_signed_cert_duration),
+// never shorter than minTTL (cert_min_ttl). With clamp (cert_clamp_token_expiry) it never outlives
+// tokenExpiry (zero when unknown), that takes precedence over minTTL with a warning.
+func certValidity(now time.Time, maxTTL time.Duration, minTTL time.Duration, tokenExpiry time.Time, clamp bool) (time.Duration, string) {
+	validity := maxTTL
+	if validity < minTTL {
+		validity = minTTL
+	}
+	if !clamp || tokenExpiry.IsZero() {
+		return validity, ""
+	}
+	remaining := tokenExpiry.Sub(now)
+	if remaining >= validity {
+		return validity, ""
+	}
+	if remaining < minTTL {
+		return remaining, fmt.Sprintf("Certificate valid for %s only, shorter than %s, as token expires before: run gsh login to renew it", remaining.Truncate(time.Second), minTTL)
+	}
+	return remaining, ""
+}
+
+// checkTokenRemaining checks if a token expiring at expiry is still valid for at least minRemaining after now
+func checkTokenRemaining(expiry time.Time, now time.Time, minRemaining time.Duration) error {
+	if remaining := expiry.Sub(now); remaining < minRemaining {
+		return fmt.Errorf("checkTokenRemaining: token expires in %s, at least %s is required", remaining.Truncate(time.Second), minRemaining)
+	}
+	return nil
 }
 
-// PublicKey returns CA public key
+// certPermissions returns critical options and extensions used on certificate for certRequest
+func (h AppHandler) certPermissions(certRequest *types.CertRequest) ssh.Permissions {
+	criticalOptions := make(map[string]string)
+	if certRequest.Command != "" {
+		criticalOptions["force-command"] = certRequest.Command
+	}
+	criticalOptions["source-address"] = certRequest.UserIP
+
+	granted := certRequest.Extensions
+	if len(granted) == 0 {
+		granted = permissions.DefaultExtensions
+	}
+	extensions := make(map[string]string)
+	for _, extension := range granted {
+		extensions[extension] = ""
+	}
+	if h.config.GetBool("ca_reason_extension") && certRequest.Reason != "" {
+		extensions[reasonExtension] = certRequest.Reason
+	}
+	if h.config.GetBool("ca_renewable_extension") && certRequest.Renewable {
+		extensions[renewableExtension] = ""
+	}
+
+	return ssh.Permissions{
+		CriticalOptions: criticalOptions,
+		Extensions:      extensions,
+	}
+}
+
+// PublicKey returns CA public key and, at public_keys, every CA public key hosts must trust: the
+// signer key followed by the keys of its chain (ca_chain_public_keys)
 //
 // - Output sample
 //
 //	{
 //		"result":"success",
+//		"public_keys":["ssh-rsa AAAAB3...", "ssh-ed25519 AAAAC3..."],
 //		"public_key":"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAACAQC6rGI3i3D1fvay1MFKHjEfcvKA
 //
 // A6vuNH5ayPcmOIoeHvkXPO6uCp4pbSNmy45szxyTEjGYJx0F6qylUzi4jZ+1BIpq5QStetsP4pryLhd
@@ -287,20 +824,306 @@ func (h AppHandler) CertCreate(c echo.Context) error {
 //	}
 func (h AppHandler) PublicKey(c echo.Context) error {
 
-	var publicKey string
+	publicKey, err := h.caPublicKey()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error getting ssh public key", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"result": "success", "public_key": publicKey,
+		"public_keys": caPublicKeys(publicKey, h.config.GetStringSlice("ca_chain_public_keys"))})
+}
+
+// caPublicKeys returns publicKey, the signer key, followed by chain keys that are not the same key
+func caPublicKeys(publicKey string, chain []string) []string {
+	keys := []string{strings.TrimSpace(publicKey)}
+	seen := map[string]bool{}
+	if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey)); err == nil {
+		seen[ssh.FingerprintSHA256(key)] = true
+	}
+	for _, chainKey := range chain {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(chainKey))
+		if err != nil || seen[ssh.FingerprintSHA256(key)] {
+			continue
+		}
+		seen[ssh.FingerprintSHA256(key)] = true
+		keys = append(keys, strings.TrimSpace(chainKey))
+	}
+	return keys
+}
+
+// sign signs cert, requested by username, by the external or the internal CA. With fallback_internal_ca,
+// certificates are still issued by the internal CA while the external CA is unavailable; certificates
+// the external CA refuses or returns invalid are never signed by the internal CA.
+func (h AppHandler) sign(cert *ssh.Certificate, certRequest *types.CertRequest, username string) (string, *echo.HTTPError) {
+	// here is where differs from an external signer and a local signer
+	if !h.config.GetBool("ca_external") {
+		return h.signInternal(cert, certRequest)
+	}
+	signedKey, httpErr := h.signExternal(cert, certRequest)
+	if httpErr != nil && h.config.GetBool("fallback_internal_ca") && errors.Is(httpErr.Internal, errVaultUnavailable) {
+		h.auditFallback(certRequest, username, httpErr)
+		return h.signInternal(cert, certRequest)
+	}
+	return signedKey, httpErr
+}
+
+// signExternal signs cert by the external CA (Vault), setting the CA key of certRequest
+func (h AppHandler) signExternal(cert *ssh.Certificate, certRequest *types.CertRequest) (string, *echo.HTTPError) {
+	secretID, err := vaultSecretID(h.config)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading Vault secret id", "details": err.Error()})
+	}
+	v := Vault{h.config.GetString("ca_role_id"), secretID, h.config, ""}
+
+	externalPubKey, err := v.GetExternalPublicKey()
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error getting ssh ca public key", "details": err.Error()}).SetInternal(err)
+	}
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(externalPubKey))
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Parse the ssh ca public key", "details": err.Error()})
+	}
+
+	signedKey, err := v.SignUserSSHCertificate(cert)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Sign user key", "details": err.Error()}).SetInternal(err)
+	}
+	certRequest.CAPublicKey = caPublicKey
+	certRequest.CAFingerprint = ssh.FingerprintSHA256(caPublicKey)
+	return signedKey, nil
+}
+
+// signInternal signs cert by the CA key at configuration (ca_private_key), setting the CA key of certRequest
+func (h AppHandler) signInternal(cert *ssh.Certificate, certRequest *types.CertRequest) (string, *echo.HTTPError) {
+	// Parse the public key
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(h.config.GetString("ca_public_key")))
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Parse the public key", "details": err.Error()})
+	}
+	// the private key can be at an HSM (ca_pkcs11_module), it never leaves the token
+	if h.config.GetString("ca_pkcs11_module") != "" {
+		if h.hsm == nil {
+			return "", echo.NewHTTPError(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Sign user key", "details": "CA HSM (ca_pkcs11_module) not available"})
+		}
+		if err := h.hsm.SignCert(cert, caPublicKey); err != nil {
+			return "", echo.NewHTTPError(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Sign user key at CA HSM", "details": err.Error()})
+		}
+	} else {
+		// Parse the private key
+		sshCASigner, err := ssh.ParsePrivateKey([]byte(h.config.GetString("ca_private_key")))
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest,
+				map[string]string{"result": "fail", "message": "Parse private ca key", "details": err.Error()})
+		}
+		err = cert.SignCert(rand.Reader, sshCASigner)
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest,
+				map[string]string{"result": "fail", "message": "Sign user key", "details": err.Error()})
+		}
+	}
+	// Get the key's fingerprint for logging
+	certRequest.CAPublicKey = caPublicKey
+	certRequest.CAFingerprint = ssh.FingerprintSHA256(caPublicKey)
+	return string(ssh.MarshalAuthorizedKey(cert)), nil
+}
+
+// auditFallback reports, at logs and audit records, a certificate signed by the internal CA
+// because the external CA failed with externalErr
+func (h AppHandler) auditFallback(certRequest *types.CertRequest, username string, externalErr *echo.HTTPError) {
+	now := time.Now()
+	reason := fmt.Sprintf("%v", externalErr.Message)
+	if message, ok := externalErr.Message.(map[string]string); ok {
+		reason = message["message"] + " (" + message["details"] + ")"
+	}
+	go func() {
+		h.logChannel <- map[string]interface{}{
+			"_owner":        username,
+			"_action":       "cert.fallback",
+			"_result":       "fallback",
+			"_key-id":       certRequest.KeyID,
+			"_error":        reason,
+			"short_message": fmt.Sprintf("External CA failed, certificate %s signed by internal CA", certRequest.KeyID),
+		}
+	}()
+	go func() {
+		h.auditChannel <- fallbackAuditRecord(certRequest, username, reason, now)
+	}()
+}
+
+// fallbackAuditRecord returns the audit record of a certificate signed by the internal CA because
+// the external CA failed with reason
+func fallbackAuditRecord(certRequest *types.CertRequest, username string, reason string, now time.Time) types.AuditRecord {
+	return types.AuditRecord{
+		UID:       uuid.Must(uuid.NewV4()),
+		StartTime: now,
+		EndTime:   now,
+		Kind:      "cert.fallback",
+		TargetUID: certRequest.UID,
+		Owner:     username,
+		Error:     reason,
+		Log: fmt.Sprintf("External CA failed, certificate %s to %s@%s signed by internal CA (fallback_internal_ca)",
+			certRequest.KeyID, certRequest.RemoteUser, certRequest.RemoteHost),
+	}
+}
+
+// caPublicKey returns CA public key, from external CA or configuration
+func (h AppHandler) caPublicKey() (string, error) {
 	if h.config.GetBool("ca_external") {
 		v := Vault{h.config.GetString("ca_role_id"), h.config.GetString("ca_external_secret_id"), h.config, ""}
-		var err error
-		publicKey, err = v.GetExternalPublicKey()
+		return v.GetExternalPublicKey()
+	}
+	return h.config.GetString("ca_public_key"), nil
+}
+
+// Reasons returned by checkCertificate for certificates that are not valid. Any structural
+// or signature problem is reported as certInvalid, so the verdict does not tell apart
+// certificates never issued by this CA.
+const (
+	certInvalid     = "invalid"
+	certNotYetValid = "not yet valid"
+	certExpired     = "expired"
+	certRevoked     = "revoked"
+)
+
+// CertValidate checks if a certificate was signed by the CA, is inside its validity window and was not revoked
+//
+// - Input JSON sample:
+//
+//	{
+//		"certificate":"ssh-rsa-cert-v01@openssh.com AAAAHHNzaC1yc2EtY2VydC12MDFAb3..."
+//	}
+//
+// - Output sample
+//
+//	{
+//		"result":"success",
+//		"valid":false,
+//		"reason":"expired"
+//	}
+func (h AppHandler) CertValidate(c echo.Context) error {
+	type ValidateRequest struct {
+		Certificate string `json:"certificate"`
+	}
+	validateRequest := new(ValidateRequest)
+	if err := c.Bind(validateRequest); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid certificate validation request", "details": err.Error()})
+	}
+
+	trustedKeys, err := h.trustedCAKeys()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error getting ssh public key", "details": err.Error()})
+	}
+
+	reason, err := checkCertificate(validateRequest.Certificate, trustedKeys, time.Now(), h.certRevoked)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error checking certificate revocation", "details": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"result": "success", "valid": reason == "", "reason": reason})
+}
+
+// trustedCAKeys returns every CA public key hosts trust: the signer key, the keys of its chain
+// (ca_chain_public_keys) and, with fallback_internal_ca, the internal CA key signing while the
+// external CA fails
+func (h AppHandler) trustedCAKeys() ([]ssh.PublicKey, error) {
+	publicKey, err := h.caPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	chain := h.config.GetStringSlice("ca_chain_public_keys")
+	if h.config.GetBool("ca_external") && h.config.GetBool("fallback_internal_ca") {
+		chain = append(chain, h.config.GetString("ca_public_key"))
+	}
+	var keys []ssh.PublicKey
+	for _, authorizedKey := range caPublicKeys(publicKey, chain) {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError,
-				map[string]string{"result": "fail", "message": "Error getting ssh public key", "details": err.Error()})
+			return nil, fmt.Errorf("trustedCAKeys: invalid CA public key (%v)", err)
 		}
-	} else {
-		publicKey = h.config.GetString("ca_public_key")
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// checkCertificate returns an empty string if certificate is a user certificate signed by any of
+// caPublicKeys, valid at now and not revoked, or the reason why it is not valid. Failing to check
+// revocation returns the error, never a verdict.
+func checkCertificate(certificate string, caPublicKeys []ssh.PublicKey, now time.Time, revoked func(fingerprint string) (bool, error)) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return certInvalid, nil
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert || cert.Signature == nil {
+		return certInvalid, nil
+	}
+
+	// Check authority and signature
+	signed := false
+	for _, caPublicKey := range caPublicKeys {
+		if verifyCertificateSignature(cert, caPublicKey) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return certInvalid, nil
+	}
+
+	// Check validity window
+	if now.Unix() < int64(cert.ValidAfter) {
+		return certNotYetValid, nil
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && now.Unix() >= int64(cert.ValidBefore) {
+		return certExpired, nil
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"result": "success", "public_key": publicKey})
+	// Check revocation using the same fingerprint stored at certificate issue
+	isRevoked, err := revoked(certificateFingerprint(base64.StdEncoding.EncodeToString(cert.Marshal())))
+	if err != nil {
+		return "", err
+	}
+	if isRevoked {
+		return certRevoked, nil
+	}
+	return "", nil
+}
+
+// verifyCertificateSignature checks that cert was signed by caPublicKey
+func verifyCertificateSignature(cert *ssh.Certificate, caPublicKey ssh.PublicKey) error {
+	if cert.Signature == nil || cert.SignatureKey == nil {
+		return errors.New("verifyCertificateSignature: certificate is not signed")
+	}
+	if !bytes.Equal(cert.SignatureKey.Marshal(), caPublicKey.Marshal()) {
+		return errors.New("verifyCertificateSignature: certificate is not signed by CA")
+	}
+	// signed bytes are the certificate without signature field
+	unsigned := *cert
+	unsigned.Signature = nil
+	signed := unsigned.Marshal()
+	if err := cert.SignatureKey.Verify(signed[:len(signed)-4], cert.Signature); err != nil {
+		return errors.New("verifyCertificateSignature: invalid signature (" + err.Error() + ")")
+	}
+	return nil
+}
+
+// certRevoked tells whether the certificate with fingerprint was revoked
+func (h AppHandler) certRevoked(fingerprint string) (bool, error) {
+	count := 0
+	if err := h.db.Model(&types.CertRequest{}).Where("cert_fingerprint = ? AND revoked_at IS NOT NULL", fingerprint).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
 }
 
 // CertInfo returns certificate info based on KeyID
@@ -328,7 +1151,7 @@ func (h AppHandler) CertInfo(c echo.Context) error {
 
 	certRequest := new(types.CertRequest)
 	//sshd only gives 15 characters for serial number
-	h.db.Where("cert_serial_number LIKE ?", serialNumber+"%").Where(types.CertRequest{
+	h.reader().Where("cert_serial_number LIKE ?", serialNumber+"%").Where(types.CertRequest{
 		CertKeyID:       keyID,
 		KeyFingerprint:  keyFingerprint,
 		CertFingerprint: certFingerprint,
@@ -0,0 +1,966 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin"
+	fileadapter "github.com/casbin/casbin/persist/file-adapter"
+	"github.com/globocom/gsh/api/config"
+	"github.com/globocom/gsh/api/permissions"
+	"github.com/globocom/gsh/api/signlimit"
+	"github.com/globocom/gsh/types"
+	"github.com/gofrs/uuid"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestValidateReason(t *testing.T) {
+	t.Run(
+		"Valid reason",
+		func(t *testing.T) {
+			err := validateReason("INC-1234: database failover (on-call)")
+			if err != nil {
+				t.Fatalf("validateReason: check fail with valid reason (%v)", err)
+			}
+		})
+	t.Run(
+		"Reason too long",
+		func(t *testing.T) {
+			err := validateReason(strings.Repeat("a", reasonMaxLength+1))
+			if err == nil {
+				t.Fatalf("validateReason: check fail with reason too long")
+			}
+		})
+	t.Run(
+		"Reason with invalid characters",
+		func(t *testing.T) {
+			err := validateReason("ticket\n\"quoted\"")
+			if err == nil {
+				t.Fatalf("validateReason: check fail with invalid characters")
+			}
+		})
+}
+
+func TestValidateRemoteUser(t *testing.T) {
+	t.Run(
+		"Valid usernames",
+		func(t *testing.T) {
+			for _, remoteUser := range []string{"alice", "root", "www-data", "_apt", "john.doe", "deploy01"} {
+				if err := validateRemoteUser(remoteUser, config.DefaultRemoteUserPattern); err != nil {
+					t.Fatalf("validateRemoteUser: check fail with %q (%v)", remoteUser, err)
+				}
+			}
+		})
+	t.Run(
+		"Invalid usernames",
+		func(t *testing.T) {
+			for _, remoteUser := range []string{"", "-oProxyCommand=sh", "alice bob", "root\nadmin", "alice,root", "a/b", strings.Repeat("a", 33)} {
+				if err := validateRemoteUser(remoteUser, config.DefaultRemoteUserPattern); err == nil {
+					t.Fatalf("validateRemoteUser: check fail with %q", remoteUser)
+				}
+			}
+		})
+	t.Run(
+		"Configured pattern matches whole remote user",
+		func(t *testing.T) {
+			if err := validateRemoteUser("app-web", "app-[a-z]+"); err != nil {
+				t.Fatalf("validateRemoteUser: check fail with configured pattern (%v)", err)
+			}
+			if err := validateRemoteUser("root;app-web", "app-[a-z]+"); err == nil {
+				t.Fatalf("validateRemoteUser: check fail matching part of remote user")
+			}
+			if err := validateRemoteUser("alice", "[a-z"); err == nil {
+				t.Fatalf("validateRemoteUser: check fail with invalid pattern")
+			}
+		})
+}
+
+func TestValidateExtraPrincipals(t *testing.T) {
+	t.Run(
+		"Valid principals",
+		func(t *testing.T) {
+			extra, err := validateExtraPrincipals("root", []string{"deploy", "root", "postgres", "deploy"}, config.DefaultRemoteUserPattern)
+			if err != nil || strings.Join(extra, ",") != "deploy,postgres" {
+				t.Fatalf("validateExtraPrincipals: check fail with valid principals (%v, %v)", extra, err)
+			}
+		})
+	t.Run(
+		"Invalid principal",
+		func(t *testing.T) {
+			for _, principal := range []string{"", "deploy,root", "deploy root", "-oProxyCommand", strings.Repeat("a", 33)} {
+				if _, err := validateExtraPrincipals("root", []string{principal}, config.DefaultRemoteUserPattern); err == nil {
+					t.Fatalf("validateExtraPrincipals: check fail with invalid principal %q", principal)
+				}
+			}
+		})
+	t.Run(
+		"Configured pattern",
+		func(t *testing.T) {
+			if _, err := validateExtraPrincipals("app-web", []string{"app-db"}, "app-[a-z]+"); err != nil {
+				t.Fatalf("validateExtraPrincipals: check fail with principal matching remote_user_pattern (%v)", err)
+			}
+			if _, err := validateExtraPrincipals("app-web", []string{"root"}, "app-[a-z]+"); err == nil {
+				t.Fatalf("validateExtraPrincipals: check fail with principal not matching remote_user_pattern")
+			}
+		})
+	t.Run(
+		"Too many principals",
+		func(t *testing.T) {
+			var principals []string
+			for i := 0; i <= maxExtraPrincipals; i++ {
+				principals = append(principals, fmt.Sprintf("user%d", i))
+			}
+			if _, err := validateExtraPrincipals("root", principals, config.DefaultRemoteUserPattern); err == nil {
+				t.Fatalf("validateExtraPrincipals: check fail with too many principals")
+			}
+		})
+}
+
+func TestVerifyKeyProof(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("verifyKeyProof: check fail generating key (%v)", err)
+		}
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			t.Fatalf("verifyKeyProof: check fail creating signer (%v)", err)
+		}
+		return signer
+	}
+	signer := newSigner()
+	now := time.Now()
+	newRequest := func(proofSigner ssh.Signer, timestamp time.Time, nonce string) *types.CertRequest {
+		certRequest := &types.CertRequest{
+			Key:        string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+			RemoteUser: "root",
+			RemoteHost: "10.0.0.1",
+			RemotePort: "22",
+		}
+		signature, err := proofSigner.Sign(rand.Reader, certRequest.KeyProofMessage(timestamp, nonce))
+		if err != nil {
+			t.Fatalf("verifyKeyProof: check fail signing proof (%v)", err)
+		}
+		certRequest.KeyProof = &types.KeyProof{Timestamp: timestamp, Nonce: nonce, Signature: base64.StdEncoding.EncodeToString(ssh.Marshal(signature))}
+		return certRequest
+	}
+
+	t.Run(
+		"Valid proof",
+		func(t *testing.T) {
+			if err := verifyKeyProof(newRequest(signer, now, "nonce"), now, true); err != nil {
+				t.Fatalf("verifyKeyProof: check fail with valid proof (%v)", err)
+			}
+		})
+	t.Run(
+		"Forged proof",
+		func(t *testing.T) {
+			if err := verifyKeyProof(newRequest(newSigner(), now, "nonce"), now, true); err == nil {
+				t.Fatalf("verifyKeyProof: check fail with proof signed by another key")
+			}
+		})
+	t.Run(
+		"Proof of another request",
+		func(t *testing.T) {
+			certRequest := newRequest(signer, now, "nonce")
+			certRequest.RemoteUser = "postgres"
+			if err := verifyKeyProof(certRequest, now, true); err == nil {
+				t.Fatalf("verifyKeyProof: check fail with proof of another remote user")
+			}
+		})
+	t.Run(
+		"Stale proof",
+		func(t *testing.T) {
+			if err := verifyKeyProof(newRequest(signer, now.Add(-keyProofMaxSkew-time.Second), "nonce"), now, true); err == nil {
+				t.Fatalf("verifyKeyProof: check fail with stale proof")
+			}
+		})
+	t.Run(
+		"Invalid signature encoding",
+		func(t *testing.T) {
+			certRequest := newRequest(signer, now, "nonce")
+			certRequest.KeyProof.Signature = "not base64!"
+			if err := verifyKeyProof(certRequest, now, true); err == nil {
+				t.Fatalf("verifyKeyProof: check fail with invalid signature encoding")
+			}
+		})
+	t.Run(
+		"Proof of another nonce",
+		func(t *testing.T) {
+			certRequest := newRequest(signer, now, "nonce")
+			certRequest.KeyProof.Nonce = "other nonce"
+			if err := verifyKeyProof(certRequest, now, true); err == nil {
+				t.Fatalf("verifyKeyProof: check fail with proof of another nonce")
+			}
+		})
+	t.Run(
+		"Proof without nonce",
+		func(t *testing.T) {
+			certRequest := newRequest(signer, now, "")
+			if err := verifyKeyProof(certRequest, now, false); err != nil {
+				t.Fatalf("verifyKeyProof: check fail with optional nonce (%v)", err)
+			}
+			if err := verifyKeyProof(certRequest, now, true); err == nil {
+				t.Fatalf("verifyKeyProof: check fail with required nonce")
+			}
+		})
+	t.Run(
+		"Missing proof",
+		func(t *testing.T) {
+			certRequest := newRequest(signer, now, "nonce")
+			certRequest.KeyProof = nil
+			if err := verifyKeyProof(certRequest, now, false); err != nil {
+				t.Fatalf("verifyKeyProof: check fail with optional proof (%v)", err)
+			}
+			if err := verifyKeyProof(certRequest, now, true); err == nil {
+				t.Fatalf("verifyKeyProof: check fail with required proof")
+			}
+		})
+}
+
+func TestCertValidity(t *testing.T) {
+	now := time.Now()
+	t.Run(
+		"Clamp disabled",
+		func(t *testing.T) {
+			validity, warning := certValidity(now, 10*time.Minute, time.Minute, now.Add(30*time.Second), false)
+			if validity != 10*time.Minute || warning != "" {
+				t.Fatalf("certValidity: check fail without clamp (%s, %s)", validity, warning)
+			}
+		})
+	t.Run(
+		"Floor over short maximum",
+		func(t *testing.T) {
+			validity, _ := certValidity(now, 30*time.Second, time.Minute, time.Time{}, true)
+			if validity != time.Minute {
+				t.Fatalf("certValidity: check fail raising to floor (%s)", validity)
+			}
+		})
+	t.Run(
+		"Unknown token expiry",
+		func(t *testing.T) {
+			validity, warning := certValidity(now, 10*time.Minute, time.Minute, time.Time{}, true)
+			if validity != 10*time.Minute || warning != "" {
+				t.Fatalf("certValidity: check fail without token expiry (%s, %s)", validity, warning)
+			}
+		})
+	t.Run(
+		"Clamped above floor",
+		func(t *testing.T) {
+			validity, warning := certValidity(now, 10*time.Minute, time.Minute, now.Add(5*time.Minute), true)
+			if validity != 5*time.Minute || warning != "" {
+				t.Fatalf("certValidity: check fail clamping to token (%s, %s)", validity, warning)
+			}
+		})
+	t.Run(
+		"Clamped below floor",
+		func(t *testing.T) {
+			validity, warning := certValidity(now, 10*time.Minute, time.Minute, now.Add(30*time.Second), true)
+			if validity != 30*time.Second || warning == "" {
+				t.Fatalf("certValidity: check fail warning below floor (%s, %s)", validity, warning)
+			}
+		})
+}
+
+func TestCheckTokenRemaining(t *testing.T) {
+	now := time.Now()
+	t.Run(
+		"Headroom disabled",
+		func(t *testing.T) {
+			if err := checkTokenRemaining(now.Add(time.Second), now, 0); err != nil {
+				t.Fatalf("checkTokenRemaining: check fail without headroom (%v)", err)
+			}
+		})
+	t.Run(
+		"Exactly at headroom",
+		func(t *testing.T) {
+			if err := checkTokenRemaining(now.Add(60*time.Second), now, 60*time.Second); err != nil {
+				t.Fatalf("checkTokenRemaining: check fail at headroom boundary (%v)", err)
+			}
+		})
+	t.Run(
+		"Inside headroom",
+		func(t *testing.T) {
+			if err := checkTokenRemaining(now.Add(59*time.Second), now, 60*time.Second); err == nil {
+				t.Fatalf("checkTokenRemaining: check fail with token expiring inside headroom")
+			}
+		})
+	t.Run(
+		"Already expired",
+		func(t *testing.T) {
+			if err := checkTokenRemaining(now.Add(-time.Second), now, 60*time.Second); err == nil {
+				t.Fatalf("checkTokenRemaining: check fail with expired token")
+			}
+		})
+}
+
+func TestCertPermissionsReason(t *testing.T) {
+	_, caPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("certPermissions: fail generating CA key (%v)", err)
+	}
+	signer, err := ssh.NewSignerFromKey(caPrivateKey)
+	if err != nil {
+		t.Fatalf("certPermissions: fail creating CA signer (%v)", err)
+	}
+	userPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("certPermissions: fail generating user key (%v)", err)
+	}
+	sshUserKey, err := ssh.NewPublicKey(userPublicKey)
+	if err != nil {
+		t.Fatalf("certPermissions: fail converting user key (%v)", err)
+	}
+
+	// signs a certificate and parse it back as sshd would
+	signCert := func(h AppHandler, certRequest *types.CertRequest) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             sshUserKey,
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{certRequest.RemoteUser},
+			ValidBefore:     ssh.CertTimeInfinity,
+			Permissions:     h.certPermissions(certRequest),
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatalf("certPermissions: fail signing certificate (%v)", err)
+		}
+		parsed, _, _, _, err := ssh.ParseAuthorizedKey(ssh.MarshalAuthorizedKey(cert))
+		if err != nil {
+			t.Fatalf("certPermissions: fail parsing certificate (%v)", err)
+		}
+		return parsed.(*ssh.Certificate)
+	}
+
+	certRequest := &types.CertRequest{RemoteUser: "alice", UserIP: "192.0.2.1", Reason: "INC-1234"}
+
+	t.Run(
+		"Reason extension enabled",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("ca_reason_extension", true)
+			cert := signCert(AppHandler{config: *config}, certRequest)
+			if cert.Extensions[reasonExtension] != "INC-1234" {
+				t.Fatalf("certPermissions: check fail with reason extension (%v)", cert.Extensions)
+			}
+			if _, ok := cert.Extensions["permit-pty"]; !ok {
+				t.Fatalf("certPermissions: check fail with permit-pty extension (%v)", cert.Extensions)
+			}
+		})
+	t.Run(
+		"Reason extension disabled",
+		func(t *testing.T) {
+			config := viper.New()
+			cert := signCert(AppHandler{config: *config}, certRequest)
+			if _, ok := cert.Extensions[reasonExtension]; ok {
+				t.Fatalf("certPermissions: check fail without reason extension (%v)", cert.Extensions)
+			}
+		})
+	t.Run(
+		"Extensions granted by roles",
+		func(t *testing.T) {
+			config := viper.New()
+			granted := []string{"permit-agent-forwarding", permissions.PortForwardingExtension}
+			cert := signCert(AppHandler{config: *config}, &types.CertRequest{RemoteUser: "alice", UserIP: "192.0.2.1", Extensions: granted})
+			if len(cert.Extensions) != len(granted) {
+				t.Fatalf("certPermissions: check fail with role extensions (%v)", cert.Extensions)
+			}
+			for _, extension := range granted {
+				if _, ok := cert.Extensions[extension]; !ok {
+					t.Fatalf("certPermissions: check fail with %s extension (%v)", extension, cert.Extensions)
+				}
+			}
+			cert = signCert(AppHandler{config: *config}, certRequest)
+			if _, ok := cert.Extensions["permit-pty"]; !ok || len(cert.Extensions) != 1 {
+				t.Fatalf("certPermissions: check fail with default extensions (%v)", cert.Extensions)
+			}
+		})
+	t.Run(
+		"Renewable extension",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("ca_renewable_extension", true)
+			renewable := &types.CertRequest{RemoteUser: "alice", UserIP: "192.0.2.1", Renewable: true}
+			if _, ok := signCert(AppHandler{config: *config}, renewable).Extensions[renewableExtension]; !ok {
+				t.Fatalf("certPermissions: check fail with renewable extension")
+			}
+			if _, ok := signCert(AppHandler{config: *config}, certRequest).Extensions[renewableExtension]; ok {
+				t.Fatalf("certPermissions: check fail with renewable extension of certificate not renewable")
+			}
+			if _, ok := signCert(AppHandler{config: *viper.New()}, renewable).Extensions[renewableExtension]; ok {
+				t.Fatalf("certPermissions: check fail with renewable extension disabled")
+			}
+		})
+}
+
+func TestCertRenewable(t *testing.T) {
+	config := viper.New()
+	config.Set("renewable_roles", []string{"web", "db"})
+	renewableRoles := config.GetStringSlice("renewable_roles")
+	t.Run(
+		"Roles renewable",
+		func(t *testing.T) {
+			if !certRenewable(map[string][]string{"alice": {"web"}}, renewableRoles) {
+				t.Fatalf("certRenewable: check fail with renewable role")
+			}
+			if !certRenewable(map[string][]string{"alice": {"ops", "web"}, "deploy": {"db"}}, renewableRoles) {
+				t.Fatalf("certRenewable: check fail with every principal authorized by a renewable role")
+			}
+		})
+	t.Run(
+		"Roles not renewable",
+		func(t *testing.T) {
+			if certRenewable(map[string][]string{"alice": {"web"}, "deploy": {"ops"}}, renewableRoles) {
+				t.Fatalf("certRenewable: check fail with principal authorized by role not renewable")
+			}
+			if certRenewable(map[string][]string{"alice": {"web"}}, viper.New().GetStringSlice("renewable_roles")) {
+				t.Fatalf("certRenewable: check fail without renewable_roles")
+			}
+			if certRenewable(map[string][]string{}, renewableRoles) {
+				t.Fatalf("certRenewable: check fail without grants")
+			}
+		})
+}
+
+func TestUnionRoles(t *testing.T) {
+	roles := unionRoles(map[string][]string{"alice": {"web", "ops"}, "deploy": {"db", "web"}})
+	if strings.Join(roles, ",") != "db,ops,web" {
+		t.Fatalf("unionRoles: check fail with roles of every principal (%v)", roles)
+	}
+	if roles := unionRoles(map[string][]string{}); len(roles) != 0 {
+		t.Fatalf("unionRoles: check fail without grants (%v)", roles)
+	}
+}
+
+func TestAuthorizingRoles(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	roles := "p, web, alice, 0.0.0.0/0, 10.0.0.1, permit-pty\n" +
+		"p, db, alice, 0.0.0.0/0, 10.0.0.2, permit-pty\n" +
+		"p, any, alice, 0.0.0.0/0, 10.0.0.0/24, permit-pty\n" +
+		"p, emergency, alice, 0.0.0.0/0, 10.0.0.0/8, permit-pty\n"
+	if err := os.WriteFile(policy, []byte(roles), 0600); err != nil {
+		t.Fatalf("authorizingRoles: check fail writing policy (%v)", err)
+	}
+	e := casbin.NewEnforcer(permissions.Model(), fileadapter.NewAdapter(policy))
+	e.AddFunction("ipMultipleMatch", permissions.IPMultipleMatchFunc)
+	config := viper.New()
+	config.Set("breakglass_roles", []string{"emergency"})
+	h := AppHandler{config: *config, permEnforcer: e}
+
+	t.Run(
+		"Request matched by a specific role",
+		func(t *testing.T) {
+			certRequest := &types.CertRequest{RemoteUser: "alice", RemoteHost: "10.0.0.2", UserIP: "10.1.0.1"}
+			approvedRoles, err := h.authorizingRoles([]string{"web", "db", "emergency"}, certRequest, "alice")
+			if err != nil || strings.Join(approvedRoles, ",") != "db" {
+				t.Fatalf("authorizingRoles: check fail with request matched by db (%v, %v)", approvedRoles, err)
+			}
+		})
+	t.Run(
+		"Request matched by overlapping roles",
+		func(t *testing.T) {
+			certRequest := &types.CertRequest{RemoteUser: "alice", RemoteHost: "10.0.0.1", UserIP: "10.1.0.1"}
+			approvedRoles, err := h.authorizingRoles([]string{"web", "emergency", "any", "db"}, certRequest, "alice")
+			if err != nil || strings.Join(approvedRoles, ",") != "any,web" {
+				t.Fatalf("authorizingRoles: check fail with overlapping roles (%v, %v)", approvedRoles, err)
+			}
+		})
+	t.Run(
+		"Request not matched",
+		func(t *testing.T) {
+			certRequest := &types.CertRequest{RemoteUser: "alice", RemoteHost: "10.0.1.1", UserIP: "10.1.0.1"}
+			if approvedRoles, err := h.authorizingRoles([]string{"web", "db", "any", "emergency"}, certRequest, "alice"); err != nil || len(approvedRoles) != 0 {
+				t.Fatalf("authorizingRoles: check fail with request not matched (%v, %v)", approvedRoles, err)
+			}
+		})
+}
+
+func TestSignCertificateLimit(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("signCertificate: check fail generating key (%v)", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("signCertificate: check fail generating key (%v)", err)
+	}
+
+	// the only slot is taken by a signature in progress
+	limiter := signlimit.New(1, 10*time.Millisecond)
+	if !limiter.Acquire() {
+		t.Fatalf("signCertificate: check fail taking slot")
+	}
+	defer limiter.Release()
+	h := AppHandler{config: *viper.New(), signLimiter: limiter}
+	_, httpErr := h.signCertificate(&types.CertRequest{Key: string(ssh.MarshalAuthorizedKey(key)), RemoteUser: "alice", Principals: []string{"alice"}}, "alice")
+	if httpErr == nil || httpErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("signCertificate: check fail with signers busy (%v)", httpErr)
+	}
+	if message, ok := httpErr.Message.(map[string]string); !ok || message["retry_after"] != "1" {
+		t.Fatalf("signCertificate: check fail with retry_after (%v)", httpErr.Message)
+	}
+}
+
+func TestSignCertificatePrincipals(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("signCertificate: check fail generating key (%v)", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("signCertificate: check fail generating key (%v)", err)
+	}
+
+	// roles granted no principal, so the certificate is never signed
+	h := AppHandler{config: *viper.New()}
+	_, httpErr := h.signCertificate(&types.CertRequest{Key: string(ssh.MarshalAuthorizedKey(key)), RemoteUser: "root"}, "alice")
+	if httpErr == nil || httpErr.Code != http.StatusForbidden {
+		t.Fatalf("signCertificate: check fail without principals (%v)", httpErr)
+	}
+}
+
+func TestCAPublicKeys(t *testing.T) {
+	newKey := func() string {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("caPublicKeys: check fail generating key (%v)", err)
+		}
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatalf("caPublicKeys: check fail converting key (%v)", err)
+		}
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+	signer, intermediate, root := newKey(), newKey(), newKey()
+
+	t.Run(
+		"Signer only",
+		func(t *testing.T) {
+			if keys := caPublicKeys(signer+"\n", nil); len(keys) != 1 || keys[0] != signer {
+				t.Fatalf("caPublicKeys: check fail without chain (%v)", keys)
+			}
+		})
+	t.Run(
+		"Signer and chain",
+		func(t *testing.T) {
+			// signer key repeated at chain and invalid keys are published once, or not at all
+			keys := caPublicKeys(signer, []string{intermediate, signer + " comment", "invalid", root})
+			if strings.Join(keys, ",") != strings.Join([]string{signer, intermediate, root}, ",") {
+				t.Fatalf("caPublicKeys: check fail with chain (%v)", keys)
+			}
+		})
+	t.Run(
+		"Trusted keys validating certificates",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("ca_public_key", signer)
+			config.Set("ca_chain_public_keys", []string{intermediate, root})
+			h := AppHandler{config: *config}
+			keys, err := h.trustedCAKeys()
+			if err != nil || len(keys) != 3 || strings.TrimSpace(string(ssh.MarshalAuthorizedKey(keys[2]))) != root {
+				t.Fatalf("trustedCAKeys: check fail with chain (%v, %v)", keys, err)
+			}
+		})
+}
+
+func TestRoleExtensions(t *testing.T) {
+	policy := []string{"ops", ".", "192.0.2.0/24", "198.51.100.0/24", "permit-pty"}
+	policyWithPorts := append(append([]string{}, policy...), "5432")
+
+	for _, test := range []struct {
+		name           string
+		policy         []string
+		stored         string
+		portForwarding bool
+		expected       string
+	}{
+		{"Stored extensions", policyWithPorts, "permit-agent-forwarding;permit-pty", true, "permit-agent-forwarding;permit-pty"},
+		{"Default extensions", policy, "", false, "permit-pty"},
+		{"Port forwarding enabled", policy, "", true, "permit-pty;permit-port-forwarding"},
+		{"Port forwarding with destination ports", policyWithPorts, "", true, "permit-pty"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			extensions := roleExtensions(test.policy, test.stored, test.portForwarding)
+			if strings.Join(extensions, ";") != test.expected {
+				t.Fatalf("roleExtensions: check fail (%v)", extensions)
+			}
+		})
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("checkCertificate: fail generating CA key (%v)", err)
+		}
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			t.Fatalf("checkCertificate: fail creating CA signer (%v)", err)
+		}
+		return signer
+	}
+	caSigner := newSigner()
+	otherSigner := newSigner()
+
+	userPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("checkCertificate: fail generating user key (%v)", err)
+	}
+	sshUserKey, err := ssh.NewPublicKey(userPublicKey)
+	if err != nil {
+		t.Fatalf("checkCertificate: fail converting user key (%v)", err)
+	}
+
+	now := time.Now()
+	newCert := func(signer ssh.Signer, validBefore time.Time) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             sshUserKey,
+			CertType:        ssh.UserCert,
+			KeyId:           "alice",
+			ValidPrincipals: []string{"alice"},
+			ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(validBefore.Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatalf("checkCertificate: fail signing certificate (%v)", err)
+		}
+		return cert
+	}
+	notRevoked := func(string) (bool, error) { return false, nil }
+
+	t.Run(
+		"Valid certificate",
+		func(t *testing.T) {
+			cert := string(ssh.MarshalAuthorizedKey(newCert(caSigner, now.Add(time.Minute))))
+			if reason, err := checkCertificate(cert, []ssh.PublicKey{caSigner.PublicKey()}, now, notRevoked); err != nil || reason != "" {
+				t.Fatalf("checkCertificate: check fail with valid certificate (%s)", reason)
+			}
+		})
+	t.Run(
+		"Expired certificate",
+		func(t *testing.T) {
+			cert := string(ssh.MarshalAuthorizedKey(newCert(caSigner, now.Add(-time.Second))))
+			if reason, err := checkCertificate(cert, []ssh.PublicKey{caSigner.PublicKey()}, now, notRevoked); err != nil || reason != certExpired {
+				t.Fatalf("checkCertificate: check fail with expired certificate (%s)", reason)
+			}
+		})
+	t.Run(
+		"Revoked certificate",
+		func(t *testing.T) {
+			signed := newCert(caSigner, now.Add(time.Minute))
+			cert := string(ssh.MarshalAuthorizedKey(signed))
+			fingerprint := certificateFingerprint(strings.TrimSpace(strings.SplitN(cert, " ", 2)[1]))
+			revoked := func(f string) (bool, error) { return f == fingerprint, nil }
+			if reason, err := checkCertificate(cert, []ssh.PublicKey{caSigner.PublicKey()}, now, revoked); err != nil || reason != certRevoked {
+				t.Fatalf("checkCertificate: check fail with revoked certificate (%s)", reason)
+			}
+		})
+	t.Run(
+		"Revocation not checked",
+		func(t *testing.T) {
+			cert := string(ssh.MarshalAuthorizedKey(newCert(caSigner, now.Add(time.Minute))))
+			failing := func(string) (bool, error) { return false, errors.New("database is down") }
+			if reason, err := checkCertificate(cert, []ssh.PublicKey{caSigner.PublicKey()}, now, failing); err == nil || reason != "" {
+				t.Fatalf("checkCertificate: check fail with revocation error (%s, %v)", reason, err)
+			}
+		})
+	t.Run(
+		"Certificate from another CA",
+		func(t *testing.T) {
+			cert := string(ssh.MarshalAuthorizedKey(newCert(otherSigner, now.Add(time.Minute))))
+			if reason, err := checkCertificate(cert, []ssh.PublicKey{caSigner.PublicKey()}, now, notRevoked); err != nil || reason != certInvalid {
+				t.Fatalf("checkCertificate: check fail with another CA (%s)", reason)
+			}
+		})
+	t.Run(
+		"Certificate from a trusted CA of the chain",
+		func(t *testing.T) {
+			cert := string(ssh.MarshalAuthorizedKey(newCert(otherSigner, now.Add(time.Minute))))
+			if reason, err := checkCertificate(cert, []ssh.PublicKey{caSigner.PublicKey(), otherSigner.PublicKey()}, now, notRevoked); err != nil || reason != "" {
+				t.Fatalf("checkCertificate: check fail with chain CA (%s)", reason)
+			}
+		})
+	t.Run(
+		"Tampered certificate",
+		func(t *testing.T) {
+			signed := newCert(caSigner, now.Add(time.Minute))
+			signed.KeyId = "mallory"
+			cert := string(ssh.MarshalAuthorizedKey(signed))
+			if reason, err := checkCertificate(cert, []ssh.PublicKey{caSigner.PublicKey()}, now, notRevoked); err != nil || reason != certInvalid {
+				t.Fatalf("checkCertificate: check fail with tampered certificate (%s)", reason)
+			}
+		})
+	t.Run(
+		"Malformed certificate",
+		func(t *testing.T) {
+			if reason, err := checkCertificate("not a certificate", []ssh.PublicKey{caSigner.PublicKey()}, now, notRevoked); err != nil || reason != certInvalid {
+				t.Fatalf("checkCertificate: check fail with malformed certificate (%s)", reason)
+			}
+		})
+}
+
+func TestKeyID(t *testing.T) {
+	nonce := uuid.Must(uuid.NewV4())
+	t.Run(
+		"Format with nonce",
+		func(t *testing.T) {
+			id := keyID("{user}-{remote_user}-{nonce}", "", "alice", "root", nonce)
+			if id != "alice-root-"+nonce.String() {
+				t.Fatalf("keyID: check fail with format (%s)", id)
+			}
+		})
+	t.Run(
+		"Format without nonce",
+		func(t *testing.T) {
+			id := keyID("{user}", "", "alice", "root", nonce)
+			if id != "alice-"+nonce.String() {
+				t.Fatalf("keyID: check fail appending nonce (%s)", id)
+			}
+		})
+	t.Run(
+		"Empty format",
+		func(t *testing.T) {
+			id := keyID("", "", "alice", "root", nonce)
+			if id != nonce.String() {
+				t.Fatalf("keyID: check fail with empty format (%s)", id)
+			}
+		})
+	t.Run(
+		"Environment label",
+		func(t *testing.T) {
+			id := keyID("{user}:{remote_user}", "prod", "alice", "root", nonce)
+			if id != "gsh:prod:alice:root-"+nonce.String() {
+				t.Fatalf("keyID: check fail with environment (%s)", id)
+			}
+
+			// the key id is kept by the signed certificate, as read by sshd
+			pub, _, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatalf("keyID: fail generating user key (%v)", err)
+			}
+			key, err := ssh.NewPublicKey(pub)
+			if err != nil {
+				t.Fatalf("keyID: fail converting user key (%v)", err)
+			}
+			_, caPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatalf("keyID: fail generating CA key (%v)", err)
+			}
+			signer, err := ssh.NewSignerFromKey(caPrivateKey)
+			if err != nil {
+				t.Fatalf("keyID: fail creating CA signer (%v)", err)
+			}
+			cert := &ssh.Certificate{Key: key, CertType: ssh.UserCert, KeyId: id, ValidPrincipals: []string{"root"}, ValidBefore: ssh.CertTimeInfinity}
+			if err := cert.SignCert(rand.Reader, signer); err != nil {
+				t.Fatalf("keyID: fail signing certificate (%v)", err)
+			}
+			parsed, _, _, _, err := ssh.ParseAuthorizedKey(ssh.MarshalAuthorizedKey(cert))
+			if err != nil || !strings.HasPrefix(parsed.(*ssh.Certificate).KeyId, "gsh:prod:") {
+				t.Fatalf("keyID: check fail with signed certificate key id (%v)", err)
+			}
+		})
+	t.Run(
+		"Near simultaneous requests",
+		func(t *testing.T) {
+			ids := make([]string, 2)
+			var wg sync.WaitGroup
+			for i := range ids {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					ids[i] = keyID("{user}", "", "alice", "root", uuid.Must(uuid.NewV4()))
+				}(i)
+			}
+			wg.Wait()
+			if ids[0] == ids[1] {
+				t.Fatalf("keyID: check fail with same key id for two requests (%s)", ids[0])
+			}
+		})
+}
+
+func TestAuthzDenialLog(t *testing.T) {
+	certRequest := &types.CertRequest{
+		Key:        "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKey alice@example.org",
+		RemoteUser: "alice",
+		RemoteHost: "198.51.100.10",
+		UserIP:     "203.0.113.10",
+	}
+	decisions := []permissions.Decision{
+		{Role: "prod-web", Allowed: false, Reason: permissions.DenySourceIP},
+	}
+	logRecord := authzDenialLog("alice", "rid-1", "203.0.113.10", certRequest, decisions)
+	t.Run(
+		"Failing condition",
+		func(t *testing.T) {
+			logged, ok := logRecord["_decisions"].([]permissions.Decision)
+			if !ok || len(logged) != 1 || logged[0].Role != "prod-web" || logged[0].Reason != permissions.DenySourceIP {
+				t.Fatalf("authzDenialLog: check fail with decisions (%v)", logRecord["_decisions"])
+			}
+			if logRecord["_rid"] != "rid-1" || logRecord["_result"] != "deny" {
+				t.Fatalf("authzDenialLog: check fail with correlation (%v)", logRecord)
+			}
+		})
+	t.Run(
+		"Without sensitive data",
+		func(t *testing.T) {
+			for field, value := range logRecord {
+				if s, ok := value.(string); ok && strings.Contains(s, "AAAAC3NzaC1lZDI1NTE5") {
+					t.Fatalf("authzDenialLog: check fail with user key at %s", field)
+				}
+			}
+		})
+}
+
+func TestSignFallback(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("sign: fail generating CA key (%v)", err)
+	}
+	der, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		t.Fatalf("sign: fail marshaling CA key (%v)", err)
+	}
+	caPublicKey, _ := ssh.NewPublicKey(&caKey.PublicKey)
+	userKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	userPublicKey, _ := ssh.NewPublicKey(userKey)
+
+	// Vault is unavailable, failing to return its public key
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	newHandler := func(fallback bool) AppHandler {
+		config := viper.New()
+		config.Set("ca_external", true)
+		config.Set("ca_endpoint", server.URL)
+		config.Set("ca_public_key_url", "/v1/ssh/public_key")
+		config.Set("ca_private_key", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+		config.Set("ca_public_key", string(ssh.MarshalAuthorizedKey(caPublicKey)))
+		config.Set("fallback_internal_ca", fallback)
+		return AppHandler{config: *config, auditChannel: make(chan types.AuditRecord, 10), logChannel: make(chan map[string]interface{}, 10)}
+	}
+	newCert := func() *ssh.Certificate {
+		return &ssh.Certificate{Key: userPublicKey, CertType: ssh.UserCert, KeyId: "gsh:alice:root:1", ValidPrincipals: []string{"root"},
+			ValidBefore: uint64(time.Now().Add(time.Minute).Unix())}
+	}
+
+	t.Run(
+		"External CA failure without fallback",
+		func(t *testing.T) {
+			h := newHandler(false)
+			certRequest := &types.CertRequest{KeyID: "gsh:alice:root:1"}
+			if _, httpErr := h.sign(newCert(), certRequest, "alice"); httpErr == nil {
+				t.Fatalf("sign: check fail, signed with external CA unavailable")
+			}
+			if len(h.auditChannel) != 0 {
+				t.Fatalf("sign: check fail, fallback audited without fallback_internal_ca")
+			}
+		})
+	t.Run(
+		"External CA failure with fallback",
+		func(t *testing.T) {
+			h := newHandler(true)
+			certRequest := &types.CertRequest{KeyID: "gsh:alice:root:1", RemoteUser: "root", RemoteHost: "10.0.0.1"}
+			signedKey, httpErr := h.sign(newCert(), certRequest, "alice")
+			if httpErr != nil {
+				t.Fatalf("sign: check fail with fallback to internal CA (%v)", httpErr.Message)
+			}
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signedKey))
+			if err != nil {
+				t.Fatalf("sign: check fail parsing fallback certificate (%v)", err)
+			}
+			cert := key.(*ssh.Certificate)
+			if ssh.FingerprintSHA256(cert.SignatureKey) != ssh.FingerprintSHA256(caPublicKey) ||
+				certRequest.CAFingerprint != ssh.FingerprintSHA256(caPublicKey) {
+				t.Fatalf("sign: check fail, fallback certificate not signed by internal CA (%s)", certRequest.CAFingerprint)
+			}
+
+			select {
+			case record := <-h.auditChannel:
+				if record.Kind != "cert.fallback" || record.Owner != "alice" || !strings.Contains(record.Error, "Error getting ssh ca public key") ||
+					!strings.Contains(record.Log, "gsh:alice:root:1 to root@10.0.0.1 signed by internal CA") {
+					t.Fatalf("sign: check fail with fallback audit record (%+v)", record)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("sign: check fail, fallback not audited")
+			}
+			select {
+			case record := <-h.logChannel:
+				if record["_action"] != "cert.fallback" {
+					t.Fatalf("sign: check fail with fallback log (%v)", record)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("sign: check fail, fallback not logged")
+			}
+		})
+	t.Run(
+		"Certificate refused by external CA verification",
+		func(t *testing.T) {
+			// Vault answers, but with a certificate signed by a key other than its CA key
+			vaultKey, _, _ := ed25519.GenerateKey(rand.Reader)
+			vaultPublicKey, _ := ssh.NewPublicKey(vaultKey)
+			_, rogueKey, _ := ed25519.GenerateKey(rand.Reader)
+			rogueSigner, _ := ssh.NewSignerFromKey(rogueKey)
+			rogueCert := newCert()
+			if err := rogueCert.SignCert(rand.Reader, rogueSigner); err != nil {
+				t.Fatalf("sign: fail signing rogue certificate (%v)", err)
+			}
+			vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/ssh/public_key":
+					w.Write(ssh.MarshalAuthorizedKey(vaultPublicKey))
+				case "/v1/auth/approle/login":
+					w.Write([]byte(`{"auth": {"client_token": "token"}}`))
+				case "/v1/ssh/sign/gsh":
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"data": map[string]string{"signed_key": string(ssh.MarshalAuthorizedKey(rogueCert))},
+					})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer vault.Close()
+
+			h := newHandler(true)
+			h.config.Set("ca_endpoint", vault.URL)
+			h.config.Set("ca_login_url", "/v1/auth/approle/login")
+			h.config.Set("ca_signer_url", "/v1/ssh/sign/gsh")
+			certRequest := &types.CertRequest{KeyID: "gsh:alice:root:1"}
+			if _, httpErr := h.sign(newCert(), certRequest, "alice"); httpErr == nil {
+				t.Fatalf("sign: check fail, signed a certificate refused by external CA verification")
+			}
+			if certRequest.CAFingerprint != "" {
+				t.Fatalf("sign: check fail, fell back to internal CA after verification failure (%s)", certRequest.CAFingerprint)
+			}
+			select {
+			case record := <-h.auditChannel:
+				t.Fatalf("sign: check fail, fallback audited after verification failure (%+v)", record)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+}
+
+func TestFallbackAuditRecord(t *testing.T) {
+	certRequest := &types.CertRequest{UID: uuid.Must(uuid.NewV4()), KeyID: "gsh:alice:root:1", RemoteUser: "root", RemoteHost: "10.0.0.1"}
+	record := fallbackAuditRecord(certRequest, "alice", "Vault unavailable", time.Now())
+	if record.TargetUID != certRequest.UID || record.Kind != "cert.fallback" {
+		t.Fatalf("fallbackAuditRecord: check fail with certificate request (%+v)", record)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/api/challenges"
+	"github.com/globocom/gsh/types"
+	"github.com/labstack/echo"
+)
+
+// CertChallenge issues single-use nonces to the authenticated user, each signed by the client in the
+// key proof of a certificate request. One nonce is issued, or count of them (up to cert_batch_limit)
+// for the requests of a batch.
+func (h AppHandler) CertChallenge(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	count, err := challengeCount(c.QueryParam("count"), h.config.GetInt("cert_batch_limit"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid number of nonces", "details": err.Error()})
+	}
+
+	// expired challenges can't be used anymore, they are removed while new ones are issued
+	now := time.Now()
+	h.db.Where("expires_at < ?", now).Delete(&types.CertChallenge{})
+	nonces := make([]string, 0, count)
+	var expiresAt time.Time
+	for i := 0; i < count; i++ {
+		challenge, err := challenges.New(username, now, h.config.GetDuration("ca_key_proof_challenge_ttl"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Error generating nonce", "details": err.Error()})
+		}
+		dbc := h.db.Create(&challenge)
+		if h.db.NewRecord(&challenge) {
+			return c.JSON(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Error storing nonce", "details": dbc.Error.Error()})
+		}
+		nonces = append(nonces, challenge.Nonce)
+		expiresAt = challenge.ExpiresAt
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"result":     "success",
+		"nonce":      nonces[0],
+		"nonces":     nonces,
+		"expires_at": expiresAt.Format(time.RFC3339),
+	})
+}
+
+// challengeCount returns the number of nonces asked by value (count query parameter), from 1 to limit.
+// Without value, one nonce is issued.
+func challengeCount(value string, limit int) (int, error) {
+	if value == "" {
+		return 1, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 || count > limit {
+		return 0, fmt.Errorf("count must be a number from 1 to %d (%s)", limit, value)
+	}
+	return count, nil
+}
+
+// consumeChallenge marks nonce as used by username at now, so it is never accepted again
+func (h AppHandler) consumeChallenge(nonce string, username string, now time.Time) error {
+	challenge := new(types.CertChallenge)
+	if h.db.Where("nonce = ?", nonce).First(challenge).RecordNotFound() {
+		return errors.New("consumeChallenge: nonce was not issued by GSH API")
+	}
+	if err := challenges.Consume(challenge, username, now); err != nil {
+		return err
+	}
+
+	// only one of concurrent requests with the same nonce consumes it
+	dbc := h.db.Model(&types.CertChallenge{}).
+		Where("id = ? AND consumed_at IS NULL", challenge.ID).
+		Update("consumed_at", now)
+	if dbc.Error != nil || dbc.RowsAffected != 1 {
+		return challenges.ErrConsumed
+	}
+	return nil
+}
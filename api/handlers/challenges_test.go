@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestChallengeCount(t *testing.T) {
+	t.Run(
+		"One nonce by default",
+		func(t *testing.T) {
+			if count, err := challengeCount("", 20); err != nil || count != 1 {
+				t.Fatalf("challengeCount: check fail without count (%d, %v)", count, err)
+			}
+		})
+	t.Run(
+		"Nonces of a batch",
+		func(t *testing.T) {
+			if count, err := challengeCount("20", 20); err != nil || count != 20 {
+				t.Fatalf("challengeCount: check fail with batch count (%d, %v)", count, err)
+			}
+		})
+	t.Run(
+		"Invalid counts",
+		func(t *testing.T) {
+			for _, value := range []string{"0", "-1", "21", "many"} {
+				if _, err := challengeCount(value, 20); err == nil {
+					t.Fatalf("challengeCount: check fail with count %s", value)
+				}
+			}
+		})
+}
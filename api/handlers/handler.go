@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/casbin/casbin"
+	"github.com/globocom/gsh/api/hsm"
+	"github.com/globocom/gsh/api/principal"
+	"github.com/globocom/gsh/api/signlimit"
 	"github.com/globocom/gsh/types"
 	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
 	"github.com/spf13/viper"
 )
 
@@ -13,16 +20,85 @@ type AppHandler struct {
 	auditChannel chan types.AuditRecord
 	logChannel   chan map[string]interface{}
 	db           *gorm.DB
+	replica      *gorm.DB
 	permEnforcer *casbin.Enforcer
+	signLimiter  *signlimit.Limiter
+	// quotaGate is shared by copies of the handler, serializing quota checks of concurrent requests
+	quotaGate *quotaGate
+	// hsm signs certificates when the CA key is at an HSM (ca_pkcs11_module)
+	hsm *hsm.Agent
 }
 
-// NewAppHandler return a new pointer of user struct
-func NewAppHandler(config viper.Viper, auditChannel chan types.AuditRecord, logChannel chan map[string]interface{}, db *gorm.DB, permEnforcer *casbin.Enforcer) *AppHandler {
+// NewAppHandler return a new pointer of user struct. replica is used by list and audit
+// queries, and can be the same as db.
+func NewAppHandler(config viper.Viper, auditChannel chan types.AuditRecord, logChannel chan map[string]interface{}, db *gorm.DB, replica *gorm.DB, permEnforcer *casbin.Enforcer) *AppHandler {
 	return &AppHandler{
 		config:       config,
 		auditChannel: auditChannel,
 		logChannel:   logChannel,
 		db:           db,
+		replica:      replica,
 		permEnforcer: permEnforcer,
+		signLimiter:  signlimit.New(config.GetInt("max_concurrent_signs"), config.GetDuration("sign_queue_timeout")),
+		quotaGate:    newQuotaGate(),
+	}
+}
+
+// UseHSM makes the internal signer sign certificates through agent, holding the CA key of an HSM
+func (h *AppHandler) UseHSM(agent *hsm.Agent) {
+	h.hsm = agent
+}
+
+// ReadOnly tells whether maintenance mode is active, set by read_only or, to enable it without
+// restarting, by the existence of read_only_file
+func (h AppHandler) ReadOnly() bool {
+	if h.config.GetBool("read_only") {
+		return true
+	}
+	if file := h.config.GetString("read_only_file"); file != "" {
+		if _, err := os.Stat(file); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// reader returns the database used by read only queries, the replica when configured
+func (h AppHandler) reader() *gorm.DB {
+	if h.replica != nil {
+		return h.replica
 	}
+	return h.db
+}
+
+// principalFor returns the certificate principal (unix username) of an authenticated identity,
+// transformed as configured by principal_template, principal_pattern and principal_case
+func (h AppHandler) principalFor(username string) (string, error) {
+	transformer, err := principal.New(h.config.GetString("principal_template"), h.config.GetString("principal_pattern"),
+		h.config.GetString("principal_replacement"), h.principalCase())
+	if err != nil {
+		return "", err
+	}
+	return transformer.Apply(username)
+}
+
+// identityPrincipal returns the certificate principal of the identity authenticated at c as username.
+// With principal_claim, it is sourced from that claim of the token instead of the username, failing
+// if the token doesn't have it.
+func (h AppHandler) identityPrincipal(c echo.Context, username string) (string, error) {
+	claim := h.config.GetString("principal_claim")
+	if claim == "" {
+		return h.principalFor(username)
+	}
+	source, ok := c.Get("principal").(string)
+	if !ok || source == "" {
+		return "", fmt.Errorf("token has no principal claim (%s)", claim)
+	}
+	return h.principalFor(source)
+}
+
+// principalCase returns the case folding of principals (principal_case), applied to the principal
+// of the identity and to the principals requested, so they match roles and certificates alike
+func (h AppHandler) principalCase() string {
+	return principal.EffectiveCase(h.config.GetString("principal_case"), h.config.GetBool("principal_lowercase"))
 }
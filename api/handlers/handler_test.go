@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+func TestReader(t *testing.T) {
+	primary := &gorm.DB{}
+	replica := &gorm.DB{}
+	t.Run(
+		"Reads use replica",
+		func(t *testing.T) {
+			h := AppHandler{db: primary, replica: replica}
+			if h.reader() != replica {
+				t.Fatalf("reader: check fail using replica")
+			}
+		})
+	t.Run(
+		"Reads fall back to primary",
+		func(t *testing.T) {
+			h := AppHandler{db: primary}
+			if h.reader() != primary {
+				t.Fatalf("reader: check fail without replica")
+			}
+		})
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Run(
+		"read_only",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("read_only", true)
+			if !(AppHandler{config: *config}).ReadOnly() {
+				t.Fatalf("ReadOnly: check fail with read_only")
+			}
+		})
+	t.Run(
+		"read_only_file",
+		func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "maintenance")
+			config := viper.New()
+			config.Set("read_only_file", file)
+			h := AppHandler{config: *config}
+			if h.ReadOnly() {
+				t.Fatalf("ReadOnly: check fail without read_only_file")
+			}
+			if err := os.WriteFile(file, []byte{}, 0600); err != nil {
+				t.Fatalf("ReadOnly: check fail writing read_only_file (%v)", err)
+			}
+			if !h.ReadOnly() {
+				t.Fatalf("ReadOnly: check fail with read_only_file")
+			}
+		})
+}
+
+func TestPrincipalFor(t *testing.T) {
+	t.Run(
+		"Identity kept by default",
+		func(t *testing.T) {
+			principal, err := (AppHandler{config: *viper.New()}).principalFor("alice.smith@example.org")
+			if err != nil || principal != "alice.smith@example.org" {
+				t.Fatalf("principalFor: check fail without template (%s, %v)", principal, err)
+			}
+		})
+	t.Run(
+		"Local part of email",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("principal_template", "{local}")
+			config.Set("principal_pattern", `\+.*$`)
+			config.Set("principal_lowercase", true)
+			principal, err := (AppHandler{config: *config}).principalFor("Alice.Smith+ops@example.org")
+			if err != nil || principal != "alice.smith" {
+				t.Fatalf("principalFor: check fail with template (%s, %v)", principal, err)
+			}
+		})
+	t.Run(
+		"Mixed case identity",
+		func(t *testing.T) {
+			for principalCase, expected := range map[string]string{"lower": "john.doe", "upper": "JOHN.DOE", "preserve": "John.Doe"} {
+				config := viper.New()
+				config.Set("principal_template", "{local}")
+				config.Set("principal_case", principalCase)
+				// principal_case replaces principal_lowercase
+				config.Set("principal_lowercase", true)
+				principal, err := (AppHandler{config: *config}).principalFor("John.Doe@example.org")
+				if err != nil || principal != expected {
+					t.Fatalf("principalFor: check fail with principal_case %s (%s, %v)", principalCase, principal, err)
+				}
+			}
+		})
+}
+
+func TestIdentityPrincipal(t *testing.T) {
+	t.Run(
+		"Principal claim distinct from username claim",
+		func(t *testing.T) {
+			c := echo.New().AcquireContext()
+			// username claim is the human identity, principal claim the unix account
+			c.Set("principal", "asmith")
+			config := viper.New()
+			config.Set("principal_claim", "unix_username")
+			principal, err := (AppHandler{config: *config}).identityPrincipal(c, "alice.smith@example.org")
+			if err != nil || principal != "asmith" {
+				t.Fatalf("identityPrincipal: check fail with principal claim (%s, %v)", principal, err)
+			}
+
+			principal, err = (AppHandler{config: *viper.New()}).identityPrincipal(c, "alice.smith@example.org")
+			if err != nil || principal != "alice.smith@example.org" {
+				t.Fatalf("identityPrincipal: check fail falling back to username claim (%s, %v)", principal, err)
+			}
+		})
+	t.Run(
+		"Principal claim transformed as usernames",
+		func(t *testing.T) {
+			c := echo.New().AcquireContext()
+			c.Set("principal", "ASmith")
+			config := viper.New()
+			config.Set("principal_claim", "unix_username")
+			config.Set("principal_case", "lower")
+			principal, err := (AppHandler{config: *config}).identityPrincipal(c, "alice.smith@example.org")
+			if err != nil || principal != "asmith" {
+				t.Fatalf("identityPrincipal: check fail with principal_case (%s, %v)", principal, err)
+			}
+		})
+	t.Run(
+		"Token without principal claim",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("principal_claim", "unix_username")
+			if principal, err := (AppHandler{config: *config}).identityPrincipal(echo.New().AcquireContext(), "alice.smith@example.org"); err == nil {
+				t.Fatalf("identityPrincipal: check fail, username used without principal claim (%s)", principal)
+			}
+		})
+}
+
+func TestCapabilities(t *testing.T) {
+	t.Run(
+		"Default config",
+		func(t *testing.T) {
+			if capabilities := (AppHandler{config: *viper.New()}).capabilities(); len(capabilities) != 0 {
+				t.Fatalf("capabilities: check fail with default config (%v)", capabilities)
+			}
+		})
+	t.Run(
+		"Enabled features",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("approval_roles", []string{"prod-db"})
+			config.Set("ca_external", true)
+			config.Set("ca_reason_extension", true)
+			capabilities := (AppHandler{config: *config}).capabilities()
+			if strings.Join(capabilities, ",") != "approvals,external_ca,reason_extension" {
+				t.Fatalf("capabilities: check fail with enabled features (%v)", capabilities)
+			}
+		})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"github.com/gofrs/uuid"
+	"github.com/labstack/echo"
+)
+
+// errImpersonationLimit is returned when an admin impersonated users too many times recently
+var errImpersonationLimit = errors.New("checkImpersonation: too many impersonations, wait before impersonating again (impersonation_limit)")
+
+// checkImpersonation checks if admin can request a certificate on behalf of user, having done it
+// recent times at the last impersonation_window. Only admins (perm_admin) impersonate, up to limit.
+func checkImpersonation(admins []string, admin string, user string, recent int, limit int) error {
+	if !contains(admins, admin) {
+		return errors.New("checkImpersonation: only admins (perm_admin) can request certificates on behalf of other users")
+	}
+	if user == admin {
+		return errors.New("checkImpersonation: admins can't impersonate themselves")
+	}
+	if limit <= 0 {
+		return errors.New("checkImpersonation: impersonation is disabled (impersonation_limit)")
+	}
+	if recent >= limit {
+		return errImpersonationLimit
+	}
+	return nil
+}
+
+// impersonationRecord returns the audit record of admin requesting a certificate on behalf of
+// user, with both users. It records the refusal when err is not nil.
+func impersonationRecord(admin string, user string, jti string, reason string, initTime time.Time, err error) types.AuditRecord {
+	record := types.AuditRecord{
+		UID:       uuid.Must(uuid.NewV4()),
+		StartTime: initTime,
+		EndTime:   time.Now(),
+		Kind:      "cert.impersonate",
+		Owner:     admin,
+		JTI:       jti,
+		Log:       fmt.Sprintf("Certificate requested by %s on behalf of %s: %s", admin, user, reason),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// impersonate authorizes admin to request the certificate at certRequest on behalf of
+// certRequest.Impersonate, auditing the attempt. The certificate is still authorized by the
+// roles of the impersonated user.
+func (h AppHandler) impersonate(certRequest *types.CertRequest, admin string, jti string, initTime time.Time) *echo.HTTPError {
+	recent := 0
+	if contains(h.config.GetStringSlice("perm_admin"), admin) {
+		since := initTime.Add(-h.config.GetDuration("impersonation_window"))
+		if err := h.db.Model(&types.CertRequest{}).Where("impersonated_by = ? AND created_at > ?", admin, since).Count(&recent).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Error reading impersonations", "details": err.Error()})
+		}
+	}
+	err := checkImpersonation(h.config.GetStringSlice("perm_admin"), admin, certRequest.Impersonate, recent, h.config.GetInt("impersonation_limit"))
+
+	record := impersonationRecord(admin, certRequest.Impersonate, jti, certRequest.Reason, initTime, err)
+	go func() {
+		h.auditChannel <- record
+	}()
+
+	if err == errImpersonationLimit {
+		return echo.NewHTTPError(http.StatusTooManyRequests,
+			map[string]string{"result": "fail", "message": "Impersonation limit reached", "details": err.Error()})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "You can't request certificates on behalf of other users", "details": err.Error()})
+	}
+	certRequest.ImpersonatedBy = admin
+	return nil
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckImpersonation(t *testing.T) {
+	admins := []string{"alice"}
+	t.Run(
+		"Admin impersonating",
+		func(t *testing.T) {
+			if err := checkImpersonation(admins, "alice", "bob", 0, 5); err != nil {
+				t.Fatalf("checkImpersonation: check fail with admin (%v)", err)
+			}
+		})
+	t.Run(
+		"Non admin refused",
+		func(t *testing.T) {
+			if err := checkImpersonation(admins, "bob", "carol", 0, 5); err == nil {
+				t.Fatalf("checkImpersonation: check fail with non admin")
+			}
+		})
+	t.Run(
+		"Admin impersonating own user",
+		func(t *testing.T) {
+			if err := checkImpersonation(admins, "alice", "alice", 0, 5); err == nil {
+				t.Fatalf("checkImpersonation: check fail with admin impersonating own user")
+			}
+		})
+	t.Run(
+		"Limit reached",
+		func(t *testing.T) {
+			if err := checkImpersonation(admins, "alice", "bob", 5, 5); err != errImpersonationLimit {
+				t.Fatalf("checkImpersonation: check fail with limit reached (%v)", err)
+			}
+		})
+	t.Run(
+		"Impersonation disabled",
+		func(t *testing.T) {
+			if err := checkImpersonation(admins, "alice", "bob", 0, 0); err == nil {
+				t.Fatalf("checkImpersonation: check fail with impersonation disabled")
+			}
+		})
+}
+
+func TestImpersonationRecord(t *testing.T) {
+	now := time.Now()
+	t.Run(
+		"Impersonation audited",
+		func(t *testing.T) {
+			record := impersonationRecord("alice", "bob", "jti", "INC-1234", now, nil)
+			if record.Kind != "cert.impersonate" || record.Owner != "alice" || record.JTI != "jti" || record.Error != "" {
+				t.Fatalf("impersonationRecord: check fail with record (%v)", record)
+			}
+			if !strings.Contains(record.Log, "alice") || !strings.Contains(record.Log, "bob") || !strings.Contains(record.Log, "INC-1234") {
+				t.Fatalf("impersonationRecord: check fail, admin, user and reason not recorded (%s)", record.Log)
+			}
+		})
+	t.Run(
+		"Refusal audited",
+		func(t *testing.T) {
+			record := impersonationRecord("bob", "carol", "jti", "INC-1234", now, errors.New("refused"))
+			if record.Owner != "bob" || record.Error != "refused" || !strings.Contains(record.Log, "carol") {
+				t.Fatalf("impersonationRecord: check fail with refusal (%v)", record)
+			}
+		})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/types"
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/ssh"
+)
+
+// Info reports the active signer backend and CA, a sanity check for operators after deploys.
+//
+// - Output sample
+//
+//	{
+//		"backend":"vault",
+//		"ca_fingerprint":"SHA256:hA2w0BvBVf0u4HS5Xh6ZzcIGEbtfHdJ2kj8S6dpnOtQ",
+//		"max_ttl":"5m0s",
+//		"allowed_key_types":["any"],
+//		"read_only":false
+//	}
+func (h AppHandler) Info(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user has permission to see signer details
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't see signer info"})
+	}
+
+	return c.JSON(http.StatusOK, h.SignerInfo())
+}
+
+// SignerInfo reads the active signer from the resolved config. The CA public key of the vault
+// backend is fetched from it, a failure is reported at CAError.
+func (h AppHandler) SignerInfo() types.SignerInfo {
+	info := types.SignerInfo{
+		Backend: types.SignerInternal,
+		MaxTTL:  h.config.GetDuration("ca_signed_cert_duration").String(),
+		// user keys are not restricted by type, any key parsed as an authorized key is certified
+		AllowedKeyTypes: []string{types.AnyKeyType},
+		ReadOnly:        h.ReadOnly(),
+	}
+	if h.config.GetBool("ca_external") {
+		info.Backend = types.SignerVault
+	} else if h.config.GetString("ca_pkcs11_module") != "" {
+		info.Backend = types.SignerHSM
+	}
+
+	publicKey, err := h.caPublicKey()
+	if err != nil {
+		info.CAError = err.Error()
+		return info
+	}
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		info.CAError = "Parse the public key (" + err.Error() + ")"
+		return info
+	}
+	info.CAFingerprint = ssh.FingerprintSHA256(caPublicKey)
+	return info
+}
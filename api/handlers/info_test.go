@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignerInfo(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("SignerInfo: check fail generating CA key (%v)", err)
+	}
+	caPublicKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("SignerInfo: check fail generating CA key (%v)", err)
+	}
+	authorizedKey := string(ssh.MarshalAuthorizedKey(caPublicKey))
+
+	t.Run(
+		"Internal signer",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("ca_public_key", authorizedKey)
+			config.Set("ca_signed_cert_duration", "5m")
+			config.Set("read_only", true)
+			info := (AppHandler{config: *config}).SignerInfo()
+			if info.Backend != types.SignerInternal {
+				t.Fatalf("SignerInfo: check fail with internal signer (%v)", info.Backend)
+			}
+			if info.CAFingerprint != ssh.FingerprintSHA256(caPublicKey) || info.CAError != "" {
+				t.Fatalf("SignerInfo: check fail with CA fingerprint (%v, %v)", info.CAFingerprint, info.CAError)
+			}
+			if info.MaxTTL != "5m0s" || !info.ReadOnly {
+				t.Fatalf("SignerInfo: check fail with max ttl and read only (%v, %v)", info.MaxTTL, info.ReadOnly)
+			}
+			if len(info.AllowedKeyTypes) != 1 || info.AllowedKeyTypes[0] != types.AnyKeyType {
+				t.Fatalf("SignerInfo: check fail with allowed key types (%v)", info.AllowedKeyTypes)
+			}
+		})
+	t.Run(
+		"Vault signer",
+		func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/ssh/public_key" {
+					http.NotFound(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(authorizedKey))
+			}))
+			defer server.Close()
+			config := viper.New()
+			config.Set("ca_external", true)
+			config.Set("ca_endpoint", server.URL)
+			config.Set("ca_public_key_url", "/v1/ssh/public_key")
+			info := (AppHandler{config: *config}).SignerInfo()
+			if info.Backend != types.SignerVault {
+				t.Fatalf("SignerInfo: check fail with vault signer (%v)", info.Backend)
+			}
+			if info.CAFingerprint != ssh.FingerprintSHA256(caPublicKey) {
+				t.Fatalf("SignerInfo: check fail with vault CA fingerprint (%v, %v)", info.CAFingerprint, info.CAError)
+			}
+		})
+	t.Run(
+		"CA unavailable",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("ca_public_key", "not a key")
+			info := (AppHandler{config: *config}).SignerInfo()
+			if info.Backend != types.SignerInternal || info.CAError == "" || info.CAFingerprint != "" {
+				t.Fatalf("SignerInfo: check fail without valid CA key (%+v)", info)
+			}
+		})
+}
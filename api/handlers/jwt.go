@@ -271,6 +271,15 @@ func GetClaim(jwt string, claim string) (string, error) {
 	return field, nil
 }
 
+// GetTokenClaim returns a claim from an already parsed token, like claims read from OIDC userinfo endpoint
+func GetTokenClaim(token *IDToken, claim string) (string, error) {
+	field, err := getField(token, claim)
+	if err != nil {
+		return "", fmt.Errorf("GetTokenClaim: The field declared in oidc_claim doesn't exist (%v)", err.Error())
+	}
+	return field, nil
+}
+
 // getField returns the value of a field in a token or error if the field doesn't exist
 func getField(token *IDToken, field string) (string, error) {
 	r := reflect.ValueOf(token)
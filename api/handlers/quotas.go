@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/types"
+	"github.com/labstack/echo"
+)
+
+// errQuotaExceeded is returned when a user was issued as many certificates as the quota allows
+var errQuotaExceeded = errors.New("checkQuota: certificate quota reached, wait or ask an admin to raise it (cert_quota)")
+
+// checkQuota checks if a user issued certificates at the last cert_quota_window can get another
+// one. A quota of zero (or less) is no limit.
+func checkQuota(issued int, quota int) error {
+	if quota <= 0 {
+		return nil
+	}
+	if issued >= quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// userQuota returns the certificate quota of username: the one set by an admin or cert_quota
+func (h AppHandler) userQuota(username string) (int, error) {
+	override := new(types.CertQuota)
+	dbc := h.db.Where("username = ?", username).First(override)
+	if dbc.RecordNotFound() {
+		return h.config.GetInt("cert_quota"), nil
+	}
+	if dbc.Error != nil {
+		return 0, dbc.Error
+	}
+	return override.Quota, nil
+}
+
+// quotaGate serializes quota checks with the start of issuances, so concurrent requests of a user
+// can't all pass a quota with room for only one of them. Issuances in progress count on the quota
+// until they are released, when the issued certificate is already stored.
+type quotaGate struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// newQuotaGate returns a quotaGate without issuances in progress
+func newQuotaGate() *quotaGate {
+	return &quotaGate{inFlight: map[string]int{}}
+}
+
+// reserve checks the quota of username, counting stored certificates (issued) and issuances in
+// progress, and runs claim (as the conditional update of an approved request) under the same lock.
+// It returns how many certificates count on the quota and, when allowed, the release of the issuance.
+func (g *quotaGate) reserve(username string, quota int, issued func() (int, error), claim func() error) (func(), int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	count := 0
+	if quota > 0 {
+		stored, err := issued()
+		if err != nil {
+			return nil, 0, err
+		}
+		count = stored + g.inFlight[username]
+		if err := checkQuota(count, quota); err != nil {
+			return nil, count, err
+		}
+	}
+	if claim != nil {
+		if err := claim(); err != nil {
+			return nil, count, err
+		}
+	}
+
+	g.inFlight[username]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.inFlight[username]--; g.inFlight[username] <= 0 {
+				delete(g.inFlight, username)
+			}
+		})
+	}, count, nil
+}
+
+// reserveIssuance starts the issuance of a certificate to username at now: it checks maintenance
+// mode and the quota, counting certificates issued at the last cert_quota_window and the ones being
+// issued, and runs claim (the conditional update of an approved request, or nil) at once. Every
+// issuance but break-glass passes here, release must be called once the certificate is stored or
+// not issued.
+func (h AppHandler) reserveIssuance(username string, now time.Time, claim func() *echo.HTTPError) (func(), *echo.HTTPError) {
+	if h.ReadOnly() {
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable,
+			map[string]string{"result": "fail", "message": "GSH is in maintenance mode (read only)", "details": "Certificates can't be issued now, try again later"})
+	}
+	quota, err := h.userQuota(username)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading certificate quota", "details": err.Error()})
+	}
+
+	window := h.config.GetDuration("cert_quota_window")
+	issued := func() (int, error) {
+		stored := 0
+		err := h.db.Model(&types.CertRequest{}).Where("owner = ? AND created_at > ?", username, now.Add(-window)).Count(&stored).Error
+		return stored, err
+	}
+	var gateClaim func() error
+	if claim != nil {
+		gateClaim = func() error {
+			if httpErr := claim(); httpErr != nil {
+				return httpErr
+			}
+			return nil
+		}
+	}
+	release, count, err := h.quotaGate.reserve(username, quota, issued, gateClaim)
+	if httpErr, ok := err.(*echo.HTTPError); ok {
+		return nil, httpErr
+	}
+	if err == errQuotaExceeded {
+		return nil, echo.NewHTTPError(http.StatusTooManyRequests,
+			map[string]string{"result": "fail", "message": "Certificate quota reached",
+				"details": fmt.Sprintf("%d certificates issued in the last %s, the quota is %d (%s)", count, window, quota, err.Error())})
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading issued certificates", "details": err.Error()})
+	}
+	return release, nil
+}
+
+// SetQuota sets the certificate quota of a user, overriding cert_quota
+func (h AppHandler) SetQuota(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user setting the quota has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't set certificate quotas"})
+	}
+
+	quota := new(types.CertQuota)
+	if err := c.Bind(quota); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Importing data requested in types.CertQuota struct", "details": err.Error()})
+	}
+	if quota.Quota < 0 {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid quota", "details": "Quota must not be negative, zero is no limit"})
+	}
+	quota.Username = c.Param("user")
+	quota.SetBy = username
+	if err := h.db.Save(quota).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error storing certificate quota", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": fmt.Sprintf("Certificate quota of %s set to %d", quota.Username, quota.Quota)})
+}
+
+// RemoveQuota removes the certificate quota set for a user, who gets cert_quota again
+func (h AppHandler) RemoveQuota(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user removing the quota has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't remove certificate quotas"})
+	}
+
+	if err := h.db.Where("username = ?", c.Param("user")).Delete(&types.CertQuota{}).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error removing certificate quota", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": fmt.Sprintf("Certificate quota of %s is cert_quota again", c.Param("user"))})
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestCheckQuota(t *testing.T) {
+	t.Run(
+		"Below quota",
+		func(t *testing.T) {
+			if err := checkQuota(9, 10); err != nil {
+				t.Fatalf("checkQuota: check fail below quota (%v)", err)
+			}
+		})
+	t.Run(
+		"At quota",
+		func(t *testing.T) {
+			if err := checkQuota(10, 10); err != errQuotaExceeded {
+				t.Fatalf("checkQuota: check fail at quota boundary (%v)", err)
+			}
+		})
+	t.Run(
+		"Above quota",
+		func(t *testing.T) {
+			if err := checkQuota(11, 10); err != errQuotaExceeded {
+				t.Fatalf("checkQuota: check fail above quota (%v)", err)
+			}
+		})
+	t.Run(
+		"Quota disabled",
+		func(t *testing.T) {
+			if err := checkQuota(1000, 0); err != nil {
+				t.Fatalf("checkQuota: check fail without quota (%v)", err)
+			}
+		})
+}
+
+func TestQuotaGate(t *testing.T) {
+	// reserveAll reserves concurrently for alice, holding every reservation until all have tried
+	reserveAll := func(g *quotaGate, quota int, issued func() (int, error), claim func() error) []func() {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var releases []func()
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if release, _, err := g.reserve("alice", quota, issued, claim); err == nil {
+					mu.Lock()
+					releases = append(releases, release)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		return releases
+	}
+	noneIssued := func() (int, error) { return 0, nil }
+
+	t.Run(
+		"Quota under concurrency",
+		func(t *testing.T) {
+			g := newQuotaGate()
+			releases := reserveAll(g, 3, noneIssued, nil)
+			if len(releases) != 3 {
+				t.Fatalf("quotaGate: check fail, %d issuances allowed with quota 3", len(releases))
+			}
+			if _, count, err := g.reserve("alice", 3, noneIssued, nil); err != errQuotaExceeded || count != 3 {
+				t.Fatalf("quotaGate: check fail, issuances in progress not counted (%d, %v)", count, err)
+			}
+			if _, _, err := g.reserve("bob", 3, noneIssued, nil); err != nil {
+				t.Fatalf("quotaGate: check fail, quota of another user used (%v)", err)
+			}
+			releases[0]()
+			releases[0]()
+			if _, _, err := g.reserve("alice", 3, noneIssued, nil); err != nil {
+				t.Fatalf("quotaGate: check fail, released issuance still counted (%v)", err)
+			}
+		})
+	t.Run(
+		"Stored certificates",
+		func(t *testing.T) {
+			g := newQuotaGate()
+			if _, count, err := g.reserve("alice", 3, func() (int, error) { return 3, nil }, nil); err != errQuotaExceeded || count != 3 {
+				t.Fatalf("quotaGate: check fail with stored certificates (%d, %v)", count, err)
+			}
+			countErr := errors.New("database is down")
+			if _, _, err := g.reserve("alice", 3, func() (int, error) { return 0, countErr }, nil); err != countErr {
+				t.Fatalf("quotaGate: check fail counting certificates (%v)", err)
+			}
+		})
+	t.Run(
+		"Approval issued once",
+		func(t *testing.T) {
+			g := newQuotaGate()
+			status := "approved"
+			claim := func() error {
+				if status != "approved" {
+					return errors.New("already issued")
+				}
+				status = "issued"
+				return nil
+			}
+			if releases := reserveAll(g, 10, noneIssued, claim); len(releases) != 1 || status != "issued" {
+				t.Fatalf("quotaGate: check fail, approved request issued %d times", len(releases))
+			}
+		})
+	t.Run(
+		"Approval over quota",
+		func(t *testing.T) {
+			g := newQuotaGate()
+			claimed := 0
+			claim := func() error {
+				claimed++
+				return nil
+			}
+			if _, _, err := g.reserve("alice", 1, func() (int, error) { return 1, nil }, claim); err != errQuotaExceeded || claimed != 0 {
+				t.Fatalf("quotaGate: check fail, approval claimed over quota (%v, %d)", err, claimed)
+			}
+		})
+}
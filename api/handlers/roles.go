@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/api/permissions"
 	"github.com/globocom/gsh/types"
 
 	"github.com/gosimple/slug"
@@ -29,24 +33,23 @@ func (h AppHandler) GetRolesForMe(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError,
 			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
 	}
-	myRoles := h.permEnforcer.GetRolesForUser(username)
+	myRoles := h.effectiveRoles(c, username)
 	allRoles := h.permEnforcer.GetPolicy()
 
 	forMeRoles := []types.Role{}
 	for _, role := range allRoles {
 		for _, myRole := range myRoles {
 			if role[0] == myRole {
-				forMeRoles = append(forMeRoles, types.Role{
-					ID:         role[0],
-					RemoteUser: role[1],
-					SourceIP:   role[2],
-					TargetIP:   role[3],
-					Actions:    role[4],
-				})
+				forMeRoles = append(forMeRoles, roleFromPolicy(role))
 			}
 		}
 	}
 
+	if err := h.withExtensions(forMeRoles); err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading role extensions", "details": err.Error()})
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{"result": "success", "roles": forMeRoles})
 }
 
@@ -78,19 +81,20 @@ func (h AppHandler) GetRoles(c echo.Context) error {
 
 	completedRoles := []types.Role{}
 	for _, role := range roles {
-		completedRoles = append(completedRoles, types.Role{
-			ID:         role[0],
-			RemoteUser: role[1],
-			SourceIP:   role[2],
-			TargetIP:   role[3],
-			Actions:    role[4],
-		})
+		completedRoles = append(completedRoles, roleFromPolicy(role))
+	}
+
+	if err := h.withExtensions(completedRoles); err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading role extensions", "details": err.Error()})
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{"result": "success", "roles": completedRoles})
 }
 
-// AddRoles adds a new role
+// AddRoles adds a new role. Optional dest_ports ("22;2222") restricts the destination ports of
+// the connections authorized by the role, and optional extensions ("permit-pty;permit-user-rc")
+// are the certificate extensions it grants, default extensions when empty.
 func (h AppHandler) AddRoles(c echo.Context) error {
 	// Validates JWT token before any other action
 	ca := auth.OpenIDCAuth{}
@@ -141,27 +145,11 @@ func (h AppHandler) AddRoles(c echo.Context) error {
 			map[string]string{"result": "fail", "message": "RoleID already exists"})
 	}
 
-	// Validates if the IPs read are in a valid format
-	sourceIPs := []string{}
-	for _, sourceEntryIP := range strings.Split(requestPolicy.SourceIP, ";") {
-		_, sorceIPNet, err := net.ParseCIDR(sourceEntryIP)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest,
-				map[string]string{"result": "fail", "message": "Invalid UserIP format", "details": err.Error()})
-		}
-		sourceIPs = append(sourceIPs, sorceIPNet.String())
-	}
-	targetIPs := []string{}
-	for _, targetEntryIP := range strings.Split(requestPolicy.TargetIP, ";") {
-		_, targetIPNet, err := net.ParseCIDR(targetEntryIP)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest,
-				map[string]string{"result": "fail", "message": "Invalid RemoteHost format", "details": err.Error()})
-		}
-		targetIPs = append(targetIPs, targetIPNet.String())
+	// Validates role fields, normalizing them
+	if message, err := normalizeRole(requestPolicy); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": message, "details": err.Error()})
 	}
-	requestPolicy.SourceIP = strings.Join(sourceIPs, ";")
-	requestPolicy.TargetIP = strings.Join(targetIPs, ";")
 
 	// Adds role if not existent
 	err = h.permEnforcer.LoadPolicy()
@@ -169,7 +157,17 @@ func (h AppHandler) AddRoles(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError,
 			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
 	}
-	check, err := h.permEnforcer.AddPolicySafe(requestPolicy.ID, requestPolicy.RemoteUser, requestPolicy.SourceIP, requestPolicy.TargetIP, requestPolicy.Actions)
+	// Extensions are stored before the policy, so the role never grants other extensions
+	if requestPolicy.Extensions != "" {
+		err = h.db.Save(&types.RoleExtensions{RoleID: requestPolicy.ID, Extensions: requestPolicy.Extensions}).Error
+	} else {
+		err = h.db.Where("role_id = ?", requestPolicy.ID).Delete(&types.RoleExtensions{}).Error
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error storing role extensions", "details": err.Error()})
+	}
+	check, err := h.permEnforcer.AddPolicySafe(policyParams(*requestPolicy)...)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError,
 			map[string]string{"result": "fail", "message": "Error adding new role", "details": err.Error()})
@@ -182,7 +180,73 @@ func (h AppHandler) AddRoles(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Role created"})
 }
 
-// RemoveRole removes an existent role
+// UpdateRole replaces the policy and extensions of an existent role. Its assignments (users and
+// groups) are kept.
+func (h AppHandler) UpdateRole(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user updating the role has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't update roles"})
+	}
+
+	requestPolicy := new(types.Role)
+	if err = c.Bind(requestPolicy); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Fail updating role", "details": err.Error()})
+	}
+	requestPolicy.ID = c.Param("role")
+	if message, err := normalizeRole(requestPolicy); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": message, "details": err.Error()})
+	}
+
+	// Checks if role exists
+	err = h.permEnforcer.LoadPolicy()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
+	}
+	policy := h.policyFor(requestPolicy.ID)
+	if policy == nil {
+		return c.JSON(http.StatusNotFound,
+			map[string]string{"result": "fail", "message": "Role ID not found"})
+	}
+
+	// Policy is replaced, assignments of the role are other policies (g) and stay
+	oldRole := roleFromPolicy(policy)
+	if err := h.replacePolicy(oldRole, *requestPolicy); err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error updating role, it was not changed", "details": err.Error()})
+	}
+
+	// Extensions are stored only with the policy replaced, which is rolled back when they fail
+	if requestPolicy.Extensions != "" {
+		err = h.db.Save(&types.RoleExtensions{RoleID: requestPolicy.ID, Extensions: requestPolicy.Extensions}).Error
+	} else {
+		err = h.db.Where("role_id = ?", requestPolicy.ID).Delete(&types.RoleExtensions{}).Error
+	}
+	if err != nil {
+		if rollbackErr := h.replacePolicy(*requestPolicy, oldRole); rollbackErr != nil {
+			return c.JSON(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Error storing role extensions, the role has the new policy", "details": err.Error() + " (" + rollbackErr.Error() + ")"})
+		}
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error storing role extensions, the role was not changed", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Role updated"})
+}
+
+// RemoveRole removes an existent role. Removed roles stop authorizing, but they are kept with
+// their assignments for role_retention to be restored, unless purge=true removes them for good.
 func (h AppHandler) RemoveRole(c echo.Context) error {
 	// Validates JWT token before any other action
 	ca := auth.OpenIDCAuth{}
@@ -199,6 +263,7 @@ func (h AppHandler) RemoveRole(c echo.Context) error {
 	}
 
 	removeRoleID := c.Param("role")
+	purge := c.QueryParam("purge") == "true"
 
 	// Checks if role exists
 	err = h.permEnforcer.LoadPolicy()
@@ -212,23 +277,41 @@ func (h AppHandler) RemoveRole(c echo.Context) error {
 	for _, role := range roles {
 		if role[0] == removeRoleID {
 			roleFound = true
-			removeRole = types.Role{
-				ID:         role[0],
-				RemoteUser: role[1],
-				SourceIP:   role[2],
-				TargetIP:   role[3],
-				Actions:    role[4],
-			}
+			removeRole = roleFromPolicy(role)
 		}
 	}
 
 	if !roleFound {
+		// a role already removed can still be purged
+		if purge {
+			dbc := h.db.Where("role_id = ?", removeRoleID).Delete(&types.DeletedRole{})
+			if dbc.Error == nil && dbc.RowsAffected == 1 {
+				return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Removed role purged"})
+			}
+		}
 		return c.JSON(http.StatusNotFound,
 			map[string]string{"result": "fail", "message": "Role ID not found"})
 	}
 
-	// Removes role if found
-	check, err := h.permEnforcer.RemovePolicySafe(removeRole.ID, removeRole.RemoteUser, removeRole.SourceIP, removeRole.TargetIP, removeRole.Actions)
+	// Removed roles are kept (with extensions and assignments) before leaving the policies
+	now := time.Now()
+	deleted := h.deletedRole(removeRole)
+	if !purge {
+		stored := types.RoleExtensions{}
+		if err := h.db.Where("role_id = ?", removeRoleID).Find(&stored).Error; err == nil {
+			deleted.Extensions = stored.Extensions
+		}
+		deleted.RemovedBy = username
+		deleted.RemovedAt = now
+		deleted.RestoreBefore = now.Add(h.config.GetDuration("role_retention"))
+		if err := h.db.Save(&deleted).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Role cannot be removed", "details": err.Error()})
+		}
+	}
+
+	// Removes role and its assignments from policies, it doesn't authorize anymore
+	check, err := h.detachRole(removeRole, deleted)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError,
 			map[string]string{"result": "fail", "message": "Role cannot be removed", "details": err.Error()})
@@ -237,8 +320,91 @@ func (h AppHandler) RemoveRole(c echo.Context) error {
 		return c.JSON(http.StatusNotFound,
 			map[string]string{"result": "fail", "message": "Role ID not found"})
 	}
+	err = h.db.Where("role_id = ?", removeRoleID).Delete(&types.RoleExtensions{}).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Role removed, but not its extensions", "details": err.Error()})
+	}
+
+	// removed roles past retention can't be restored anymore, as purged ones
+	h.db.Where("restore_before < ?", now).Delete(&types.DeletedRole{})
 
-	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Role removed"})
+	if purge {
+		h.db.Where("role_id = ?", removeRoleID).Delete(&types.DeletedRole{})
+		return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Role purged"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"result":         "success",
+		"message":        fmt.Sprintf("Role removed, it can be restored until %s", deleted.RestoreBefore.Format(time.RFC3339)),
+		"restore_before": deleted.RestoreBefore.Format(time.RFC3339),
+	})
+}
+
+// RestoreRole restores a removed role with its extensions and assignments, while it is retained
+func (h AppHandler) RestoreRole(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user restoring the role has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't restore roles"})
+	}
+
+	restoreRoleID := c.Param("role")
+	// Assignments are restored only when asked, users offboarded meanwhile would get access back
+	assignments := c.QueryParam("assignments") == "true"
+
+	// removed roles past retention can't be restored anymore
+	h.db.Where("restore_before < ?", time.Now()).Delete(&types.DeletedRole{})
+	deleted := new(types.DeletedRole)
+	if h.db.Where("role_id = ?", restoreRoleID).First(deleted).RecordNotFound() {
+		return c.JSON(http.StatusNotFound,
+			map[string]string{"result": "fail", "message": "Removed role not found", "details": "Role was purged or its retention (role_retention) expired"})
+	}
+
+	// A new role with the same ID is never replaced
+	exists, err := h.roleExists(restoreRoleID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
+	}
+	if exists {
+		return c.JSON(http.StatusConflict,
+			map[string]string{"result": "fail", "message": "A role with this ID was created after removal"})
+	}
+
+	// Extensions are stored before the policy, so the role never grants other extensions
+	if deleted.Extensions != "" {
+		err = h.db.Save(&types.RoleExtensions{RoleID: deleted.RoleID, Extensions: deleted.Extensions}).Error
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError,
+				map[string]string{"result": "fail", "message": "Error storing role extensions", "details": err.Error()})
+		}
+	}
+	reassigned, err := h.attachRole(*deleted, assignments)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Role cannot be restored", "details": err.Error()})
+	}
+	if err := h.db.Where("role_id = ?", restoreRoleID).Delete(&types.DeletedRole{}).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Role restored, but it is still listed as removed", "details": err.Error()})
+	}
+
+	response := map[string]interface{}{"result": "success", "message": "Role restored with its assignments", "reassigned": reassigned}
+	if !assignments {
+		response["message"] = "Role restored without assignments"
+		if subjects := splitSubjects(deleted.Subjects); len(subjects) > 0 {
+			response["details"] = fmt.Sprintf("Assignments at removal were not restored: %s", strings.Join(subjects, ", "))
+		}
+	}
+	return c.JSON(http.StatusOK, response)
 }
 
 // AssociateRoleToUser associates a role to a specific user
@@ -260,6 +426,12 @@ func (h AppHandler) AssociateRoleToUser(c echo.Context) error {
 	roleID := c.Param("role")
 	user := c.Param("user")
 
+	// Group subjects are assigned only at group endpoints
+	if permissions.IsGroupSubject(user) {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid user, use /authz/roles/:role/groups/:group to assign groups"})
+	}
+
 	// Checks if role exists
 	err = h.permEnforcer.LoadPolicy()
 	if err != nil {
@@ -279,11 +451,14 @@ func (h AppHandler) AssociateRoleToUser(c echo.Context) error {
 			map[string]string{"result": "fail", "message": "Role ID not found"})
 	}
 
-	// Add role to user if found
-	check := h.permEnforcer.AddRoleForUser(user, roleID)
-	if !check {
-		return c.JSON(http.StatusUnprocessableEntity,
-			map[string]string{"result": "fail", "message": "User already have this role"})
+	// Add role to user if found, assigning it again is not an error
+	alreadyAssigned, err := h.assignRole(user, roleID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error associating role", "details": err.Error()})
+	}
+	if alreadyAssigned {
+		return c.JSON(http.StatusOK, map[string]string{"result": types.RoleAlreadyAssigned, "message": "User already has this role"})
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Role associated"})
@@ -320,13 +495,7 @@ func (h AppHandler) GetRolesByUser(c echo.Context) error {
 	for _, role := range allRoles {
 		for _, myRole := range myRoles {
 			if role[0] == myRole {
-				forUserRoles = append(forUserRoles, types.Role{
-					ID:         role[0],
-					RemoteUser: role[1],
-					SourceIP:   role[2],
-					TargetIP:   role[3],
-					Actions:    role[4],
-				})
+				forUserRoles = append(forUserRoles, roleFromPolicy(role))
 			}
 		}
 	}
@@ -382,6 +551,378 @@ func (h AppHandler) DisassociateRoleToUser(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Role dissociated"})
 }
 
+// AssociateRoleToGroup associates a role to a group, authorizing every user with this group at OIDC groups claim
+func (h AppHandler) AssociateRoleToGroup(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user associating the role has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't associate role to a group"})
+	}
+
+	roleID := c.Param("role")
+	group := c.Param("group")
+
+	// Checks if role exists
+	roleFound, err := h.roleExists(roleID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
+	}
+	if !roleFound {
+		return c.JSON(http.StatusNotFound,
+			map[string]string{"result": "fail", "message": "Role ID not found"})
+	}
+
+	// Add role to group if found, assigning it again is not an error
+	alreadyAssigned, err := h.assignRole(permissions.GroupSubject(group), roleID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error associating role", "details": err.Error()})
+	}
+	if alreadyAssigned {
+		return c.JSON(http.StatusOK, map[string]string{"result": types.RoleAlreadyAssigned, "message": "Group already has this role"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Role associated"})
+}
+
+// DisassociateRoleToGroup disassociates a role to a group
+func (h AppHandler) DisassociateRoleToGroup(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user disassociating the role has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't disassociate role to a group"})
+	}
+
+	roleID := c.Param("role")
+	group := c.Param("group")
+
+	// Checks if role exists
+	roleFound, err := h.roleExists(roleID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
+	}
+	if !roleFound {
+		return c.JSON(http.StatusNotFound,
+			map[string]string{"result": "fail", "message": "Role ID not found"})
+	}
+
+	// Remove role from group if found
+	check := h.permEnforcer.DeleteRoleForUser(permissions.GroupSubject(group), roleID)
+	if !check {
+		return c.JSON(http.StatusUnprocessableEntity,
+			map[string]string{"result": "fail", "message": "Group don't have this role"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"result": "success", "message": "Role dissociated"})
+}
+
+// SimulateRole evaluates if a role would authorize a certificate request, without signing anything
+//
+// - Input JSON sample:
+//
+//	{
+//		"role":"prod-web",
+//		"user":"alice",
+//		"remote_user":"alice",
+//		"remote_host":"198.51.100.10",
+//		"remote_port":"22",
+//		"user_ip":"192.0.2.10"
+//	}
+//
+// - Output sample
+//
+//	{
+//		"result":"success",
+//		"allowed":false,
+//		"assigned":true,
+//		"reason":"source ip not allowed by role",
+//		"role":{"id":"prod-web","remote_user":".","user_ip":"192.0.2.0/24","remote_host":"198.51.100.0/24","actions":"permit-pty"}
+//	}
+func (h AppHandler) SimulateRole(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user simulating has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't simulate roles"})
+	}
+
+	type SimulateRequest struct {
+		Role       string `json:"role"`
+		User       string `json:"user"`
+		RemoteUser string `json:"remote_user"`
+		RemoteHost string `json:"remote_host"`
+		UserIP     string `json:"user_ip"`
+		RemotePort string `json:"remote_port"`
+	}
+	simulateRequest := new(SimulateRequest)
+	if err = c.Bind(simulateRequest); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid simulate request", "details": err.Error()})
+	}
+	if net.ParseIP(simulateRequest.UserIP) == nil || net.ParseIP(simulateRequest.RemoteHost) == nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid user_ip or remote_host, both must be IP addresses"})
+	}
+	if err := validatePort(simulateRequest.RemotePort); err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid remote_port", "details": err.Error()})
+	}
+
+	// Finds role
+	err = h.permEnforcer.LoadPolicy()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
+	}
+	policy := h.policyFor(simulateRequest.Role)
+	if policy == nil {
+		return c.JSON(http.StatusNotFound,
+			map[string]string{"result": "fail", "message": "Role ID not found"})
+	}
+
+	// Same authorization used at certificate requests, explaining the failing condition
+	localUser, err := h.principalFor(simulateRequest.User)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Error transforming user into principal", "details": err.Error()})
+	}
+	allowed, err := h.enforce(simulateRequest.Role, simulateRequest.RemoteUser, simulateRequest.UserIP, simulateRequest.RemoteHost, simulateRequest.RemotePort, localUser)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error using enforcer to authorize certificate", "details": err.Error()})
+	}
+	reason := ""
+	if !allowed {
+		_, reason = permissions.Explain(policy, simulateRequest.RemoteUser, simulateRequest.UserIP, simulateRequest.RemoteHost, simulateRequest.RemotePort, "permit-pty", localUser)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"result":   "success",
+		"allowed":  allowed,
+		"assigned": contains(h.permEnforcer.GetRolesForUser(simulateRequest.User), simulateRequest.Role),
+		"reason":   reason,
+		"role":     roleFromPolicy(policy),
+	})
+}
+
+// roleFromPolicy returns the role of a policy (id, remoteuser, sourceip, targetip, actions, destports)
+func roleFromPolicy(policy []string) types.Role {
+	return types.Role{
+		ID:         policy[0],
+		RemoteUser: policy[1],
+		SourceIP:   policy[2],
+		TargetIP:   policy[3],
+		Actions:    policy[4],
+		DestPorts:  permissions.PolicyDestPorts(policy),
+	}
+}
+
+// policyParams returns the policy values of role. Destination ports are the sixth value, only
+// stored when set, so roles without them keep the policy format used before it.
+func policyParams(role types.Role) []interface{} {
+	params := []interface{}{role.ID, role.RemoteUser, role.SourceIP, role.TargetIP, role.Actions}
+	if role.DestPorts != "" {
+		params = append(params, role.DestPorts)
+	}
+	return params
+}
+
+// normalizeRole validates the networks, destination ports and extensions of role, normalizing
+// them as stored. On failure it returns the message responded with the error.
+func normalizeRole(role *types.Role) (string, error) {
+	var err error
+
+	// Validates if the IPs read are in a valid format
+	sourceIPs := []string{}
+	for _, sourceEntryIP := range strings.Split(role.SourceIP, ";") {
+		_, sorceIPNet, err := net.ParseCIDR(sourceEntryIP)
+		if err != nil {
+			return "Invalid UserIP format", err
+		}
+		sourceIPs = append(sourceIPs, sorceIPNet.String())
+	}
+	targetIPs := []string{}
+	for _, targetEntryIP := range strings.Split(role.TargetIP, ";") {
+		_, targetIPNet, err := net.ParseCIDR(targetEntryIP)
+		if err != nil {
+			return "Invalid RemoteHost format", err
+		}
+		targetIPs = append(targetIPs, targetIPNet.String())
+	}
+	role.SourceIP = strings.Join(sourceIPs, ";")
+	role.TargetIP = strings.Join(targetIPs, ";")
+
+	// Validates destination ports, empty allows any port
+	role.DestPorts, err = permissions.ParseDestPorts(role.DestPorts)
+	if err != nil {
+		return "Invalid DestPorts format", err
+	}
+
+	// Validates certificate extensions. OpenSSH certificates can't restrict forwarding
+	// destinations, so roles with destination ports can't permit port forwarding.
+	role.Extensions, err = permissions.ParseExtensions(role.Extensions)
+	if err != nil {
+		return "Invalid Extensions format", err
+	}
+	if role.DestPorts != "" && contains(strings.Split(role.Extensions, ";"), permissions.PortForwardingExtension) {
+		return "Roles with DestPorts can't permit port forwarding", errors.New("normalizeRole: remove permit-port-forwarding or dest_ports")
+	}
+	return "", nil
+}
+
+// withExtensions fills the extensions of roles created with them, the others keep it empty
+// (default extensions)
+func (h AppHandler) withExtensions(roles []types.Role) error {
+	stored := []types.RoleExtensions{}
+	if err := h.reader().Find(&stored).Error; err != nil {
+		return err
+	}
+	storedByRole := map[string]string{}
+	for _, roleExtensions := range stored {
+		storedByRole[roleExtensions.RoleID] = roleExtensions.Extensions
+	}
+	for i := range roles {
+		roles[i].Extensions = storedByRole[roles[i].ID]
+	}
+	return nil
+}
+
+// deletedRole returns role and its assignments (users and groups), kept while it is removed
+func (h AppHandler) deletedRole(role types.Role) types.DeletedRole {
+	return types.DeletedRole{
+		RoleID:     role.ID,
+		RemoteUser: role.RemoteUser,
+		SourceIP:   role.SourceIP,
+		TargetIP:   role.TargetIP,
+		Actions:    role.Actions,
+		DestPorts:  role.DestPorts,
+		Subjects:   strings.Join(h.permEnforcer.GetUsersForRole(role.ID), ";"),
+	}
+}
+
+// detachRole removes role policy and the assignments at deleted, telling whether the policy existed
+func (h AppHandler) detachRole(role types.Role, deleted types.DeletedRole) (bool, error) {
+	check, err := h.permEnforcer.RemovePolicySafe(policyParams(role)...)
+	if err != nil || !check {
+		return check, err
+	}
+	for _, subject := range splitSubjects(deleted.Subjects) {
+		h.permEnforcer.DeleteRoleForUser(subject, role.ID)
+	}
+	return true, nil
+}
+
+// replacePolicy replaces the policy of old by the policy of role. The new policy is added before the
+// old one is removed, and removed again when the old one can't be, so the role never loses its policy.
+func (h AppHandler) replacePolicy(old types.Role, role types.Role) error {
+	oldParams, newParams := policyParams(old), policyParams(role)
+	if reflect.DeepEqual(oldParams, newParams) {
+		return nil
+	}
+	if _, err := h.permEnforcer.AddPolicySafe(newParams...); err != nil {
+		return err
+	}
+	if _, err := h.permEnforcer.RemovePolicySafe(oldParams...); err != nil {
+		_, _ = h.permEnforcer.RemovePolicySafe(newParams...)
+		return err
+	}
+	return nil
+}
+
+// attachRole adds back the policy of a removed role and, with assignments, its users and groups.
+// It returns the subjects assigned again.
+func (h AppHandler) attachRole(deleted types.DeletedRole, assignments bool) ([]string, error) {
+	role := types.Role{
+		ID:         deleted.RoleID,
+		RemoteUser: deleted.RemoteUser,
+		SourceIP:   deleted.SourceIP,
+		TargetIP:   deleted.TargetIP,
+		Actions:    deleted.Actions,
+		DestPorts:  deleted.DestPorts,
+	}
+	if _, err := h.permEnforcer.AddPolicySafe(policyParams(role)...); err != nil {
+		return nil, err
+	}
+	reassigned := []string{}
+	if !assignments {
+		return reassigned, nil
+	}
+	for _, subject := range splitSubjects(deleted.Subjects) {
+		alreadyAssigned, err := h.assignRole(subject, role.ID)
+		if err != nil {
+			return reassigned, err
+		}
+		if !alreadyAssigned {
+			reassigned = append(reassigned, subject)
+		}
+	}
+	return reassigned, nil
+}
+
+// splitSubjects returns the users and groups of a removed role, stored separated by ";"
+func splitSubjects(subjects string) []string {
+	if subjects == "" {
+		return nil
+	}
+	return strings.Split(subjects, ";")
+}
+
+// assignRole assigns roleID to subject (user or group subject), telling whether subject already had it
+func (h AppHandler) assignRole(subject string, roleID string) (bool, error) {
+	if h.permEnforcer.HasRoleForUser(subject, roleID) {
+		return true, nil
+	}
+	if !h.permEnforcer.AddRoleForUser(subject, roleID) {
+		return false, errors.New("assignRole: role not stored")
+	}
+	return false, nil
+}
+
+// roleExists reloads policies and tells whether roleID exists
+func (h AppHandler) roleExists(roleID string) (bool, error) {
+	err := h.permEnforcer.LoadPolicy()
+	if err != nil {
+		return false, err
+	}
+	for _, role := range h.permEnforcer.GetFilteredPolicy(0, roleID) {
+		if role[0] == roleID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// effectiveRoles returns roles assigned to username and to the groups at its token
+func (h AppHandler) effectiveRoles(c echo.Context, username string) []string {
+	groups, _ := c.Get("groups").([]string)
+	return permissions.EffectiveRoles(h.permEnforcer.GetRolesForUser, username, groups)
+}
+
 // GetUsersWithRole prints all associated users to specific role
 func (h AppHandler) GetUsersWithRole(c echo.Context) error {
 	// Validates JWT token before any other action
@@ -413,13 +954,7 @@ func (h AppHandler) GetUsersWithRole(c echo.Context) error {
 	finishRole := types.Role{}
 	for _, currentRole := range roles {
 		if currentRole[0] == role {
-			finishRole = types.Role{
-				ID:         currentRole[0],
-				RemoteUser: currentRole[1],
-				SourceIP:   currentRole[2],
-				TargetIP:   currentRole[3],
-				Actions:    currentRole[4],
-			}
+			finishRole = roleFromPolicy(currentRole)
 			found = true
 		}
 	}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/types"
+	"github.com/labstack/echo"
+)
+
+// roleUsageWindow is the time window of role usage reports without since
+const roleUsageWindow = 30 * 24 * time.Hour
+
+// authorizedLog returns log of an issued certificate audit record, with the roles authorizing it
+func authorizedLog(log string, roles []string) string {
+	return strings.TrimSpace(fmt.Sprintf("%s (authorized by %s)", log, strings.Join(roles, ",")))
+}
+
+// auditRoles returns the roles authorizing the certificate of an audit record, recorded comma
+// separated at its roles. Records of certificates issued before roles were recorded have none.
+func auditRoles(record types.AuditRecord) []string {
+	var roles []string
+	for _, role := range strings.Split(record.Roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// roleUsage counts the certificates each role authorized at records (audit records of issued
+// certificates), sorted by role. Every role of roles is reported, dormant ones with zero, and
+// so are the roles of records that no longer exist.
+func roleUsage(roles []string, records []types.AuditRecord) []types.RoleUsage {
+	usageByRole := map[string]*types.RoleUsage{}
+	for _, role := range roles {
+		usageByRole[role] = &types.RoleUsage{Role: role}
+	}
+	for _, record := range records {
+		if record.Error != "" {
+			continue
+		}
+		for _, role := range auditRoles(record) {
+			usage, ok := usageByRole[role]
+			if !ok {
+				usage = &types.RoleUsage{Role: role}
+				usageByRole[role] = usage
+			}
+			usage.Certificates++
+			if usage.LastUsed == nil || record.EndTime.After(*usage.LastUsed) {
+				lastUsed := record.EndTime
+				usage.LastUsed = &lastUsed
+			}
+		}
+	}
+
+	usages := []types.RoleUsage{}
+	for _, usage := range usageByRole {
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Role < usages[j].Role })
+	return usages
+}
+
+// usageWindow returns the time window of a role usage report from since and until (RFC 3339,
+// optional). Until defaults to now and since to roleUsageWindow before until.
+func usageWindow(since string, until string, now time.Time) (time.Time, time.Time, error) {
+	untilTime := now
+	if until != "" {
+		var err error
+		if untilTime, err = time.Parse(time.RFC3339, until); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("until is not a RFC 3339 time (%v)", err)
+		}
+	}
+	sinceTime := untilTime.Add(-roleUsageWindow)
+	if since != "" {
+		var err error
+		if sinceTime, err = time.Parse(time.RFC3339, since); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("since is not a RFC 3339 time (%v)", err)
+		}
+	}
+	if !sinceTime.Before(untilTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("since (%s) must be before until (%s)", sinceTime.Format(time.RFC3339), untilTime.Format(time.RFC3339))
+	}
+	return sinceTime, untilTime, nil
+}
+
+// RoleUsage reports how many certificates each role authorized between since and until, read from
+// audit records. Dormant roles are reported with zero certificates.
+func (h AppHandler) RoleUsage(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user getting role usage has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{
+				"result":  "fail",
+				"message": fmt.Sprintf("This user (%s) can't read role usage, contact %v", username, h.config.GetStringSlice("perm_admin")),
+			})
+	}
+
+	since, until, err := usageWindow(c.QueryParam("since"), c.QueryParam("until"), time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid time window", "details": err.Error()})
+	}
+
+	err = h.permEnforcer.LoadPolicy()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading roles", "details": err.Error()})
+	}
+	var roles []string
+	for _, policy := range h.permEnforcer.GetPolicy() {
+		roles = append(roles, policy[0])
+	}
+
+	var records []types.AuditRecord
+	err = h.db.Where("kind IN (?) AND error = '' AND roles <> '' AND end_time > ? AND end_time <= ?",
+		[]string{"cert.create", "cert.breakglass"}, since, until).Find(&records).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError,
+			map[string]string{"result": "fail", "message": "Error reading audit records", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"result": "success",
+		"usage":  types.RoleUsageReport{Since: since, Until: until, Roles: roleUsage(roles, records)},
+	})
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestAuditRoles(t *testing.T) {
+	t.Run(
+		"Certificate authorized by roles",
+		func(t *testing.T) {
+			roles := auditRoles(types.AuditRecord{Kind: "cert.create", Roles: "admins,deployers", Log: "INC-1234"})
+			if !reflect.DeepEqual(roles, []string{"admins", "deployers"}) {
+				t.Fatalf("auditRoles: check fail with authorized certificate (%v)", roles)
+			}
+		})
+	t.Run(
+		"Roles are not read from log",
+		func(t *testing.T) {
+			roles := auditRoles(types.AuditRecord{Kind: "cert.create", Log: authorizedLog("INC-1234 (forged)", []string{"admins"})})
+			if roles != nil {
+				t.Fatalf("auditRoles: check fail with roles at log (%v)", roles)
+			}
+		})
+	t.Run(
+		"Record without roles",
+		func(t *testing.T) {
+			if roles := auditRoles(types.AuditRecord{Kind: "cert.create", Log: "INC-1234"}); roles != nil {
+				t.Fatalf("auditRoles: check fail without roles (%v)", roles)
+			}
+		})
+}
+
+func TestRoleUsage(t *testing.T) {
+	now := time.Now()
+	issued := func(roles []string, at time.Time) types.AuditRecord {
+		return types.AuditRecord{Kind: "cert.create", EndTime: at, Roles: strings.Join(roles, ","), Log: authorizedLog("INC-1234", roles)}
+	}
+
+	t.Run(
+		"Issuance increments usage of authorizing roles",
+		func(t *testing.T) {
+			records := []types.AuditRecord{
+				issued([]string{"deployers"}, now.Add(-2*time.Hour)),
+				issued([]string{"admins", "deployers"}, now.Add(-time.Hour)),
+				{Kind: "cert.create", EndTime: now, Error: "You don't have permission to request this certificate", Log: "Your roles are: [admins]"},
+			}
+			usages := roleUsage([]string{"admins", "deployers", "dormant"}, records)
+			if len(usages) != 3 {
+				t.Fatalf("roleUsage: check fail with roles (%v)", usages)
+			}
+			counts := map[string]int{}
+			for _, usage := range usages {
+				counts[usage.Role] = usage.Certificates
+			}
+			if !reflect.DeepEqual(counts, map[string]int{"admins": 1, "deployers": 2, "dormant": 0}) {
+				t.Fatalf("roleUsage: check fail with counts (%v)", counts)
+			}
+			if usages[1].Role != "deployers" || usages[1].LastUsed == nil || !usages[1].LastUsed.Equal(now.Add(-time.Hour)) {
+				t.Fatalf("roleUsage: check fail with last use (%+v)", usages[1])
+			}
+			if usages[2].Role != "dormant" || usages[2].LastUsed != nil {
+				t.Fatalf("roleUsage: check fail with dormant role (%+v)", usages[2])
+			}
+		})
+	t.Run(
+		"Removed role",
+		func(t *testing.T) {
+			usages := roleUsage(nil, []types.AuditRecord{issued([]string{"removed"}, now)})
+			if len(usages) != 1 || usages[0].Role != "removed" || usages[0].Certificates != 1 {
+				t.Fatalf("roleUsage: check fail with removed role (%v)", usages)
+			}
+		})
+}
+
+func TestUsageWindow(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run(
+		"Default window",
+		func(t *testing.T) {
+			since, until, err := usageWindow("", "", now)
+			if err != nil || !until.Equal(now) || !since.Equal(now.Add(-roleUsageWindow)) {
+				t.Fatalf("usageWindow: check fail with default window (%v, %v, %v)", since, until, err)
+			}
+		})
+	t.Run(
+		"Since and until",
+		func(t *testing.T) {
+			since, until, err := usageWindow("2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z", now)
+			if err != nil || since.Month() != time.January || until.Month() != time.February {
+				t.Fatalf("usageWindow: check fail with since and until (%v, %v, %v)", since, until, err)
+			}
+		})
+	t.Run(
+		"Invalid window",
+		func(t *testing.T) {
+			if _, _, err := usageWindow("yesterday", "", now); err == nil {
+				t.Fatalf("usageWindow: check fail with invalid since")
+			}
+			if _, _, err := usageWindow("2024-02-01T00:00:00Z", "2024-01-01T00:00:00Z", now); err == nil {
+				t.Fatalf("usageWindow: check fail with since after until")
+			}
+		})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/globocom/gsh/api/auth"
+	"github.com/globocom/gsh/api/permissions"
+	"github.com/globocom/gsh/api/selftest"
+	"github.com/globocom/gsh/types"
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/ssh"
+)
+
+// selfTestKeyID is the key id of certificates signed by self tests, they are never stored or returned
+const selfTestKeyID = "gsh-selftest"
+
+// SelfTest exercises the certificate issuing path without issuing a certificate: OIDC keys,
+// validation of the caller token, a dry-run authorization of the caller roles and a signature
+// of a throwaway key by the configured CA. Each stage is reported with its duration.
+// The optional remote_host query parameter is the target of the dry-run authorization.
+//
+// - Output sample
+//
+//	{
+//		"result":"fail",
+//		"duration_ms":412,
+//		"stages":[
+//			{"name":"oidc","result":"success","duration_ms":120,"details":"signature keys loaded from https://sso.example.com/certs"},
+//			{"name":"token","result":"success","duration_ms":1,"details":"token of john valid"},
+//			{"name":"authorization","result":"success","duration_ms":8,"details":"2 roles evaluated, none allowed access to 127.0.0.1"},
+//			{"name":"signing","result":"fail","duration_ms":283,"details":"Failed to get Vault token (...)"}
+//		]
+//	}
+func (h AppHandler) SelfTest(c echo.Context) error {
+	// Validates JWT token before any other action
+	ca := auth.OpenIDCAuth{}
+	username, err := ca.Authenticate(c, h.config)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized,
+			map[string]string{"result": "fail", "message": "Failed validating JWT", "details": err.Error()})
+	}
+
+	// Validates if the user running the self test has permission to do so
+	if !contains(h.config.GetStringSlice("perm_admin"), username) {
+		return c.JSON(http.StatusForbidden,
+			map[string]string{"result": "fail", "message": "This user can't run self tests"})
+	}
+
+	remoteHost := c.QueryParam("remote_host")
+	if remoteHost == "" {
+		remoteHost = "127.0.0.1"
+	}
+	if net.ParseIP(remoteHost) == nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Invalid remote_host, it must be an IP address"})
+	}
+	localUser, err := h.principalFor(username)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			map[string]string{"result": "fail", "message": "Error transforming user into principal", "details": err.Error()})
+	}
+
+	report := selftest.Run([]selftest.Stage{
+		{Name: "oidc", Run: h.selfTestOIDC},
+		{Name: "token", Run: func() (string, error) {
+			if _, err := ca.Authenticate(c, h.config); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("token of %s valid", username), nil
+		}},
+		{Name: "authorization", Run: func() (string, error) {
+			return h.selfTestAuthorization(h.effectiveRoles(c, username), localUser, c.RealIP(), remoteHost)
+		}},
+		{Name: "signing", Run: func() (string, error) {
+			return h.selfTestSigning(localUser)
+		}},
+	})
+
+	// Sending log record, built before the goroutine as the context is reused after returning
+	logRecord := map[string]interface{}{
+		"_owner":        username,
+		"_rid":          c.Get(echo.HeaderXRequestID),
+		"_real-ip":      c.RealIP(),
+		"_action":       "selftest",
+		"_result":       report.Result,
+		"_duration":     report.Duration,
+		"short_message": "Self test finished",
+	}
+	go func() {
+		h.logChannel <- logRecord
+	}()
+
+	status := http.StatusOK
+	if report.Result != types.SelfTestSuccess {
+		status = http.StatusServiceUnavailable
+	}
+	return c.JSON(status, report)
+}
+
+// selfTestOIDC fetches the OIDC signature keys, as done to validate tokens when keys are not configured
+func (h AppHandler) selfTestOIDC() (string, error) {
+	if h.config.Get("oidc_keys") != nil && h.config.GetString("oidc_certs") == "" {
+		return "signature keys set at configuration", nil
+	}
+	client := &http.Client{
+		Timeout: time.Duration(10) * time.Second,
+	}
+	resp, err := client.Get(h.config.GetString("oidc_certs"))
+	if err != nil {
+		return "", fmt.Errorf("Failed to get OIDC signature keys (%v)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to get OIDC signature keys, OIDC Server status code: %d", resp.StatusCode)
+	}
+	return "signature keys loaded from " + h.config.GetString("oidc_certs"), nil
+}
+
+// selfTestAuthorization evaluates the roles of the caller as a certificate request to remoteHost
+// would. Being denied is not a failure, the stage checks that policies can be read and evaluated.
+func (h AppHandler) selfTestAuthorization(roles []string, localUser string, sourceIP string, remoteHost string) (string, error) {
+	if err := h.permEnforcer.LoadPolicy(); err != nil {
+		return "", fmt.Errorf("Error reading roles (%v)", err)
+	}
+	decisions := permissions.Decide(roles, h.policyFor, localUser, sourceIP, remoteHost, "", "permit-pty", localUser)
+	for _, decision := range decisions {
+		if decision.Allowed {
+			return fmt.Sprintf("%d roles evaluated, %s allows access to %s", len(decisions), decision.Role, remoteHost), nil
+		}
+	}
+	return fmt.Sprintf("%d roles evaluated, none allowed access to %s", len(decisions), remoteHost), nil
+}
+
+// selfTestSigning signs a throwaway key with the configured CA and verifies the certificate.
+// Neither the key nor the certificate leave this function.
+func (h AppHandler) selfTestSigning(localUser string) (string, error) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("Error generating throwaway key (%v)", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("Error generating throwaway key (%v)", err)
+	}
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.UserCert,
+		KeyId:           selfTestKeyID,
+		ValidPrincipals: []string{localUser},
+		ValidAfter:      uint64(time.Now().Add(-30 * time.Second).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Minute).Unix()),
+	}
+
+	if h.config.GetBool("ca_external") {
+		secretID, err := vaultSecretID(h.config)
+		if err != nil {
+			return "", fmt.Errorf("Error reading Vault secret id (%v)", err)
+		}
+		v := Vault{h.config.GetString("ca_role_id"), secretID, h.config, ""}
+		// SignUserSSHCertificate verifies the certificate against the Vault CA public key
+		if _, err := v.SignUserSSHCertificate(cert); err != nil {
+			return "", err
+		}
+		return "throwaway key signed by Vault", nil
+	}
+
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(h.config.GetString("ca_public_key")))
+	if err != nil {
+		return "", fmt.Errorf("Parse the public key (%v)", err)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(h.config.GetString("ca_private_key")))
+	if err != nil {
+		return "", fmt.Errorf("Parse private ca key (%v)", err)
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return "", fmt.Errorf("Sign user key (%v)", err)
+	}
+	if err := verifySignedKey(string(ssh.MarshalAuthorizedKey(cert)), caPublicKey, key); err != nil {
+		return "", errors.New("private and public CA keys do not match (" + err.Error() + ")")
+	}
+	return "throwaway key signed by " + ssh.FingerprintSHA256(caPublicKey), nil
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"sort"
 
 	"github.com/labstack/echo"
 )
@@ -18,7 +19,7 @@ func StatusReady(c echo.Context) error {
 
 // StatusConfig is a method that respond WORKING and is used to verify that the application is running (live)
 func (h AppHandler) StatusConfig(c echo.Context) error {
-	return c.JSON(http.StatusOK, map[string]string{
+	return c.JSON(http.StatusOK, map[string]interface{}{
 		"oidc_base_url":      h.config.GetString("oidc_base_url"),
 		"oidc_realm":         h.config.GetString("oidc_realm"),
 		"oidc_audience":      h.config.GetString("oidc_audience"),
@@ -28,5 +29,40 @@ func (h AppHandler) StatusConfig(c echo.Context) error {
 		"oidc_certs":         h.config.GetString("oidc_certs"),
 		"oidc_callback_port": h.config.GetString("oidc_callback_port"),
 		"oidc_client_secret": h.config.GetString("oidc_client_secret"), // only for Google Accounts compatibility
+		// token sent by clients, access (default) or id
+		"token_validation_mode": h.config.GetString("token_validation_mode"),
+		// claim of the principal (unix account) when it differs from the username claim
+		"principal_claim": h.config.GetString("principal_claim"),
+		// active host CA keys, more than one while the host CA is rotated
+		"host_ca_public_keys": h.config.GetStringSlice("host_ca_public_keys"),
+		// keys of the user CA chain, trusted by hosts besides the signer key (GET /publickey)
+		"ca_chain_public_keys": h.config.GetStringSlice("ca_chain_public_keys"),
+		"capabilities":         h.capabilities(),
+		// longest validity of issued certificates, they are not revoked when roles are dissociated
+		"ca_signed_cert_duration": h.config.GetDuration("ca_signed_cert_duration").String(),
 	})
 }
+
+// capabilities lists the optional features enabled at this GSH API, shown by gsh target-info
+func (h AppHandler) capabilities() []string {
+	capabilities := []string{}
+	for capability, enabled := range map[string]bool{
+		"approvals":        len(h.config.GetStringSlice("approval_roles")) > 0,
+		"break_glass":      len(h.config.GetStringSlice("breakglass_roles")) > 0,
+		"ca_chain":         len(h.config.GetStringSlice("ca_chain_public_keys")) > 0,
+		"external_ca":      h.config.GetBool("ca_external"),
+		"fallback_ca":      h.config.GetBool("ca_external") && h.config.GetBool("fallback_internal_ca"),
+		"hsm_ca":           h.config.GetString("ca_pkcs11_module") != "",
+		"host_ca":          len(h.config.GetStringSlice("host_ca_public_keys")) > 0,
+		"port_forwarding":  h.config.GetBool("ca_port_forwarding"),
+		"read_only":        h.ReadOnly(),
+		"reason_extension": h.config.GetBool("ca_reason_extension"),
+		"renewable_certs":  len(h.config.GetStringSlice("renewable_roles")) > 0,
+	} {
+		if enabled {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
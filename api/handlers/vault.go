@@ -4,16 +4,30 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/globocom/gsh/api/permissions"
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh"
 )
 
+// errVaultUnavailable wraps errors of Vault not being reachable (connection errors, timeouts and
+// 502, 503 or 504 responses), unlike Vault refusing a request or returning an invalid certificate
+var errVaultUnavailable = errors.New("Vault unavailable")
+
+// unavailableStatus returns if status code of a Vault response means it is unavailable
+func unavailableStatus(statusCode int) bool {
+	return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable ||
+		statusCode == http.StatusGatewayTimeout
+}
+
 // Vault store configuration to use remote Vault as cert signer
 type Vault struct {
 	roleID   string
@@ -61,6 +75,20 @@ func GetVault() Vault {
 	return Vault{}
 }
 
+// vaultSecretID returns the AppRole secret id, read from ca_external_secret_id_file when it is set.
+// The file is read at every use, so rotated secret ids (see RotateSecretID) are used without restarts.
+func vaultSecretID(config viper.Viper) (string, error) {
+	file := config.GetString("ca_external_secret_id_file")
+	if file == "" {
+		return config.GetString("ca_external_secret_id"), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.New("Failed to read Vault secret id file (" + err.Error() + ")")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
 // GetToken autenticate on Vault instance and returns a client token
 func (v *Vault) GetToken() error {
 	data := make(map[string]string)
@@ -74,9 +102,12 @@ func (v *Vault) GetToken() error {
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
-		return errors.New("Failed to authenticate with vault: " + err.Error())
+		return fmt.Errorf("%w: Failed to authenticate with vault: %s", errVaultUnavailable, err)
 	}
 	defer resp.Body.Close()
+	if unavailableStatus(resp.StatusCode) {
+		return fmt.Errorf("%w: Failed to authenticate with vault: status code %d", errVaultUnavailable, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return errors.New("Failed to authenticate with vault: status code " + strconv.Itoa(resp.StatusCode))
 	}
@@ -97,14 +128,22 @@ func (v *Vault) SignUserSSHCertificate(c *ssh.Certificate) (string, error) {
 	// get new vault client token
 	err := v.GetToken()
 	if err != nil {
-		return "", errors.New("Failed to get Vault token (" + err.Error() + ")")
+		return "", fmt.Errorf("Failed to get Vault token (%w)", err)
 	}
 
 	// set Vault data struct for sign
-	data := make(map[string]string)
+	data := make(map[string]interface{})
 	data["public_key"] = string(ssh.MarshalAuthorizedKey(c.Key))
 	data["valid_principals"] = strings.Join(c.ValidPrincipals, ",")
 	data["cert_type"] = "user"
+	// key id is kept only if the Vault role has allow_user_key_ids enabled
+	data["key_id"] = c.KeyId
+	// Vault uses role default_extensions when none is sent, so extensions are sent only if
+	// they differ from the default ones (they must be at role allowed_extensions). With
+	// ca_port_forwarding they are always sent, so defaults can't grant port forwarding denied by roles.
+	if !defaultExtensions(c.Permissions.Extensions) || v.config.GetBool("ca_port_forwarding") {
+		data["extensions"] = c.Permissions.Extensions
+	}
 
 	// request vault
 	jsonData, _ := json.Marshal(data)
@@ -116,9 +155,12 @@ func (v *Vault) SignUserSSHCertificate(c *ssh.Certificate) (string, error) {
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", errors.New("signUserSSHCertificate: Failed to sign SSH certificate")
+		return "", fmt.Errorf("%w: signUserSSHCertificate: Failed to sign SSH certificate (%s)", errVaultUnavailable, err)
 	}
 	defer resp.Body.Close()
+	if unavailableStatus(resp.StatusCode) {
+		return "", fmt.Errorf("%w: signUserSSHCertificate: Failed to sign SSH certificate, status code %d", errVaultUnavailable, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return "", errors.New("signUserSSHCertificate: Failed to sign SSH certificate, not 200 ok")
 	}
@@ -131,16 +173,56 @@ func (v *Vault) SignUserSSHCertificate(c *ssh.Certificate) (string, error) {
 		return "", errors.New("signUserSSHCertificate: Failed to decode SSH certificate (" + err.Error() + ")")
 	}
 
-	return strings.TrimSuffix(sshCertificate.Data.SignedKey, "\n"), nil
+	signedKey := strings.TrimSuffix(sshCertificate.Data.SignedKey, "\n")
+
+	// verify the certificate before handing it to the user, catching Vault misconfiguration
+	externalPubKey, err := v.GetExternalPublicKey()
+	if err != nil {
+		return "", fmt.Errorf("signUserSSHCertificate: Failed to get CA public key (%w)", err)
+	}
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(externalPubKey))
+	if err != nil {
+		return "", errors.New("signUserSSHCertificate: Failed to parse CA public key (" + err.Error() + ")")
+	}
+	if err := verifySignedKey(signedKey, caPublicKey, c.Key); err != nil {
+		return "", err
+	}
+
+	return signedKey, nil
+}
+
+// verifySignedKey checks that signedKey is a user certificate of key, signed by caPublicKey
+func verifySignedKey(signedKey string, caPublicKey ssh.PublicKey, key ssh.PublicKey) error {
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signedKey))
+	if err != nil {
+		return errors.New("verifySignedKey: Failed to parse signed key (" + err.Error() + ")")
+	}
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok {
+		return errors.New("verifySignedKey: Signed key is not a certificate")
+	}
+	if cert.CertType != ssh.UserCert {
+		return errors.New("verifySignedKey: Signed key is not a user certificate")
+	}
+	if err := verifyCertificateSignature(cert, caPublicKey); err != nil {
+		return errors.New("verifySignedKey: " + err.Error())
+	}
+	if !bytes.Equal(cert.Key.Marshal(), key.Marshal()) {
+		return errors.New("verifySignedKey: Certificate key does not match the submitted key")
+	}
+	return nil
 }
 
 // GetExternalPublicKey returns public key from external CA
 func (v *Vault) GetExternalPublicKey() (string, error) {
 	resp, err := http.Get(v.config.GetString("ca_endpoint") + v.config.GetString("ca_public_key_url"))
 	if err != nil {
-		return "-1", err
+		return "-1", fmt.Errorf("%w: %s", errVaultUnavailable, err)
 	}
 	defer resp.Body.Close()
+	if unavailableStatus(resp.StatusCode) {
+		return "", fmt.Errorf("%w: External CA did not respond correctly: status code %d", errVaultUnavailable, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return "", errors.New("External CA did not respond correctly: status code " + strconv.Itoa(resp.StatusCode))
 	}
@@ -151,3 +233,134 @@ func (v *Vault) GetExternalPublicKey() (string, error) {
 
 	return string(data), nil
 }
+
+type secretIDResponse struct {
+	Data struct {
+		SecretID         string `json:"secret_id"`
+		SecretIDAccessor string `json:"secret_id_accessor"`
+	} `json:"data"`
+}
+
+// GenerateSecretID generates a new AppRole secret id at ca_secret_id_url, returning it and its accessor
+func (v *Vault) GenerateSecretID() (string, string, error) {
+	req, _ := http.NewRequest("POST", v.config.GetString("ca_endpoint")+v.config.GetString("ca_secret_id_url"), bytes.NewBufferString("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.token)
+	client := &http.Client{
+		Timeout: time.Duration(10) * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", errors.New("generateSecretID: Failed to generate secret id (" + err.Error() + ")")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", errors.New("generateSecretID: Failed to generate secret id: status code " + strconv.Itoa(resp.StatusCode))
+	}
+	secretIDResponse := secretIDResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&secretIDResponse); err != nil {
+		return "", "", errors.New("generateSecretID: Failed to decode secret id (" + err.Error() + ")")
+	}
+	if secretIDResponse.Data.SecretID == "" {
+		return "", "", errors.New("generateSecretID: Vault returned an empty secret id")
+	}
+	return secretIDResponse.Data.SecretID, secretIDResponse.Data.SecretIDAccessor, nil
+}
+
+// DestroySecretID revokes an AppRole secret id at ca_secret_id_url
+func (v *Vault) DestroySecretID(secretID string) error {
+	jsonData, _ := json.Marshal(map[string]string{"secret_id": secretID})
+	req, _ := http.NewRequest("POST", v.config.GetString("ca_endpoint")+v.config.GetString("ca_secret_id_url")+"/destroy", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.token)
+	client := &http.Client{
+		Timeout: time.Duration(10) * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New("destroySecretID: Failed to destroy secret id (" + err.Error() + ")")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return errors.New("destroySecretID: Failed to destroy secret id: status code " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// RotateSecretID replaces the AppRole secret id stored at ca_external_secret_id_file. A new secret
+// id is generated using the current one, checked by logging in with it and written to the file
+// (read at every Vault login, so running APIs use it right away). The previous secret id is
+// destroyed only after that, so running it again always leaves a working secret id. It returns
+// the accessor of the new secret id, safe to be logged.
+func RotateSecretID(config viper.Viper) (string, error) {
+	file := config.GetString("ca_external_secret_id_file")
+	if file == "" {
+		return "", errors.New("rotateSecretID: Vault secret id file (ca_external_secret_id_file) not set")
+	}
+	if config.GetString("ca_secret_id_url") == "" {
+		return "", errors.New("rotateSecretID: Vault secret id URL (ca_secret_id_url) not set")
+	}
+	current, err := vaultSecretID(config)
+	if err != nil {
+		// the first rotation can start from ca_external_secret_id
+		current = config.GetString("ca_external_secret_id")
+	}
+	v := Vault{config.GetString("ca_role_id"), current, config, ""}
+	if err := v.GetToken(); err != nil {
+		return "", errors.New("rotateSecretID: Failed to get Vault token with current secret id (" + err.Error() + ")")
+	}
+	secretID, accessor, err := v.GenerateSecretID()
+	if err != nil {
+		return "", errors.New("rotateSecretID: " + err.Error())
+	}
+
+	// the new secret id must work before replacing the current one
+	check := Vault{config.GetString("ca_role_id"), secretID, config, ""}
+	if err := check.GetToken(); err != nil {
+		return "", errors.New("rotateSecretID: Failed to get Vault token with new secret id (" + err.Error() + ")")
+	}
+	if err := writeSecretIDFile(file, secretID); err != nil {
+		return "", errors.New("rotateSecretID: Failed to write secret id file (" + err.Error() + ")")
+	}
+
+	if current != "" && current != secretID {
+		if err := v.DestroySecretID(current); err != nil {
+			return accessor, errors.New("rotateSecretID: secret id rotated, but the previous one was not destroyed (" + err.Error() + ")")
+		}
+	}
+	return accessor, nil
+}
+
+// writeSecretIDFile replaces file content atomically, so readers never see a partial secret id
+func writeSecretIDFile(file string, secretID string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".secret-id-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.WriteString(secretID + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// defaultExtensions tells whether extensions are exactly the default extensions of roles
+func defaultExtensions(extensions map[string]string) bool {
+	if len(extensions) != len(permissions.DefaultExtensions) {
+		return false
+	}
+	for _, extension := range permissions.DefaultExtensions {
+		if _, ok := extensions[extension]; !ok {
+			return false
+		}
+	}
+	return true
+}
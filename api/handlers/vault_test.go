@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVerifySignedKey(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("verifySignedKey: fail generating CA key (%v)", err)
+		}
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			t.Fatalf("verifySignedKey: fail creating CA signer (%v)", err)
+		}
+		return signer
+	}
+	caSigner := newSigner()
+	otherSigner := newSigner()
+	userKey := newSigner().PublicKey()
+	otherUserKey := newSigner().PublicKey()
+
+	now := time.Now()
+	newCert := func(signer ssh.Signer, key ssh.PublicKey) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             key,
+			CertType:        ssh.UserCert,
+			KeyId:           "alice",
+			ValidPrincipals: []string{"alice"},
+			ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(now.Add(time.Minute).Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatalf("verifySignedKey: fail signing certificate (%v)", err)
+		}
+		return cert
+	}
+	marshal := func(cert *ssh.Certificate) string {
+		return string(ssh.MarshalAuthorizedKey(cert))
+	}
+
+	t.Run(
+		"Valid certificate",
+		func(t *testing.T) {
+			if err := verifySignedKey(marshal(newCert(caSigner, userKey)), caSigner.PublicKey(), userKey); err != nil {
+				t.Fatalf("verifySignedKey: check fail with valid certificate (%v)", err)
+			}
+		})
+	t.Run(
+		"Certificate from another CA",
+		func(t *testing.T) {
+			if err := verifySignedKey(marshal(newCert(otherSigner, userKey)), caSigner.PublicKey(), userKey); err == nil {
+				t.Fatalf("verifySignedKey: check fail with another CA (%v)", err)
+			}
+		})
+	t.Run(
+		"Tampered certificate",
+		func(t *testing.T) {
+			cert := newCert(caSigner, userKey)
+			cert.ValidPrincipals = []string{"root"}
+			if err := verifySignedKey(marshal(cert), caSigner.PublicKey(), userKey); err == nil {
+				t.Fatalf("verifySignedKey: check fail with tampered certificate (%v)", err)
+			}
+		})
+	t.Run(
+		"Mismatched key",
+		func(t *testing.T) {
+			if err := verifySignedKey(marshal(newCert(caSigner, otherUserKey)), caSigner.PublicKey(), userKey); err == nil {
+				t.Fatalf("verifySignedKey: check fail with mismatched key (%v)", err)
+			}
+		})
+	t.Run(
+		"Not a certificate",
+		func(t *testing.T) {
+			if err := verifySignedKey(string(ssh.MarshalAuthorizedKey(userKey)), caSigner.PublicKey(), userKey); err == nil {
+				t.Fatalf("verifySignedKey: check fail with plain public key (%v)", err)
+			}
+		})
+}
+
+// mockVault is an AppRole backend keeping valid secret ids
+type mockVault struct {
+	sync.Mutex
+	secretIDs map[string]bool
+	generated int
+}
+
+func (m *mockVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+	var body map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	switch r.URL.Path {
+	case "/login":
+		if body["role_id"] != "role" || !m.secretIDs[body["secret_id"]] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"token"}}`))
+	case "/secret-id":
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		m.generated++
+		secretID := "secret-" + strings.Repeat("x", m.generated)
+		m.secretIDs[secretID] = true
+		_, _ = w.Write([]byte(`{"data":{"secret_id":"` + secretID + `","secret_id_accessor":"accessor-` + secretID + `"}}`))
+	case "/secret-id/destroy":
+		delete(m.secretIDs, body["secret_id"])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRotateSecretID(t *testing.T) {
+	vault := &mockVault{secretIDs: map[string]bool{"initial": true}}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "secret-id")
+	config := viper.New()
+	config.Set("ca_endpoint", server.URL)
+	config.Set("ca_login_url", "/login")
+	config.Set("ca_secret_id_url", "/secret-id")
+	config.Set("ca_role_id", "role")
+	config.Set("ca_external_secret_id", "initial")
+	config.Set("ca_external_secret_id_file", file)
+
+	t.Run(
+		"First rotation",
+		func(t *testing.T) {
+			accessor, err := RotateSecretID(*config)
+			if err != nil || accessor != "accessor-secret-x" {
+				t.Fatalf("RotateSecretID: check fail rotating secret id (%s, %v)", accessor, err)
+			}
+			secretID, err := vaultSecretID(*config)
+			if err != nil || secretID != "secret-x" {
+				t.Fatalf("RotateSecretID: check fail reading rotated secret id (%s, %v)", secretID, err)
+			}
+			if vault.secretIDs["initial"] {
+				t.Fatalf("RotateSecretID: check fail destroying previous secret id")
+			}
+		})
+	t.Run(
+		"Rotation again",
+		func(t *testing.T) {
+			if _, err := RotateSecretID(*config); err != nil {
+				t.Fatalf("RotateSecretID: check fail rotating twice (%v)", err)
+			}
+			secretID, _ := vaultSecretID(*config)
+			if secretID != "secret-xx" || len(vault.secretIDs) != 1 {
+				t.Fatalf("RotateSecretID: check fail keeping only new secret id (%s, %v)", secretID, vault.secretIDs)
+			}
+			info, err := os.Stat(file)
+			if err != nil || info.Mode().Perm() != 0600 {
+				t.Fatalf("RotateSecretID: check fail with secret id file permissions (%v)", err)
+			}
+		})
+	t.Run(
+		"Invalid current secret id",
+		func(t *testing.T) {
+			if err := os.WriteFile(file, []byte("revoked\n"), 0600); err != nil {
+				t.Fatalf("RotateSecretID: fail writing secret id file (%v)", err)
+			}
+			if _, err := RotateSecretID(*config); err == nil {
+				t.Fatalf("RotateSecretID: check fail with invalid current secret id")
+			}
+			if secretID, _ := vaultSecretID(*config); secretID != "revoked" {
+				t.Fatalf("RotateSecretID: check fail keeping secret id file on failure (%s)", secretID)
+			}
+		})
+	t.Run(
+		"Without secret id file",
+		func(t *testing.T) {
+			config := viper.New()
+			config.Set("ca_secret_id_url", "/secret-id")
+			if _, err := RotateSecretID(*config); err == nil {
+				t.Fatalf("RotateSecretID: check fail without ca_external_secret_id_file")
+			}
+		})
+}
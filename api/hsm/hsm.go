@@ -0,0 +1,124 @@
+package hsm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// askpass prints the token PIN to ssh-add, which reads it only through SSH_ASKPASS. The PIN is
+// passed by environment, it is never written to disk.
+const askpass = "#!/bin/sh\nprintf '%s\\n' \"$GSH_PKCS11_PIN\"\n"
+
+// Agent is a private ssh-agent holding the keys of a PKCS#11 token (as an HSM). Certificates are
+// signed by the token through the agent, so the CA private key never leaves it.
+type Agent struct {
+	dir     string
+	socket  string
+	process *exec.Cmd
+}
+
+// Start runs a private ssh-agent, waiting up to timeout for it, and loads the keys of the token
+// at PKCS#11 module, unlocked by pin
+func Start(module string, pin string, timeout time.Duration) (*Agent, error) {
+	module, err := filepath.Abs(module)
+	if err != nil {
+		return nil, fmt.Errorf("Start: invalid PKCS#11 module path (%v)", err)
+	}
+	if _, err := os.Stat(module); err != nil {
+		return nil, fmt.Errorf("Start: PKCS#11 module not found (%v)", err)
+	}
+	dir, err := os.MkdirTemp("", "gsh-hsm-")
+	if err != nil {
+		return nil, fmt.Errorf("Start: fail creating agent folder (%v)", err)
+	}
+	a := &Agent{dir: dir, socket: filepath.Join(dir, "agent.sock")}
+
+	// the agent only loads this module (-P), running in foreground to be stopped by Close
+	// #nosec
+	a.process = exec.Command("ssh-agent", "-D", "-a", a.socket, "-P", module)
+	if err := a.process.Start(); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("Start: fail running ssh-agent (%v)", err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := os.Stat(a.socket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			a.Close()
+			return nil, errors.New("Start: ssh-agent did not start in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	askpassFile := filepath.Join(dir, "askpass")
+	if err := os.WriteFile(askpassFile, []byte(askpass), 0700); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("Start: fail writing askpass (%v)", err)
+	}
+	// #nosec
+	add := exec.Command("ssh-add", "-s", module)
+	add.Env = append(os.Environ(), "SSH_AUTH_SOCK="+a.socket, "SSH_ASKPASS="+askpassFile,
+		"SSH_ASKPASS_REQUIRE=force", "DISPLAY=gsh", "GSH_PKCS11_PIN="+pin)
+	var output bytes.Buffer
+	add.Stdout = &output
+	add.Stderr = &output
+	if err := add.Run(); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("Start: fail loading PKCS#11 keys (%v: %s)", err, strings.TrimSpace(output.String()))
+	}
+	return a, nil
+}
+
+// Close stops the agent and removes its folder
+func (a *Agent) Close() {
+	if a.process != nil && a.process.Process != nil {
+		_ = a.process.Process.Kill()
+		_ = a.process.Wait()
+	}
+	_ = os.RemoveAll(a.dir)
+}
+
+// CheckKey checks if the token has the private key of caPublicKey
+func (a *Agent) CheckKey(caPublicKey ssh.PublicKey) error {
+	return a.withSigner(caPublicKey, func(ssh.Signer) error { return nil })
+}
+
+// SignCert signs cert by the private key of caPublicKey, at the token
+func (a *Agent) SignCert(cert *ssh.Certificate, caPublicKey ssh.PublicKey) error {
+	return a.withSigner(caPublicKey, func(signer ssh.Signer) error {
+		return cert.SignCert(rand.Reader, signer)
+	})
+}
+
+// withSigner calls f with the agent signer of caPublicKey, connected to the agent while f runs
+func (a *Agent) withSigner(caPublicKey ssh.PublicKey, f func(ssh.Signer) error) error {
+	conn, err := net.Dial("unix", a.socket)
+	if err != nil {
+		return fmt.Errorf("fail connecting to PKCS#11 agent (%v)", err)
+	}
+	defer conn.Close()
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		return fmt.Errorf("fail listing PKCS#11 keys (%v)", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(caPublicKey)
+	for _, signer := range signers {
+		if ssh.FingerprintSHA256(signer.PublicKey()) == fingerprint {
+			return f(signer)
+		}
+	}
+	return fmt.Errorf("CA key %s not found at PKCS#11 token", fingerprint)
+}
@@ -0,0 +1,133 @@
+package hsm
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// softHSMModules are the usual paths of SoftHSM PKCS#11 module, used when SOFTHSM2_MODULE is not set
+var softHSMModules = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// softHSMToken initializes a SoftHSM token with pin, at a temporary folder, holding a new CA key.
+// It returns the module path and the CA public key, skipping the test without SoftHSM.
+func softHSMToken(t *testing.T, pin string) (string, ssh.PublicKey) {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		for _, path := range softHSMModules {
+			if _, err := os.Stat(path); err == nil {
+				module = path
+				break
+			}
+		}
+	}
+	if _, err := exec.LookPath("softhsm2-util"); err != nil || module == "" {
+		t.Skipf("SoftHSM: not available (module %q, %v)", module, err)
+	}
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.MkdirAll(filepath.Join(dir, "tokens"), 0700); err != nil {
+		t.Fatalf("SoftHSM: fail creating token folder (%v)", err)
+	}
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+filepath.Join(dir, "tokens")+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatalf("SoftHSM: fail writing config (%v)", err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("SoftHSM: fail generating CA key (%v)", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("SoftHSM: fail marshaling CA key (%v)", err)
+	}
+	keyFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("SoftHSM: fail writing CA key (%v)", err)
+	}
+	for _, args := range [][]string{
+		{"--init-token", "--free", "--label", "gsh", "--pin", pin, "--so-pin", "12345678"},
+		{"--import", keyFile, "--token", "gsh", "--label", "ca", "--id", "01", "--pin", pin},
+	} {
+		if output, err := exec.Command("softhsm2-util", args...).CombinedOutput(); err != nil {
+			t.Fatalf("SoftHSM: fail running softhsm2-util %v (%v: %s)", args, err, output)
+		}
+	}
+	// the CA key is only at the token
+	_ = os.Remove(keyFile)
+
+	caPublicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("SoftHSM: fail converting CA key (%v)", err)
+	}
+	return module, caPublicKey
+}
+
+func TestAgent(t *testing.T) {
+	t.Run(
+		"Module not found",
+		func(t *testing.T) {
+			if _, err := Start(filepath.Join(t.TempDir(), "missing.so"), "1234", time.Second); err == nil {
+				t.Fatalf("Start: check fail with missing module")
+			}
+		})
+	t.Run(
+		"Sign at SoftHSM token",
+		func(t *testing.T) {
+			module, caPublicKey := softHSMToken(t, "1234")
+			a, err := Start(module, "1234", 10*time.Second)
+			if err != nil {
+				t.Fatalf("Start: check fail loading SoftHSM token (%v)", err)
+			}
+			defer a.Close()
+			if err := a.CheckKey(caPublicKey); err != nil {
+				t.Fatalf("CheckKey: check fail with CA key at token (%v)", err)
+			}
+
+			userKey, _, _ := ed25519.GenerateKey(rand.Reader)
+			userPublicKey, _ := ssh.NewPublicKey(userKey)
+			cert := &ssh.Certificate{Key: userPublicKey, CertType: ssh.UserCert, KeyId: "gsh:alice:root:1", ValidPrincipals: []string{"root"},
+				ValidAfter: uint64(time.Now().Add(-time.Minute).Unix()), ValidBefore: uint64(time.Now().Add(time.Minute).Unix())}
+			if err := a.SignCert(cert, caPublicKey); err != nil {
+				t.Fatalf("SignCert: check fail signing at token (%v)", err)
+			}
+			if ssh.FingerprintSHA256(cert.SignatureKey) != ssh.FingerprintSHA256(caPublicKey) {
+				t.Fatalf("SignCert: check fail, certificate not signed by CA key (%s)", ssh.FingerprintSHA256(cert.SignatureKey))
+			}
+			if err := (&ssh.CertChecker{}).CheckCert("root", cert); err != nil {
+				t.Fatalf("SignCert: check fail verifying signature (%v)", err)
+			}
+
+			otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+			otherPublicKey, _ := ssh.NewPublicKey(otherKey)
+			if err := a.CheckKey(otherPublicKey); err == nil {
+				t.Fatalf("CheckKey: check fail with key not at token")
+			}
+		})
+	t.Run(
+		"Wrong PIN",
+		func(t *testing.T) {
+			module, _ := softHSMToken(t, "1234")
+			if a, err := Start(module, "4321", 10*time.Second); err == nil {
+				a.Close()
+				t.Fatalf("Start: check fail with wrong PIN")
+			}
+		})
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/globocom/gsh/api/config"
+	"github.com/globocom/gsh/api/hsm"
+	"github.com/globocom/gsh/api/middlewares"
 	"github.com/globocom/gsh/api/permissions"
 	"github.com/globocom/gsh/api/storage"
 	"github.com/globocom/gsh/types"
@@ -13,15 +21,64 @@ import (
 	"github.com/globocom/gsh/api/workers"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
 )
 
 func main() {
 	// Reading configuration
 	configuration := config.Init()
-	err := config.Check(configuration)
+
+	// TLS floor of API and of its connections (Vault, OIDC provider)
+	tlsConfig, err := config.TLSConfig(configuration)
 	if err != nil {
 		panic(err)
 	}
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tlsConfig.MinVersion}
+	}
+
+	// Rotates Vault AppRole secret id and exits (gsh-api vault-rotate-secret)
+	if len(os.Args) > 1 && os.Args[1] == "vault-rotate-secret" {
+		accessor, err := handlers.RotateSecretID(configuration)
+		if accessor != "" {
+			fmt.Printf("Vault secret id rotated (accessor %s)\n", accessor)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Imports an existing OpenSSH CA into the internal signer config and exits
+	// (gsh-api ca-import --private-key file --public-key file)
+	if len(os.Args) > 1 && os.Args[1] == "ca-import" {
+		file := configuration.ConfigFileUsed()
+		if file == "" {
+			file = config.DefaultFile
+		}
+		if err := caImport(os.Args[2:], file); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("CA key pair imported into %s\n", file)
+		if configuration.GetBool("ca_external") {
+			fmt.Println("Warning: ca_external is enabled, the imported CA is used only by the internal signer")
+		}
+		os.Exit(0)
+	}
+
+	err = config.Check(configuration)
+	if err != nil {
+		panic(err)
+	}
+
+	// Prints the active signer backend and CA and exits (gsh-api info)
+	if len(os.Args) > 1 && os.Args[1] == "info" {
+		printSignerInfo(handlers.NewAppHandler(configuration, nil, nil, nil, nil, nil).SignerInfo())
+		os.Exit(0)
+	}
 
 	// Configuring storage
 	db, err := storage.Init(configuration)
@@ -29,6 +86,13 @@ func main() {
 		panic(err)
 	}
 	defer db.Close()
+	replica, err := storage.InitReplica(configuration, db)
+	if err != nil {
+		panic(err)
+	}
+	if replica != db {
+		defer replica.Close()
+	}
 
 	// Configuring Casbin
 	permEnforcer, err := permissions.Init(configuration)
@@ -41,34 +105,156 @@ func main() {
 	var auditChannel = make(chan types.AuditRecord, defaultChannelSize)
 	var logChannel = make(chan map[string]interface{}, defaultChannelSize)
 	var stopChannel = make(chan bool)
-	workers.InitWorkers(configuration, &auditChannel, &logChannel, &stopChannel, db)
+	workers.InitWorkers(configuration, &auditChannel, &logChannel, &stopChannel, db, replica)
 	defer workers.StopWorkers(&stopChannel)
 
 	// Init echo framework
 	e := echo.New()
 
 	// Creating handler with pointers to persistent data
-	appHandler := handlers.NewAppHandler(configuration, auditChannel, logChannel, db, permEnforcer)
+	appHandler := handlers.NewAppHandler(configuration, auditChannel, logChannel, db, replica, permEnforcer)
 
-	// Middlewares
+	// CA key at an HSM signs through a private ssh-agent holding the PKCS#11 token keys
+	if module := configuration.GetString("ca_pkcs11_module"); module != "" {
+		caHSM, err := startHSM(configuration, module)
+		if err != nil {
+			panic(err)
+		}
+		defer caHSM.Close()
+		appHandler.UseHSM(caHSM)
+		fmt.Printf("CA key is at HSM (%s)\n", module)
+	}
+
+	// Middlewares (client ip is only read from X-Forwarded-For set by trusted_proxies)
+	trustedProxies, err := middlewares.ParseCIDRs(configuration.GetStringSlice("trusted_proxies"))
+	if err != nil {
+		panic(err)
+	}
+	e.Use(middlewares.RealIP(trustedProxies))
+	e.Use(middleware.RequestID())
+	e.Use(middlewares.Instance(configuration.GetString("instance_name")))
 	e.Use(middleware.Logger())
+	// each item of a batch is a certificate request, bounded by http_body_limit alike
+	bodyLimit := configuration.GetInt64("http_body_limit")
+	e.Use(middlewares.BodyLimit(bodyLimit, map[string]int64{"/certificates/batch": bodyLimit * configuration.GetInt64("cert_batch_limit")}))
+	e.Use(middlewares.ReadOnly(appHandler.ReadOnly, []string{"/certificates/validate", "/authz/simulate"}))
+	if appHandler.ReadOnly() {
+		fmt.Println("Maintenance mode is active (read_only): certificates will not be issued")
+	} else if configuration.GetString("read_only_file") != "" {
+		fmt.Printf("Maintenance mode is enabled while %s exists\n", configuration.GetString("read_only_file"))
+	}
+
+	// Role management, self tests and the audit stream are restricted to admin networks, when
+	// admin_source_cidrs is set. Approvals are not: approvers (perm_approver) are not admins and
+	// requesters fetch approved certificates at GET /approvals/:id.
+	adminNetworks, err := middlewares.ParseCIDRs(configuration.GetStringSlice("admin_source_cidrs"))
+	if err != nil {
+		panic(err)
+	}
+	adminSource := middlewares.AdminSource(adminNetworks, trustedProxies)
 
 	// Routes (live test if application crash, ready test backend services)
 	e.GET("/status/live", handlers.StatusLive)
 	e.GET("/status/ready", handlers.StatusReady)
 	e.GET("/status/config", appHandler.StatusConfig)
+	e.GET("/selftest", appHandler.SelfTest, adminSource)
+	e.GET("/info", appHandler.Info, adminSource)
 	e.GET("/publickey", appHandler.PublicKey)
 	e.GET("/certificates/:serial", appHandler.CertInfo)
 	e.POST("/certificates", appHandler.CertCreate)
+	e.POST("/certificates/batch", appHandler.CertBatch)
+	e.POST("/certificates/challenge", appHandler.CertChallenge)
+	e.POST("/certificates/validate", appHandler.CertValidate)
+	e.GET("/audit/stream", appHandler.AuditStream, adminSource)
+
+	e.GET("/approvals", appHandler.GetApprovals)
+	e.GET("/approvals/:id", appHandler.GetApproval)
+	e.POST("/approvals/:id/approve", appHandler.ApproveApproval)
+	e.POST("/approvals/:id/deny", appHandler.DenyApproval)
 
 	e.GET("/authz/roles/me", appHandler.GetRolesForMe)
-	e.GET("/authz/roles", appHandler.GetRoles)
-	e.GET("/authz/roles/:role", appHandler.GetUsersWithRole)
-	e.POST("/authz/roles", appHandler.AddRoles)
-	e.DELETE("/authz/roles/:role", appHandler.RemoveRole)
-	e.GET("/authz/user/:user", appHandler.GetRolesByUser)
-	e.POST("/authz/roles/:role/:user", appHandler.AssociateRoleToUser)
-	e.DELETE("/authz/roles/:role/:user", appHandler.DisassociateRoleToUser)
+	e.GET("/authz/roles", appHandler.GetRoles, adminSource)
+	e.GET("/authz/roles/:role", appHandler.GetUsersWithRole, adminSource)
+	e.POST("/authz/roles", appHandler.AddRoles, adminSource)
+	e.POST("/authz/simulate", appHandler.SimulateRole, adminSource)
+	e.GET("/authz/role-usage", appHandler.RoleUsage, adminSource)
+	e.PUT("/authz/roles/:role", appHandler.UpdateRole, adminSource)
+	e.DELETE("/authz/roles/:role", appHandler.RemoveRole, adminSource)
+	e.POST("/authz/deleted-roles/:role/restore", appHandler.RestoreRole, adminSource)
+	e.GET("/authz/user/:user", appHandler.GetRolesByUser, adminSource)
+	e.PUT("/authz/quotas/:user", appHandler.SetQuota, adminSource)
+	e.DELETE("/authz/quotas/:user", appHandler.RemoveQuota, adminSource)
+	e.POST("/authz/roles/:role/:user", appHandler.AssociateRoleToUser, adminSource)
+	e.DELETE("/authz/roles/:role/:user", appHandler.DisassociateRoleToUser, adminSource)
+	e.POST("/authz/roles/:role/groups/:group", appHandler.AssociateRoleToGroup, adminSource)
+	e.DELETE("/authz/roles/:role/groups/:group", appHandler.DisassociateRoleToGroup, adminSource)
 
+	// TLS is served when tls_cert_file is set, otherwise it is terminated by a proxy
+	if configuration.GetString("tls_cert_file") != "" {
+		e.TLSServer.Addr = ":" + os.Getenv("PORT")
+		e.TLSServer.TLSConfig = tlsConfig
+		e.Logger.Fatal(e.StartServer(e.TLSServer))
+	}
 	e.Logger.Fatal(e.Start(":" + os.Getenv("PORT")))
 }
+
+// caImport validates the CA key pair informed by args (--private-key and --public-key files) and
+// writes it at configuration file
+func caImport(args []string, file string) error {
+	flags := flag.NewFlagSet("ca-import", flag.ContinueOnError)
+	privateKeyFile := flags.String("private-key", "", "CA private key file (unencrypted)")
+	publicKeyFile := flags.String("public-key", "", "CA public key file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *privateKeyFile == "" || *publicKeyFile == "" {
+		return errors.New("caImport: --private-key and --public-key are required")
+	}
+	privateKey, err := os.ReadFile(*privateKeyFile)
+	if err != nil {
+		return errors.New("caImport: reading private key (" + err.Error() + ")")
+	}
+	publicKey, err := os.ReadFile(*publicKeyFile)
+	if err != nil {
+		return errors.New("caImport: reading public key (" + err.Error() + ")")
+	}
+	caPublicKey, err := config.CAKeyPair(privateKey, publicKey)
+	if err != nil {
+		return err
+	}
+	return config.ImportCA(file, string(privateKey), caPublicKey)
+}
+
+// printSignerInfo prints the signer info reported by gsh-api info
+func printSignerInfo(info types.SignerInfo) {
+	fmt.Printf("Signer backend: %s\n", info.Backend)
+	if info.CAError != "" {
+		fmt.Printf("CA fingerprint: unavailable (%s)\n", info.CAError)
+	} else {
+		fmt.Printf("CA fingerprint: %s\n", info.CAFingerprint)
+	}
+	fmt.Printf("Max TTL: %s\n", info.MaxTTL)
+	fmt.Printf("Allowed key types: %s\n", strings.Join(info.AllowedKeyTypes, ", "))
+	fmt.Printf("Read only: %t\n", info.ReadOnly)
+}
+
+// startHSM starts the agent of the CA HSM at PKCS#11 module, checking it has the key of ca_public_key
+func startHSM(configuration viper.Viper, module string) (*hsm.Agent, error) {
+	pin, err := config.HSMPin(configuration)
+	if err != nil {
+		return nil, err
+	}
+	caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(configuration.GetString("ca_public_key")))
+	if err != nil {
+		return nil, err
+	}
+	agent, err := hsm.Start(module, pin, configuration.GetDuration("ca_pkcs11_timeout"))
+	if err != nil {
+		return nil, err
+	}
+	if err := agent.CheckKey(caPublicKey); err != nil {
+		agent.Close()
+		return nil, err
+	}
+	return agent, nil
+}
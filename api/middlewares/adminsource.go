@@ -0,0 +1,27 @@
+package middlewares
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// AdminSource returns a middleware that refuses requests (403 Forbidden) not originating from
+// allowed networks. Without allowed networks every request is accepted.
+func AdminSource(allowed []*net.IPNet, trustedProxies []*net.IPNet) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(allowed) == 0 {
+				return next(c)
+			}
+			ip := SourceIP(c.Request(), trustedProxies)
+			if ip == nil || !containsIP(allowed, ip) {
+				return c.JSON(http.StatusForbidden,
+					map[string]string{"result": "fail", "message": "Management requests are not allowed from this source address", "details": fmt.Sprintf("%v is not in admin_source_cidrs", ip)})
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestAdminSource(t *testing.T) {
+	allowed, _ := ParseCIDRs([]string{"10.10.0.0/16"})
+	proxies, _ := ParseCIDRs([]string{"192.168.0.0/24"})
+	tests := []struct {
+		name       string
+		allowed    bool
+		remoteAddr string
+		forwarded  string
+		code       int
+	}{
+		{"Allowed source", true, "10.10.1.1:4000", "", http.StatusOK},
+		{"Blocked source", true, "10.20.1.1:4000", "", http.StatusForbidden},
+		{"Allowed behind trusted proxy", true, "192.168.0.10:4000", "10.10.1.1", http.StatusOK},
+		{"Blocked behind trusted proxy", true, "192.168.0.10:4000", "10.20.1.1", http.StatusForbidden},
+		{"Spoofed behind trusted proxy", true, "192.168.0.10:4000", "10.10.1.1, 10.20.1.1", http.StatusForbidden},
+		{"Chain of trusted proxies", true, "192.168.0.10:4000", "10.10.1.1, 192.168.0.11", http.StatusOK},
+		{"Forwarded ignored from untrusted", true, "10.20.1.1:4000", "10.10.1.1", http.StatusForbidden},
+		{"Without allowlist", false, "10.20.1.1:4000", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				networks := allowed
+				if !test.allowed {
+					networks = nil
+				}
+				e := echo.New()
+				e.POST("/authz/roles", func(c echo.Context) error {
+					return c.String(http.StatusOK, "ok")
+				}, AdminSource(networks, proxies))
+				req := httptest.NewRequest(http.MethodPost, "/authz/roles", nil)
+				req.RemoteAddr = test.remoteAddr
+				if test.forwarded != "" {
+					req.Header.Set(echo.HeaderXForwardedFor, test.forwarded)
+				}
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				if rec.Code != test.code {
+					t.Fatalf("AdminSource: check fail from %s (forwarded %q) (%d)", test.remoteAddr, test.forwarded, rec.Code)
+				}
+			})
+	}
+}
@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// BodyLimit returns a middleware that rejects requests with body larger than limit bytes (413 Request Entity Too Large).
+// Paths at pathLimits (as batch endpoints) have their own limit instead.
+func BodyLimit(limit int64, pathLimits map[string]int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Body == nil || req.Body == http.NoBody {
+				return next(c)
+			}
+			maxBytes := limit
+			if pathLimit, ok := pathLimits[req.URL.Path]; ok {
+				maxBytes = pathLimit
+			}
+
+			// Content-Length is informed, checking before reading
+			if req.ContentLength > maxBytes {
+				return requestTooLarge(c, maxBytes)
+			}
+
+			// Content-Length is not informed or is not reliable, reading until limit
+			body, err := io.ReadAll(http.MaxBytesReader(c.Response(), req.Body, maxBytes))
+			if err != nil {
+				if int64(len(body)) >= maxBytes {
+					return requestTooLarge(c, maxBytes)
+				}
+				return c.JSON(http.StatusBadRequest,
+					map[string]string{"result": "fail", "message": "Error reading request body", "details": err.Error()})
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			return next(c)
+		}
+	}
+}
+
+// requestTooLarge responds 413 to requests with body larger than limit bytes. Clients waiting
+// for 100-continue never receive it, so the connection is closed instead of reading their body.
+func requestTooLarge(c echo.Context, limit int64) error {
+	if strings.EqualFold(c.Request().Header.Get("Expect"), "100-continue") {
+		c.Response().Header().Set("Connection", "close")
+	}
+	return c.JSON(http.StatusRequestEntityTooLarge,
+		map[string]string{"result": "fail", "message": "Request body too large", "details": "Maximum size is " + strconv.FormatInt(limit, 10) + " bytes"})
+}
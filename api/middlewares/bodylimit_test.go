@@ -0,0 +1,148 @@
+package middlewares
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func newBodyLimitServer(limit int64) *echo.Echo {
+	e := echo.New()
+	echoBody := func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, string(body))
+	}
+	e.Use(BodyLimit(limit, map[string]int64{"/certificates/batch": 4 * limit}))
+	e.POST("/certificates", echoBody)
+	e.POST("/certificates/batch", echoBody)
+	return e
+}
+
+func TestBodyLimit(t *testing.T) {
+	e := newBodyLimitServer(16)
+
+	t.Run(
+		"Body under limit",
+		func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/certificates", strings.NewReader(`{"key":"small"}`))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK || rec.Body.String() != `{"key":"small"}` {
+				t.Fatalf("BodyLimit: check fail with body under limit (%d %s)", rec.Code, rec.Body.String())
+			}
+		})
+	t.Run(
+		"Oversized body with Content-Length",
+		func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/certificates", strings.NewReader(strings.Repeat("a", 1024)))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("BodyLimit: check fail with oversized body (%d)", rec.Code)
+			}
+		})
+	t.Run(
+		"Oversized body without Content-Length",
+		func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/certificates", io.NopCloser(strings.NewReader(strings.Repeat("a", 1024))))
+			req.ContentLength = -1
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("BodyLimit: check fail with oversized chunked body (%d)", rec.Code)
+			}
+		})
+	t.Run(
+		"Path limit",
+		func(t *testing.T) {
+			body := strings.Repeat("a", 48)
+			req := httptest.NewRequest(http.MethodPost, "/certificates/batch", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK || rec.Body.String() != body {
+				t.Fatalf("BodyLimit: check fail with body under path limit (%d %s)", rec.Code, rec.Body.String())
+			}
+			req = httptest.NewRequest(http.MethodPost, "/certificates/batch", strings.NewReader(strings.Repeat("a", 1024)))
+			rec = httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("BodyLimit: check fail with body over path limit (%d)", rec.Code)
+			}
+		})
+}
+
+// expectContinueRequest sends the headers of a POST /certificates with "Expect: 100-continue" to
+// addr, announcing size bytes of body, and returns the connection and its reader
+func expectContinueRequest(t *testing.T, addr string, size int) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("BodyLimit: fail connecting (%v)", err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("BodyLimit: fail setting deadline (%v)", err)
+	}
+	_, err = fmt.Fprintf(conn, "POST /certificates HTTP/1.1\r\nHost: %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", addr, size)
+	if err != nil {
+		t.Fatalf("BodyLimit: fail sending headers (%v)", err)
+	}
+	return conn, bufio.NewReader(conn)
+}
+
+func TestBodyLimitExpectContinue(t *testing.T) {
+	server := httptest.NewServer(newBodyLimitServer(16))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	t.Run(
+		"Body under limit",
+		func(t *testing.T) {
+			body := `{"key":"small"}`
+			conn, reader := expectContinueRequest(t, addr, len(body))
+			defer conn.Close()
+
+			// body is only sent after 100 Continue, as curl and proxies do
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil || resp.StatusCode != http.StatusContinue {
+				t.Fatalf("BodyLimit: check fail waiting 100 Continue (%v, %v)", resp, err)
+			}
+			if _, err := io.WriteString(conn, body); err != nil {
+				t.Fatalf("BodyLimit: fail sending body (%v)", err)
+			}
+			resp, err = http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("BodyLimit: check fail reading response (%v)", err)
+			}
+			defer resp.Body.Close()
+			received, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(received) != body {
+				t.Fatalf("BodyLimit: check fail with body under limit (%d %s)", resp.StatusCode, received)
+			}
+		})
+	t.Run(
+		"Oversized body",
+		func(t *testing.T) {
+			conn, reader := expectContinueRequest(t, addr, 1024)
+			defer conn.Close()
+
+			// rejected without 100 Continue, the body is never sent
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("BodyLimit: check fail reading response (%v)", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusRequestEntityTooLarge || !resp.Close {
+				t.Fatalf("BodyLimit: check fail with oversized body (%d, close %v)", resp.StatusCode, resp.Close)
+			}
+		})
+}
@@ -0,0 +1,21 @@
+package middlewares
+
+import (
+	"github.com/labstack/echo"
+)
+
+// HeaderXGSHInstance identifies the API instance that handled a request
+const HeaderXGSHInstance = "X-GSH-Instance"
+
+// Instance returns a middleware that adds the X-GSH-Instance header with name to responses,
+// telling which API server of a fleet handled each request (with X-Request-ID)
+func Instance(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if name != "" {
+				c.Response().Header().Set(HeaderXGSHInstance, name)
+			}
+			return next(c)
+		}
+	}
+}
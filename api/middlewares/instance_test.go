@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+func TestInstance(t *testing.T) {
+	newServer := func(name string) *echo.Echo {
+		e := echo.New()
+		e.Use(middleware.RequestID())
+		e.Use(Instance(name))
+		e.GET("/status/live", func(c echo.Context) error {
+			return c.String(http.StatusOK, "WORKING")
+		})
+		e.GET("/fail", func(c echo.Context) error {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"result": "fail"})
+		})
+		return e
+	}
+	t.Run(
+		"Configured name",
+		func(t *testing.T) {
+			for _, path := range []string{"/status/live", "/fail"} {
+				rec := httptest.NewRecorder()
+				newServer("api-1").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Header().Get(HeaderXGSHInstance) != "api-1" || rec.Header().Get(echo.HeaderXRequestID) == "" {
+					t.Fatalf("Instance: check fail with headers at %s (%v)", path, rec.Header())
+				}
+			}
+		})
+	t.Run(
+		"Without name",
+		func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newServer("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/live", nil))
+			if _, ok := rec.Header()[HeaderXGSHInstance]; ok {
+				t.Fatalf("Instance: check fail without name (%v)", rec.Header())
+			}
+		})
+}
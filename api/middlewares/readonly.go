@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// ReadOnly returns a middleware that, while enabled returns true, refuses requests that change
+// data (503 Service Unavailable). Read requests and POST requests to paths at allowed (as
+// validation endpoints) keep working.
+func ReadOnly(enabled func() bool, allowed []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			for _, path := range allowed {
+				if c.Request().URL.Path == path {
+					return next(c)
+				}
+			}
+			if !enabled() {
+				return next(c)
+			}
+			return c.JSON(http.StatusServiceUnavailable,
+				map[string]string{"result": "fail", "message": "GSH is in maintenance mode (read only)", "details": "Certificates can't be issued and changes are not accepted now, try again later"})
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func newReadOnlyServer(enabled bool) *echo.Echo {
+	e := echo.New()
+	e.Use(ReadOnly(func() bool { return enabled }, []string{"/certificates/validate"}))
+	ok := func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	}
+	e.POST("/certificates", ok)
+	e.POST("/certificates/validate", ok)
+	e.GET("/publickey", ok)
+	return e
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		method  string
+		path    string
+		code    int
+	}{
+		{"Issuance refused", true, http.MethodPost, "/certificates", http.StatusServiceUnavailable},
+		{"Validation works", true, http.MethodPost, "/certificates/validate", http.StatusOK},
+		{"Reads work", true, http.MethodGet, "/publickey", http.StatusOK},
+		{"Issuance without maintenance", false, http.MethodPost, "/certificates", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				e := newReadOnlyServer(test.enabled)
+				req := httptest.NewRequest(test.method, test.path, nil)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				if rec.Code != test.code {
+					t.Fatalf("ReadOnly: check fail with %s %s (%d)", test.method, test.path, rec.Code)
+				}
+			})
+	}
+}
@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// ParseCIDRs parses a list of networks in CIDR notation
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q (%v)", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// SourceIP returns the ip address of the client of r. X-Forwarded-For is only honored when the
+// request comes from trustedProxies, using the last address not added by a trusted proxy.
+func SourceIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !containsIP(trustedProxies, ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values(echo.HeaderXForwardedFor), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// RealIP returns a middleware that makes echo.Context RealIP safe to use: X-Forwarded-For and
+// X-Real-IP are removed from requests not coming from trustedProxies, and replaced by the
+// client address found by SourceIP otherwise
+func RealIP(trustedProxies []*net.IPNet) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			req.Header.Del(echo.HeaderXRealIP)
+			ip := SourceIP(req, trustedProxies)
+			if ip == nil || !containsIP(trustedProxies, remoteIP(req)) {
+				req.Header.Del(echo.HeaderXForwardedFor)
+				return next(c)
+			}
+			req.Header.Set(echo.HeaderXForwardedFor, ip.String())
+			return next(c)
+		}
+	}
+}
+
+// remoteIP returns the ip address of the direct peer of r
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// containsIP tells whether ip is in one of networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	if _, err := ParseCIDRs([]string{"10.0.0.0/8", "2001:db8::/32"}); err != nil {
+		t.Fatalf("ParseCIDRs: check fail with valid networks (%v)", err)
+	}
+	if _, err := ParseCIDRs([]string{"10.0.0.1"}); err == nil {
+		t.Fatalf("ParseCIDRs: check fail with address without mask")
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	proxies, _ := ParseCIDRs([]string{"192.168.0.0/24"})
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		ip         string
+	}{
+		{"Direct client", "10.10.1.1:4000", "", "", "10.10.1.1"},
+		{"Spoofed forwarded from untrusted", "10.20.1.1:4000", "10.10.1.1", "", "10.20.1.1"},
+		{"Spoofed real ip from untrusted", "10.20.1.1:4000", "", "10.10.1.1", "10.20.1.1"},
+		{"Client behind trusted proxy", "192.168.0.10:4000", "10.10.1.1", "", "10.10.1.1"},
+		{"Spoofed forwarded behind trusted proxy", "192.168.0.10:4000", "10.10.1.1, 10.20.1.1", "", "10.20.1.1"},
+		{"Chain of trusted proxies", "192.168.0.10:4000", "10.10.1.1, 192.168.0.11", "", "10.10.1.1"},
+		{"Trusted proxy without forwarded", "192.168.0.10:4000", "", "", "192.168.0.10"},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				e := echo.New()
+				e.Use(RealIP(proxies))
+				e.GET("/", func(c echo.Context) error {
+					return c.String(http.StatusOK, c.RealIP())
+				})
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = test.remoteAddr
+				if test.forwarded != "" {
+					req.Header.Set(echo.HeaderXForwardedFor, test.forwarded)
+				}
+				if test.realIP != "" {
+					req.Header.Set(echo.HeaderXRealIP, test.realIP)
+				}
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				if rec.Body.String() != test.ip {
+					t.Fatalf("RealIP: check fail from %s (forwarded %q), expected %s (%s)", test.remoteAddr, test.forwarded, test.ip, rec.Body.String())
+				}
+			})
+	}
+}
@@ -3,9 +3,11 @@ package permissions
 import (
 	"errors"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/casbin/casbin"
+	"github.com/casbin/casbin/model"
 	gormadapter "github.com/casbin/gorm-adapter"
 	"github.com/spf13/viper"
 )
@@ -13,12 +15,39 @@ import (
 // Init creates and returns a new Enforcer
 func Init(config viper.Viper) (*casbin.Enforcer, error) {
 	a := gormadapter.NewAdapter("mysql", config.GetString("storage_uri"), true)
+	m := Model()
+
+	// Initiates a new enforcer
+	e, err := casbin.NewEnforcerSafe(m, a)
+	if err != nil {
+		return nil, errors.New("init: Could not create new Enforcer")
+	}
+
+	e.SetModel(m)
+	e.EnableAutoSave(true)
+
+	// Enable multiples IP address as source or targets
+	e.AddFunction("ipMultipleMatch", IPMultipleMatchFunc)
+
+	// Reload policies from database before add admin policies
+	err = e.LoadPolicy()
+	if err != nil {
+		return nil, errors.New("init: Could not load policies")
+	}
+
+	return e, nil
+}
+
+// Model returns the casbin model of GSH roles
+func Model() model.Model {
 	m := casbin.NewModel()
 
 	// Add user request definitions:
 	m.AddDef("r", "r", "id, remoteuser, sourceip, targetip, actions, currentuser")
 
 	// Add policy definition
+	// Roles can have a sixth value, destination ports (see PolicyDestPorts), checked out of the
+	// matcher so roles created before it still load.
 	m.AddDef("p", "p", "id, remoteuser, sourceip, targetip, actions")
 
 	// Add role definition
@@ -41,26 +70,162 @@ func Init(config viper.Viper) (*casbin.Enforcer, error) {
 			"( ipMultipleMatch(r.targetip, p.targetip) ) && "+
 			"( p.actions == '*' || r.actions == p.actions )",
 	)
+	return m
+}
 
-	// Initiates a new enforcer
-	e, err := casbin.NewEnforcerSafe(m, a)
-	if err != nil {
-		return nil, errors.New("init: Could not create new Enforcer")
+// groupPrefix identifies casbin subjects that are groups, instead of users
+const groupPrefix = "group:"
+
+// GroupSubject returns the casbin subject used to assign roles to group
+func GroupSubject(group string) string {
+	return groupPrefix + group
+}
+
+// IsGroupSubject tells whether subject is a group
+func IsGroupSubject(subject string) bool {
+	return strings.HasPrefix(subject, groupPrefix)
+}
+
+// EffectiveRoles returns the union of roles assigned to username and to each one of groups,
+// without duplicates. rolesFor returns the roles of a casbin subject (usually GetRolesForUser).
+func EffectiveRoles(rolesFor func(subject string) []string, username string, groups []string) []string {
+	roles := []string{}
+	seen := map[string]bool{}
+	subjects := []string{username}
+	for _, group := range groups {
+		subjects = append(subjects, GroupSubject(group))
+	}
+	for _, subject := range subjects {
+		for _, role := range rolesFor(subject) {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
 	}
+	return roles
+}
 
-	e.SetModel(m)
-	e.EnableAutoSave(true)
+// Conditions reported by Explain when a role denies a request
+const (
+	DenyInvalidRole = "invalid role"
+	DenyRemoteUser  = "remote user not allowed by role"
+	DenySourceIP    = "source ip not allowed by role"
+	DenyTargetIP    = "remote host not allowed by role"
+	DenyDestPort    = "destination port not allowed by role"
+	DenyActions     = "actions not allowed by role"
+)
 
-	// Enable multiples IP address as source or targets
-	e.AddFunction("ipMultipleMatch", IPMultipleMatchFunc)
+// DefaultDestPort is the destination port of requests that don't inform one
+const DefaultDestPort = "22"
 
-	// Reload policies from database before add admin policies
-	err = e.LoadPolicy()
-	if err != nil {
-		return nil, errors.New("init: Could not load policies")
+// Explain evaluates a role policy (id, remoteuser, sourceip, targetip, actions, destports) with
+// the same conditions of the enforcer matcher and DestPortMatch, returning the first condition
+// that denies the request
+func Explain(policy []string, remoteUser string, sourceIP string, targetIP string, destPort string, actions string, currentUser string) (bool, string) {
+	if len(policy) < 5 {
+		return false, DenyInvalidRole
+	}
+	if !remoteUserMatch(policy[1], remoteUser, currentUser) {
+		return false, DenyRemoteUser
+	}
+	if match, err := IPMultipleMatch(sourceIP, policy[2]); err != nil || !match {
+		return false, DenySourceIP
+	}
+	if match, err := IPMultipleMatch(targetIP, policy[3]); err != nil || !match {
+		return false, DenyTargetIP
+	}
+	if match, err := DestPortMatch(destPort, PolicyDestPorts(policy)); err != nil || !match {
+		return false, DenyDestPort
 	}
+	if policy[4] != "*" && policy[4] != actions {
+		return false, DenyActions
+	}
+	return true, ""
+}
 
-	return e, nil
+// PolicyDestPorts returns the destination ports of a role policy, empty when it allows any port
+func PolicyDestPorts(policy []string) string {
+	if len(policy) < 6 {
+		return ""
+	}
+	return policy[5]
+}
+
+// ParseDestPorts validates a list of destination ports separated by ";", returning it normalized.
+// Empty or "*" allows any port.
+func ParseDestPorts(ports string) (string, error) {
+	if ports == "" || ports == "*" {
+		return "", nil
+	}
+	parsed := []string{}
+	for _, port := range strings.Split(ports, ";") {
+		number, err := strconv.Atoi(strings.TrimSpace(port))
+		if err != nil || number < 1 || number > 65535 {
+			return "", errors.New("ParseDestPorts: invalid port " + port)
+		}
+		parsed = append(parsed, strconv.Itoa(number))
+	}
+	return strings.Join(parsed, ";"), nil
+}
+
+// DestPortMatch tells whether destPort is one of ports (separated by ";"). Empty ports allow any
+// port, and an empty destPort is DefaultDestPort.
+func DestPortMatch(destPort string, ports string) (bool, error) {
+	if ports == "" || ports == "*" {
+		return true, nil
+	}
+	if destPort == "" {
+		destPort = DefaultDestPort
+	}
+	number, err := strconv.Atoi(destPort)
+	if err != nil || number < 1 || number > 65535 {
+		return false, errors.New("DestPortMatch: invalid destination port " + destPort)
+	}
+	for _, port := range strings.Split(ports, ";") {
+		if port == strconv.Itoa(number) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Decision is the result of evaluating one role for a request
+type Decision struct {
+	Role    string `json:"role"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Decide explains the decision of each role for a request. policyFor returns the policy of a
+// role, or nil when the role does not exist.
+func Decide(roles []string, policyFor func(role string) []string, remoteUser string, sourceIP string, targetIP string, destPort string, actions string, currentUser string) []Decision {
+	decisions := []Decision{}
+	for _, role := range roles {
+		allowed, reason := Explain(policyFor(role), remoteUser, sourceIP, targetIP, destPort, actions, currentUser)
+		decisions = append(decisions, Decision{Role: role, Allowed: allowed, Reason: reason})
+	}
+	return decisions
+}
+
+// ExplainBreakGlass evaluates a break-glass role policy as Explain does, ignoring source ip,
+// remote host and destination port restrictions. Remote user and actions still apply.
+func ExplainBreakGlass(policy []string, remoteUser string, actions string, currentUser string) (bool, string) {
+	if len(policy) < 5 {
+		return false, DenyInvalidRole
+	}
+	if !remoteUserMatch(policy[1], remoteUser, currentUser) {
+		return false, DenyRemoteUser
+	}
+	if policy[4] != "*" && policy[4] != actions {
+		return false, DenyActions
+	}
+	return true, ""
+}
+
+// remoteUserMatch tells whether the remote user pattern of a policy allows remoteUser
+func remoteUserMatch(pattern string, remoteUser string, currentUser string) bool {
+	return pattern == "*" || pattern == remoteUser || (pattern == "." && remoteUser == currentUser)
 }
 
 // IPMultipleMatch determines whether any of IP address in ip1 matches the pattern of any IP address in ip2, ip2 can be an IP address or a CIDR pattern.
@@ -82,6 +247,8 @@ func IPMultipleMatch(ips1 string, ips2 string) (bool, error) {
 				if objIP1.Equal(objIP2) {
 					anyMatch = true
 				}
+				// plain IP addresses have no CIDR to match
+				continue
 			}
 
 			if cidr.Contains(objIP1) {
@@ -29,6 +29,18 @@ func TestIPMultipleMatch_ipv4(t *testing.T) {
 				t.Fatalf("IPMultipleMatch: check fail with simple IPv4 /24 (%v)", result)
 			}
 		})
+	t.Run(
+		"Testing plain IPv4 address",
+		func(t *testing.T) {
+			result, err := IPMultipleMatch("192.0.2.1", "192.0.2.1")
+			if result == false || err != nil {
+				t.Fatalf("IPMultipleMatch: check fail with plain IPv4 (%v, %v)", result, err)
+			}
+			result, err = IPMultipleMatch("192.0.2.2", "192.0.2.1;198.51.100.0/24")
+			if result == true || err != nil {
+				t.Fatalf("IPMultipleMatch: check fail with another plain IPv4 (%v, %v)", result, err)
+			}
+		})
 	t.Run(
 		"Testing invalid ipv4 with CIDR /24",
 		func(t *testing.T) {
@@ -73,3 +85,188 @@ func TestIPMultipleMatch_ipv6(t *testing.T) {
 			}
 		})
 }
+
+func TestEffectiveRoles(t *testing.T) {
+	assignments := map[string][]string{
+		"alice":          {"dev"},
+		"group:ops":      {"prod-web", "dev"},
+		"group:dba":      {"prod-db", "prod-web"},
+		"group:no-roles": {},
+	}
+	rolesFor := func(subject string) []string {
+		return assignments[subject]
+	}
+
+	t.Run(
+		"User without groups",
+		func(t *testing.T) {
+			roles := EffectiveRoles(rolesFor, "alice", nil)
+			if len(roles) != 1 || roles[0] != "dev" {
+				t.Fatalf("EffectiveRoles: check fail without groups (%v)", roles)
+			}
+		})
+	t.Run(
+		"User in multiple groups with overlapping roles",
+		func(t *testing.T) {
+			roles := EffectiveRoles(rolesFor, "alice", []string{"ops", "dba", "no-roles"})
+			expected := []string{"dev", "prod-web", "prod-db"}
+			if len(roles) != len(expected) {
+				t.Fatalf("EffectiveRoles: check fail with overlapping roles (%v)", roles)
+			}
+			for i := range expected {
+				if roles[i] != expected[i] {
+					t.Fatalf("EffectiveRoles: check fail with overlapping roles (%v)", roles)
+				}
+			}
+		})
+	t.Run(
+		"User only authorized by group",
+		func(t *testing.T) {
+			roles := EffectiveRoles(rolesFor, "bob", []string{"dba"})
+			if len(roles) != 2 || roles[0] != "prod-db" {
+				t.Fatalf("EffectiveRoles: check fail with group roles (%v)", roles)
+			}
+		})
+	t.Run(
+		"Group subject",
+		func(t *testing.T) {
+			if !IsGroupSubject(GroupSubject("ops")) || IsGroupSubject("alice") {
+				t.Fatalf("EffectiveRoles: check fail with group subject")
+			}
+		})
+}
+
+func TestExplain(t *testing.T) {
+	policy := []string{"prod-web", ".", "192.0.2.0/24", "198.51.100.0/24", "permit-pty"}
+	sshOnly := []string{"prod-web", ".", "192.0.2.0/24", "198.51.100.0/24", "permit-pty", "22;2222"}
+	tests := []struct {
+		name       string
+		policy     []string
+		remoteUser string
+		sourceIP   string
+		targetIP   string
+		destPort   string
+		allowed    bool
+		reason     string
+	}{
+		{"Allow same user", policy, "alice", "192.0.2.10", "198.51.100.10", "", true, ""},
+		{"Allow any remote user", []string{"prod-web", "*", "192.0.2.0/24", "198.51.100.0/24", "*"}, "root", "192.0.2.10", "198.51.100.10", "", true, ""},
+		{"Deny another remote user", policy, "root", "192.0.2.10", "198.51.100.10", "", false, DenyRemoteUser},
+		{"Deny source ip", policy, "alice", "203.0.113.10", "198.51.100.10", "", false, DenySourceIP},
+		{"Deny invalid source ip", policy, "alice", "not-an-ip", "198.51.100.10", "", false, DenySourceIP},
+		{"Deny remote host", policy, "alice", "192.0.2.10", "203.0.113.10", "", false, DenyTargetIP},
+		{"Deny actions", []string{"prod-web", ".", "192.0.2.0/24", "198.51.100.0/24", "no-pty"}, "alice", "192.0.2.10", "198.51.100.10", "", false, DenyActions},
+		{"Deny invalid role", []string{"prod-web"}, "alice", "192.0.2.10", "198.51.100.10", "", false, DenyInvalidRole},
+		{"Allow any port without constraint", policy, "alice", "192.0.2.10", "198.51.100.10", "5432", true, ""},
+		{"Allow constrained port", sshOnly, "alice", "192.0.2.10", "198.51.100.10", "2222", true, ""},
+		{"Allow default port", sshOnly, "alice", "192.0.2.10", "198.51.100.10", "", true, ""},
+		{"Deny port out of constraint", sshOnly, "alice", "192.0.2.10", "198.51.100.10", "5432", false, DenyDestPort},
+		{"Deny invalid port", sshOnly, "alice", "192.0.2.10", "198.51.100.10", "ssh", false, DenyDestPort},
+		{"Allow plain IP addresses", []string{"prod-web", ".", "192.0.2.10", "198.51.100.10", "permit-pty"}, "alice", "192.0.2.10", "198.51.100.10", "", true, ""},
+		{"Deny another plain IP address", []string{"prod-web", ".", "192.0.2.10", "198.51.100.10", "permit-pty"}, "alice", "192.0.2.10", "198.51.100.11", "", false, DenyTargetIP},
+		{"Allow any port with wildcard", []string{"prod-web", ".", "192.0.2.0/24", "198.51.100.0/24", "permit-pty", "*"}, "alice", "192.0.2.10", "198.51.100.10", "5432", true, ""},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				allowed, reason := Explain(test.policy, test.remoteUser, test.sourceIP, test.targetIP, test.destPort, "permit-pty", "alice")
+				if allowed != test.allowed || reason != test.reason {
+					t.Fatalf("Explain: check fail (%v, %s)", allowed, reason)
+				}
+			})
+	}
+}
+
+func TestParseDestPorts(t *testing.T) {
+	t.Run(
+		"Valid ports",
+		func(t *testing.T) {
+			for input, expected := range map[string]string{"": "", "*": "", "22": "22", "22; 02222": "22;2222"} {
+				ports, err := ParseDestPorts(input)
+				if err != nil || ports != expected {
+					t.Fatalf("ParseDestPorts: check fail with %q (%q, %v)", input, ports, err)
+				}
+			}
+		})
+	t.Run(
+		"Invalid ports",
+		func(t *testing.T) {
+			for _, input := range []string{"0", "65536", "ssh", "22;", "22-80"} {
+				if _, err := ParseDestPorts(input); err == nil {
+					t.Fatalf("ParseDestPorts: check fail with %q", input)
+				}
+			}
+		})
+}
+
+func TestExplainBreakGlass(t *testing.T) {
+	policy := []string{"emergency", ".", "192.0.2.0/24", "198.51.100.0/24", "permit-pty"}
+	t.Run(
+		"Ignore source ip and remote host",
+		func(t *testing.T) {
+			allowed, reason := ExplainBreakGlass(policy, "alice", "permit-pty", "alice")
+			if !allowed || reason != "" {
+				t.Fatalf("ExplainBreakGlass: check fail ignoring ip restrictions (%v, %s)", allowed, reason)
+			}
+		})
+	t.Run(
+		"Deny another remote user",
+		func(t *testing.T) {
+			allowed, reason := ExplainBreakGlass(policy, "root", "permit-pty", "alice")
+			if allowed || reason != DenyRemoteUser {
+				t.Fatalf("ExplainBreakGlass: check fail with another remote user (%v, %s)", allowed, reason)
+			}
+		})
+	t.Run(
+		"Deny actions",
+		func(t *testing.T) {
+			allowed, reason := ExplainBreakGlass(policy, "alice", "no-pty", "alice")
+			if allowed || reason != DenyActions {
+				t.Fatalf("ExplainBreakGlass: check fail with actions (%v, %s)", allowed, reason)
+			}
+		})
+}
+
+func TestDecide(t *testing.T) {
+	policies := map[string][]string{
+		"prod-web": {"prod-web", ".", "192.0.2.0/24", "198.51.100.0/24", "permit-pty"},
+		"prod-db":  {"prod-db", "postgres", "192.0.2.0/24", "203.0.113.0/24", "permit-pty"},
+	}
+	policyFor := func(role string) []string { return policies[role] }
+	t.Run(
+		"Explain each role",
+		func(t *testing.T) {
+			decisions := Decide([]string{"prod-web", "prod-db", "removed"}, policyFor, "alice", "203.0.113.10", "198.51.100.10", "", "permit-pty", "alice")
+			expected := []Decision{
+				{Role: "prod-web", Allowed: false, Reason: DenySourceIP},
+				{Role: "prod-db", Allowed: false, Reason: DenyRemoteUser},
+				{Role: "removed", Allowed: false, Reason: DenyInvalidRole},
+			}
+			if len(decisions) != len(expected) {
+				t.Fatalf("Decide: check fail with decisions (%v)", decisions)
+			}
+			for i := range expected {
+				if decisions[i] != expected[i] {
+					t.Fatalf("Decide: check fail with decision %d (%v)", i, decisions[i])
+				}
+			}
+		})
+	t.Run(
+		"Plain IP address role",
+		func(t *testing.T) {
+			plainIP := func(role string) []string { return []string{role, ".", "192.0.2.10", "198.51.100.10", "permit-pty"} }
+			decisions := Decide([]string{"single-host"}, plainIP, "alice", "192.0.2.10", "203.0.113.10", "", "permit-pty", "alice")
+			if len(decisions) != 1 || decisions[0] != (Decision{Role: "single-host", Allowed: false, Reason: DenyTargetIP}) {
+				t.Fatalf("Decide: check fail with plain IP address role (%v)", decisions)
+			}
+		})
+	t.Run(
+		"Without roles",
+		func(t *testing.T) {
+			decisions := Decide([]string{}, policyFor, "alice", "192.0.2.10", "198.51.100.10", "", "permit-pty", "alice")
+			if len(decisions) != 0 {
+				t.Fatalf("Decide: check fail without roles (%v)", decisions)
+			}
+		})
+}
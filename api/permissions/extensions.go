@@ -0,0 +1,126 @@
+package permissions
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// PortForwardingExtension is the certificate extension that permits port forwarding
+const PortForwardingExtension = "permit-port-forwarding"
+
+// KnownExtensions are the OpenSSH certificate extensions a role can grant
+var KnownExtensions = []string{
+	"permit-X11-forwarding",
+	"permit-agent-forwarding",
+	PortForwardingExtension,
+	"permit-pty",
+	"permit-user-rc",
+}
+
+// DefaultExtensions are granted by roles created without extensions
+var DefaultExtensions = []string{"permit-pty"}
+
+// ParseExtensions validates a list of extensions separated by ";", returning it sorted and
+// without duplicates. Empty means DefaultExtensions.
+func ParseExtensions(extensions string) (string, error) {
+	if extensions == "" {
+		return "", nil
+	}
+	set := map[string]bool{}
+	for _, extension := range strings.Split(extensions, ";") {
+		extension = strings.TrimSpace(extension)
+		if !knownExtension(extension) {
+			return "", errors.New("ParseExtensions: unknown extension " + extension + " (use " + strings.Join(KnownExtensions, ", ") + ")")
+		}
+		set[extension] = true
+	}
+	return joinExtensions(set), nil
+}
+
+// RoleExtensions applies permit and deny to DefaultExtensions, returning the extensions of a
+// role. Deny wins over permit, and a role must grant at least one extension.
+func RoleExtensions(permit []string, deny []string) (string, error) {
+	for _, extension := range append(append([]string{}, permit...), deny...) {
+		if !knownExtension(extension) {
+			return "", errors.New("RoleExtensions: unknown extension " + extension + " (use " + strings.Join(KnownExtensions, ", ") + ")")
+		}
+	}
+	set := map[string]bool{}
+	for _, extension := range DefaultExtensions {
+		set[extension] = true
+	}
+	for _, extension := range permit {
+		set[extension] = true
+	}
+	for _, extension := range deny {
+		delete(set, extension)
+	}
+	if len(set) == 0 {
+		return "", errors.New("RoleExtensions: role must permit at least one extension")
+	}
+	return joinExtensions(set), nil
+}
+
+// CertExtensions returns the extensions of a certificate authorized by roles, the union of the
+// extensions granted by each role. extensionsFor returns the extensions granted by a role.
+func CertExtensions(roles []string, extensionsFor func(role string) []string) []string {
+	set := map[string]bool{}
+	for _, role := range roles {
+		for _, extension := range extensionsFor(role) {
+			set[extension] = true
+		}
+	}
+	extensions := []string{}
+	for extension := range set {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// CommonExtensions returns the extensions present in all of sets, sorted. A certificate for
+// several principals has only the extensions granted for every one of them.
+func CommonExtensions(sets [][]string) []string {
+	extensions := []string{}
+	if len(sets) == 0 {
+		return extensions
+	}
+	count := map[string]int{}
+	for _, set := range sets {
+		seen := map[string]bool{}
+		for _, extension := range set {
+			if !seen[extension] {
+				seen[extension] = true
+				count[extension]++
+			}
+		}
+	}
+	for extension, n := range count {
+		if n == len(sets) {
+			extensions = append(extensions, extension)
+		}
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// knownExtension tells whether extension is one of KnownExtensions
+func knownExtension(extension string) bool {
+	for _, known := range KnownExtensions {
+		if extension == known {
+			return true
+		}
+	}
+	return false
+}
+
+// joinExtensions returns extensions at set sorted and separated by ";"
+func joinExtensions(set map[string]bool) string {
+	extensions := []string{}
+	for extension := range set {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	return strings.Join(extensions, ";")
+}
@@ -0,0 +1,96 @@
+package permissions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExtensions(t *testing.T) {
+	t.Run(
+		"Valid extensions",
+		func(t *testing.T) {
+			for input, expected := range map[string]string{
+				"":                                      "",
+				"permit-pty":                            "permit-pty",
+				"permit-user-rc; permit-pty;permit-pty": "permit-pty;permit-user-rc",
+			} {
+				extensions, err := ParseExtensions(input)
+				if err != nil || extensions != expected {
+					t.Fatalf("ParseExtensions: check fail with %q (%q, %v)", input, extensions, err)
+				}
+			}
+		})
+	t.Run(
+		"Unknown extensions",
+		func(t *testing.T) {
+			for _, input := range []string{"pty", "permit-pty;", "force-command", "permit-x11-forwarding"} {
+				if _, err := ParseExtensions(input); err == nil {
+					t.Fatalf("ParseExtensions: check fail with %q", input)
+				}
+			}
+		})
+}
+
+func TestRoleExtensions(t *testing.T) {
+	t.Run(
+		"Default baseline",
+		func(t *testing.T) {
+			extensions, err := RoleExtensions(nil, nil)
+			if err != nil || extensions != strings.Join(DefaultExtensions, ";") {
+				t.Fatalf("RoleExtensions: check fail with baseline (%q, %v)", extensions, err)
+			}
+		})
+	t.Run(
+		"Permit and deny",
+		func(t *testing.T) {
+			extensions, err := RoleExtensions([]string{"permit-agent-forwarding", PortForwardingExtension}, []string{PortForwardingExtension})
+			if err != nil || extensions != "permit-agent-forwarding;permit-pty" {
+				t.Fatalf("RoleExtensions: check fail with permit and deny (%q, %v)", extensions, err)
+			}
+		})
+	t.Run(
+		"Unknown extension",
+		func(t *testing.T) {
+			if _, err := RoleExtensions(nil, []string{"permit-everything"}); err == nil {
+				t.Fatalf("RoleExtensions: check fail with unknown extension")
+			}
+		})
+	t.Run(
+		"No extension left",
+		func(t *testing.T) {
+			if _, err := RoleExtensions(nil, []string{"permit-pty"}); err == nil {
+				t.Fatalf("RoleExtensions: check fail without extensions")
+			}
+		})
+}
+
+func TestCertExtensions(t *testing.T) {
+	granted := map[string][]string{
+		"ops":    {"permit-agent-forwarding", "permit-pty"},
+		"tunnel": {PortForwardingExtension},
+	}
+	extensionsFor := func(role string) []string { return granted[role] }
+
+	extensions := CertExtensions([]string{"ops", "tunnel"}, extensionsFor)
+	if strings.Join(extensions, ";") != "permit-agent-forwarding;permit-port-forwarding;permit-pty" {
+		t.Fatalf("CertExtensions: check fail with union of roles (%v)", extensions)
+	}
+	extensions = CertExtensions([]string{"tunnel"}, extensionsFor)
+	if strings.Join(extensions, ";") != PortForwardingExtension {
+		t.Fatalf("CertExtensions: check fail with one role (%v)", extensions)
+	}
+}
+
+func TestCommonExtensions(t *testing.T) {
+	extensions := CommonExtensions([][]string{
+		{"permit-agent-forwarding", "permit-pty"},
+		{"permit-pty", PortForwardingExtension},
+	})
+	if strings.Join(extensions, ";") != "permit-pty" {
+		t.Fatalf("CommonExtensions: check fail with intersection (%v)", extensions)
+	}
+	extensions = CommonExtensions([][]string{{"permit-agent-forwarding"}, {"permit-pty"}})
+	if len(extensions) != 0 {
+		t.Fatalf("CommonExtensions: check fail without common extensions (%v)", extensions)
+	}
+}
@@ -0,0 +1,55 @@
+package permissions
+
+import (
+	"errors"
+	"sort"
+)
+
+// RolePrincipals returns the principals a role policy grants to a certificate of remoteUser,
+// requested by currentUser. Roles allowing any user ("*") or remoteUser itself grant remoteUser,
+// roles allowing the user's own login (".") grant currentUser. Others grant nothing.
+func RolePrincipals(policy []string, remoteUser string, currentUser string) []string {
+	if len(policy) < 2 || remoteUser == "" || !remoteUserMatch(policy[1], remoteUser, currentUser) {
+		return nil
+	}
+	return []string{remoteUser}
+}
+
+// CertPrincipals returns the principals of a certificate authorized by roles, the union of the
+// principals granted by each role. policyFor returns the policy of a role, nil if not found.
+func CertPrincipals(roles []string, policyFor func(role string) []string, remoteUser string, currentUser string) []string {
+	set := map[string]bool{}
+	for _, role := range roles {
+		for _, principal := range RolePrincipals(policyFor(role), remoteUser, currentUser) {
+			set[principal] = true
+		}
+	}
+	principals := []string{}
+	for principal := range set {
+		principals = append(principals, principal)
+	}
+	sort.Strings(principals)
+	return principals
+}
+
+// AuthorizePrincipals returns the roles authorizing each of principals, checked by authorize.
+// A certificate is issued for all principals or none, so it fails with the first principal
+// no role authorizes, returned as denied.
+func AuthorizePrincipals(principals []string, roles []string, authorize func(role string, principal string) (bool, error)) (map[string][]string, string, error) {
+	grants := map[string][]string{}
+	for _, principal := range principals {
+		for _, role := range roles {
+			result, err := authorize(role, principal)
+			if err != nil {
+				return nil, "", err
+			}
+			if result {
+				grants[principal] = append(grants[principal], role)
+			}
+		}
+		if len(grants[principal]) == 0 {
+			return nil, principal, errors.New("AuthorizePrincipals: no role authorizes principal " + principal)
+		}
+	}
+	return grants, "", nil
+}
@@ -0,0 +1,101 @@
+package permissions
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRolePrincipals(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		policy     []string
+		remoteUser string
+		expected   []string
+	}{
+		{"Any user", []string{"ops", "*", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"}, "root", []string{"root"}},
+		{"Literal user", []string{"dba", "postgres", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"}, "postgres", []string{"postgres"}},
+		{"Other literal user", []string{"dba", "postgres", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"}, "root", nil},
+		{"Own login", []string{"dev", ".", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"}, "alice", []string{"alice"}},
+		{"Other login", []string{"dev", ".", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"}, "root", nil},
+		{"Empty remote user", []string{"ops", "*", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"}, "", nil},
+		{"Missing policy", nil, "root", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			principals := RolePrincipals(test.policy, test.remoteUser, "alice")
+			if !reflect.DeepEqual(principals, test.expected) {
+				t.Fatalf("RolePrincipals: check fail with %v (%v)", test.policy, principals)
+			}
+		})
+	}
+}
+
+func TestCertPrincipals(t *testing.T) {
+	policies := map[string][]string{
+		"ops": {"ops", "*", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"},
+		"dba": {"dba", "postgres", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"},
+		"dev": {"dev", ".", "0.0.0.0/0", "0.0.0.0/0", "permit-pty"},
+	}
+	policyFor := func(role string) []string { return policies[role] }
+
+	t.Run(
+		"Union of roles",
+		func(t *testing.T) {
+			principals := CertPrincipals([]string{"ops", "dba"}, policyFor, "postgres", "alice")
+			if !reflect.DeepEqual(principals, []string{"postgres"}) {
+				t.Fatalf("CertPrincipals: check fail with union of roles (%v)", principals)
+			}
+		})
+	t.Run(
+		"No role grants",
+		func(t *testing.T) {
+			principals := CertPrincipals([]string{"dba", "dev", "unknown"}, policyFor, "root", "alice")
+			if len(principals) != 0 {
+				t.Fatalf("CertPrincipals: check fail without grants (%v)", principals)
+			}
+		})
+}
+
+func TestAuthorizePrincipals(t *testing.T) {
+	allowed := map[string][]string{
+		"ops": {"root", "deploy"},
+		"dba": {"postgres"},
+	}
+	authorize := func(role string, principal string) (bool, error) {
+		for _, p := range allowed[role] {
+			if p == principal {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	t.Run(
+		"All allowed",
+		func(t *testing.T) {
+			grants, denied, err := AuthorizePrincipals([]string{"deploy", "postgres"}, []string{"ops", "dba"}, authorize)
+			if err != nil || denied != "" {
+				t.Fatalf("AuthorizePrincipals: check fail with all allowed (%v)", err)
+			}
+			expected := map[string][]string{"deploy": {"ops"}, "postgres": {"dba"}}
+			if !reflect.DeepEqual(grants, expected) {
+				t.Fatalf("AuthorizePrincipals: check fail with grants (%v)", grants)
+			}
+		})
+	t.Run(
+		"Partially denied",
+		func(t *testing.T) {
+			grants, denied, err := AuthorizePrincipals([]string{"deploy", "www-data", "postgres"}, []string{"ops", "dba"}, authorize)
+			if err == nil || denied != "www-data" || grants != nil {
+				t.Fatalf("AuthorizePrincipals: check fail with denied principal (%v, %v)", denied, grants)
+			}
+		})
+	t.Run(
+		"Enforcer error",
+		func(t *testing.T) {
+			failing := func(role string, principal string) (bool, error) { return false, errors.New("enforcer down") }
+			if _, denied, err := AuthorizePrincipals([]string{"root"}, []string{"ops"}, failing); err == nil || denied != "" {
+				t.Fatalf("AuthorizePrincipals: check fail with enforcer error (%v)", err)
+			}
+		})
+}
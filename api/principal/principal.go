@@ -0,0 +1,119 @@
+package principal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Placeholders accepted by templates: the identity as authenticated, and the local part and
+// domain of an email identity (an identity without "@" has empty domain)
+const (
+	Identity = "{identity}"
+	Local    = "{local}"
+	Domain   = "{domain}"
+)
+
+// DefaultTemplate keeps the authenticated identity as principal
+const DefaultTemplate = Identity
+
+// Case foldings of principals (principal_case), Unix usernames are case-sensitive
+const (
+	CasePreserve = "preserve"
+	CaseLower    = "lower"
+	CaseUpper    = "upper"
+)
+
+var (
+	placeholderRe = regexp.MustCompile(`{[^{}]*}`)
+
+	// ErrEmptyPrincipal is returned when a transformation results in an empty principal
+	ErrEmptyPrincipal = errors.New("principal: transformation results in empty principal")
+)
+
+// Transformer maps authenticated identities to certificate principals (unix usernames)
+type Transformer struct {
+	template    string
+	re          *regexp.Regexp
+	replacement string
+	caseFolding string
+}
+
+// New returns a Transformer applying template, then replacing pattern (if set) by replacement,
+// and folding the case of the result (see Fold). It returns an error on invalid template, pattern
+// or case folding.
+func New(template string, pattern string, replacement string, caseFolding string) (*Transformer, error) {
+	if template == "" {
+		template = DefaultTemplate
+	}
+	if err := CheckCase(caseFolding); err != nil {
+		return nil, err
+	}
+	for _, placeholder := range placeholderRe.FindAllString(template, -1) {
+		if placeholder != Identity && placeholder != Local && placeholder != Domain {
+			return nil, fmt.Errorf("principal: unknown placeholder %s in template %q", placeholder, template)
+		}
+	}
+	if strings.ContainsAny(placeholderRe.ReplaceAllString(template, ""), "{}") {
+		return nil, fmt.Errorf("principal: unbalanced braces in template %q", template)
+	}
+	t := &Transformer{template: template, replacement: replacement, caseFolding: caseFolding}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("principal: invalid pattern %q (%v)", pattern, err)
+		}
+		t.re = re
+	}
+	return t, nil
+}
+
+// Apply returns the principal for identity
+func (t *Transformer) Apply(identity string) (string, error) {
+	local, domain := identity, ""
+	if at := strings.LastIndex(identity, "@"); at >= 0 {
+		local, domain = identity[:at], identity[at+1:]
+	}
+	principal := strings.NewReplacer(Identity, identity, Local, local, Domain, domain).Replace(t.template)
+	if t.re != nil {
+		principal = t.re.ReplaceAllString(principal, t.replacement)
+	}
+	principal = Fold(principal, t.caseFolding)
+	if principal == "" {
+		return "", ErrEmptyPrincipal
+	}
+	if strings.ContainsAny(principal, " \t\r\n,") {
+		return "", fmt.Errorf("principal: invalid principal %q for identity %q", principal, identity)
+	}
+	return principal, nil
+}
+
+// CheckCase returns an error if caseFolding is not lower, upper, preserve or empty (preserve)
+func CheckCase(caseFolding string) error {
+	switch caseFolding {
+	case "", CasePreserve, CaseLower, CaseUpper:
+		return nil
+	}
+	return fmt.Errorf("principal: case folding must be %s, %s or %s (%q)", CaseLower, CaseUpper, CasePreserve, caseFolding)
+}
+
+// Fold returns principal in lower or upper case, as caseFolding. Other values preserve it.
+func Fold(principal string, caseFolding string) string {
+	switch caseFolding {
+	case CaseLower:
+		return strings.ToLower(principal)
+	case CaseUpper:
+		return strings.ToUpper(principal)
+	}
+	return principal
+}
+
+// EffectiveCase returns the case folding of principal_case, or lower for configurations setting only
+// principal_lowercase, that principal_case replaced
+func EffectiveCase(caseFolding string, lowercase bool) string {
+	if caseFolding == "" && lowercase {
+		return CaseLower
+	}
+	return caseFolding
+}
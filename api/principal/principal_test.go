@@ -0,0 +1,110 @@
+package principal
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	t.Run(
+		"Default template",
+		func(t *testing.T) {
+			if _, err := New("", "", "", ""); err != nil {
+				t.Fatalf("New: check fail with empty template (%v)", err)
+			}
+		})
+	t.Run(
+		"Unknown placeholder",
+		func(t *testing.T) {
+			if _, err := New("{local-part}", "", "", ""); err == nil {
+				t.Fatalf("New: check fail with unknown placeholder")
+			}
+		})
+	t.Run(
+		"Unbalanced braces",
+		func(t *testing.T) {
+			if _, err := New("{local", "", "", ""); err == nil {
+				t.Fatalf("New: check fail with unbalanced braces")
+			}
+		})
+	t.Run(
+		"Invalid pattern",
+		func(t *testing.T) {
+			if _, err := New(Local, "[a-", "", ""); err == nil {
+				t.Fatalf("New: check fail with invalid pattern")
+			}
+		})
+}
+
+func TestFold(t *testing.T) {
+	t.Run(
+		"Mixed case identities",
+		func(t *testing.T) {
+			for caseFolding, expected := range map[string]string{CaseLower: "john.doe", CaseUpper: "JOHN.DOE", CasePreserve: "John.Doe", "": "John.Doe"} {
+				if folded := Fold("John.Doe", caseFolding); folded != expected {
+					t.Fatalf("Fold: check fail with %q (%s)", caseFolding, folded)
+				}
+			}
+		})
+	t.Run(
+		"Case foldings",
+		func(t *testing.T) {
+			if err := CheckCase("title"); err == nil {
+				t.Fatalf("CheckCase: check fail with unknown case folding")
+			}
+			if _, err := New("", "", "", "Lower"); err == nil {
+				t.Fatalf("New: check fail with unknown case folding")
+			}
+			if EffectiveCase("", true) != CaseLower || EffectiveCase(CasePreserve, true) != CasePreserve || EffectiveCase("", false) != "" {
+				t.Fatalf("EffectiveCase: check fail with principal_lowercase")
+			}
+		})
+}
+
+func TestApply(t *testing.T) {
+	cases := []struct {
+		name        string
+		template    string
+		pattern     string
+		replacement string
+		caseFolding string
+		identity    string
+		principal   string
+		fail        bool
+	}{
+		{name: "Default keeps identity", identity: "alice", principal: "alice"},
+		{name: "Local part of email", template: Local, identity: "alice@example.org", principal: "alice"},
+		{name: "Local part with dots", template: Local, identity: "alice.smith@example.org", principal: "alice.smith"},
+		{name: "Local part with plus", template: Local, pattern: `\+.*$`, identity: "alice+ops@example.org", principal: "alice"},
+		{name: "Dots replaced", template: Local, pattern: `\.`, replacement: "_", identity: "alice.b.smith@example.org", principal: "alice_b_smith"},
+		{name: "Prefix and lowercase", template: "ext-" + Local, caseFolding: CaseLower, identity: "Alice@Example.org", principal: "ext-alice"},
+		{name: "Mixed case lower", template: Local, caseFolding: CaseLower, identity: "John.Doe@example.org", principal: "john.doe"},
+		{name: "Mixed case upper", template: Local, caseFolding: CaseUpper, identity: "John.Doe@example.org", principal: "JOHN.DOE"},
+		{name: "Mixed case preserved", template: Local, caseFolding: CasePreserve, identity: "John.Doe@example.org", principal: "John.Doe"},
+		{name: "Domain", template: Local + "." + Domain, identity: "alice@example.org", principal: "alice.example.org"},
+		{name: "Identity without at", template: Local, identity: "alice", principal: "alice"},
+		{name: "Quoted at in local part", template: Local, identity: `"a@b"@example.org`, principal: `"a@b"`},
+		{name: "Capture groups", template: Identity, pattern: `^(\w+)@corp\.example$`, replacement: "corp_$1", identity: "bob@corp.example", principal: "corp_bob"},
+		{name: "Empty result", template: Local, identity: "@example.org", fail: true},
+		{name: "Whitespace result", template: Identity, identity: "alice smith", fail: true},
+		{name: "Comma result", template: Identity, identity: "alice,root", fail: true},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(
+			tc.name,
+			func(t *testing.T) {
+				transformer, err := New(tc.template, tc.pattern, tc.replacement, tc.caseFolding)
+				if err != nil {
+					t.Fatalf("Apply: fail creating transformer (%v)", err)
+				}
+				principal, err := transformer.Apply(tc.identity)
+				if tc.fail {
+					if err == nil {
+						t.Fatalf("Apply: check fail, expected error for %q (got %q)", tc.identity, principal)
+					}
+					return
+				}
+				if err != nil || principal != tc.principal {
+					t.Fatalf("Apply: check fail for %q (expected %q, got %q, %v)", tc.identity, tc.principal, principal, err)
+				}
+			})
+	}
+}
@@ -0,0 +1,70 @@
+package selftest
+
+import (
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+// now is the clock used to time stages, replaced at tests
+var now = time.Now
+
+// Stage is a named check of the certificate issuing path. Run returns details about what was
+// checked, or an error if the stage failed.
+type Stage struct {
+	Name string
+	Run  func() (string, error)
+}
+
+// Run executes every stage in order, timing each one. A failing stage does not stop the next
+// ones, so a single report shows every broken dependency.
+func Run(stages []Stage) types.SelfTestReport {
+	report := types.SelfTestReport{Result: types.SelfTestSuccess, Stages: []types.SelfTestStage{}}
+	start := now()
+	for _, stage := range stages {
+		report.Stages = append(report.Stages, runStage(stage))
+		if report.Stages[len(report.Stages)-1].Result != types.SelfTestSuccess {
+			report.Result = types.SelfTestFail
+		}
+	}
+	report.Duration = milliseconds(now().Sub(start))
+	return report
+}
+
+// runStage executes a stage, a panic is reported as a failure instead of aborting the report
+func runStage(stage Stage) (result types.SelfTestStage) {
+	result = types.SelfTestStage{Name: stage.Name}
+	start := now()
+	defer func() {
+		if r := recover(); r != nil {
+			result.Result = types.SelfTestFail
+			result.Details = "panic: " + toString(r)
+			result.Duration = milliseconds(now().Sub(start))
+		}
+	}()
+	details, err := stage.Run()
+	result.Duration = milliseconds(now().Sub(start))
+	if err != nil {
+		result.Result = types.SelfTestFail
+		result.Details = err.Error()
+		return result
+	}
+	result.Result = types.SelfTestSuccess
+	result.Details = details
+	return result
+}
+
+func milliseconds(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func toString(v interface{}) string {
+	switch value := v.(type) {
+	case error:
+		return value.Error()
+	case string:
+		return value
+	default:
+		return "unknown error"
+	}
+}
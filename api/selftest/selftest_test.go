@@ -0,0 +1,87 @@
+package selftest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+// fakeClock advances step at every call
+func fakeClock(step time.Duration) func() time.Time {
+	current := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		current = current.Add(step)
+		return current
+	}
+}
+
+func TestRun(t *testing.T) {
+	defer func() { now = time.Now }()
+
+	t.Run(
+		"All stages succeed",
+		func(t *testing.T) {
+			now = fakeClock(10 * time.Millisecond)
+			report := Run([]Stage{
+				{Name: "oidc", Run: func() (string, error) { return "keys loaded", nil }},
+				{Name: "signing", Run: func() (string, error) { return "signed", nil }},
+			})
+			if report.Result != types.SelfTestSuccess {
+				t.Fatalf("Run: check fail result (%v)", report.Result)
+			}
+			if len(report.Stages) != 2 || report.Stages[0].Name != "oidc" || report.Stages[1].Name != "signing" {
+				t.Fatalf("Run: check fail stages order (%v)", report.Stages)
+			}
+			if report.Stages[0].Details != "keys loaded" || report.Stages[0].Duration != 10 {
+				t.Fatalf("Run: check fail stage (%v)", report.Stages[0])
+			}
+			// start and end of report surround 2 stages, each one reading the clock twice
+			if report.Duration != 50 {
+				t.Fatalf("Run: check fail report duration (%v)", report.Duration)
+			}
+		})
+
+	t.Run(
+		"Failing stage fails report and next stages still run",
+		func(t *testing.T) {
+			now = fakeClock(time.Millisecond)
+			ran := false
+			report := Run([]Stage{
+				{Name: "token", Run: func() (string, error) { return "", errors.New("expired") }},
+				{Name: "signing", Run: func() (string, error) { ran = true; return "signed", nil }},
+			})
+			if report.Result != types.SelfTestFail {
+				t.Fatalf("Run: check fail result (%v)", report.Result)
+			}
+			if report.Stages[0].Result != types.SelfTestFail || report.Stages[0].Details != "expired" {
+				t.Fatalf("Run: check fail failed stage (%v)", report.Stages[0])
+			}
+			if !ran || report.Stages[1].Result != types.SelfTestSuccess {
+				t.Fatalf("Run: check fail stage after failure (%v)", report.Stages[1])
+			}
+		})
+
+	t.Run(
+		"Panicking stage is reported",
+		func(t *testing.T) {
+			now = fakeClock(time.Millisecond)
+			report := Run([]Stage{
+				{Name: "authorization", Run: func() (string, error) { panic("nil enforcer") }},
+			})
+			if report.Result != types.SelfTestFail || report.Stages[0].Details != "panic: nil enforcer" {
+				t.Fatalf("Run: check fail panic (%v)", report)
+			}
+		})
+
+	t.Run(
+		"No stages",
+		func(t *testing.T) {
+			now = fakeClock(time.Millisecond)
+			report := Run(nil)
+			if report.Result != types.SelfTestSuccess || len(report.Stages) != 0 {
+				t.Fatalf("Run: check fail empty report (%v)", report)
+			}
+		})
+}
@@ -0,0 +1,56 @@
+package signlimit
+
+import "time"
+
+// Limiter bounds how many certificates are signed at the same time, protecting the signer (as
+// Vault) from bursts of requests. Requests above the limit wait up to timeout for a slot.
+// A nil Limiter does not limit.
+type Limiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// New returns a Limiter of size concurrent signatures, or nil (no limit) when size is not positive
+func New(size int, timeout time.Duration) *Limiter {
+	if size <= 0 {
+		return nil
+	}
+	return &Limiter{slots: make(chan struct{}, size), timeout: timeout}
+}
+
+// Acquire takes a slot, waiting up to the timeout of l. It returns false when no slot was
+// freed in time, then Release must not be called.
+func (l *Limiter) Acquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// RetryAfter is the suggested wait, in whole seconds, before retrying a request refused by l
+func (l *Limiter) RetryAfter() int {
+	if l == nil || l.timeout < time.Second {
+		return 1
+	}
+	return int(l.timeout / time.Second)
+}
@@ -0,0 +1,97 @@
+package signlimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run(
+		"Without limit",
+		func(t *testing.T) {
+			l := New(0, time.Millisecond)
+			if l != nil {
+				t.Fatalf("New: check fail without limit (%v)", l)
+			}
+			for i := 0; i < 100; i++ {
+				if !l.Acquire() {
+					t.Fatalf("Acquire: check fail without limit")
+				}
+			}
+			l.Release()
+		})
+	t.Run(
+		"Cap is enforced and excess requests time out",
+		func(t *testing.T) {
+			l := New(2, 50*time.Millisecond)
+			release := make(chan struct{})
+			var running, maxRunning, refused int32
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if !l.Acquire() {
+						atomic.AddInt32(&refused, 1)
+						return
+					}
+					defer l.Release()
+					current := atomic.AddInt32(&running, 1)
+					for {
+						max := atomic.LoadInt32(&maxRunning)
+						if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+							break
+						}
+					}
+					<-release
+					atomic.AddInt32(&running, -1)
+				}()
+			}
+			// signatures hold their slots longer than the timeout of waiting requests
+			time.Sleep(200 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			if maxRunning != 2 || refused != 3 {
+				t.Fatalf("Limiter: check fail with cap 2 (max running %d, refused %d)", maxRunning, refused)
+			}
+		})
+	t.Run(
+		"Waiting request gets a released slot",
+		func(t *testing.T) {
+			l := New(1, time.Second)
+			if !l.Acquire() {
+				t.Fatalf("Acquire: check fail with free slot")
+			}
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				l.Release()
+			}()
+			if !l.Acquire() {
+				t.Fatalf("Acquire: check fail waiting for released slot")
+			}
+			l.Release()
+		})
+	t.Run(
+		"Retry after",
+		func(t *testing.T) {
+			if got := New(1, 5*time.Second).RetryAfter(); got != 5 {
+				t.Fatalf("RetryAfter: check fail (%d)", got)
+			}
+			if got := New(1, 100*time.Millisecond).RetryAfter(); got != 1 {
+				t.Fatalf("RetryAfter: check fail below a second (%d)", got)
+			}
+		})
+}
+
+func BenchmarkLimiter(b *testing.B) {
+	l := New(4, time.Second)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if l.Acquire() {
+				l.Release()
+			}
+		}
+	})
+}
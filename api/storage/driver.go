@@ -18,40 +18,70 @@ func Init(config viper.Viper) (*gorm.DB, error) {
 
 	// Configure for MYSQL using gorm
 	if config.GetString("storage_driver") == "mysql" {
-		// Connecting to the Database
-		db, err := gorm.Open("mysql", config.GetString("storage_uri"))
+		db, err := open(config, config.GetString("storage_uri"))
 		if err != nil {
-			log.Println(err)
+			return nil, err
 		}
-		// Trying to reconnect without database until maxAttempts
-		var dbError error
-		maxAttempts := config.GetInt("storage_max_attempts")
-		for attempts := 1; attempts <= maxAttempts; attempts++ {
-			dbError = db.DB().Ping()
-			if dbError == nil {
-				break
-			}
-			log.Println(dbError)
-			time.Sleep(time.Duration(attempts) * time.Second)
-		}
-		if dbError != nil {
-			log.Fatal(dbError)
-		}
-		// disabling NO_ZERO_DATE mode
-		_, err = db.DB().Exec("SET SESSION sql_mode = 'ONLY_FULL_GROUP_BY,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION';")
-		if err != nil {
-			return nil, errors.New("Storage driver: mysql: error setting sql_mode (" + err.Error() + ")")
-		}
-		db.DB().SetMaxOpenConns(config.GetInt("storage_max_connections"))
 		db.AutoMigrate(
 			&types.AuditRecord{},
+			&types.AuditExport{},
 			&types.CertRequest{},
+			&types.CertApproval{},
+			&types.CertChallenge{},
+			&types.RoleExtensions{},
+			&types.DeletedRole{},
+			&types.CertQuota{},
 		)
-		if config.GetBool("storage_debug") {
-			db.LogMode(true)
-		}
 		return db, nil
 	}
 
 	return nil, errors.New("init: Storage driver not found")
 }
+
+// InitReplica prepare the read-only replica used by list and audit queries. When
+// storage_uri_replica is not set, primary is returned and used for reads too.
+func InitReplica(config viper.Viper, primary *gorm.DB) (*gorm.DB, error) {
+	if len(config.GetString("storage_uri_replica")) == 0 {
+		return primary, nil
+	}
+
+	// Configure for MYSQL using gorm
+	if config.GetString("storage_driver") == "mysql" {
+		return open(config, config.GetString("storage_uri_replica"))
+	}
+
+	return nil, errors.New("initReplica: Storage driver not found")
+}
+
+// open connects to the mysql database at uri, trying until storage_max_attempts
+func open(config viper.Viper, uri string) (*gorm.DB, error) {
+	// Connecting to the Database
+	db, err := gorm.Open("mysql", uri)
+	if err != nil {
+		log.Println(err)
+	}
+	// Trying to reconnect without database until maxAttempts
+	var dbError error
+	maxAttempts := config.GetInt("storage_max_attempts")
+	for attempts := 1; attempts <= maxAttempts; attempts++ {
+		dbError = db.DB().Ping()
+		if dbError == nil {
+			break
+		}
+		log.Println(dbError)
+		time.Sleep(time.Duration(attempts) * time.Second)
+	}
+	if dbError != nil {
+		log.Fatal(dbError)
+	}
+	// disabling NO_ZERO_DATE mode
+	_, err = db.DB().Exec("SET SESSION sql_mode = 'ONLY_FULL_GROUP_BY,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION';")
+	if err != nil {
+		return nil, errors.New("Storage driver: mysql: error setting sql_mode (" + err.Error() + ")")
+	}
+	db.DB().SetMaxOpenConns(config.GetInt("storage_max_connections"))
+	if config.GetBool("storage_debug") {
+		db.LogMode(true)
+	}
+	return db, nil
+}
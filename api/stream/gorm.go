@@ -0,0 +1,36 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"github.com/jinzhu/gorm"
+)
+
+// issuanceKinds are the audit record kinds of issued certificates
+var issuanceKinds = []string{"cert.create", "cert.breakglass"}
+
+// GormSource reads issuance events from GSH database
+type GormSource struct {
+	DB *gorm.DB
+}
+
+// Events returns issuance events after since matching filter, ordered by time
+func (s GormSource) Events(since time.Time, filter Filter) ([]types.IssuanceEvent, error) {
+	query := s.DB.Table("audit_records").
+		Select("audit_records.uid AS audit_uid, audit_records.end_time AS time, audit_records.kind, audit_records.owner, "+
+			"cert_requests.uid AS cert_uid, cert_requests.cert_key_id AS key_id, cert_requests.remote_user, "+
+			"cert_requests.remote_host, cert_requests.user_ip, cert_requests.reason, cert_requests.cert_serial_number AS serial").
+		Joins("JOIN cert_requests ON cert_requests.uid = audit_records.target_uid").
+		Where("audit_records.kind IN (?) AND audit_records.end_time > ? AND audit_records.error = ''", issuanceKinds, since)
+	if filter.User != "" {
+		query = query.Where("audit_records.owner = ?", filter.User)
+	}
+	if filter.Host != "" {
+		query = query.Where("cert_requests.remote_host = ?", filter.Host)
+	}
+
+	var events []types.IssuanceEvent
+	dbc := query.Order("audit_records.end_time").Scan(&events)
+	return events, dbc.Error
+}
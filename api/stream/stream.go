@@ -0,0 +1,79 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"github.com/gofrs/uuid"
+)
+
+// Filter restricts streamed events to an owner and a remote host, empty fields match everything
+type Filter struct {
+	User string
+	Host string
+}
+
+// Source is the interface used to read issuance events
+type Source interface {
+	// Events returns issuance events after since matching filter, ordered by time
+	Events(since time.Time, filter Filter) ([]types.IssuanceEvent, error)
+}
+
+// Tail polls a Source returning each issuance event once. Audit records are written
+// asynchronously, so every poll reads again the Overlap window before the latest event.
+type Tail struct {
+	Source  Source
+	Filter  Filter
+	Overlap time.Duration
+
+	since time.Time
+	last  time.Time
+	seen  map[uuid.UUID]time.Time
+}
+
+// NewTail returns a Tail of events after since
+func NewTail(source Source, filter Filter, since time.Time, overlap time.Duration) *Tail {
+	return &Tail{
+		Source:  source,
+		Filter:  filter,
+		Overlap: overlap,
+		since:   since,
+		last:    since,
+		seen:    map[uuid.UUID]time.Time{},
+	}
+}
+
+// Poll returns events not returned before
+func (t *Tail) Poll() ([]types.IssuanceEvent, error) {
+	from := t.last.Add(-t.Overlap)
+	if from.Before(t.since) {
+		from = t.since
+	}
+	events, err := t.Source.Events(from, t.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	newEvents := []types.IssuanceEvent{}
+	for _, event := range events {
+		if _, ok := t.seen[event.AuditUID]; ok {
+			continue
+		}
+		if !event.Time.After(from) {
+			continue
+		}
+		t.seen[event.AuditUID] = event.Time
+		if event.Time.After(t.last) {
+			t.last = event.Time
+		}
+		newEvents = append(newEvents, event)
+	}
+
+	// events out of the overlap window are never read again
+	for uid, eventTime := range t.seen {
+		if !eventTime.After(t.last.Add(-t.Overlap)) {
+			delete(t.seen, uid)
+		}
+	}
+	return newEvents, nil
+}
@@ -0,0 +1,84 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"github.com/gofrs/uuid"
+)
+
+// memorySource is a Source with events in memory
+type memorySource struct {
+	events []types.IssuanceEvent
+}
+
+func (s *memorySource) Events(since time.Time, filter Filter) ([]types.IssuanceEvent, error) {
+	events := []types.IssuanceEvent{}
+	for _, event := range s.events {
+		if event.Time.After(since) && (filter.User == "" || filter.User == event.Owner) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func newEvent(owner string, eventTime time.Time) types.IssuanceEvent {
+	return types.IssuanceEvent{AuditUID: uuid.Must(uuid.NewV4()), Owner: owner, Time: eventTime}
+}
+
+func TestTailPoll(t *testing.T) {
+	start := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	t.Run(
+		"Events are returned once",
+		func(t *testing.T) {
+			source := &memorySource{events: []types.IssuanceEvent{newEvent("alice", start.Add(time.Second))}}
+			tail := NewTail(source, Filter{}, start, 5*time.Second)
+			events, err := tail.Poll()
+			if err != nil || len(events) != 1 {
+				t.Fatalf("Poll: check fail with first poll (%v, %v)", events, err)
+			}
+			events, err = tail.Poll()
+			if err != nil || len(events) != 0 {
+				t.Fatalf("Poll: check fail returning event twice (%v, %v)", events, err)
+			}
+		})
+	t.Run(
+		"Late audit record",
+		func(t *testing.T) {
+			source := &memorySource{events: []types.IssuanceEvent{newEvent("alice", start.Add(3*time.Second))}}
+			tail := NewTail(source, Filter{}, start, 5*time.Second)
+			if events, _ := tail.Poll(); len(events) != 1 {
+				t.Fatalf("Poll: check fail with first poll (%v)", events)
+			}
+			// written after the previous poll, but ended before the latest event
+			source.events = append(source.events, newEvent("bob", start.Add(2*time.Second)))
+			events, err := tail.Poll()
+			if err != nil || len(events) != 1 || events[0].Owner != "bob" {
+				t.Fatalf("Poll: check fail with late audit record (%v, %v)", events, err)
+			}
+		})
+	t.Run(
+		"Events before since",
+		func(t *testing.T) {
+			source := &memorySource{events: []types.IssuanceEvent{newEvent("alice", start.Add(-time.Second))}}
+			tail := NewTail(source, Filter{}, start, 5*time.Second)
+			events, err := tail.Poll()
+			if err != nil || len(events) != 0 {
+				t.Fatalf("Poll: check fail with event before since (%v, %v)", events, err)
+			}
+		})
+	t.Run(
+		"Filter",
+		func(t *testing.T) {
+			source := &memorySource{events: []types.IssuanceEvent{
+				newEvent("alice", start.Add(time.Second)),
+				newEvent("bob", start.Add(2*time.Second)),
+			}}
+			tail := NewTail(source, Filter{User: "bob"}, start, 5*time.Second)
+			events, err := tail.Poll()
+			if err != nil || len(events) != 1 || events[0].Owner != "bob" {
+				t.Fatalf("Poll: check fail with filter (%v, %v)", events, err)
+			}
+		})
+}
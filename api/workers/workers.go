@@ -1,8 +1,12 @@
 package workers
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/globocom/gsh/api/eventlog"
+	"github.com/globocom/gsh/api/export"
 	"github.com/globocom/gsh/types"
 	"github.com/jinzhu/gorm"
 	"github.com/spf13/viper"
@@ -12,26 +16,68 @@ import (
 type Worker struct{}
 
 // InitWorkers is the function thats starts workers
-func InitWorkers(config viper.Viper, auditChannel *chan types.AuditRecord, logChannel *chan map[string]interface{}, stopChannel *chan bool, db *gorm.DB) {
+func InitWorkers(config viper.Viper, auditChannel *chan types.AuditRecord, logChannel *chan map[string]interface{}, stopChannel *chan bool, db *gorm.DB, replica *gorm.DB) {
+	// issuance and denial events are sent to syslog too, when enabled (checked by config.Check)
+	events := &Events{Stdout: true}
+	if config.GetBool("syslog_enabled") {
+		events.Stdout = config.GetBool("syslog_stdout")
+		events.Severity, _ = eventlog.ParseSeverity(config.GetString("syslog_severity"))
+		events.DenialSeverity, _ = eventlog.ParseSeverity(config.GetString("syslog_denial_severity"))
+		facility, _ := eventlog.ParseFacility(config.GetString("syslog_facility"))
+		syslog, err := eventlog.New(config.GetString("syslog_address"), facility, config.GetDuration("syslog_timeout"), config.GetInt("syslog_queue_size"))
+		if err != nil {
+			fmt.Printf("Syslog error, events are not sent to syslog: %s\n", err.Error())
+		} else {
+			events.Syslog = syslog
+		}
+	}
+
 	workers := config.GetInt("workers_audit")
 	for j := 0; j < workers; j++ {
 		worker := &Worker{}
-		go worker.WriteAudit(auditChannel, stopChannel, db)
+		go worker.WriteAudit(auditChannel, stopChannel, db, events)
 	}
 	workers = config.GetInt("workers_log")
 	for j := 0; j < workers; j++ {
 		worker := &Worker{}
-		go worker.WriteLog(logChannel, stopChannel)
+		go worker.WriteLog(logChannel, stopChannel, events)
 	}
+	if config.GetBool("audit_export_enabled") {
+		exporter := &export.Exporter{
+			Source: export.GormSource{DB: db, Replica: replica},
+			Store: export.S3Store{
+				Endpoint:  config.GetString("audit_export_endpoint"),
+				Bucket:    config.GetString("audit_export_bucket"),
+				Region:    config.GetString("audit_export_region"),
+				AccessKey: config.GetString("audit_export_access_key"),
+				SecretKey: config.GetString("audit_export_secret_key"),
+			},
+			Prefix: config.GetString("audit_export_prefix"),
+			Delay:  config.GetDuration("audit_export_delay"),
+		}
+		worker := &Worker{}
+		go worker.ExportAudit(exporter, config.GetDuration("audit_export_interval"), stopChannel)
+	}
+}
 
+// Events are the destinations of log records and certificate events besides the database
+type Events struct {
+	// Stdout prints log records, it is only disabled to send them to syslog instead
+	Stdout bool
+	// Syslog receives certificate audit records and log records, nil when syslog is not enabled
+	Syslog *eventlog.Syslog
+	// Severity is the syslog severity of events, DenialSeverity the one of denials and failures
+	Severity       int
+	DenialSeverity int
 }
 
 // WriteAudit is the function thats receive AuditRecord from channel auditChannel and handle it
-func (w *Worker) WriteAudit(auditChannel *chan types.AuditRecord, stopChannel *chan bool, db *gorm.DB) {
+func (w *Worker) WriteAudit(auditChannel *chan types.AuditRecord, stopChannel *chan bool, db *gorm.DB, events *Events) {
 	for {
 		select {
 		case auditRecord := <-*auditChannel:
 			db.Create(&auditRecord)
+			events.audit(auditRecord)
 		case <-*stopChannel:
 			return
 		}
@@ -39,11 +85,65 @@ func (w *Worker) WriteAudit(auditChannel *chan types.AuditRecord, stopChannel *c
 }
 
 // WriteLog is the function thats receive map from channel auditRecordChannel and handle it
-func (w *Worker) WriteLog(logChannel *chan map[string]interface{}, stopChannel *chan bool) {
+func (w *Worker) WriteLog(logChannel *chan map[string]interface{}, stopChannel *chan bool, events *Events) {
 	for {
 		select {
 		case logRecord := <-*logChannel:
-			fmt.Printf("%v\n", logRecord)
+			events.log(logRecord)
+		case <-*stopChannel:
+			return
+		}
+	}
+}
+
+// audit sends certificate audit records to syslog, as issuance or denial events
+func (e *Events) audit(auditRecord types.AuditRecord) {
+	if e.Syslog == nil || !eventlog.IsCertEvent(auditRecord) {
+		return
+	}
+	severity := e.Severity
+	if auditRecord.Error != "" {
+		severity = e.DenialSeverity
+	}
+	e.Syslog.Log(severity, auditRecord.Kind, eventlog.CertEvent(auditRecord), time.Now())
+}
+
+// log prints logRecord and sends it to syslog, both as enabled. Failed and denied actions (_result)
+// are sent with the severity of denials.
+func (e *Events) log(logRecord map[string]interface{}) {
+	if e.Stdout {
+		fmt.Printf("%v\n", logRecord)
+	}
+	if e.Syslog == nil {
+		return
+	}
+	severity := e.Severity
+	if result := logRecord["_result"]; result == "fail" || result == "deny" {
+		severity = e.DenialSeverity
+	}
+	msgID, _ := logRecord["_action"].(string)
+	message, err := json.Marshal(logRecord)
+	if err != nil {
+		message = []byte(fmt.Sprintf("%v", logRecord))
+	}
+	e.Syslog.Log(severity, msgID, string(message), time.Now())
+}
+
+// ExportAudit is the function thats periodically exports audit records to object storage
+func (w *Worker) ExportAudit(exporter *export.Exporter, interval time.Duration, stopChannel *chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			auditExport, err := exporter.Export(now)
+			if err != nil {
+				fmt.Printf("Audit export error: %s\n", err.Error())
+				continue
+			}
+			if auditExport.Records > 0 {
+				fmt.Printf("Audit export: %d records exported to %s\n", auditExport.Records, auditExport.ObjectKey)
+			}
 		case <-*stopChannel:
 			return
 		}
@@ -0,0 +1,90 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package approvals
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// PollInterval is the default interval between checks of a certificate request waiting for approval
+const PollInterval = 5 * time.Second
+
+// ErrTimeout is returned when a certificate request is not approved before the timeout
+var ErrTimeout = errors.New("timeout waiting for certificate approval")
+
+// Fetch makes GET /approvals/:id request to GSH API and returns the status code and body
+func Fetch(netClient *http.Client, endpoint string, token string, requestID string) (int, []byte, error) {
+	req, err := http.NewRequest("GET", endpoint+"/approvals/"+requestID, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "JWT "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// Wait polls GSH API every interval until the certificate request is approved and returns the certificate
+// response body. It fails when the request is denied or expired, or when timeout is reached.
+func Wait(netClient *http.Client, endpoint string, token string, requestID string, interval time.Duration, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		statusCode, body, err := Fetch(netClient, endpoint, token, requestID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch statusCode {
+		case http.StatusOK:
+			return body, nil
+		case http.StatusAccepted:
+			// still pending
+		default:
+			return nil, fmt.Errorf("certificate request %s not approved (%d)\n\n%s", requestID, statusCode, body)
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return nil, ErrTimeout
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package approvals
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// mockAPI answers pending until approveAfter requests are made, then answers with status
+func mockAPI(approveAfter int, status int) *httptest.Server {
+	requests := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/approvals/request-id" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if requests <= approveAfter {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"result":"pending"}`))
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"result":"success","certificate":"cert"}`))
+	}))
+}
+
+func TestWait(t *testing.T) {
+	t.Run(
+		"Approved after polling",
+		func(t *testing.T) {
+			server := mockAPI(2, http.StatusOK)
+			defer server.Close()
+			body, err := Wait(server.Client(), server.URL, "token", "request-id", time.Millisecond, time.Second)
+			if err != nil {
+				t.Fatalf("Wait: check fail waiting approval (%v)", err)
+			}
+			if string(body) != `{"result":"success","certificate":"cert"}` {
+				t.Fatalf("Wait: check fail with body (%s)", body)
+			}
+		})
+	t.Run(
+		"Denied after polling",
+		func(t *testing.T) {
+			server := mockAPI(1, http.StatusForbidden)
+			defer server.Close()
+			_, err := Wait(server.Client(), server.URL, "token", "request-id", time.Millisecond, time.Second)
+			if err == nil || err == ErrTimeout {
+				t.Fatalf("Wait: check fail with denied request (%v)", err)
+			}
+		})
+	t.Run(
+		"Timeout",
+		func(t *testing.T) {
+			server := mockAPI(1000, http.StatusOK)
+			defer server.Close()
+			_, err := Wait(server.Client(), server.URL, "token", "request-id", time.Millisecond, 20*time.Millisecond)
+			if err != ErrTimeout {
+				t.Fatalf("Wait: check fail with timeout (%v)", err)
+			}
+		})
+}
@@ -0,0 +1,334 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+)
+
+// auditTailMaxBackoff is the maximum wait before reconnecting to the audit stream
+const auditTailMaxBackoff = 30 * time.Second
+
+// errStreamRefused is returned when GSH API refuses the audit stream, reconnecting won't help
+var errStreamRefused = errors.New("audit stream refused")
+
+// auditTailCmd represents the auditTail command
+var auditTailCmd = &cobra.Command{
+	Use:   "audit-tail",
+	Short: "Watches certificate issuances as they happen",
+	Long: `
+
+Streams certificate issuances from GSH API and prints them as they happen,
+reconnecting when the connection drops (only admins can stream).
+
+	gsh audit-tail --user alice --host 198.51.100.10
+	gsh audit-tail --output json | jq .
+	gsh audit-tail --fields user,host,serial,time --output jsonl
+	`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		user, err := cmd.Flags().GetString("user")
+		if err != nil {
+			fmt.Printf("Client error parsing user option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		host, err := cmd.Flags().GetString("host")
+		if err != nil {
+			fmt.Printf("Client error parsing host option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			fmt.Printf("Client error parsing output option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if output != "text" && output != "json" && output != "jsonl" {
+			fmt.Printf("Client error parsing output option: (%s is not text, json or jsonl)\n", output)
+			os.Exit(1)
+		}
+		fieldsFlag, err := cmd.Flags().GetString("fields")
+		if err != nil {
+			fmt.Printf("Client error parsing fields option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		fields, err := parseAuditFields(fieldsFlag)
+		if err != nil {
+			fmt.Printf("Client error parsing fields option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		query := url.Values{}
+		if user != "" {
+			query.Set("user", user)
+		}
+		if host != "" {
+			query.Set("host", host)
+		}
+		streamURL := currentTarget.Endpoint + "/audit/stream?" + query.Encode()
+
+		// Setting custom HTTP client with timeouts, without timeout for the whole (endless) stream
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout:   10 * time.Second,
+			TLSClientConfig:       config.TLSClientConfig(),
+			ResponseHeaderTimeout: 10 * time.Second,
+		}
+		var netClient = &http.Client{
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		// json and jsonl print one object per line, as events arrive
+		printEvent := func(event types.IssuanceEvent) {
+			if output == "json" || output == "jsonl" {
+				data, _ := projectAuditEvent(event, fields)
+				fmt.Println(string(data))
+				return
+			}
+			if len(fields) > 0 {
+				fmt.Println(auditEventValues(event, fields))
+				return
+			}
+			fmt.Printf("%s %s %s -> %s@%s from %s key id %s %s\n", event.Time.Local().Format(time.RFC3339), event.Kind,
+				event.Owner, event.RemoteUser, event.RemoteHost, event.UserIP, event.KeyID, event.Reason)
+		}
+
+		lastEventID := ""
+		backoff := time.Second
+		for {
+			// Token is recovered at each connection, as it can be refreshed
+			oauth2Token, err := auth.RecoverToken(currentTarget)
+			if err != nil {
+				fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+
+			connectedAt := time.Now()
+			lastEventID, err = tailStream(netClient, streamURL, oauth2Token.AccessToken, lastEventID, printEvent)
+			if errors.Is(err, errStreamRefused) {
+				fmt.Printf("Client error streaming audit: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			if time.Since(connectedAt) > auditTailMaxBackoff {
+				backoff = time.Second
+			}
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			fmt.Fprintf(os.Stderr, "Audit stream disconnected (%s), reconnecting in %s\n", err.Error(), backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > auditTailMaxBackoff {
+				backoff = auditTailMaxBackoff
+			}
+		}
+	},
+}
+
+// tailStream reads the issuance events of the audit stream at streamURL, after lastEventID,
+// calling handle for each one. It returns when the stream ends, with the id of the last event read.
+func tailStream(client *http.Client, streamURL string, token string, lastEventID string, handle func(event types.IssuanceEvent)) (string, error) {
+	req, err := http.NewRequest("GET", streamURL, nil)
+	if err != nil {
+		return lastEventID, err
+	}
+	req.Header.Set("Authorization", "JWT "+token)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return lastEventID, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusBadRequest:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return lastEventID, fmt.Errorf("%w: status %d (%s)", errStreamRefused, resp.StatusCode, strings.TrimSpace(string(body)))
+	case resp.StatusCode != http.StatusOK:
+		return lastEventID, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	// Server-sent events: fields until a blank line, comments start with ":"
+	var id, event, data string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if event == "issuance" && data != "" {
+				issuance := types.IssuanceEvent{}
+				if err := json.Unmarshal([]byte(data), &issuance); err == nil {
+					handle(issuance)
+				}
+				if id != "" {
+					lastEventID = id
+				}
+			}
+			id, event, data = "", "", ""
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field := strings.SplitN(line, ":", 2)
+		value := ""
+		if len(field) == 2 {
+			value = strings.TrimPrefix(field[1], " ")
+		}
+		switch field[0] {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			data += value
+		}
+	}
+	return lastEventID, scanner.Err()
+}
+
+// auditFieldAliases are short names accepted by --fields
+var auditFieldAliases = map[string]string{
+	"user": "owner",
+	"host": "remote_host",
+}
+
+// parseAuditFields returns the issuance event fields (JSON names) at comma separated fields,
+// refusing names not in types.IssuanceEvent
+func parseAuditFields(fields string) ([]string, error) {
+	if strings.TrimSpace(fields) == "" {
+		return nil, nil
+	}
+	known := map[string]bool{}
+	eventType := reflect.TypeOf(types.IssuanceEvent{})
+	for i := 0; i < eventType.NumField(); i++ {
+		known[strings.Split(eventType.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	var parsed []string
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if alias, ok := auditFieldAliases[field]; ok {
+			field = alias
+		}
+		if !known[field] {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		parsed = append(parsed, field)
+	}
+	return parsed, nil
+}
+
+// auditEventMap returns event as a map of JSON field names to their encoded values
+func auditEventMap(event types.IssuanceEvent) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &values)
+	return values, err
+}
+
+// projectAuditEvent returns event as a JSON object with only fields, in their order (all fields
+// when fields is empty). Fields omitted from event are null.
+func projectAuditEvent(event types.IssuanceEvent, fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return json.Marshal(event)
+	}
+	values, err := auditEventMap(event)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, _ := json.Marshal(field)
+		buf.Write(key)
+		buf.WriteString(":")
+		if value, ok := values[field]; ok {
+			buf.Write(value)
+		} else {
+			buf.WriteString("null")
+		}
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// auditEventValues returns the values of fields of event separated by tabs, for text output
+func auditEventValues(event types.IssuanceEvent, fields []string) string {
+	values, _ := auditEventMap(event)
+	var line []string
+	for _, field := range fields {
+		var value interface{}
+		_ = json.Unmarshal(values[field], &value)
+		if value == nil {
+			value = ""
+		}
+		line = append(line, fmt.Sprint(value))
+	}
+	return strings.Join(line, "\t")
+}
+
+func init() {
+	rootCmd.AddCommand(auditTailCmd)
+
+	auditTailCmd.Flags().String("user", "", "Shows only certificates issued to user")
+	auditTailCmd.Flags().String("host", "", "Shows only certificates issued to remote host")
+	auditTailCmd.Flags().StringP("output", "o", "text", "Defines the output format (text, json or jsonl, one JSON object per line)")
+	auditTailCmd.Flags().String("fields", "", "Shows only these comma separated fields (as user,host,serial,time)")
+}
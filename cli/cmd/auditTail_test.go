@@ -0,0 +1,181 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestTailStream(t *testing.T) {
+	t.Run(
+		"Read events and reconnect",
+		func(t *testing.T) {
+			var lastEventIDs []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+				if r.Header.Get("Authorization") != "JWT token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, ": keepalive\n\n")
+				fmt.Fprint(w, "id: 2019-01-01T10:00:01Z\nevent: issuance\ndata: {\"owner\":\"alice\",\"remote_host\":\"198.51.100.10\"}\n\n")
+				fmt.Fprint(w, "id: 2019-01-01T10:00:02Z\nevent: issuance\ndata: {\"owner\":\"bob\",\"remote_host\":\"198.51.100.11\"}\n\n")
+				fmt.Fprint(w, "event: error\ndata: Error reading audit records\n\n")
+			}))
+			defer server.Close()
+
+			var events []types.IssuanceEvent
+			handle := func(event types.IssuanceEvent) { events = append(events, event) }
+			lastEventID, err := tailStream(server.Client(), server.URL, "token", "", handle)
+			if err != nil {
+				t.Fatalf("tailStream: check fail reading stream (%v)", err)
+			}
+			if len(events) != 2 || events[0].Owner != "alice" || events[1].RemoteHost != "198.51.100.11" {
+				t.Fatalf("tailStream: check fail with events (%v)", events)
+			}
+			if lastEventID != "2019-01-01T10:00:02Z" {
+				t.Fatalf("tailStream: check fail with last event id (%s)", lastEventID)
+			}
+
+			// reconnecting resumes after the last event
+			_, err = tailStream(server.Client(), server.URL, "token", lastEventID, handle)
+			if err != nil || len(lastEventIDs) != 2 || lastEventIDs[1] != "2019-01-01T10:00:02Z" {
+				t.Fatalf("tailStream: check fail reconnecting (%v, %v)", lastEventIDs, err)
+			}
+		})
+	t.Run(
+		"Stream refused",
+		func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			}))
+			defer server.Close()
+
+			lastEventID, err := tailStream(server.Client(), server.URL, "token", "2019-01-01T10:00:02Z", func(types.IssuanceEvent) {})
+			if !errors.Is(err, errStreamRefused) || lastEventID != "2019-01-01T10:00:02Z" {
+				t.Fatalf("tailStream: check fail with refused stream (%s, %v)", lastEventID, err)
+			}
+		})
+	t.Run(
+		"Server unavailable",
+		func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			defer server.Close()
+
+			_, err := tailStream(server.Client(), server.URL, "token", "", func(types.IssuanceEvent) {})
+			if err == nil || errors.Is(err, errStreamRefused) {
+				t.Fatalf("tailStream: check fail with unavailable server (%v)", err)
+			}
+		})
+}
+
+func TestParseAuditFields(t *testing.T) {
+	t.Run(
+		"Fields and aliases",
+		func(t *testing.T) {
+			fields, err := parseAuditFields("user, host,serial,time")
+			if err != nil || fmt.Sprint(fields) != "[owner remote_host serial time]" {
+				t.Fatalf("parseAuditFields: check fail with fields (%v, %v)", fields, err)
+			}
+		})
+	t.Run(
+		"No fields",
+		func(t *testing.T) {
+			if fields, err := parseAuditFields(""); err != nil || fields != nil {
+				t.Fatalf("parseAuditFields: check fail without fields (%v, %v)", fields, err)
+			}
+		})
+	t.Run(
+		"Unknown field",
+		func(t *testing.T) {
+			if _, err := parseAuditFields("user,password"); err == nil {
+				t.Fatalf("parseAuditFields: check fail with unknown field")
+			}
+		})
+}
+
+func TestProjectAuditEvent(t *testing.T) {
+	event := types.IssuanceEvent{
+		Time:       time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+		Kind:       "cert.create",
+		Owner:      "alice",
+		RemoteUser: "alice",
+		RemoteHost: "198.51.100.10",
+		Serial:     "42",
+	}
+	t.Run(
+		"Projection keeps field order",
+		func(t *testing.T) {
+			data, err := projectAuditEvent(event, []string{"owner", "remote_host", "serial", "time"})
+			expected := `{"owner":"alice","remote_host":"198.51.100.10","serial":"42","time":"2019-01-02T03:04:05Z"}`
+			if err != nil || string(data) != expected {
+				t.Fatalf("projectAuditEvent: check fail with projection (%s, %v)", data, err)
+			}
+		})
+	t.Run(
+		"Omitted field is null",
+		func(t *testing.T) {
+			data, err := projectAuditEvent(event, []string{"owner", "reason"})
+			if err != nil || string(data) != `{"owner":"alice","reason":null}` {
+				t.Fatalf("projectAuditEvent: check fail with omitted field (%s, %v)", data, err)
+			}
+		})
+	t.Run(
+		"JSON line without fields",
+		func(t *testing.T) {
+			data, err := projectAuditEvent(event, nil)
+			if err != nil || strings.Contains(string(data), "\n") {
+				t.Fatalf("projectAuditEvent: check fail with JSON line (%s, %v)", data, err)
+			}
+			decoded := types.IssuanceEvent{}
+			if err := json.Unmarshal(data, &decoded); err != nil || decoded.Serial != "42" {
+				t.Fatalf("projectAuditEvent: check fail decoding JSON line (%v)", err)
+			}
+		})
+	t.Run(
+		"Text values",
+		func(t *testing.T) {
+			if line := auditEventValues(event, []string{"owner", "reason", "serial"}); line != "alice\t\t42" {
+				t.Fatalf("auditEventValues: check fail with text values (%q)", line)
+			}
+		})
+}
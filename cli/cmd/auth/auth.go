@@ -30,14 +30,11 @@
 package auth
 
 import (
-	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -45,9 +42,9 @@ import (
 
 	"github.com/99designs/keyring"
 	oidc "github.com/coreos/go-oidc"
+	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/globocom/gsh/types"
 	"github.com/labstack/gommon/random"
-	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/oauth2"
 )
@@ -77,8 +74,26 @@ const errorMarkup = `
 	<p>%s</p>
 `
 
+// ClientID returns the OIDC client id (audience) of tokens requested for currentTarget: the
+// audience configured at target or, without it, oidc_audience published by GSH API
+func ClientID(currentTarget *types.Target, discovered string) string {
+	if currentTarget.Audience != "" {
+		return currentTarget.Audience
+	}
+	return discovered
+}
+
+// AudienceOptions returns the options asking OIDC provider for a token to the audience configured
+// at currentTarget, as required by IdPs issuing tokens to audiences other than the client id
+func AudienceOptions(currentTarget *types.Target) []oauth2.AuthCodeOption {
+	if currentTarget.Audience == "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("audience", currentTarget.Audience)}
+}
+
 // Callback is function that verifies code and get tokens (and store then on config file)
-func Callback(state string, codeVerifier string, redirectURL string, oauth2config oauth2.Config, targetLabel string, finish chan bool) http.HandlerFunc {
+func Callback(state string, codeVerifier string, redirectURL string, oauth2config oauth2.Config, targetLabel string, account string, finish chan bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			finish <- true
@@ -93,7 +108,7 @@ func Callback(state string, codeVerifier string, redirectURL string, oauth2confi
 		} else {
 			// State OK, continue OpenID Connect Flow
 			code := r.URL.Query().Get("code")
-			ctx := context.Background()
+			ctx := config.Context()
 			oauth2Token, err := oauth2config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
 			if err != nil {
 				// Exchange error
@@ -103,9 +118,8 @@ func Callback(state string, codeVerifier string, redirectURL string, oauth2confi
 				// Exchange success
 				page = fmt.Sprintf(callbackPage, successMarkup)
 
-				// Storing tokens on current target
-				oauth2Token.AccessToken = oauth2Token.Extra("id_token").(string)
-				err = StorageTokens(targetLabel, *oauth2Token)
+				// Storing tokens on current target, the ID token is kept besides the access token
+				err = StorageTokens(targetLabel, account, *oauth2Token)
 				if err != nil {
 					// Exchange error
 					msg = fmt.Sprintf(errorMarkup, err.Error())
@@ -144,10 +158,28 @@ func PKCEgenerator() (string, string, error) {
 	return codeVerifier, codeChallenge, nil
 }
 
-// StorageTokens uses keyring to storage refresh and access tokens
-func StorageTokens(targetLabel string, token oauth2.Token) error {
+// tokenKey returns the keyring key of account tokens at target. The unnamed account
+// uses the target label, as tokens were stored before accounts existed.
+func tokenKey(targetLabel string, account string) string {
+	if account == "" {
+		return targetLabel
+	}
+	return account + "@" + targetLabel
+}
+
+// storedToken is the token stored at keyring, the oauth2 token and the ID token issued with it.
+// Tokens stored before ID tokens were kept apart have IDToken empty.
+type storedToken struct {
+	oauth2.Token
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// StorageTokens uses keyring to storage refresh, access and ID tokens of account at target
+func StorageTokens(targetLabel string, account string, token oauth2.Token) error {
 	var storage []keyring.BackendType
-	storageConfig := viper.GetString("targets." + targetLabel + ".token-storage")
+	// token-storage can be set at user or system-wide config
+	target, _ := config.Targets()[targetLabel].(map[string]interface{})
+	storageConfig, _ := target["token-storage"].(string)
 	storage = append(storage, keyring.BackendType(storageConfig))
 	ring, err := keyring.Open(keyring.Config{
 		// Configuration for keychain
@@ -173,14 +205,15 @@ func StorageTokens(targetLabel string, token oauth2.Token) error {
 		return err
 	}
 
-	oauth2TokenJSON, err := json.Marshal(token)
+	idToken, _ := token.Extra("id_token").(string)
+	oauth2TokenJSON, err := json.Marshal(storedToken{Token: token, IDToken: idToken})
 	if err != nil {
 		fmt.Printf("Client error marshalling oauth2 tokens: (%s)\n", err.Error())
 		return err
 	}
 
 	err = ring.Set(keyring.Item{
-		Key:  targetLabel,
+		Key:  tokenKey(targetLabel, account),
 		Data: oauth2TokenJSON,
 	})
 	if err != nil {
@@ -190,11 +223,55 @@ func StorageTokens(targetLabel string, token oauth2.Token) error {
 	return nil
 }
 
-// RecoverToken uses keyring to recover access token
+// RecoverToken uses keyring to recover access token of current target account
 func RecoverToken(currentTarget *types.Target) (*oauth2.Token, error) {
+	configResponse, err := config.DiscoveryFor(currentTarget)
+	if err != nil {
+		os.Exit(1)
+	}
+	return RenewToken(currentTarget, configResponse, func(issuer string) (*oidc.Provider, error) {
+		return oidc.NewProvider(config.Context(), issuer)
+	})
+}
+
+// RenewToken recovers access token of current target account from keyring, refreshing it with the
+// OIDC provider of configResponse (a GSH API discovery already made) got by provider
+func RenewToken(currentTarget *types.Target, configResponse *config.DiscoveryResponse, provider func(issuer string) (*oidc.Provider, error)) (*oauth2.Token, error) {
+	token, err := storedTokenOf(currentTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := config.Context()
+	oauth2provider, err := provider(configResponse.Issuer)
+	if err != nil {
+		fmt.Printf("GSH client setting OIDC provider error: %s\n", err.Error())
+		return nil, err
+	}
+
+	// Configure an OpenID Connect aware OAuth2 client.
+	oauth2config := &oauth2.Config{
+		ClientID: ClientID(currentTarget, configResponse.Audience),
+		Endpoint: oauth2provider.Endpoint(),
+	}
+	store := func(refreshed oauth2.Token) error {
+		return StorageTokens(currentTarget.Label, currentTarget.Account, refreshed)
+	}
+	tokenRefreshed, err := refreshToken(token, oauth2config.TokenSource(ctx, token), store)
+	if err != nil {
+		fmt.Printf("GSH client renew token error: %s\n", err.Error())
+		return nil, err
+	}
+
+	return bearerFor(tokenRefreshed, configResponse.Mode, time.Now(), func(refreshToken string) oauth2.TokenSource {
+		return oauth2config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken})
+	}, store)
+}
+
+// storedTokenOf reads the tokens of current target account from keyring
+func storedTokenOf(currentTarget *types.Target) (*oauth2.Token, error) {
 	var storage []keyring.BackendType
-	storageConfig := viper.GetString("targets." + currentTarget.Label + ".token-storage")
-	storage = append(storage, keyring.BackendType(storageConfig))
+	storage = append(storage, keyring.BackendType(currentTarget.TokenStorage))
 	ring, err := keyring.Open(keyring.Config{
 		// Configuration for keychain
 		AllowedBackends: storage,
@@ -219,78 +296,111 @@ func RecoverToken(currentTarget *types.Target) (*oauth2.Token, error) {
 		return nil, err
 	}
 
-	tokenKeyItem, err := ring.Get(currentTarget.Label)
+	tokenKeyItem, err := ring.Get(tokenKey(currentTarget.Label, currentTarget.Account))
 	if err != nil {
 		fmt.Printf("Client error reading token storage: (%s)\n", err.Error())
 		return nil, err
 	}
 
-	token := new(oauth2.Token)
-	if err := json.Unmarshal(tokenKeyItem.Data, &token); err != nil {
+	stored := new(storedToken)
+	if err := json.Unmarshal(tokenKeyItem.Data, &stored); err != nil {
 		fmt.Printf("Client error unmarshalling stored token: (%s)\n", err.Error())
 		return nil, err
 	}
+	token := &stored.Token
+	if len(stored.IDToken) > 0 {
+		token = token.WithExtra(map[string]interface{}{"id_token": stored.IDToken})
+	}
+	return token, nil
+}
+
+// errIDTokenExpired is returned by bearerToken when the ID token to be sent to GSH API expired
+var errIDTokenExpired = errors.New("ID token expired")
 
-	// Setting custom HTTP client with timeouts
-	var netTransport = &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout: 10 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout: 10 * time.Second,
+// bearerToken returns the token sent to GSH API, as its AccessToken. GSH APIs validating ID tokens
+// (token_validation_mode id) get the ID token, others the access token. An ID token expired at now
+// is not sent, returning errIDTokenExpired.
+func bearerToken(token *oauth2.Token, mode string, now time.Time) (*oauth2.Token, error) {
+	if mode != "id" {
+		return token, nil
 	}
-	var netClient = &http.Client{
-		Timeout:   10 * time.Second,
-		Transport: netTransport,
+	idToken, _ := token.Extra("id_token").(string)
+	if len(idToken) == 0 {
+		fmt.Println("Client error: GSH API validates ID tokens and no ID token is stored, run gsh login")
+		return nil, errors.New("ID token not stored")
 	}
+	if expiry, ok := idTokenExpiry(idToken); ok && !now.Before(expiry) {
+		return nil, errIDTokenExpired
+	}
+	bearer := *token
+	bearer.AccessToken = idToken
+	return &bearer, nil
+}
 
-	// Making discovery GSH request
-	resp, err := netClient.Get(currentTarget.Endpoint + "/status/config")
-	if err != nil {
-		fmt.Printf("GSH API is down: %s (%s)\n", currentTarget.Endpoint, err.Error())
-		os.Exit(1)
+// bearerFor returns the bearer token of token (see bearerToken). The access token can outlive the
+// ID token, so an expired ID token is refreshed with the source returned by refresh for the refresh
+// token, failing when the IdP issues no fresh ID token.
+func bearerFor(token *oauth2.Token, mode string, now time.Time, refresh func(refreshToken string) oauth2.TokenSource, store func(oauth2.Token) error) (*oauth2.Token, error) {
+	bearer, err := bearerToken(token, mode, now)
+	if err != errIDTokenExpired {
+		return bearer, err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("GSH API body response error: %s\n", err.Error())
-		os.Exit(1)
+	if token.RefreshToken != "" {
+		var refreshed *oauth2.Token
+		refreshed, err = refreshToken(token, refresh(token.RefreshToken), store)
+		if err == nil {
+			bearer, err = bearerToken(refreshed, mode, now)
+		}
 	}
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("GSH API status response error: %v\n", resp.StatusCode)
-		os.Exit(1)
+	if bearer == nil {
+		fmt.Println("Client error: ID token expired and no fresh ID token was issued, run gsh login")
+		if err == nil {
+			err = errIDTokenExpired
+		}
+		return nil, err
 	}
-	type ConfigResponse struct {
-		BaseURL  string `json:"oidc_base_url"`
-		Realm    string `json:"oidc_realm"`
-		Audience string `json:"oidc_audience"`
-		Issuer   string `json:"oidc_issuer"`
-		Certs    string `json:"oidc_certs"`
+	return bearer, nil
+}
+
+// idTokenExpiry returns the expiration (exp claim) of idToken, not verified: it only tells whether
+// the ID token is worth sending to GSH API, which verifies it
+func idTokenExpiry(idToken string) (time.Time, bool) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
 	}
-	configResponse := new(ConfigResponse)
-	if err := json.Unmarshal(body, &configResponse); err != nil {
-		fmt.Printf("GSH API body unmarshal error: %s\n", err.Error())
-		os.Exit(1)
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
 	}
+	claims := struct {
+		Exp float64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(claims.Exp), 0), true
+}
 
-	ctx := context.Background()
-	oauth2provider, err := oidc.NewProvider(ctx, configResponse.Issuer)
+// refreshToken gets a valid token from source, refreshing token if it is expired. A refreshed
+// token is stored, as IdPs that rotate refresh tokens refuse the old one at the next refresh.
+// Failing to store is not fatal, the refreshed token is still valid for this command.
+// IdPs that don't issue an ID token on refresh keep the ID token of token.
+func refreshToken(token *oauth2.Token, source oauth2.TokenSource, store func(oauth2.Token) error) (*oauth2.Token, error) {
+	refreshed, err := source.Token()
 	if err != nil {
-		fmt.Printf("GSH client setting OIDC provider error: %s\n", err.Error())
-		os.Exit(1)
+		return nil, err
 	}
-
-	// Configure an OpenID Connect aware OAuth2 client.
-	oauth2config := &oauth2.Config{
-		ClientID: configResponse.Audience,
-		Endpoint: oauth2provider.Endpoint(),
+	if idToken, _ := refreshed.Extra("id_token").(string); len(idToken) == 0 && token.Extra("id_token") != nil {
+		refreshed = refreshed.WithExtra(map[string]interface{}{"id_token": token.Extra("id_token")})
 	}
-	tokenRefreshed, err := oauth2config.TokenSource(ctx, token).Token()
-	if err != nil {
-		fmt.Printf("GSH client renew token error: %s\n", err.Error())
-		os.Exit(1)
+	if refreshed.AccessToken == token.AccessToken && refreshed.RefreshToken == token.RefreshToken {
+		return refreshed, nil
 	}
-
-	return tokenRefreshed, nil
+	if err := store(*refreshed); err != nil {
+		fmt.Printf("Client error storing refreshed token, next refresh can require a new login: (%s)\n", err.Error())
+	}
+	return refreshed, nil
 }
 
 // terminalPrompt prints to user insert a password for an encrypted file
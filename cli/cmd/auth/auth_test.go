@@ -0,0 +1,328 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenKey(t *testing.T) {
+	t.Run(
+		"Unnamed account",
+		func(t *testing.T) {
+			if key := tokenKey("prod", ""); key != "prod" {
+				t.Fatalf("tokenKey: check fail with unnamed account (%s)", key)
+			}
+		})
+	t.Run(
+		"Selecting between two accounts",
+		func(t *testing.T) {
+			personal := tokenKey("prod", "personal")
+			breakGlass := tokenKey("prod", "break-glass")
+			if personal == breakGlass || personal == tokenKey("prod", "") {
+				t.Fatalf("tokenKey: check fail with two accounts (%s, %s)", personal, breakGlass)
+			}
+			if personal != tokenKey("prod", "personal") {
+				t.Fatalf("tokenKey: check fail selecting the same account twice (%s)", personal)
+			}
+		})
+	t.Run(
+		"Same account at two targets",
+		func(t *testing.T) {
+			if tokenKey("prod", "personal") == tokenKey("dev", "personal") {
+				t.Fatalf("tokenKey: check fail with same account at two targets")
+			}
+		})
+}
+
+func TestRefreshToken(t *testing.T) {
+	// IdP rotating refresh tokens: each refresh token is accepted once
+	current := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("refresh_token") != fmt.Sprintf("refresh-%d", current) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		current++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("access-%d", current),
+			"refresh_token": fmt.Sprintf("refresh-%d", current),
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	defer server.Close()
+	oauth2config := &oauth2.Config{ClientID: "gsh", Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+
+	var stored *oauth2.Token
+	store := func(token oauth2.Token) error {
+		stored = &token
+		return nil
+	}
+	expired := func(token oauth2.Token) *oauth2.Token {
+		token.Expiry = time.Now().Add(-time.Minute)
+		return &token
+	}
+
+	t.Run(
+		"Rotated refresh token is stored",
+		func(t *testing.T) {
+			token := &oauth2.Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Minute)}
+			refreshed, err := refreshToken(token, oauth2config.TokenSource(context.Background(), token), store)
+			if err != nil || refreshed.RefreshToken != "refresh-2" {
+				t.Fatalf("refreshToken: check fail refreshing (%v, %v)", refreshed, err)
+			}
+			if stored == nil || stored.RefreshToken != "refresh-2" || stored.AccessToken != "access-2" {
+				t.Fatalf("refreshToken: check fail storing rotated token (%v)", stored)
+			}
+		})
+	t.Run(
+		"Stored token refreshes again",
+		func(t *testing.T) {
+			token := expired(*stored)
+			refreshed, err := refreshToken(token, oauth2config.TokenSource(context.Background(), token), store)
+			if err != nil || refreshed.RefreshToken != "refresh-3" || stored.RefreshToken != "refresh-3" {
+				t.Fatalf("refreshToken: check fail refreshing with stored token (%v, %v)", refreshed, err)
+			}
+		})
+	t.Run(
+		"Valid token is not stored",
+		func(t *testing.T) {
+			stored = nil
+			token := &oauth2.Token{AccessToken: "access-3", RefreshToken: "refresh-3", Expiry: time.Now().Add(time.Hour)}
+			refreshed, err := refreshToken(token, oauth2config.TokenSource(context.Background(), token), store)
+			if err != nil || refreshed.AccessToken != "access-3" || stored != nil {
+				t.Fatalf("refreshToken: check fail with valid token (%v, %v, %v)", refreshed, err, stored)
+			}
+		})
+	t.Run(
+		"Store failure keeps refreshed token",
+		func(t *testing.T) {
+			token := expired(oauth2.Token{AccessToken: "access-3", RefreshToken: "refresh-3"})
+			refreshed, err := refreshToken(token, oauth2config.TokenSource(context.Background(), token), func(oauth2.Token) error {
+				return errors.New("keyring locked")
+			})
+			if err != nil || refreshed.RefreshToken != "refresh-4" {
+				t.Fatalf("refreshToken: check fail with store failure (%v, %v)", refreshed, err)
+			}
+		})
+	t.Run(
+		"ID token kept when refresh doesn't issue one",
+		func(t *testing.T) {
+			token := expired(oauth2.Token{AccessToken: "access-4", RefreshToken: "refresh-4"})
+			token = token.WithExtra(map[string]interface{}{"id_token": "id-4"})
+			refreshed, err := refreshToken(token, oauth2config.TokenSource(context.Background(), token), store)
+			if err != nil || refreshed.AccessToken != "access-5" || refreshed.Extra("id_token") != "id-4" {
+				t.Fatalf("refreshToken: check fail keeping ID token (%v, %v)", refreshed, err)
+			}
+			if stored.Extra("id_token") != "id-4" {
+				t.Fatalf("refreshToken: check fail storing ID token (%v)", stored)
+			}
+		})
+}
+
+func TestBearerToken(t *testing.T) {
+	jwt := func(exp time.Time) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))) + ".c2ln"
+	}
+	now := time.Now()
+	token := (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{"id_token": "id"})
+	t.Run(
+		"Access token validation mode",
+		func(t *testing.T) {
+			for _, mode := range []string{"access", ""} {
+				if bearer, err := bearerToken(token, mode, now); err != nil || bearer.AccessToken != "access" {
+					t.Fatalf("bearerToken: check fail at mode %q (%v, %v)", mode, bearer, err)
+				}
+			}
+		})
+	t.Run(
+		"ID token validation mode",
+		func(t *testing.T) {
+			bearer, err := bearerToken(token, "id", now)
+			if err != nil || bearer.AccessToken != "id" || token.AccessToken != "access" {
+				t.Fatalf("bearerToken: check fail at mode id (%v, %v)", bearer, err)
+			}
+			if _, err := bearerToken(&oauth2.Token{AccessToken: "access"}, "id", now); err == nil {
+				t.Fatalf("bearerToken: check fail at mode id without ID token")
+			}
+		})
+	t.Run(
+		"Expired ID token",
+		func(t *testing.T) {
+			expired := (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{"id_token": jwt(now.Add(-time.Minute))})
+			if _, err := bearerToken(expired, "id", now); err != errIDTokenExpired {
+				t.Fatalf("bearerToken: check fail with expired ID token (%v)", err)
+			}
+			valid := (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{"id_token": jwt(now.Add(time.Minute))})
+			if _, err := bearerToken(valid, "id", now); err != nil {
+				t.Fatalf("bearerToken: check fail with valid ID token (%v)", err)
+			}
+		})
+}
+
+func TestBearerFor(t *testing.T) {
+	jwt := func(exp time.Time) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))) + ".c2ln"
+	}
+	now := time.Now()
+	freshIDToken := jwt(now.Add(time.Hour))
+	// IdP issuing a fresh ID token only when issueIDToken is set
+	issueIDToken := true
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+		response := map[string]interface{}{
+			"access_token": fmt.Sprintf("access-%d", refreshes),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		}
+		if issueIDToken {
+			response["id_token"] = freshIDToken
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+	oauth2config := &oauth2.Config{ClientID: "gsh", Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+	refresh := func(refreshToken string) oauth2.TokenSource {
+		return oauth2config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: refreshToken})
+	}
+	var stored *oauth2.Token
+	store := func(token oauth2.Token) error {
+		stored = &token
+		return nil
+	}
+	// access token still valid, but its ID token expired
+	token := (&oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: now.Add(time.Hour)}).
+		WithExtra(map[string]interface{}{"id_token": jwt(now.Add(-time.Minute))})
+
+	t.Run(
+		"Expired ID token refreshed",
+		func(t *testing.T) {
+			bearer, err := bearerFor(token, "id", now, refresh, store)
+			if err != nil || bearer.AccessToken != freshIDToken || refreshes != 1 {
+				t.Fatalf("bearerFor: check fail refreshing expired ID token (%v, %v, %d)", bearer, err, refreshes)
+			}
+			if stored == nil || stored.Extra("id_token") != freshIDToken {
+				t.Fatalf("bearerFor: check fail storing fresh ID token (%v)", stored)
+			}
+		})
+	t.Run(
+		"No fresh ID token issued",
+		func(t *testing.T) {
+			issueIDToken = false
+			if bearer, err := bearerFor(token, "id", now, refresh, store); err == nil {
+				t.Fatalf("bearerFor: check fail sending expired ID token (%v)", bearer)
+			}
+		})
+	t.Run(
+		"No refresh token",
+		func(t *testing.T) {
+			withoutRefresh := (&oauth2.Token{AccessToken: "access", Expiry: now.Add(time.Hour)}).
+				WithExtra(map[string]interface{}{"id_token": jwt(now.Add(-time.Minute))})
+			if _, err := bearerFor(withoutRefresh, "id", now, refresh, store); err != errIDTokenExpired {
+				t.Fatalf("bearerFor: check fail without refresh token (%v)", err)
+			}
+		})
+	t.Run(
+		"Valid ID token not refreshed",
+		func(t *testing.T) {
+			refreshes = 0
+			valid := (&oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}).
+				WithExtra(map[string]interface{}{"id_token": freshIDToken})
+			if bearer, err := bearerFor(valid, "id", now, refresh, store); err != nil || bearer.AccessToken != freshIDToken || refreshes != 0 {
+				t.Fatalf("bearerFor: check fail with valid ID token (%v, %v, %d)", bearer, err, refreshes)
+			}
+		})
+}
+
+func TestClientID(t *testing.T) {
+	t.Run(
+		"Audience published by GSH API",
+		func(t *testing.T) {
+			target := &types.Target{Label: "prod"}
+			if clientID := ClientID(target, "gsh"); clientID != "gsh" {
+				t.Fatalf("ClientID: check fail without target audience (%s)", clientID)
+			}
+			if options := AudienceOptions(target); len(options) != 0 {
+				t.Fatalf("AudienceOptions: check fail without target audience (%v)", options)
+			}
+		})
+	t.Run(
+		"Token requested to target audience",
+		func(t *testing.T) {
+			target := &types.Target{Label: "prod", Audience: "gsh-prod"}
+			var tokenClientID string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// client id is sent as basic auth or form parameter, as the IdP accepts
+				if clientID, _, ok := r.BasicAuth(); ok {
+					tokenClientID = clientID
+				} else if err := r.ParseForm(); err == nil {
+					tokenClientID = r.Form.Get("client_id")
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":300}`)
+			}))
+			defer server.Close()
+
+			oauth2config := oauth2.Config{
+				ClientID: ClientID(target, "gsh"),
+				Endpoint: oauth2.Endpoint{AuthURL: server.URL + "/auth", TokenURL: server.URL + "/token"},
+			}
+			authURL, err := url.Parse(oauth2config.AuthCodeURL("state", AudienceOptions(target)...))
+			if err != nil {
+				t.Fatalf("AudienceOptions: check fail parsing auth URL (%v)", err)
+			}
+			if authURL.Query().Get("client_id") != "gsh-prod" || authURL.Query().Get("audience") != "gsh-prod" {
+				t.Fatalf("AudienceOptions: check fail with auth URL (%s)", authURL)
+			}
+			if _, err := oauth2config.Exchange(context.Background(), "code"); err != nil {
+				t.Fatalf("ClientID: check fail exchanging code (%v)", err)
+			}
+			if tokenClientID != "gsh-prod" {
+				t.Fatalf("ClientID: check fail with token request client id (%s)", tokenClientID)
+			}
+		})
+}
@@ -0,0 +1,298 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench [host...]",
+	Short: "Load tests certificate issuance of GSH API, reporting latency percentiles (never opens ssh sessions)",
+	Long: `
+
+Load tests GSH API of current target, requesting certificates to synthetic
+hosts (--hosts) with --concurrency requests at a time, and reports latency
+percentiles (p50, p95 and p99) and error rate, to size deployments.
+
+Requests are the same made by [[gsh host-connect]], to hosts given as
+arguments (127.0.0.1 by default) in turns. Certificates are really issued,
+audited and counted by quotas: GSH API has no dry-run signing. They are
+discarded, no key is written and no ssh session is opened.
+
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		hosts, err := cmd.Flags().GetInt("hosts")
+		if err != nil {
+			fmt.Printf("Client error parsing hosts option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		concurrency, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			fmt.Printf("Client error parsing concurrency option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if hosts <= 0 || concurrency <= 0 {
+			fmt.Println("Client error: --hosts and --concurrency must be positive")
+			os.Exit(1)
+		}
+		port, err := cmd.Flags().GetString("port")
+		if err != nil {
+			fmt.Printf("Client error parsing port option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+		username, err := cmd.Flags().GetString("username")
+		if err != nil {
+			fmt.Printf("Client error parsing username option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if username == "" {
+			username = currentTarget.DefaultUsername
+		}
+		if username == "" {
+			fmt.Println("Client error: remote user not set, use --username or a target default username")
+			os.Exit(1)
+		}
+
+		// Source ip of requests, as discovered by host-connect
+		sourceIP, err := cmd.Flags().GetString("source")
+		if err != nil {
+			fmt.Printf("Client error parsing source option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if sourceIP == "" {
+			u, err := url.Parse(currentTarget.Endpoint)
+			if err != nil {
+				fmt.Printf("Client error parsing URL endpoint: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			dial := func(address string) (net.Conn, error) {
+				return net.DialTimeout("tcp", address, time.Second)
+			}
+			localIP, err := outboundIP([]string{endpointAddress(u)}, 1, dial, net.InterfaceAddrs)
+			if err != nil {
+				fmt.Printf("Client error discovering local ip address: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			sourceIP = localIP.String()
+		}
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+			MaxIdleConnsPerHost: concurrency,
+		}
+		var netClient = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		if len(args) == 0 {
+			args = []string{"127.0.0.1"}
+		}
+		fmt.Printf("Requesting %d certificates to %s, %d at a time\n", hosts, currentTarget.Endpoint, concurrency)
+		fmt.Println("Warning: certificates are really issued and audited, they are discarded without opening ssh sessions")
+
+		report := runBench(hosts, concurrency, func(i int) (int, error) {
+			certRequest, signer, err := benchCertRequest(args[i%len(args)], port, username, sourceIP)
+			if err != nil {
+				return 0, err
+			}
+			// key proof as host-connect makes it, servers can require it (ca_require_key_proof)
+			nonce, _ := fetchChallenge(netClient, currentTarget.Endpoint, oauth2Token.AccessToken)
+			if err := signKeyProof(&certRequest, signer, nonce, time.Now()); err != nil {
+				return 0, err
+			}
+			certRequestJSON, _ := json.Marshal(certRequest)
+			statusCode, _, err := postCertRequest(netClient, currentTarget.Endpoint, oauth2Token.AccessToken, certRequestJSON)
+			return statusCode, err
+		})
+		printBenchReport(report)
+	},
+}
+
+// benchReport is the result of runBench. Latency percentiles are of issued certificates (200).
+type benchReport struct {
+	Requests int
+	Errors   int
+	Statuses map[int]int
+	Elapsed  time.Duration
+	P50      time.Duration
+	P95      time.Duration
+	P99      time.Duration
+}
+
+// ErrorRate returns the fraction of requests not issuing a certificate
+func (r benchReport) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+// runBench calls request for each of requests, concurrency at a time, and aggregates their latency.
+// request returns the response status, requests failing without response are counted at status 0.
+func runBench(requests int, concurrency int, request func(i int) (int, error)) benchReport {
+	report := benchReport{Requests: requests, Statuses: map[int]int{}}
+	latencies := []time.Duration{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan int)
+
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				requestStart := time.Now()
+				statusCode, err := request(i)
+				latency := time.Since(requestStart)
+				if err != nil {
+					statusCode = 0
+				}
+
+				mu.Lock()
+				report.Statuses[statusCode]++
+				if statusCode == http.StatusOK {
+					latencies = append(latencies, latency)
+				} else {
+					report.Errors++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 50)
+	report.P95 = percentile(latencies, 95)
+	report.P99 = percentile(latencies, 99)
+	return report
+}
+
+// percentile returns the p percentile of sorted latencies, by nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// benchCertRequest returns a certificate request to host, as made by host-connect, of a new key and its signer
+func benchCertRequest(host string, port string, username string, sourceIP string) (types.CertRequest, ssh.Signer, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return types.CertRequest{}, nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return types.CertRequest{}, nil, err
+	}
+	return types.CertRequest{
+		Key:        string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		RemoteHost: host,
+		RemotePort: port,
+		RemoteUser: username,
+		UserIP:     sourceIP,
+	}, signer, nil
+}
+
+// printBenchReport prints latency percentiles, throughput and error rate of report
+func printBenchReport(report benchReport) {
+	fmt.Printf("Requests: %d in %s (%.1f/s)\n", report.Requests, report.Elapsed.Round(time.Millisecond),
+		float64(report.Requests)/report.Elapsed.Seconds())
+	fmt.Printf("Errors: %d (%.1f%%)\n", report.Errors, report.ErrorRate()*100)
+	fmt.Printf("Latency: p50 %s, p95 %s, p99 %s\n", report.P50.Round(time.Millisecond),
+		report.P95.Round(time.Millisecond), report.P99.Round(time.Millisecond))
+	statuses := []int{}
+	for status := range report.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		if status == 0 {
+			fmt.Printf("Status none (request failed): %d\n", report.Statuses[status])
+			continue
+		}
+		fmt.Printf("Status %d: %d\n", status, report.Statuses[status])
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().Int("hosts", 10, "Defines how many synthetic hosts are requested certificates, one request each")
+	benchCmd.Flags().Int("concurrency", 1, "Defines how many certificate requests are made at a time")
+	benchCmd.Flags().StringP("username", "u", "", "Defines the remote user of certificates (default is target default username)")
+	benchCmd.Flags().StringP("port", "p", "22", "Defines the destination port of certificate requests")
+	benchCmd.Flags().StringP("source", "s", "", "Defines user IP of certificate requests (default is discovered as host-connect does)")
+}
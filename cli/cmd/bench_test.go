@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestRunBench(t *testing.T) {
+	t.Run(
+		"Aggregates timing from mock API",
+		func(t *testing.T) {
+			var received int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				certRequest := types.CertRequest{}
+				if r.URL.Path != "/certificates" || r.Header.Get("Authorization") != "JWT token" || json.NewDecoder(r.Body).Decode(&certRequest) != nil {
+					http.Error(w, "bad request", http.StatusBadRequest)
+					return
+				}
+				// every fourth request is denied by roles
+				if atomic.AddInt32(&received, 1)%4 == 0 {
+					http.Error(w, `{"result":"fail"}`, http.StatusForbidden)
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+				_, _ = w.Write([]byte(`{"certificate":"ssh-ed25519-cert-v01@openssh.com AAAA"}`))
+			}))
+			defer server.Close()
+
+			report := runBench(20, 4, func(i int) (int, error) {
+				certRequest, _, err := benchCertRequest("10.0.0.1", "22", "alice", "192.0.2.1")
+				if err != nil {
+					return 0, err
+				}
+				certRequestJSON, _ := json.Marshal(certRequest)
+				statusCode, _, err := postCertRequest(server.Client(), server.URL, "token", certRequestJSON)
+				return statusCode, err
+			})
+			if received != 20 || report.Requests != 20 {
+				t.Fatalf("runBench: check fail with requests made (%d, %d)", received, report.Requests)
+			}
+			if report.Errors != 5 || report.Statuses[http.StatusOK] != 15 || report.Statuses[http.StatusForbidden] != 5 || report.ErrorRate() != 0.25 {
+				t.Fatalf("runBench: check fail with error rate (%+v)", report)
+			}
+			if report.P50 < 5*time.Millisecond || report.P50 > report.P95 || report.P95 > report.P99 {
+				t.Fatalf("runBench: check fail with latency percentiles (%s, %s, %s)", report.P50, report.P95, report.P99)
+			}
+		})
+	t.Run(
+		"API unreachable",
+		func(t *testing.T) {
+			server := httptest.NewServer(http.NotFoundHandler())
+			endpoint := server.URL
+			server.Close()
+			report := runBench(3, 2, func(i int) (int, error) {
+				statusCode, _, err := postCertRequest(&http.Client{Timeout: time.Second}, endpoint, "token", []byte("{}"))
+				return statusCode, err
+			})
+			if report.Errors != 3 || report.Statuses[0] != 3 || report.P99 != 0 {
+				t.Fatalf("runBench: check fail with API unreachable (%+v)", report)
+			}
+		})
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := []time.Duration{}
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{50: 50 * time.Millisecond, 95: 95 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond} {
+		if got := percentile(latencies, p); got != expected {
+			t.Fatalf("percentile: check fail with p%v (%s)", p, got)
+		}
+	}
+	if percentile([]time.Duration{7 * time.Millisecond}, 99) != 7*time.Millisecond || percentile(nil, 50) != 0 {
+		t.Fatalf("percentile: check fail with few latencies")
+	}
+}
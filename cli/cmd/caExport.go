@@ -0,0 +1,220 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// Formats supported by ca-export
+const (
+	caFormatSSHD           = "sshd"
+	caFormatAuthorizedKeys = "authorized-keys"
+)
+
+// caExportCmd represents the caExport command
+var caExportCmd = &cobra.Command{
+	Use:   "ca-export",
+	Short: "Exports GSH CA public keys to configure remote hosts",
+	Long: `
+
+Exports the CA public keys of current target, so remote hosts accept
+certificates issued by GSH. When GSH signs with an intermediate CA, the keys
+of its chain are exported too, one per line.
+
+With --format sshd (default), the content of a TrustedUserCAKeys file is
+written to stdout and the sshd_config snippet to stderr:
+
+	gsh ca-export --format sshd > /etc/ssh/gsh_user_ca.pub
+
+With --format authorized-keys, a cert-authority line for ~/.ssh/authorized_keys
+is written, optionally restricted to some principals:
+
+	gsh ca-export --format authorized-keys --principals deploy >> ~/.ssh/authorized_keys
+	`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			fmt.Printf("Client error parsing format option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		path, err := cmd.Flags().GetString("path")
+		if err != nil {
+			fmt.Printf("Client error parsing path option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		principals, err := cmd.Flags().GetStringSlice("principals")
+		if err != nil {
+			fmt.Printf("Client error parsing principals option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		caPublicKeys, err := fetchCAPublicKeys(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting CA public keys: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		content, snippet, err := caExport(caPublicKeys, "gsh-"+currentTarget.Label, format, path, principals)
+		if err != nil {
+			fmt.Printf("Client error exporting CA public keys: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Print(content)
+		if snippet != "" {
+			fmt.Fprint(os.Stderr, snippet)
+		}
+	},
+}
+
+// fetchCAPublicKeys gets the CA public keys of target from GET /publickey, the signer key first
+func fetchCAPublicKeys(currentTarget *types.Target) ([]ssh.PublicKey, error) {
+	// Setting custom HTTP client with timeouts
+	var netTransport = &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout: 10 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     config.TLSClientConfig(),
+	}
+	var netClient = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: config.TargetTransport(netTransport, currentTarget),
+	}
+
+	// Make GSH request
+	resp, err := netClient.Get(currentTarget.Endpoint + "/publickey")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status response %d (%s)", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return parsePublicKeyResponse(body)
+}
+
+// parsePublicKeyResponse parses the CA public keys of a GET /publickey response. GSH API without
+// CA chain support informs only public_key.
+func parsePublicKeyResponse(body []byte) ([]ssh.PublicKey, error) {
+	type PublicKeyResponse struct {
+		Result     string   `json:"result"`
+		PublicKey  string   `json:"public_key"`
+		PublicKeys []string `json:"public_keys"`
+	}
+	publicKeyResponse := new(PublicKeyResponse)
+	if err := json.Unmarshal(body, &publicKeyResponse); err != nil {
+		return nil, fmt.Errorf("parsing public key response (%v)", err)
+	}
+	keys := publicKeyResponse.PublicKeys
+	if len(keys) == 0 {
+		keys = []string{publicKeyResponse.PublicKey}
+	}
+	caPublicKeys := []ssh.PublicKey{}
+	for _, key := range keys {
+		caPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("parsing CA public key (%v)", err)
+		}
+		caPublicKeys = append(caPublicKeys, caPublicKey)
+	}
+	return caPublicKeys, nil
+}
+
+// caExport formats the CA public keys as format, a line for each key. It returns the content to be
+// written at the trusted file and, for sshd format, the sshd_config snippet that uses it from path.
+func caExport(caPublicKeys []ssh.PublicKey, comment string, format string, path string, principals []string) (string, string, error) {
+	if len(caPublicKeys) == 0 {
+		return "", "", fmt.Errorf("caExport: no CA public key")
+	}
+	keys := []string{}
+	fingerprints := []string{}
+	for _, caPublicKey := range caPublicKeys {
+		keys = append(keys, strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(caPublicKey)), "\n"))
+		fingerprints = append(fingerprints, ssh.FingerprintSHA256(caPublicKey))
+	}
+	switch format {
+	case caFormatSSHD:
+		content := ""
+		for _, key := range keys {
+			content += fmt.Sprintf("%s %s\n", key, comment)
+		}
+		snippet := fmt.Sprintf("# Add to /etc/ssh/sshd_config and reload sshd (%s)\nTrustedUserCAKeys %s\n",
+			strings.Join(fingerprints, ", "), path)
+		return content, snippet, nil
+	case caFormatAuthorizedKeys:
+		options := "cert-authority"
+		if len(principals) > 0 {
+			for _, principal := range principals {
+				if principal == "" || strings.ContainsAny(principal, "\",\t\n ") {
+					return "", "", fmt.Errorf("caExport: invalid principal (%q)", principal)
+				}
+			}
+			options += fmt.Sprintf(",principals=\"%s\"", strings.Join(principals, ","))
+		}
+		content := ""
+		for _, key := range keys {
+			content += fmt.Sprintf("%s %s %s\n", options, key, comment)
+		}
+		return content, "", nil
+	}
+	return "", "", fmt.Errorf("caExport: unknown format %s (use %s or %s)", format, caFormatSSHD, caFormatAuthorizedKeys)
+}
+
+func init() {
+	rootCmd.AddCommand(caExportCmd)
+
+	caExportCmd.Flags().StringP("format", "f", caFormatSSHD, "Defines the output format (sshd or authorized-keys)")
+	caExportCmd.Flags().String("path", "/etc/ssh/gsh_user_ca.pub", "Defines the TrustedUserCAKeys path used at sshd_config snippet")
+	caExportCmd.Flags().StringSlice("principals", []string{}, "Defines the principals allowed at authorized-keys format")
+}
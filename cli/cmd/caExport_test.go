@@ -0,0 +1,167 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newCAPublicKey returns a new ed25519 public key
+func newCAPublicKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("caExport: check fail generating key (%v)", err)
+	}
+	caPublicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("caExport: check fail converting key (%v)", err)
+	}
+	return caPublicKey
+}
+
+func TestCAExport(t *testing.T) {
+	caPublicKey := newCAPublicKey(t)
+
+	t.Run(
+		"sshd format",
+		func(t *testing.T) {
+			content, snippet, err := caExport([]ssh.PublicKey{caPublicKey}, "gsh-prod", caFormatSSHD, "/etc/ssh/gsh_user_ca.pub", nil)
+			if err != nil {
+				t.Fatalf("caExport: check fail with sshd format (%v)", err)
+			}
+			key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(content))
+			if err != nil || comment != "gsh-prod" || ssh.FingerprintSHA256(key) != ssh.FingerprintSHA256(caPublicKey) {
+				t.Fatalf("caExport: check fail parsing sshd content (%s, %v)", content, err)
+			}
+			if !strings.Contains(snippet, "TrustedUserCAKeys /etc/ssh/gsh_user_ca.pub\n") {
+				t.Fatalf("caExport: check fail with sshd snippet (%s)", snippet)
+			}
+		})
+	t.Run(
+		"authorized-keys format",
+		func(t *testing.T) {
+			content, snippet, err := caExport([]ssh.PublicKey{caPublicKey}, "gsh-prod", caFormatAuthorizedKeys, "", []string{"deploy", "alice"})
+			if err != nil || snippet != "" {
+				t.Fatalf("caExport: check fail with authorized-keys format (%v)", err)
+			}
+			key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(content))
+			if err != nil || ssh.FingerprintSHA256(key) != ssh.FingerprintSHA256(caPublicKey) {
+				t.Fatalf("caExport: check fail parsing authorized-keys content (%s, %v)", content, err)
+			}
+			if len(options) != 2 || options[0] != "cert-authority" || options[1] != `principals="deploy,alice"` {
+				t.Fatalf("caExport: check fail with authorized-keys options (%v)", options)
+			}
+		})
+	t.Run(
+		"CA chain",
+		func(t *testing.T) {
+			chainPublicKey := newCAPublicKey(t)
+			content, snippet, err := caExport([]ssh.PublicKey{caPublicKey, chainPublicKey}, "gsh-prod", caFormatSSHD, "/etc/ssh/gsh_user_ca.pub", nil)
+			if err != nil {
+				t.Fatalf("caExport: check fail with CA chain (%v)", err)
+			}
+			rest := []byte(content)
+			for _, expected := range []ssh.PublicKey{caPublicKey, chainPublicKey} {
+				var key ssh.PublicKey
+				key, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+				if err != nil || ssh.FingerprintSHA256(key) != ssh.FingerprintSHA256(expected) {
+					t.Fatalf("caExport: check fail parsing CA chain (%s, %v)", content, err)
+				}
+			}
+			if !strings.Contains(snippet, ssh.FingerprintSHA256(chainPublicKey)) {
+				t.Fatalf("caExport: check fail with CA chain snippet (%s)", snippet)
+			}
+		})
+	t.Run(
+		"No CA public key",
+		func(t *testing.T) {
+			if _, _, err := caExport(nil, "gsh-prod", caFormatSSHD, "", nil); err == nil {
+				t.Fatalf("caExport: check fail without CA public key")
+			}
+		})
+	t.Run(
+		"Invalid principal",
+		func(t *testing.T) {
+			_, _, err := caExport([]ssh.PublicKey{caPublicKey}, "gsh-prod", caFormatAuthorizedKeys, "", []string{"a\"b"})
+			if err == nil {
+				t.Fatalf("caExport: check fail with invalid principal")
+			}
+		})
+	t.Run(
+		"Unknown format",
+		func(t *testing.T) {
+			_, _, err := caExport([]ssh.PublicKey{caPublicKey}, "gsh-prod", "pem", "", nil)
+			if err == nil {
+				t.Fatalf("caExport: check fail with unknown format")
+			}
+		})
+}
+
+func TestParsePublicKeyResponse(t *testing.T) {
+	signer, intermediate := newCAPublicKey(t), newCAPublicKey(t)
+	authorizedKey := func(key ssh.PublicKey) string {
+		return string(ssh.MarshalAuthorizedKey(key))
+	}
+
+	t.Run(
+		"CA chain",
+		func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{"result": "success", "public_key": authorizedKey(signer),
+				"public_keys": []string{authorizedKey(signer), authorizedKey(intermediate)}})
+			keys, err := parsePublicKeyResponse(body)
+			if err != nil || len(keys) != 2 || ssh.FingerprintSHA256(keys[0]) != ssh.FingerprintSHA256(signer) ||
+				ssh.FingerprintSHA256(keys[1]) != ssh.FingerprintSHA256(intermediate) {
+				t.Fatalf("parsePublicKeyResponse: check fail with CA chain (%v, %v)", keys, err)
+			}
+		})
+	t.Run(
+		"Signer key only",
+		func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"result": "success", "public_key": authorizedKey(signer)})
+			keys, err := parsePublicKeyResponse(body)
+			if err != nil || len(keys) != 1 || ssh.FingerprintSHA256(keys[0]) != ssh.FingerprintSHA256(signer) {
+				t.Fatalf("parsePublicKeyResponse: check fail with signer key only (%v, %v)", keys, err)
+			}
+		})
+	t.Run(
+		"Invalid key",
+		func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{"public_keys": []string{authorizedKey(signer), "invalid"}})
+			if _, err := parsePublicKeyResponse(body); err == nil {
+				t.Fatalf("parsePublicKeyResponse: check fail with invalid key")
+			}
+		})
+}
@@ -0,0 +1,135 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/spf13/cobra"
+)
+
+// certApproveCmd represents the certApprove command
+var certApproveCmd = &cobra.Command{
+	Use:   "cert-approve [request-id]",
+	Short: "Approves a certificate request waiting for approval",
+	Long: `
+
+Approves a certificate request waiting for approval. Certificate requests
+authorized only by roles configured to require approval must be approved
+by a second person before the certificate is issued.
+
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		decideCertRequest(args[0], "approve")
+	},
+}
+
+// decideCertRequest sends the decision (approve or deny) about a certificate request to GSH API
+func decideCertRequest(requestID string, decision string) {
+	// Get current target
+	currentTarget := config.GetCurrentTarget()
+
+	// Get OIDC HTTP Client
+	oauth2Token, err := auth.RecoverToken(currentTarget)
+	if err != nil {
+		fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+
+	// Setting custom HTTP client with timeouts
+	var netTransport = &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout: 10 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     config.TLSClientConfig(),
+	}
+	var netClient = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: config.TargetTransport(netTransport, currentTarget),
+	}
+
+	// Make GSH request
+	req, err := http.NewRequest("POST", currentTarget.Endpoint+"/approvals/"+requestID+"/"+decision, nil)
+	if err != nil {
+		fmt.Printf("Client error pre %s request: (%s)\n", decision, err.Error())
+		os.Exit(1)
+	}
+	req.Header.Set("Authorization", "JWT "+oauth2Token.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := netClient.Do(req)
+	if err != nil {
+		fmt.Printf("Client error post %s request: (%s)\n", decision, err.Error())
+		os.Exit(1)
+	}
+
+	// Read body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("Client error reading %s response: (%s)\n", decision, err.Error())
+		os.Exit(1)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Client error checking http status response: (%v)\n", resp.StatusCode)
+	}
+	defer resp.Body.Close()
+
+	// Parse decision response
+	type DecisionResponse struct {
+		Details string `json:"details"`
+		Message string `json:"message"`
+		Result  string `json:"result"`
+	}
+
+	decisionResponse := new(DecisionResponse)
+	if err := json.Unmarshal(body, &decisionResponse); err != nil {
+		fmt.Printf("Client error parsing %s response: (%s)\n", decision, err.Error())
+		os.Exit(1)
+	}
+
+	if decisionResponse.Result == "fail" {
+		fmt.Printf("Client error calling GSH API: (%v)\n", decisionResponse)
+		os.Exit(1)
+	}
+	fmt.Println(decisionResponse.Message)
+}
+
+func init() {
+	rootCmd.AddCommand(certApproveCmd)
+}
@@ -0,0 +1,264 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/tablecli"
+	"golang.org/x/crypto/ssh"
+)
+
+// certBatchCmd represents the certBatch command
+var certBatchCmd = &cobra.Command{
+	Use:   "cert-batch [host...]",
+	Short: "Requests certificates for many hosts in one round trip",
+	Long: `
+
+Requests certificates for many hosts at once, to bootstrap them, in a single
+request to GSH API. One key pair is generated, as by [[gsh host-connect]], and
+each host gets its own certificate, authorized on its own: some hosts can be
+issued while others are denied. The files of each issued certificate are
+printed, ssh sessions are not opened. GSH API limits the hosts of a batch
+(cert_batch_limit).
+
+	gsh cert-batch 198.51.100.10 198.51.100.11 web.example.com
+	gsh cert-batch --username deploy 198.51.100.10 198.51.100.11
+	`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		port, err := cmd.Flags().GetString("port")
+		if err != nil {
+			fmt.Printf("Client error parsing port option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+		username, err := cmd.Flags().GetString("username")
+		if err != nil {
+			fmt.Printf("Client error parsing username option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if username == "" {
+			username = currentTarget.DefaultUsername
+		}
+		if username == "" {
+			fmt.Println("Client error: remote user not set, use --username or a target default username")
+			os.Exit(1)
+		}
+
+		// Source ip of requests, as discovered by host-connect
+		u, err := url.Parse(currentTarget.Endpoint)
+		if err != nil {
+			fmt.Printf("Client error parsing URL endpoint: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		dial := func(address string) (net.Conn, error) {
+			return net.DialTimeout("tcp", address, time.Second)
+		}
+		localIP, err := outboundIP([]string{endpointAddress(u)}, 1, dial, net.InterfaceAddrs)
+		if err != nil {
+			fmt.Printf("Client error discovering local ip address: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Generate the key pair shared by the certificates of the batch
+		privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			fmt.Printf("Client error generating RSA keys: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			fmt.Printf("Client error converting RSA to SSH keys: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		privateKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}))
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Setting custom HTTP client with timeouts, signing a batch can take a while
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   120 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		// key proof nonces are single-use, each request has its own, all asked in one request
+		nonces, _ := fetchChallenges(netClient, currentTarget.Endpoint, oauth2Token.AccessToken, len(args))
+
+		// Each host is a request of its own, resolved and proven as host-connect does
+		certRequests := []types.CertRequest{}
+		for i, host := range args {
+			resolveCtx, cancelResolve := context.WithTimeout(context.Background(), 10*time.Second)
+			remoteIP, err := resolveHost(resolveCtx, config.Resolver(currentTarget), host)
+			cancelResolve()
+			if err != nil {
+				fmt.Printf("Client error resolving remote host %s: (%s)\n", host, err.Error())
+				os.Exit(1)
+			}
+			certRequest := types.CertRequest{
+				Key:        string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+				RemoteHost: remoteIP,
+				RemotePort: port,
+				RemoteUser: username,
+				UserIP:     localIP.String(),
+			}
+			nonce := ""
+			if i < len(nonces) {
+				nonce = nonces[i]
+			}
+			if err := signKeyProof(&certRequest, signer, nonce, time.Now()); err != nil {
+				fmt.Printf("Client error signing key proof: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			certRequests = append(certRequests, certRequest)
+		}
+
+		results, err := postCertBatch(netClient, currentTarget.Endpoint, oauth2Token.AccessToken, certRequests)
+		if err != nil {
+			fmt.Printf("Client error requesting certificate batch: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Write the files of each issued certificate, partial success is reported per host
+		failed := false
+		table := tablecli.Table{Headers: tablecli.Row([]string{"Host", "Result", "Certificate"})}
+		for i, result := range results {
+			certResponse, err := batchCertificate(result)
+			if err == nil {
+				_, certFile, writeErr := files.WriteKeys(privateKeyPEM, certResponse.Certificate)
+				if writeErr == nil {
+					table.AddRow(tablecli.Row([]string{args[i], "issued", certFile}))
+					continue
+				}
+				err = writeErr
+			}
+			failed = true
+			table.AddRow(tablecli.Row([]string{args[i], "fail", err.Error()}))
+		}
+		fmt.Println(table.String())
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// postCertBatch posts certRequests to GSH API batch endpoint, returning a result for each of them
+func postCertBatch(netClient *http.Client, endpoint string, accessToken string, certRequests []types.CertRequest) ([]types.CertBatchResult, error) {
+	certRequestsJSON, err := json.Marshal(certRequests)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", endpoint+"/certificates/batch", bytes.NewBuffer(certRequestsJSON))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "JWT "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := map[string]string{}
+		_ = json.Unmarshal(body, &errorResponse)
+		return nil, fmt.Errorf("GSH API status response %d (%s %s)", resp.StatusCode, errorResponse["message"], errorResponse["details"])
+	}
+	results := []types.CertBatchResult{}
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil, fmt.Errorf("parsing batch response (%v)", err)
+	}
+	if len(results) != len(certRequests) {
+		return nil, fmt.Errorf("batch response has %d results for %d requests", len(results), len(certRequests))
+	}
+	return results, nil
+}
+
+// batchCertificate returns the issued certificate of a batch result, or why it was not issued
+func batchCertificate(result types.CertBatchResult) (*types.CertResponse, error) {
+	if result.Status != http.StatusOK {
+		errorResponse := map[string]string{}
+		_ = json.Unmarshal(result.Response, &errorResponse)
+		return nil, fmt.Errorf("status %d (%s %s)", result.Status, errorResponse["message"], errorResponse["details"])
+	}
+	certResponse := new(types.CertResponse)
+	if err := json.Unmarshal(result.Response, certResponse); err != nil {
+		return nil, fmt.Errorf("parsing certificate response (%v)", err)
+	}
+	if certResponse.Certificate == "" {
+		return nil, fmt.Errorf("certificate response without certificate")
+	}
+	return certResponse, nil
+}
+
+func init() {
+	rootCmd.AddCommand(certBatchCmd)
+
+	certBatchCmd.Flags().StringP("username", "u", "", "Defines the remote user of certificates (default is target default username)")
+	certBatchCmd.Flags().StringP("port", "p", "22", "Defines the destination port of certificate requests")
+}
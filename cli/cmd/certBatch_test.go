@@ -0,0 +1,88 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestPostCertBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/certificates/batch" || r.Header.Get("Authorization") != "JWT token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var certRequests []types.CertRequest
+		if err := json.NewDecoder(r.Body).Decode(&certRequests); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		results := []types.CertBatchResult{}
+		for _, certRequest := range certRequests {
+			if certRequest.RemoteHost == "10.0.0.1" {
+				response, _ := json.Marshal(types.CertResponse{Result: "success", Certificate: "ssh-rsa-cert-v01@openssh.com AAAA"})
+				results = append(results, types.CertBatchResult{Status: http.StatusOK, Response: response})
+				continue
+			}
+			response, _ := json.Marshal(map[string]string{"result": "fail", "message": "You don't have permission to request this certificate"})
+			results = append(results, types.CertBatchResult{Status: http.StatusForbidden, Response: response})
+		}
+		_ = json.NewEncoder(w).Encode(results)
+	}))
+	defer server.Close()
+
+	t.Run(
+		"Mixed results",
+		func(t *testing.T) {
+			results, err := postCertBatch(server.Client(), server.URL, "token", []types.CertRequest{{RemoteHost: "10.0.0.1"}, {RemoteHost: "10.0.0.2"}})
+			if err != nil || len(results) != 2 {
+				t.Fatalf("postCertBatch: check fail with mixed batch (%v, %v)", results, err)
+			}
+			certResponse, err := batchCertificate(results[0])
+			if err != nil || certResponse.Certificate != "ssh-rsa-cert-v01@openssh.com AAAA" {
+				t.Fatalf("batchCertificate: check fail with issued certificate (%v, %v)", certResponse, err)
+			}
+			if _, err := batchCertificate(results[1]); err == nil {
+				t.Fatalf("batchCertificate: check fail with denied certificate")
+			}
+		})
+	t.Run(
+		"Batch refused",
+		func(t *testing.T) {
+			if _, err := postCertBatch(server.Client(), server.URL, "other", []types.CertRequest{{RemoteHost: "10.0.0.1"}}); err == nil {
+				t.Fatalf("postCertBatch: check fail with refused batch")
+			}
+		})
+}
@@ -0,0 +1,127 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/approvals"
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+)
+
+// certFetchCmd represents the certFetch command
+var certFetchCmd = &cobra.Command{
+	Use:   "cert-fetch [request-id]",
+	Short: "Fetches the certificate of an approved certificate request",
+	Long: `
+
+Fetches the certificate of a certificate request created by [[gsh host-connect]]
+that was waiting for approval. The certificate is stored beside the private key
+generated at request time, and the ssh command to use them is printed.
+
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		// Make GSH request
+		statusCode, body, err := approvals.Fetch(netClient, currentTarget.Endpoint, oauth2Token.AccessToken, args[0])
+		if err != nil {
+			fmt.Printf("Client error get certificate request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Parse certificate response
+		certResponse := new(types.CertResponse)
+		if err := json.Unmarshal(body, &certResponse); err != nil {
+			fmt.Printf("Client error parsing certificate response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if statusCode == http.StatusAccepted {
+			fmt.Printf("Certificate request %s is still waiting for approval\n", args[0])
+			os.Exit(1)
+		}
+		if statusCode != http.StatusOK {
+			fmt.Printf("Client error checking http status response: (%d)\n\n%s\n", statusCode, certResponse.Message)
+			os.Exit(1)
+		}
+
+		// Write certificate beside private key stored at request time
+		keyFile, certFile, err := files.WritePendingCert(args[0], certResponse.Certificate)
+		if err != nil {
+			fmt.Printf("Client error writing certificate files: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		knownHostsFile, err := files.KnownHostsPath()
+		if err != nil {
+			fmt.Printf("Client error getting known_hosts path: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("ssh -i %s -i %s -o UserKnownHostsFile=%s -o StrictHostKeyChecking=accept-new -l %s %s\n",
+			keyFile, certFile, knownHostsFile, certResponse.RemoteUser, certResponse.RemoteHost)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(certFetchCmd)
+}
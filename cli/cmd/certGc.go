@@ -0,0 +1,97 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/spf13/cobra"
+)
+
+// certGcCmd represents the certGc command
+var certGcCmd = &cobra.Command{
+	Use:   "cert-gc",
+	Short: "Removes expired certificates from the local certificates folder",
+	Long: `
+
+Removes expired certificates, with their private keys, from the certificates
+folder of every target (~/.gsh/certs). With --orphans, private keys without
+certificate older than a day are removed too (keys of requests waiting for
+approval are newer). With --dry-run the files are only listed.
+
+	gsh cert-gc
+	gsh cert-gc --orphans --dry-run
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			fmt.Printf("Client error parsing dry-run option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		orphans, err := cmd.Flags().GetBool("orphans")
+		if err != nil {
+			fmt.Printf("Client error parsing orphans option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		path, err := files.CertsPath()
+		if err != nil {
+			fmt.Printf("Client error getting certificates path: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		cleanup, err := files.CleanCerts(path, time.Now(), orphans, dryRun)
+		if err != nil {
+			fmt.Printf("Client error removing expired certificates: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		if dryRun {
+			for _, file := range cleanup.Files {
+				fmt.Println(file)
+			}
+			fmt.Printf("%d expired certificates and %d orphaned keys would be removed (%d files)\n", cleanup.Expired, cleanup.Orphans, len(cleanup.Files))
+			return
+		}
+		fmt.Printf("%d expired certificates and %d orphaned keys removed (%d files)\n", cleanup.Expired, cleanup.Orphans, len(cleanup.Files))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(certGcCmd)
+
+	certGcCmd.Flags().Bool("dry-run", false, "Lists the files to be removed without removing them")
+	certGcCmd.Flags().Bool("orphans", false, "Also removes private keys without certificate older than a day")
+}
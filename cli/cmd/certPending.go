@@ -0,0 +1,148 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/tablecli"
+)
+
+// certPendingCmd represents the certPending command
+var certPendingCmd = &cobra.Command{
+	Use:   "cert-pending",
+	Short: "List certificate requests waiting for approval",
+	Long: `
+
+List certificate requests waiting for approval at GSH API. Use
+[[gsh cert-approve]] or [[gsh cert-deny]] to decide each request.
+
+	`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Make GSH request
+		req, err := http.NewRequest("GET", currentTarget.Endpoint+"/approvals", nil)
+		if err != nil {
+			fmt.Printf("Client error pre approvals request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		req.Header.Set("Authorization", "JWT "+oauth2Token.AccessToken)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := netClient.Do(req)
+		if err != nil {
+			fmt.Printf("Client error get approvals request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Read body
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			fmt.Printf("Client error reading approvals response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Client error checking http status response: (%v)\n", resp.StatusCode)
+		}
+		defer resp.Body.Close()
+
+		// Parse approvals response
+		type ApprovalsResponse struct {
+			Details  string               `json:"details"`
+			Message  string               `json:"message"`
+			Result   string               `json:"result"`
+			Requests []types.CertApproval `json:"requests"`
+		}
+
+		approvalsResponse := new(ApprovalsResponse)
+		if err := json.Unmarshal(body, &approvalsResponse); err != nil {
+			fmt.Printf("Client error parsing approvals response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Check response
+		if approvalsResponse.Result == "fail" {
+			fmt.Printf("Client error calling GSH API: (%v)\n", approvalsResponse)
+			os.Exit(1)
+		}
+
+		table := tablecli.Table{Headers: tablecli.Row([]string{"Request ID", "Requester", "Remote user", "Remote host", "User IP", "Roles", "Expires at"})}
+		for _, request := range approvalsResponse.Requests {
+			table.AddRow(tablecli.Row([]string{
+				request.UID.String(),
+				request.Requester,
+				request.RemoteUser,
+				request.RemoteHost,
+				request.UserIP,
+				request.Roles,
+				request.ExpiresAt.Format(time.RFC3339),
+			}))
+		}
+		fmt.Println(table.String())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(certPendingCmd)
+}
@@ -0,0 +1,142 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/spf13/cobra"
+)
+
+// certValidateCmd represents the certValidate command
+var certValidateCmd = &cobra.Command{
+	Use:   "cert-validate [file]",
+	Short: "Validates a certificate against GSH API",
+	Long: `
+
+Validates a certificate file (usually *-cert.pub) against GSH API. The
+certificate is valid when it was signed by the CA of current target, is
+inside its validity window and was not revoked.
+
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		// Read certificate file
+		certificate, err := os.ReadFile(filepath.Clean(args[0]))
+		if err != nil {
+			fmt.Printf("Client error reading certificate file: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		// Prepare validate request
+		type ValidateRequest struct {
+			Certificate string `json:"certificate"`
+		}
+		validateRequest := ValidateRequest{Certificate: string(certificate)}
+		validateRequestJSON, err := json.Marshal(validateRequest)
+		if err != nil {
+			fmt.Printf("Client error formating json: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Make GSH request
+		req, err := http.NewRequest("POST", currentTarget.Endpoint+"/certificates/validate", bytes.NewBuffer(validateRequestJSON))
+		if err != nil {
+			fmt.Printf("Client error pre validate request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := netClient.Do(req)
+		if err != nil {
+			fmt.Printf("Client error post validate request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Read body
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			fmt.Printf("Client error reading validate response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Client error checking http status response: (%d)\n\n%s\n", resp.StatusCode, body)
+			os.Exit(1)
+		}
+
+		// Parse validate response
+		type ValidateResponse struct {
+			Result string `json:"result"`
+			Valid  bool   `json:"valid"`
+			Reason string `json:"reason"`
+		}
+		validateResponse := new(ValidateResponse)
+		if err := json.Unmarshal(body, &validateResponse); err != nil {
+			fmt.Printf("Client error parsing validate response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		if !validateResponse.Valid {
+			fmt.Printf("Certificate is not valid: %s\n", validateResponse.Reason)
+			os.Exit(1)
+		}
+		fmt.Println("Certificate is valid")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(certValidateCmd)
+}
@@ -0,0 +1,120 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+// Keystore is a store of client certificates with their private keys, as the OS keystore of
+// managed devices
+type Keystore interface {
+	Certificates() ([]tls.Certificate, error)
+}
+
+// SystemKeystore is the OS keystore, nil at platforms without a keystore provider
+var SystemKeystore Keystore
+
+// ClientCertificate returns the client certificate presented by gsh to GSH API of target: the one at
+// keystore selected by client_cert_subject and client_cert_issuer or else, without a selection or a
+// keystore, the one at client_cert and client_key files. It returns nil when target has none.
+func ClientCertificate(target *types.Target, keystore Keystore, now time.Time) (*tls.Certificate, error) {
+	if (target.ClientCertSubject != "" || target.ClientCertIssuer != "") && keystore != nil {
+		certificates, err := keystore.Certificates()
+		if err != nil {
+			return nil, fmt.Errorf("reading keystore: %s", err.Error())
+		}
+		certificate, err := selectCertificate(certificates, target.ClientCertSubject, target.ClientCertIssuer, now)
+		if err == nil || target.ClientCert == "" {
+			return certificate, err
+		}
+	}
+	if target.ClientCert == "" {
+		if target.ClientCertSubject != "" || target.ClientCertIssuer != "" {
+			return nil, errors.New("no keystore to select client certificate and no client_cert file")
+		}
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(target.ClientCert, target.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading client certificate: %s", err.Error())
+	}
+	return &certificate, nil
+}
+
+// selectCertificate returns the first certificate of certificates valid at now with subject and issuer,
+// matched by common name or distinguished name (an empty subject or issuer matches any certificate)
+func selectCertificate(certificates []tls.Certificate, subject string, issuer string, now time.Time) (*tls.Certificate, error) {
+	for i := range certificates {
+		leaf := certificates[i].Leaf
+		if leaf == nil && len(certificates[i].Certificate) > 0 {
+			leaf, _ = x509.ParseCertificate(certificates[i].Certificate[0])
+		}
+		if leaf == nil || now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+			continue
+		}
+		if subject != "" && subject != leaf.Subject.CommonName && subject != leaf.Subject.String() {
+			continue
+		}
+		if issuer != "" && issuer != leaf.Issuer.CommonName && issuer != leaf.Issuer.String() {
+			continue
+		}
+		return &certificates[i], nil
+	}
+	return nil, fmt.Errorf("no valid client certificate of subject %q and issuer %q at keystore", subject, issuer)
+}
+
+// clientCertTransport returns base presenting the client certificate of target when GSH API asks for
+// one (mTLS). Certificates are read at each handshake, as keystores renew them.
+func clientCertTransport(base http.RoundTripper, target *types.Target) http.RoundTripper {
+	transport, ok := base.(*http.Transport)
+	if !ok || (target.ClientCert == "" && target.ClientCertSubject == "" && target.ClientCertIssuer == "") {
+		return base
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		certificate, err := ClientCertificate(target, SystemKeystore, time.Now())
+		if certificate == nil && err == nil {
+			return &tls.Certificate{}, nil
+		}
+		return certificate, err
+	}
+	return transport
+}
@@ -0,0 +1,186 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+// fakeKeystore is a Keystore with fixed certificates
+type fakeKeystore []tls.Certificate
+
+func (k fakeKeystore) Certificates() ([]tls.Certificate, error) {
+	return k, nil
+}
+
+// newClientCertificate returns a certificate of subject issued by issuer (self-signed, only names matter)
+func newClientCertificate(t *testing.T, subject string, issuer string, notAfter time.Time) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ClientCertificate: fail generating key (%v)", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: subject, Organization: []string{"Globo"}},
+		Issuer:       pkix.Name{CommonName: issuer},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	parent := &x509.Certificate{Subject: pkix.Name{CommonName: issuer}}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("ClientCertificate: fail creating certificate (%v)", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// commonName returns the subject common name of certificate
+func commonName(t *testing.T, certificate *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("ClientCertificate: fail parsing certificate (%v)", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestClientCertificate(t *testing.T) {
+	now := time.Now()
+	keystore := fakeKeystore{
+		newClientCertificate(t, "laptop-01", "Personal CA", now.Add(time.Hour)),
+		newClientCertificate(t, "laptop-01", "Device CA", now.Add(-time.Minute)),
+		newClientCertificate(t, "laptop-02", "Device CA", now.Add(time.Hour)),
+		newClientCertificate(t, "laptop-01", "Device CA", now.Add(time.Hour)),
+	}
+
+	t.Run(
+		"Selected by subject and issuer",
+		func(t *testing.T) {
+			target := &types.Target{ClientCertSubject: "laptop-01", ClientCertIssuer: "Device CA"}
+			certificate, err := ClientCertificate(target, keystore, now)
+			if err != nil || certificate != &keystore[3] {
+				t.Fatalf("ClientCertificate: check fail selecting valid certificate (%v)", err)
+			}
+		})
+	t.Run(
+		"Selected by distinguished name",
+		func(t *testing.T) {
+			target := &types.Target{ClientCertSubject: "CN=laptop-02,O=Globo"}
+			certificate, err := ClientCertificate(target, keystore, now)
+			if err != nil || commonName(t, certificate) != "laptop-02" {
+				t.Fatalf("ClientCertificate: check fail selecting by DN (%v)", err)
+			}
+		})
+	t.Run(
+		"Not at keystore",
+		func(t *testing.T) {
+			target := &types.Target{ClientCertSubject: "laptop-03"}
+			if _, err := ClientCertificate(target, keystore, now); err == nil {
+				t.Fatalf("ClientCertificate: check fail with certificate not at keystore")
+			}
+			if _, err := ClientCertificate(target, nil, now); err == nil {
+				t.Fatalf("ClientCertificate: check fail without keystore")
+			}
+		})
+	t.Run(
+		"Fall back to files",
+		func(t *testing.T) {
+			dir := t.TempDir()
+			fileCertificate := newClientCertificate(t, "file", "Device CA", now.Add(time.Hour))
+			keyDER, err := x509.MarshalECPrivateKey(fileCertificate.PrivateKey.(*ecdsa.PrivateKey))
+			if err != nil {
+				t.Fatalf("ClientCertificate: fail marshalling key (%v)", err)
+			}
+			target := &types.Target{ClientCertSubject: "laptop-03", ClientCert: filepath.Join(dir, "cert.pem"), ClientKey: filepath.Join(dir, "key.pem")}
+			_ = os.WriteFile(target.ClientCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fileCertificate.Certificate[0]}), 0600)
+			_ = os.WriteFile(target.ClientKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+			for _, ks := range []Keystore{keystore, nil} {
+				certificate, err := ClientCertificate(target, ks, now)
+				if err != nil || commonName(t, certificate) != "file" {
+					t.Fatalf("ClientCertificate: check fail falling back to files (%v)", err)
+				}
+			}
+		})
+	t.Run(
+		"Without client certificate",
+		func(t *testing.T) {
+			if certificate, err := ClientCertificate(&types.Target{}, keystore, now); certificate != nil || err != nil {
+				t.Fatalf("ClientCertificate: check fail without client certificate (%v)", err)
+			}
+		})
+}
+
+func TestTargetTransportClientCertificate(t *testing.T) {
+	var presented string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	defer func(keystore Keystore) { SystemKeystore = keystore }(SystemKeystore)
+	SystemKeystore = fakeKeystore{
+		newClientCertificate(t, "laptop-02", "Device CA", time.Now().Add(time.Hour)),
+		newClientCertificate(t, "laptop-01", "Device CA", time.Now().Add(time.Hour)),
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	base := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	client := &http.Client{Transport: TargetTransport(base, &types.Target{ClientCertSubject: "laptop-01"})}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("TargetTransport: check fail with mTLS request (%v)", err)
+	}
+	resp.Body.Close()
+	if presented != "laptop-01" {
+		t.Fatalf("TargetTransport: check fail with client certificate presented (%q)", presented)
+	}
+	if base.TLSClientConfig.GetClientCertificate != nil {
+		t.Fatalf("TargetTransport: check fail, base transport changed")
+	}
+}
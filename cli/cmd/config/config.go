@@ -31,11 +31,13 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/globocom/gsh/types"
@@ -44,60 +46,178 @@ import (
 
 // DiscoveryResponse is struct with discovery data from GSH API
 type DiscoveryResponse struct {
-	BaseURL       string `json:"oidc_base_url"`
-	Realm         string `json:"oidc_realm"`
-	Audience      string `json:"oidc_audience"`
-	UsernameClaim string `json:"oidc_claim"`
+	BaseURL        string   `json:"oidc_base_url"`
+	Realm          string   `json:"oidc_realm"`
+	Audience       string   `json:"oidc_audience"`
+	UsernameClaim  string   `json:"oidc_claim"`
+	PrincipalClaim string   `json:"principal_claim"`
+	Issuer         string   `json:"oidc_issuer"`
+	HostCAKeys     []string `json:"host_ca_public_keys"`
+	Capabilities   []string `json:"capabilities"`
+	Mode           string   `json:"token_validation_mode"`
+	CertDuration   string   `json:"ca_signed_cert_duration"`
 }
 
 // GetCurrentTarget return a types.Target with current target
 func GetCurrentTarget() *types.Target {
-	// Get current target
-	currentTarget := new(types.Target)
-	targets := viper.GetStringMap("targets")
-	for k, v := range targets {
-		target := v.(map[string]interface{})
-
-		// check if target is properly configured
-		if target["current"] == nil {
-			fmt.Printf("You need to configure a target using target-add command\n")
-			os.Exit(1)
+	targets := Targets()
+	label, err := currentTargetLabel(targets)
+	if err != nil {
+		fmt.Printf("Client error getting current target: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	currentTarget := targetFromConfig(label, targets[label].(map[string]interface{}))
+
+	// check token storage
+	if currentTarget.TokenStorage == "" {
+		fmt.Printf("Token storage is not set. You can set it using the -s flag at 'gsh login' command\n")
+	}
+	applyTargetFlags(currentTarget)
+	return currentTarget
+}
+
+// currentTargetLabel returns the label of the target marked current at targets. When none is
+// current and targets has exactly one target, it is selected as current.
+func currentTargetLabel(targets map[string]interface{}) (string, error) {
+	labels := []string{}
+	for label, v := range targets {
+		target, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if current, _ := target["current"].(bool); current {
+			return label, nil
+		}
+		labels = append(labels, label)
+	}
+	switch len(labels) {
+	case 0:
+		return "", errors.New("no target configured; run gsh target-add")
+	case 1:
+		return labels[0], nil
+	}
+	return "", errors.New("no current target set; run gsh target-set")
+}
+
+// GetTarget returns the target named label, with --account and --header flags applied as GetCurrentTarget does
+func GetTarget(label string) (*types.Target, error) {
+	target, ok := Targets()[label].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("target does not exist: %s", label)
+	}
+	namedTarget := targetFromConfig(label, target)
+	applyTargetFlags(namedTarget)
+	return namedTarget, nil
+}
+
+// targetFromConfig returns the target named label from its config entry
+func targetFromConfig(label string, target map[string]interface{}) *types.Target {
+	namedTarget := &types.Target{Label: label}
+	namedTarget.Endpoint, _ = target["endpoint"].(string)
+	namedTarget.TokenStorage, _ = target["token-storage"].(string)
+
+	// default remote username for this target (optional)
+	if defaultUsername, ok := target["default_username"].(string); ok {
+		namedTarget.DefaultUsername = defaultUsername
+	}
+
+	// host CA public key trusted at managed known_hosts (optional)
+	if hostCAKey, ok := target["host_ca_key"].(string); ok {
+		namedTarget.HostCAKey = hostCAKey
+	}
+
+	// remote users expected at this target, checked before requesting certificates (optional)
+	if allowedUsers, ok := target["allowed_users"].([]interface{}); ok {
+		for _, allowedUser := range allowedUsers {
+			namedTarget.AllowedUsers = append(namedTarget.AllowedUsers, fmt.Sprint(allowedUser))
 		}
+	}
 
-		if target["current"] != nil {
-			// format output for activated target
-			if target["current"].(bool) {
-				currentTarget.Label = k
-				currentTarget.Endpoint = target["endpoint"].(string)
-
-				// check token storage
-				if target["token-storage"] == nil {
-					fmt.Printf("Token storage is not set. You can set it using the -s flag at 'gsh login' command\n")
-					currentTarget.TokenStorage = ""
-				} else {
-					currentTarget.TokenStorage = target["token-storage"].(string)
-				}
+	// DNS server resolving remote hosts, as seen by GSH API in split-horizon DNS (optional)
+	if dnsResolver, ok := target["dns_resolver"].(string); ok {
+		namedTarget.DNSResolver = dnsResolver
+	}
+
+	// prefix of request ids sent to GSH API, to find requests of this target at logs (optional)
+	if requestIDPrefix, ok := target["request_id_prefix"].(string); ok {
+		namedTarget.RequestIDPrefix = SanitizeRequestIDPrefix(requestIDPrefix)
+	}
+
+	// OIDC client id (audience) of tokens requested for this target, instead of oidc_audience (optional)
+	if audience, ok := target["audience"].(string); ok {
+		namedTarget.Audience = strings.TrimSpace(audience)
+	}
+
+	// client certificate presented to GSH API (mTLS), selected at OS keystore by subject and issuer
+	// or read from files, used when the keystore has no such certificate (optional)
+	namedTarget.ClientCertSubject, _ = target["client_cert_subject"].(string)
+	namedTarget.ClientCertIssuer, _ = target["client_cert_issuer"].(string)
+	namedTarget.ClientCert, _ = target["client_cert"].(string)
+	namedTarget.ClientKey, _ = target["client_key"].(string)
+
+	// host aliases ([user@]host[:port] by name), the ones of target override global aliases (optional)
+	for name, value := range viper.GetStringMapString("aliases") {
+		if namedTarget.Aliases == nil {
+			namedTarget.Aliases = map[string]string{}
+		}
+		namedTarget.Aliases[name] = value
+	}
+	if aliases, ok := target["aliases"].(map[string]interface{}); ok {
+		for name, value := range aliases {
+			if namedTarget.Aliases == nil {
+				namedTarget.Aliases = map[string]string{}
 			}
+			namedTarget.Aliases[name] = fmt.Sprint(value)
 		}
 	}
-	return currentTarget
+
+	// extra headers sent to GSH API, as required by some gateways (optional)
+	if extraHeaders, ok := target["extra_headers"].(map[string]interface{}); ok {
+		namedTarget.ExtraHeaders = map[string]string{}
+		for name, value := range extraHeaders {
+			namedTarget.ExtraHeaders[name] = fmt.Sprint(value)
+		}
+	}
+	return namedTarget
 }
 
-// Discovery makes GET /status/config request to GSH API to get OIDC configuration
+// applyTargetFlags sets --account and --header flags at target
+func applyTargetFlags(target *types.Target) {
+	// account selected with --account flag, empty for the unnamed account
+	target.Account = viper.GetString("account")
+
+	// headers set with --header flag replace the ones configured at target
+	headers, err := ParseHeaders(flagHeaders)
+	if err != nil {
+		fmt.Printf("Client error parsing header option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	for name, value := range headers {
+		if target.ExtraHeaders == nil {
+			target.ExtraHeaders = map[string]string{}
+		}
+		target.ExtraHeaders[name] = value
+	}
+}
+
+// Discovery makes GET /status/config request to GSH API of current target to get OIDC configuration
 func Discovery() (*DiscoveryResponse, error) {
-	// Get current target
-	currentTarget := GetCurrentTarget()
+	return DiscoveryFor(GetCurrentTarget())
+}
 
+// DiscoveryFor makes GET /status/config request to GSH API of target to get OIDC configuration
+func DiscoveryFor(currentTarget *types.Target) (*DiscoveryResponse, error) {
 	// Setting custom HTTP client with timeouts
 	var netTransport = &http.Transport{
 		Dial: (&net.Dialer{
 			Timeout: 10 * time.Second,
 		}).Dial,
 		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     TLSClientConfig(),
 	}
 	var netClient = &http.Client{
 		Timeout:   10 * time.Second,
-		Transport: netTransport,
+		Transport: TargetTransport(netTransport, currentTarget),
 	}
 
 	// Making discovery GSH request
@@ -114,7 +234,7 @@ func Discovery() (*DiscoveryResponse, error) {
 	}
 	if resp.StatusCode != http.StatusOK {
 		fmt.Printf("GSH API status response error: %v\n", resp.StatusCode)
-		return nil, err
+		return nil, fmt.Errorf("GSH API status response %d", resp.StatusCode)
 	}
 	configResponse := new(DiscoveryResponse)
 	if err := json.Unmarshal(body, &configResponse); err != nil {
@@ -0,0 +1,77 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"testing"
+)
+
+func TestCurrentTargetLabel(t *testing.T) {
+	t.Run(
+		"Zero targets",
+		func(t *testing.T) {
+			if label, err := currentTargetLabel(map[string]interface{}{}); err == nil {
+				t.Fatalf("currentTargetLabel: check fail without targets (%s)", label)
+			}
+		})
+	t.Run(
+		"One target is selected",
+		func(t *testing.T) {
+			targets := map[string]interface{}{
+				"prod": map[string]interface{}{"endpoint": "https://gsh.example.com", "current": false},
+			}
+			if label, err := currentTargetLabel(targets); err != nil || label != "prod" {
+				t.Fatalf("currentTargetLabel: check fail selecting the only target (%s, %v)", label, err)
+			}
+		})
+	t.Run(
+		"Current target",
+		func(t *testing.T) {
+			targets := map[string]interface{}{
+				"prod": map[string]interface{}{"endpoint": "https://gsh.example.com", "current": false},
+				"dev":  map[string]interface{}{"endpoint": "https://gsh.dev.example.com", "current": true},
+			}
+			if label, err := currentTargetLabel(targets); err != nil || label != "dev" {
+				t.Fatalf("currentTargetLabel: check fail with current target (%s, %v)", label, err)
+			}
+		})
+	t.Run(
+		"Multiple targets and none current",
+		func(t *testing.T) {
+			targets := map[string]interface{}{
+				"prod": map[string]interface{}{"endpoint": "https://gsh.example.com", "current": false},
+				"dev":  map[string]interface{}{"endpoint": "https://gsh.dev.example.com"},
+			}
+			label, err := currentTargetLabel(targets)
+			if err == nil || err.Error() != "no current target set; run gsh target-set" {
+				t.Fatalf("currentTargetLabel: check fail without current target (%s, %v)", label, err)
+			}
+		})
+}
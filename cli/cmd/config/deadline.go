@@ -0,0 +1,88 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// commandContext bounds the network calls of the running command, with the deadline of --deadline
+var commandContext = context.Background()
+
+// commandTimeout is the duration set with --deadline, shown when it expires
+var commandTimeout time.Duration
+
+// SetDeadline bounds the network calls of the running command (--deadline) to timeout from now.
+// The returned function releases it.
+func SetDeadline(timeout time.Duration) context.CancelFunc {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	commandContext, commandTimeout = ctx, timeout
+	return func() {
+		cancel()
+		commandContext, commandTimeout = context.Background(), 0
+	}
+}
+
+// Context returns the context of the running command, done when --deadline expires
+func Context() context.Context {
+	return commandContext
+}
+
+// DeadlineError returns the error of a call made after or aborted by --deadline expiration, nil
+// when it did not expire
+func DeadlineError() error {
+	if !errors.Is(commandContext.Err(), context.DeadlineExceeded) {
+		return nil
+	}
+	return fmt.Errorf("command deadline of %s exceeded", commandTimeout)
+}
+
+// deadlineTransport is a http.RoundTripper sending requests with the context of the running command
+type deadlineTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip sends req bounded by --deadline, unless it already has its own context
+func (t deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context() == context.Background() {
+		req = req.WithContext(commandContext)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		if deadlineErr := DeadlineError(); deadlineErr != nil {
+			return nil, deadlineErr
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,81 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestSetDeadline(t *testing.T) {
+	// slow GSH API, answering only after the client gives up
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: TargetTransport(http.DefaultTransport, &types.Target{Endpoint: server.URL})}
+
+	t.Run(
+		"Slow API triggers deadline",
+		func(t *testing.T) {
+			release := SetDeadline(100 * time.Millisecond)
+			defer release()
+
+			start := time.Now()
+			_, err := client.Get(server.URL + "/status/config")
+			if err == nil || !strings.Contains(err.Error(), "command deadline of 100ms exceeded") {
+				t.Fatalf("SetDeadline: check fail with slow API (%v)", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("SetDeadline: check fail aborting request (%s)", elapsed)
+			}
+			if DeadlineError() == nil {
+				t.Fatalf("SetDeadline: check fail with deadline error")
+			}
+		})
+	t.Run(
+		"Released deadline",
+		func(t *testing.T) {
+			if DeadlineError() != nil {
+				t.Fatalf("SetDeadline: check fail releasing deadline")
+			}
+			if _, ok := Context().Deadline(); ok {
+				t.Fatalf("SetDeadline: check fail with context deadline")
+			}
+		})
+}
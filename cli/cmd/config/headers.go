@@ -0,0 +1,149 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/globocom/gsh/types"
+	"github.com/labstack/gommon/random"
+)
+
+// flagHeaders are the headers set with --header flag, in "Name: value" format
+var flagHeaders []string
+
+// SetFlagHeaders keeps the headers set with --header flag, merged to target extra headers
+func SetFlagHeaders(headers []string) {
+	flagHeaders = headers
+}
+
+// ParseHeaders parses headers in "Name: value" format
+func ParseHeaders(headers []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, header := range headers {
+		field := strings.SplitN(header, ":", 2)
+		name := strings.TrimSpace(field[0])
+		if len(field) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, errors.New("ParseHeaders: header must be in \"Name: value\" format (" + header + ")")
+		}
+		parsed[name] = strings.TrimSpace(field[1])
+	}
+	return parsed, nil
+}
+
+// protectedHeaders are set by gsh and never replaced by extra headers. Expect is never sent:
+// request bodies are small, and some proxies hang requests waiting for 100-continue.
+var protectedHeaders = []string{"Authorization", "Content-Type", "Expect"}
+
+// headerTransport is a http.RoundTripper adding extra headers to requests
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+// RoundTrip sends a copy of req with the extra headers
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		name = http.CanonicalHeaderKey(name)
+		protected := false
+		for _, protectedHeader := range protectedHeaders {
+			if name == protectedHeader {
+				protected = true
+			}
+		}
+		if !protected {
+			req.Header.Set(name, value)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// HeaderTransport returns a http.RoundTripper that adds headers to every request sent with base,
+// without replacing Authorization and Content-Type headers set by gsh nor setting Expect
+func HeaderTransport(base http.RoundTripper, headers map[string]string) http.RoundTripper {
+	if len(headers) == 0 {
+		return base
+	}
+	return headerTransport{base: base, headers: headers}
+}
+
+// requestIDPrefixMaxLength bounds request_id_prefix, the request id is still generated after it
+const requestIDPrefixMaxLength = 32
+
+// SanitizeRequestIDPrefix keeps only letters, digits, '-', '_' and '.' of prefix, so it is safe
+// at X-Request-ID header and at GSH API logs
+func SanitizeRequestIDPrefix(prefix string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return -1
+	}, prefix)
+	if len(sanitized) > requestIDPrefixMaxLength {
+		sanitized = sanitized[:requestIDPrefixMaxLength]
+	}
+	return sanitized
+}
+
+// requestIDTransport is a http.RoundTripper setting X-Request-ID of requests with a prefix
+type requestIDTransport struct {
+	base   http.RoundTripper
+	prefix string
+}
+
+// RoundTrip sends a copy of req with a new request id, or the one already set, after the prefix
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	id := req.Header.Get("X-Request-ID")
+	if id == "" {
+		id = random.String(32)
+	}
+	if !strings.HasPrefix(id, t.prefix) {
+		id = t.prefix + id
+	}
+	req.Header.Set("X-Request-ID", id)
+	return t.base.RoundTrip(req)
+}
+
+// TargetTransport returns a http.RoundTripper adding the extra headers of target (as HeaderTransport)
+// and, when target has a request_id_prefix, a X-Request-ID starting with it, so requests of a target
+// are easy to find at GSH API logs. Requests are bounded by --deadline (see SetDeadline) and present
+// the client certificate of target, when it has one (see ClientCertificate).
+func TargetTransport(base http.RoundTripper, target *types.Target) http.RoundTripper {
+	base = deadlineTransport{base: clientCertTransport(base, target)}
+	if target.RequestIDPrefix != "" {
+		base = requestIDTransport{base: base, prefix: SanitizeRequestIDPrefix(target.RequestIDPrefix)}
+	}
+	return HeaderTransport(base, target.ExtraHeaders)
+}
@@ -0,0 +1,193 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestParseHeaders(t *testing.T) {
+	t.Run(
+		"Valid headers",
+		func(t *testing.T) {
+			headers, err := ParseHeaders([]string{"X-Api-Key: secret", "X-Tenant:gsh, prod"})
+			if err != nil || headers["X-Api-Key"] != "secret" || headers["X-Tenant"] != "gsh, prod" {
+				t.Fatalf("ParseHeaders: check fail with valid headers (%v, %v)", headers, err)
+			}
+		})
+	t.Run(
+		"Invalid headers",
+		func(t *testing.T) {
+			for _, header := range []string{"X-Api-Key", ": secret", "X Api Key: secret"} {
+				if _, err := ParseHeaders([]string{header}); err == nil {
+					t.Fatalf("ParseHeaders: check fail with invalid header (%s)", header)
+				}
+			}
+		})
+}
+
+func TestHeaderTransport(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+
+	headers := map[string]string{
+		"x-api-key":     "secret",
+		"X-Tenant":      "gsh",
+		"Authorization": "Basic clobber",
+		"content-type":  "text/plain",
+	}
+	client := &http.Client{Transport: HeaderTransport(http.DefaultTransport, headers)}
+	req, err := http.NewRequest("POST", server.URL, nil)
+	if err != nil {
+		t.Fatalf("HeaderTransport: check fail creating request (%v)", err)
+	}
+	req.Header.Set("Authorization", "JWT token")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("HeaderTransport: check fail sending request (%v)", err)
+	}
+	resp.Body.Close()
+
+	t.Run(
+		"Custom headers",
+		func(t *testing.T) {
+			if received.Get("X-Api-Key") != "secret" || received.Get("X-Tenant") != "gsh" {
+				t.Fatalf("HeaderTransport: check fail with custom headers (%v)", received)
+			}
+		})
+	t.Run(
+		"Protected headers",
+		func(t *testing.T) {
+			if received.Get("Authorization") != "JWT token" || received.Get("Content-Type") != "application/json" {
+				t.Fatalf("HeaderTransport: check fail with protected headers (%v)", received)
+			}
+		})
+	t.Run(
+		"Original request",
+		func(t *testing.T) {
+			if req.Header.Get("X-Api-Key") != "" {
+				t.Fatalf("HeaderTransport: check fail changing original request (%v)", req.Header)
+			}
+		})
+}
+
+// recordTransport keeps the last request sent through it, answering 200
+type recordTransport struct {
+	req *http.Request
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestHeaderTransportExpect(t *testing.T) {
+	base := &recordTransport{}
+	client := &http.Client{Transport: HeaderTransport(base, map[string]string{"expect": "100-continue", "X-Tenant": "gsh"})}
+	req, err := http.NewRequest("POST", "https://gsh.example.com/certificates", strings.NewReader(`{"key":"small"}`))
+	if err != nil {
+		t.Fatalf("HeaderTransport: check fail creating request (%v)", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("HeaderTransport: check fail sending request (%v)", err)
+	}
+	resp.Body.Close()
+	if base.req.Header.Get("Expect") != "" || base.req.Header.Get("X-Tenant") != "gsh" {
+		t.Fatalf("HeaderTransport: check fail with Expect header (%v)", base.req.Header)
+	}
+}
+
+func TestSanitizeRequestIDPrefix(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"":                      "",
+		"tenant-a.":             "tenant-a.",
+		"tenant a\r\n:x/":       "tenantax",
+		strings.Repeat("a", 40): strings.Repeat("a", requestIDPrefixMaxLength),
+	} {
+		if got := SanitizeRequestIDPrefix(prefix); got != want {
+			t.Fatalf("SanitizeRequestIDPrefix: check fail with %q (%q)", prefix, got)
+		}
+	}
+}
+
+func TestTargetTransport(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+	send := func(t *testing.T, target *types.Target) {
+		client := &http.Client{Transport: TargetTransport(http.DefaultTransport, target)}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("TargetTransport: check fail sending request (%v)", err)
+		}
+		resp.Body.Close()
+	}
+
+	t.Run(
+		"Request id carries the prefix",
+		func(t *testing.T) {
+			send(t, &types.Target{RequestIDPrefix: "tenant a-", ExtraHeaders: map[string]string{"X-Tenant": "a"}})
+			id := received.Get("X-Request-ID")
+			if !strings.HasPrefix(id, "tenanta-") || len(id) <= len("tenanta-") {
+				t.Fatalf("TargetTransport: check fail with request id (%q)", id)
+			}
+			if received.Get("X-Tenant") != "a" {
+				t.Fatalf("TargetTransport: check fail with extra headers (%v)", received)
+			}
+		})
+	t.Run(
+		"Request id of extra headers is prefixed",
+		func(t *testing.T) {
+			send(t, &types.Target{RequestIDPrefix: "tenant-a-", ExtraHeaders: map[string]string{"X-Request-ID": "fixed"}})
+			if id := received.Get("X-Request-ID"); id != "tenant-a-fixed" {
+				t.Fatalf("TargetTransport: check fail with request id of extra headers (%q)", id)
+			}
+		})
+	t.Run(
+		"Without prefix",
+		func(t *testing.T) {
+			send(t, &types.Target{})
+			if id := received.Get("X-Request-ID"); id != "" {
+				t.Fatalf("TargetTransport: check fail, request id sent without prefix (%q)", id)
+			}
+		})
+}
@@ -0,0 +1,117 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// SystemConfigFile is the system-wide config, where admins provision targets for every user.
+// GSH_SYSTEM_CONFIG environment variable replaces it.
+const SystemConfigFile = "/etc/gsh/config.yaml"
+
+// systemTargets are the targets read from system-wide config
+var systemTargets = map[string]interface{}{}
+
+// LoadSystemConfig reads the targets of system-wide config file. A missing file is not an error.
+func LoadSystemConfig(file string) error {
+	systemTargets = map[string]interface{}{}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+	system := viper.New()
+	system.SetConfigFile(file)
+	system.SetConfigType("yaml")
+	if err := system.ReadInConfig(); err != nil {
+		return err
+	}
+	systemTargets = system.GetStringMap("targets")
+	return nil
+}
+
+// Targets returns system-wide targets merged with the targets of user config
+func Targets() map[string]interface{} {
+	return MergeTargets(systemTargets, viper.GetStringMap("targets"))
+}
+
+// IsSystemTarget tells whether target is provided by system-wide config. User config can
+// override its fields, but can't remove it.
+func IsSystemTarget(name string) bool {
+	_, ok := systemTargets[name]
+	return ok
+}
+
+// MergeTargets merges system and user targets into a new map. Fields set by user override the
+// ones of a system target with the same name. When any user target is current, current flags of
+// system targets are ignored, so target-set at user config always wins.
+func MergeTargets(system map[string]interface{}, user map[string]interface{}) map[string]interface{} {
+	userCurrent := false
+	for _, v := range user {
+		if target, ok := v.(map[string]interface{}); ok && target["current"] == true {
+			userCurrent = true
+		}
+	}
+
+	merged := map[string]interface{}{}
+	for name, v := range system {
+		target, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mergedTarget := map[string]interface{}{"current": false}
+		for field, value := range target {
+			mergedTarget[field] = value
+		}
+		if userCurrent {
+			mergedTarget["current"] = false
+		}
+		merged[name] = mergedTarget
+	}
+	for name, v := range user {
+		target, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mergedTarget, ok := merged[name].(map[string]interface{})
+		if !ok {
+			mergedTarget = map[string]interface{}{}
+		}
+		for field, value := range target {
+			mergedTarget[field] = value
+		}
+		if mergedTarget["current"] == nil {
+			mergedTarget["current"] = false
+		}
+		merged[name] = mergedTarget
+	}
+	return merged
+}
@@ -0,0 +1,129 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeTargets(t *testing.T) {
+	system := map[string]interface{}{
+		"prod": map[string]interface{}{"endpoint": "https://gsh.example.com", "current": true, "token-storage": "file"},
+		"lab":  map[string]interface{}{"endpoint": "https://gsh-lab.example.com"},
+	}
+
+	t.Run(
+		"System targets without user config",
+		func(t *testing.T) {
+			merged := MergeTargets(system, map[string]interface{}{})
+			if len(merged) != 2 {
+				t.Fatalf("MergeTargets: check fail targets (%v)", merged)
+			}
+			if merged["prod"].(map[string]interface{})["current"] != true {
+				t.Fatalf("MergeTargets: check fail system current (%v)", merged["prod"])
+			}
+			if merged["lab"].(map[string]interface{})["current"] != false {
+				t.Fatalf("MergeTargets: check fail default current (%v)", merged["lab"])
+			}
+		})
+
+	t.Run(
+		"User fields override system fields",
+		func(t *testing.T) {
+			user := map[string]interface{}{
+				"prod": map[string]interface{}{"token-storage": "keychain"},
+			}
+			merged := MergeTargets(system, user)
+			prod := merged["prod"].(map[string]interface{})
+			if prod["token-storage"] != "keychain" || prod["endpoint"] != "https://gsh.example.com" {
+				t.Fatalf("MergeTargets: check fail override (%v)", prod)
+			}
+			if system["prod"].(map[string]interface{})["token-storage"] != "file" {
+				t.Fatalf("MergeTargets: check fail system targets changed (%v)", system["prod"])
+			}
+		})
+
+	t.Run(
+		"User current wins over system current",
+		func(t *testing.T) {
+			user := map[string]interface{}{
+				"dev": map[string]interface{}{"endpoint": "http://localhost:8000", "current": true},
+			}
+			merged := MergeTargets(system, user)
+			if merged["prod"].(map[string]interface{})["current"] != false {
+				t.Fatalf("MergeTargets: check fail system current (%v)", merged["prod"])
+			}
+			if merged["dev"].(map[string]interface{})["current"] != true {
+				t.Fatalf("MergeTargets: check fail user current (%v)", merged["dev"])
+			}
+		})
+
+	t.Run(
+		"Removed user targets are ignored",
+		func(t *testing.T) {
+			merged := MergeTargets(system, map[string]interface{}{"old": nil})
+			if _, ok := merged["old"]; ok || len(merged) != 2 {
+				t.Fatalf("MergeTargets: check fail removed target (%v)", merged)
+			}
+		})
+}
+
+func TestLoadSystemConfig(t *testing.T) {
+	defer func() { systemTargets = map[string]interface{}{} }()
+
+	t.Run(
+		"Missing file",
+		func(t *testing.T) {
+			if err := LoadSystemConfig(filepath.Join(t.TempDir(), "config.yaml")); err != nil {
+				t.Fatalf("LoadSystemConfig: check fail missing file (%v)", err)
+			}
+			if len(systemTargets) != 0 {
+				t.Fatalf("LoadSystemConfig: check fail targets (%v)", systemTargets)
+			}
+		})
+
+	t.Run(
+		"Targets are read-only",
+		func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			content := "targets:\n  prod:\n    endpoint: https://gsh.example.com\n    current: true\n"
+			if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+				t.Fatalf("LoadSystemConfig: check fail writing config (%v)", err)
+			}
+			if err := LoadSystemConfig(file); err != nil {
+				t.Fatalf("LoadSystemConfig: check fail reading config (%v)", err)
+			}
+			if !IsSystemTarget("prod") || IsSystemTarget("dev") {
+				t.Fatalf("LoadSystemConfig: check fail system targets (%v)", systemTargets)
+			}
+		})
+}
@@ -0,0 +1,176 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// connectProxyCmd represents the connectProxy command
+var connectProxyCmd = &cobra.Command{
+	Use:   "connect-proxy [host] [port]",
+	Short: "Requests a certificate and connects stdin/stdout to host (ssh ProxyCommand)",
+	Long: `
+
+Requests a certificate for host, as gsh host-connect --no-shell, then dials
+host:port and pipes stdin/stdout, so it is used as ssh ProxyCommand. The key
+and certificate are added to ssh-agent (while the certificate is valid) and
+written at the gsh config directory:
+
+	Host web01.example.com
+	    ProxyCommand gsh connect-proxy --username %r %h %p
+	`,
+	Hidden: true,
+	Args:   cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// stdout is the ssh transport, so messages are written at stderr
+		host, port := args[0], args[1]
+		if _, err := strconv.Atoi(port); err != nil {
+			fmt.Fprintf(os.Stderr, "Client error parsing port: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		username, err := cmd.Flags().GetString("username")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Client error parsing username option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		gshPath, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Client error getting gsh path: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		keyFile, err := files.SSHConfigKeyPath(host)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Client error getting key path: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if err := requestProxyCertificate(gshPath, keyFile, username, host, port); err != nil {
+			fmt.Fprintf(os.Stderr, "Client error requesting certificate: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if os.Getenv("SSH_AUTH_SOCK") != "" {
+			if err := addProxyCertificate(keyFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: certificate not added to ssh-agent (%s)\n", err.Error())
+			}
+		}
+
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 10*time.Second)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Client error connecting to remote host: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if err := proxyPipe(conn, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Client error proxying connection: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// requestProxyCertificate runs gsh host-connect --no-shell with the same --config, writing the
+// private key at keyFile and its certificate beside it. Its output goes to stderr, where ssh shows
+// it to the user.
+func requestProxyCertificate(gshPath string, keyFile string, username string, host string, port string) error {
+	args := []string{"host-connect", "--no-shell", "--key-out", keyFile, "--port", port}
+	if username != "" {
+		args = append(args, "--username", username)
+	}
+	if cfgFile != "" {
+		args = append(args, "--config", cfgFile)
+	}
+	// #nosec
+	sh := exec.Command(gshPath, append(args, host)...)
+	sh.Stdin = os.Stdin
+	sh.Stdout = os.Stderr
+	sh.Stderr = os.Stderr
+	return sh.Run()
+}
+
+// addProxyCertificate adds the key at keyFile and its certificate to ssh-agent until the
+// certificate expires. ssh reads identity files before ProxyCommand finishes, but asks ssh-agent
+// only when authenticating, so the certificate just issued is used at the first connection.
+func addProxyCertificate(keyFile string) error {
+	data, err := os.ReadFile(keyFile + "-cert.pub")
+	if err != nil {
+		return err
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return err
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("%s-cert.pub is not a certificate", keyFile)
+	}
+	lifetime := int64(time.Until(time.Unix(int64(cert.ValidBefore), 0)).Seconds())
+	if lifetime <= 0 {
+		return fmt.Errorf("certificate expired")
+	}
+
+	// ssh-add loads the certificate beside the key
+	// #nosec
+	sh := exec.Command("ssh-add", "-q", "-t", strconv.FormatInt(lifetime, 10), keyFile)
+	sh.Stdout = os.Stderr
+	sh.Stderr = os.Stderr
+	return sh.Run()
+}
+
+// proxyPipe copies in to conn and conn to out until the remote host closes conn. When in ends
+// (ssh closed its side), only the write half of conn is closed, so the remote host still sends
+// what is pending before closing.
+func proxyPipe(conn net.Conn, in io.Reader, out io.Writer) error {
+	defer conn.Close()
+	go func() {
+		_, _ = io.Copy(conn, in)
+		if tcpConn, ok := conn.(interface{ CloseWrite() error }); ok {
+			_ = tcpConn.CloseWrite()
+		} else {
+			_ = conn.Close()
+		}
+	}()
+	_, err := io.Copy(out, conn)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(connectProxyCmd)
+
+	connectProxyCmd.Flags().StringP("username", "u", "", "Defines remote user to connect on remote host, as ssh %r (default is from OIDC token)")
+}
@@ -0,0 +1,95 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyPipe(t *testing.T) {
+	// echo server, closing the connection after echoing everything sent
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("proxyPipe: check fail listening (%v)", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}(conn)
+		}
+	}()
+
+	t.Run("Echoes input until stdin closes", func(t *testing.T) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("proxyPipe: check fail dialing (%v)", err)
+		}
+		var out bytes.Buffer
+		done := make(chan error, 1)
+		go func() { done <- proxyPipe(conn, strings.NewReader("SSH-2.0-OpenSSH\r\n"), &out) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("proxyPipe: check fail piping (%v)", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("proxyPipe: check fail, pipe did not finish after stdin closed")
+		}
+		if out.String() != "SSH-2.0-OpenSSH\r\n" {
+			t.Fatalf("proxyPipe: check fail echoed output (%q)", out.String())
+		}
+	})
+
+	t.Run("Returns when remote host closes", func(t *testing.T) {
+		server, client := net.Pipe()
+		in, inWriter := io.Pipe()
+		defer inWriter.Close()
+		done := make(chan error, 1)
+		go func() { done <- proxyPipe(client, in, io.Discard) }()
+		server.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("proxyPipe: check fail, pipe did not finish after remote host closed")
+		}
+	})
+}
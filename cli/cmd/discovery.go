@@ -0,0 +1,116 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/globocom/gsh/types"
+)
+
+// discoveryRetryBackoff is the wait before retrying GSH API discovery or OIDC provider metadata,
+// doubled at each retry
+const discoveryRetryBackoff = 500 * time.Millisecond
+
+// discoveryCacheDuration is how long the last GSH API discovery is used while GSH API is unreachable
+const discoveryCacheDuration = 24 * time.Hour
+
+// withRetries runs call, retrying network failures (as a momentary IdP or GSH API blip) up to
+// retries times, but never after --deadline. The wait before each retry starts at backoff and is doubled.
+func withRetries(retries int, backoff time.Duration, sleep func(time.Duration), call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || !apiUnreachable(err) || attempt >= retries || config.DeadlineError() != nil {
+			return err
+		}
+		sleep(backoff << uint(attempt))
+	}
+}
+
+// retryDiscovery makes GSH API discovery of target, retrying network failures up to retries times
+func retryDiscovery(target *types.Target, retries int, backoff time.Duration, sleep func(time.Duration)) (*config.DiscoveryResponse, error) {
+	var configResponse *config.DiscoveryResponse
+	err := withRetries(retries, backoff, sleep, func() error {
+		var err error
+		configResponse, err = config.DiscoveryFor(target)
+		return err
+	})
+	return configResponse, err
+}
+
+// discover makes GSH API discovery of current target, retried on network failures. The last
+// discovery is cached, and used while GSH API is still unreachable after retries. Without it, it fails.
+func discover(retries int) (*config.DiscoveryResponse, error) {
+	return discoverTarget(config.GetCurrentTarget(), retries)
+}
+
+// discoverTarget makes GSH API discovery of target as discover does, caching it at target cache folder
+func discoverTarget(target *types.Target, retries int) (*config.DiscoveryResponse, error) {
+	configResponse, err := retryDiscovery(target, retries, discoveryRetryBackoff, time.Sleep)
+	if err == nil {
+		// cache is best effort, a failure only means there is no fallback next time
+		_ = writeDiscoveryCache(target, configResponse)
+		return configResponse, nil
+	}
+	data, cacheErr := files.ReadTargetCache(target.Label, "discovery", discoveryCacheDuration)
+	cached, err := cachedDiscovery(err, data, cacheErr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: GSH API is unreachable, using cached discovery of %s\n", target.Endpoint)
+	return cached, nil
+}
+
+// writeDiscoveryCache stores configResponse as the last discovery of target
+func writeDiscoveryCache(target *types.Target, configResponse *config.DiscoveryResponse) error {
+	data, err := json.Marshal(configResponse)
+	if err != nil {
+		return err
+	}
+	return files.WriteTargetCache(target.Label, "discovery", data)
+}
+
+// cachedDiscovery returns the discovery cached at data to be used while GSH API is unreachable.
+// Only network failures (apiErr) fall back to it, other failures and cache errors return apiErr.
+func cachedDiscovery(apiErr error, data []byte, cacheErr error) (*config.DiscoveryResponse, error) {
+	if !apiUnreachable(apiErr) || cacheErr != nil {
+		return nil, apiErr
+	}
+	cached := new(config.DiscoveryResponse)
+	if err := json.Unmarshal(data, cached); err != nil || cached.Issuer == "" {
+		return nil, apiErr
+	}
+	return cached, nil
+}
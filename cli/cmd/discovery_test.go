@@ -0,0 +1,138 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+)
+
+// flakyDiscoveryServer is a GSH API whose first failures discovery requests are dropped, as a
+// momentary network failure, and the next ones answered with status
+func flakyDiscoveryServer(t *testing.T, failures int, status int) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatalf("retryDiscovery: fail dropping connection (%v)", err)
+			}
+			conn.Close()
+			return
+		}
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"oidc_issuer": "https://oidc.example.com", "oidc_claim": "email"})
+	}))
+	return server, &requests
+}
+
+func TestRetryDiscovery(t *testing.T) {
+	var delays []time.Duration
+	sleep := func(delay time.Duration) { delays = append(delays, delay) }
+
+	t.Run(
+		"First discovery fails and retry succeeds",
+		func(t *testing.T) {
+			delays = nil
+			server, requests := flakyDiscoveryServer(t, 1, http.StatusOK)
+			defer server.Close()
+			configResponse, err := retryDiscovery(&types.Target{Endpoint: server.URL}, 2, time.Second, sleep)
+			if err != nil || configResponse.Issuer != "https://oidc.example.com" {
+				t.Fatalf("retryDiscovery: check fail with retry (%v, %v)", configResponse, err)
+			}
+			if *requests != 2 || len(delays) != 1 || delays[0] != time.Second {
+				t.Fatalf("retryDiscovery: check fail with attempts (%d, %v)", *requests, delays)
+			}
+		})
+	t.Run(
+		"Retries exhausted",
+		func(t *testing.T) {
+			delays = nil
+			server, requests := flakyDiscoveryServer(t, 10, http.StatusOK)
+			defer server.Close()
+			if _, err := retryDiscovery(&types.Target{Endpoint: server.URL}, 2, time.Second, sleep); err == nil {
+				t.Fatalf("retryDiscovery: check fail with retries exhausted")
+			}
+			if *requests != 3 || len(delays) != 2 || delays[1] != 2*time.Second {
+				t.Fatalf("retryDiscovery: check fail with backoff (%d, %v)", *requests, delays)
+			}
+		})
+	t.Run(
+		"Error response not retried",
+		func(t *testing.T) {
+			delays = nil
+			server, requests := flakyDiscoveryServer(t, 0, http.StatusInternalServerError)
+			defer server.Close()
+			if _, err := retryDiscovery(&types.Target{Endpoint: server.URL}, 2, time.Second, sleep); err == nil {
+				t.Fatalf("retryDiscovery: check fail with error response")
+			}
+			if *requests != 1 || len(delays) != 0 {
+				t.Fatalf("retryDiscovery: check fail retrying error response (%d, %v)", *requests, delays)
+			}
+		})
+}
+
+func TestCachedDiscovery(t *testing.T) {
+	unreachable := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	data, _ := json.Marshal(config.DiscoveryResponse{Issuer: "https://oidc.example.com", UsernameClaim: "email"})
+
+	t.Run(
+		"Cached discovery while unreachable",
+		func(t *testing.T) {
+			cached, err := cachedDiscovery(unreachable, data, nil)
+			if err != nil || cached.Issuer != "https://oidc.example.com" || cached.UsernameClaim != "email" {
+				t.Fatalf("cachedDiscovery: check fail with cache (%v, %v)", cached, err)
+			}
+		})
+	t.Run(
+		"No cache",
+		func(t *testing.T) {
+			if _, err := cachedDiscovery(unreachable, nil, errors.New("no cache")); err != unreachable {
+				t.Fatalf("cachedDiscovery: check fail without cache (%v)", err)
+			}
+		})
+	t.Run(
+		"Error response",
+		func(t *testing.T) {
+			apiErr := errors.New("GSH API status response 500")
+			if _, err := cachedDiscovery(apiErr, data, nil); err != apiErr {
+				t.Fatalf("cachedDiscovery: check fail with error response (%v)", err)
+			}
+		})
+}
@@ -0,0 +1,208 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"github.com/globocom/gsh/api/selftest"
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks every step needed to get a certificate",
+	Long: `
+
+Checks every step needed to get a certificate from current target: GSH API
+discovery, OIDC provider discovery and the stored token. Then GSH API self
+test checks, on the server side, token validation, a dry-run authorization
+and a test signing of a throwaway key by the configured CA. Self test is
+restricted to GSH admins. Each stage is reported with its duration.
+
+	gsh doctor
+	gsh doctor --host 198.51.100.10
+	`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		remoteHost, err := cmd.Flags().GetString("host")
+		if err != nil {
+			fmt.Printf("Client error parsing host option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if remoteHost != "" && net.ParseIP(remoteHost) == nil {
+			fmt.Printf("Client error parsing host option, is it an IP address?: (%s)\n", remoteHost)
+			os.Exit(1)
+		}
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		var configResponse *config.DiscoveryResponse
+		var accessToken string
+		var serverReport *types.SelfTestReport
+		report := selftest.Run([]selftest.Stage{
+			{Name: "discovery", Run: func() (string, error) {
+				configResponse, err = config.Discovery()
+				if err != nil || configResponse == nil {
+					return "", fmt.Errorf("GSH API discovery failed at %s (%v)", currentTarget.Endpoint, err)
+				}
+				return "GSH API at " + currentTarget.Endpoint, nil
+			}},
+			{Name: "oidc", Run: func() (string, error) {
+				if configResponse == nil {
+					return "", fmt.Errorf("OIDC issuer unknown, discovery failed")
+				}
+				if _, err := oidc.NewProvider(context.Background(), configResponse.Issuer); err != nil {
+					return "", err
+				}
+				return "OIDC provider at " + configResponse.Issuer, nil
+			}},
+			{Name: "token", Run: func() (string, error) {
+				oauth2Token, err := auth.RecoverToken(currentTarget)
+				if err != nil {
+					return "", fmt.Errorf("%v, run gsh login", err)
+				}
+				accessToken = oauth2Token.AccessToken
+				return "valid until " + oauth2Token.Expiry.Format(time.RFC3339), nil
+			}},
+			{Name: "selftest", Run: func() (string, error) {
+				if accessToken == "" {
+					return "", fmt.Errorf("no token to authenticate GSH API self test")
+				}
+				serverReport, err = requestSelfTest(currentTarget, accessToken, remoteHost)
+				if err != nil {
+					return "", err
+				}
+				return "GSH API self test " + serverReport.Result, nil
+			}},
+		})
+		if serverReport != nil {
+			report = mergeSelfTestReports(report, "api/", *serverReport)
+		}
+
+		printSelfTestReport(os.Stdout, report)
+		if report.Result != types.SelfTestSuccess {
+			os.Exit(1)
+		}
+	},
+}
+
+// requestSelfTest calls GSH API self test. A failing self test answers 503 with the report.
+func requestSelfTest(currentTarget *types.Target, accessToken string, remoteHost string) (*types.SelfTestReport, error) {
+	// Setting custom HTTP client with timeouts, signing with Vault can take a while
+	var netTransport = &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout: 10 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     config.TLSClientConfig(),
+	}
+	var netClient = &http.Client{
+		Timeout:   60 * time.Second,
+		Transport: config.TargetTransport(netTransport, currentTarget),
+	}
+
+	req, err := http.NewRequest("GET", currentTarget.Endpoint+"/selftest", nil)
+	if err != nil {
+		return nil, err
+	}
+	if remoteHost != "" {
+		query := req.URL.Query()
+		query.Set("remote_host", remoteHost)
+		req.URL.RawQuery = query.Encode()
+	}
+	req.Header.Set("Authorization", "JWT "+accessToken)
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		errorResponse := map[string]string{}
+		_ = json.Unmarshal(body, &errorResponse)
+		return nil, fmt.Errorf("GSH API status response %d (%s)", resp.StatusCode, errorResponse["message"])
+	}
+	report := new(types.SelfTestReport)
+	if err := json.Unmarshal(body, report); err != nil {
+		return nil, fmt.Errorf("parsing self test response (%v)", err)
+	}
+	return report, nil
+}
+
+// mergeSelfTestReports appends the stages of other to report, prefixing their names. The merged
+// report fails if any of them failed, its duration remains the one of report.
+func mergeSelfTestReports(report types.SelfTestReport, prefix string, other types.SelfTestReport) types.SelfTestReport {
+	merged := types.SelfTestReport{Result: report.Result, Duration: report.Duration}
+	merged.Stages = append(merged.Stages, report.Stages...)
+	for _, stage := range other.Stages {
+		stage.Name = prefix + stage.Name
+		merged.Stages = append(merged.Stages, stage)
+	}
+	if other.Result != types.SelfTestSuccess {
+		merged.Result = types.SelfTestFail
+	}
+	return merged
+}
+
+// printSelfTestReport writes a line for each stage and a summary line
+func printSelfTestReport(w io.Writer, report types.SelfTestReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, stage := range report.Stages {
+		fmt.Fprintf(tw, "%s\t%s\t%dms\t%s\n", stage.Name, stage.Result, stage.Duration, stage.Details)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Result: %s (%dms)\n", report.Result, report.Duration)
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().String("host", "", "Defines the remote host IP address used at dry-run authorization (default 127.0.0.1)")
+}
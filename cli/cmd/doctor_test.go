@@ -0,0 +1,105 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestMergeSelfTestReports(t *testing.T) {
+	client := types.SelfTestReport{
+		Result:   types.SelfTestSuccess,
+		Duration: 40,
+		Stages: []types.SelfTestStage{
+			{Name: "discovery", Result: types.SelfTestSuccess, Duration: 10},
+			{Name: "selftest", Result: types.SelfTestSuccess, Duration: 30},
+		},
+	}
+
+	t.Run(
+		"Server failure fails merged report",
+		func(t *testing.T) {
+			server := types.SelfTestReport{
+				Result: types.SelfTestFail,
+				Stages: []types.SelfTestStage{
+					{Name: "token", Result: types.SelfTestSuccess},
+					{Name: "signing", Result: types.SelfTestFail, Details: "Vault is sealed"},
+				},
+			}
+			merged := mergeSelfTestReports(client, "api/", server)
+			if merged.Result != types.SelfTestFail || merged.Duration != 40 {
+				t.Fatalf("mergeSelfTestReports: check fail result (%v)", merged)
+			}
+			names := []string{}
+			for _, stage := range merged.Stages {
+				names = append(names, stage.Name)
+			}
+			if strings.Join(names, ",") != "discovery,selftest,api/token,api/signing" {
+				t.Fatalf("mergeSelfTestReports: check fail stages (%v)", names)
+			}
+			if client.Stages[0].Name != "discovery" || len(client.Stages) != 2 {
+				t.Fatalf("mergeSelfTestReports: check fail client report changed (%v)", client)
+			}
+		})
+
+	t.Run(
+		"Server success keeps client result",
+		func(t *testing.T) {
+			failed := client
+			failed.Result = types.SelfTestFail
+			merged := mergeSelfTestReports(failed, "api/", types.SelfTestReport{Result: types.SelfTestSuccess})
+			if merged.Result != types.SelfTestFail || len(merged.Stages) != 2 {
+				t.Fatalf("mergeSelfTestReports: check fail result (%v)", merged)
+			}
+		})
+}
+
+func TestPrintSelfTestReport(t *testing.T) {
+	report := types.SelfTestReport{
+		Result:   types.SelfTestFail,
+		Duration: 312,
+		Stages: []types.SelfTestStage{
+			{Name: "discovery", Result: types.SelfTestSuccess, Duration: 12, Details: "GSH API at https://gsh.example.com"},
+			{Name: "api/signing", Result: types.SelfTestFail, Duration: 300, Details: "Vault is sealed"},
+		},
+	}
+	var out bytes.Buffer
+	printSelfTestReport(&out, report)
+	expected := "discovery    success  12ms   GSH API at https://gsh.example.com\n" +
+		"api/signing  fail     300ms  Vault is sealed\n" +
+		"Result: fail (312ms)\n"
+	if out.String() != expected {
+		t.Fatalf("printSelfTestReport: check fail output (%q)", out.String())
+	}
+}
@@ -30,13 +30,18 @@
 package files
 
 import (
+	"bufio"
 	"errors"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/labstack/gommon/random"
 	homedir "github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
 )
 
 // GetConfigPath return Viper config path
@@ -58,12 +63,12 @@ func GetConfigPath() (string, error) {
 	return path, nil
 }
 
-// WriteKeys saves string as files and returns the files paths
-func WriteKeys(key string, cert string) (string, string, error) {
+// targetCertPath returns (and creates if needed) the certificates folder of current target
+func targetCertPath() (string, error) {
 	// Find home directory.
 	configPath, err := GetConfigPath()
 	if err != nil {
-		return "", "", errors.New("File error getting config path (" + err.Error() + ")")
+		return "", errors.New("File error getting config path (" + err.Error() + ")")
 	}
 
 	// Set specific path for certificates and private keys
@@ -71,7 +76,7 @@ func WriteKeys(key string, cert string) (string, string, error) {
 	if _, err := os.Stat(certPath); os.IsNotExist(err) {
 		err := os.Mkdir(certPath, 0750)
 		if err != nil {
-			return "", "", errors.New("File error creating cert path (" + err.Error() + ")")
+			return "", errors.New("File error creating cert path (" + err.Error() + ")")
 		}
 	}
 
@@ -82,44 +87,466 @@ func WriteKeys(key string, cert string) (string, string, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		err := os.Mkdir(path, 0750)
 		if err != nil {
-			return "", "", errors.New("File error creating target cert path (" + err.Error() + ")")
+			return "", errors.New("File error creating target cert path (" + err.Error() + ")")
+		}
+	}
+	return path, nil
+}
+
+// CertsPath returns the certificates folder of every target (~/.gsh/certs)
+func CertsPath() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", errors.New("File error getting config path (" + err.Error() + ")")
+	}
+	return filepath.Join(configPath, "certs"), nil
+}
+
+// orphanKeyAge is how old a private key without certificate must be to be removed by CleanCerts,
+// keys of requests waiting for approval only get their certificate after it
+const orphanKeyAge = 24 * time.Hour
+
+// CertCleanup is the result of CleanCerts
+type CertCleanup struct {
+	Expired int      // expired certificates
+	Orphans int      // private keys without certificate
+	Files   []string // files removed, or to be removed with dry run
+}
+
+// CleanCerts removes the certificates expired at now from the folder of each target at path (as
+// CertsPath), with their private keys. With orphans, private keys without certificate older than
+// orphanKeyAge are removed too. With dryRun nothing is removed, only reported.
+func CleanCerts(path string, now time.Time, orphans bool, dryRun bool) (CertCleanup, error) {
+	cleanup := CertCleanup{Files: []string{}}
+	targets, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return cleanup, nil
+	}
+	if err != nil {
+		return cleanup, errors.New("File error reading certificates folder (" + err.Error() + ")")
+	}
+	for _, target := range targets {
+		if !target.IsDir() {
+			continue
+		}
+		targetPath := filepath.Join(path, target.Name())
+		entries, err := os.ReadDir(targetPath)
+		if err != nil {
+			return cleanup, errors.New("File error reading certificates folder (" + err.Error() + ")")
+		}
+		names := map[string]bool{}
+		for _, entry := range entries {
+			names[entry.Name()] = entry.Type().IsRegular()
+		}
+		for name, regular := range names {
+			file := filepath.Join(targetPath, name)
+			switch {
+			case !regular || strings.HasPrefix(name, "."):
+				continue
+			case strings.HasSuffix(name, "-cert.pub"):
+				if !certExpired(file, now) {
+					continue
+				}
+				cleanup.Expired++
+				cleanup.Files = append(cleanup.Files, file)
+				if keyName := strings.TrimSuffix(name, "-cert.pub"); names[keyName] {
+					cleanup.Files = append(cleanup.Files, filepath.Join(targetPath, keyName))
+				}
+			case orphans && !names[name+"-cert.pub"] && orphanKey(file, now):
+				cleanup.Orphans++
+				cleanup.Files = append(cleanup.Files, file)
+			}
+		}
+	}
+	sort.Strings(cleanup.Files)
+	if dryRun {
+		return cleanup, nil
+	}
+	for _, file := range cleanup.Files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return cleanup, errors.New("File error removing " + file + " (" + err.Error() + ")")
 		}
 	}
+	return cleanup, nil
+}
+
+// certExpired tells whether the certificate at file expired at now. Files that can't be parsed as
+// a certificate are never removed.
+func certExpired(file string, now time.Time) bool {
+	// #nosec
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return false
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.ValidBefore == ssh.CertTimeInfinity {
+		return false
+	}
+	return !time.Unix(int64(cert.ValidBefore), 0).After(now)
+}
+
+// orphanKey tells whether file is a private key not modified for orphanKeyAge at now
+func orphanKey(file string, now time.Time) bool {
+	info, err := os.Stat(file)
+	if err != nil || now.Sub(info.ModTime()) < orphanKeyAge {
+		return false
+	}
+	// #nosec
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(string(content), "-----BEGIN ") && strings.Contains(string(content), "PRIVATE KEY-----")
+}
+
+// WriteKeys saves string as files and returns the files paths
+func WriteKeys(key string, cert string) (string, string, error) {
+	path, err := targetCertPath()
+	if err != nil {
+		return "", "", err
+	}
 
 	// Store private key file with random name
-	id := random.String(32)
-	keyFileLocation := filepath.Join(path, id)
-	keyFile, err := os.Create(filepath.Clean(keyFileLocation))
+	return writeKeysAt(path, random.String(32), key, cert)
+}
+
+// writeKeysAt saves the private key as name at path and its certificate with suffix "-cert.pub"
+// (https://man.openbsd.org/ssh.1#i). Both are written to temporary files first and renamed when
+// complete, so on any error neither the key nor the certificate is left behind.
+func writeKeysAt(path string, name string, key string, cert string) (string, string, error) {
+	keyFileLocation := filepath.Join(path, name)
+	certLocation := keyFileLocation + "-cert.pub"
+
+	keyTemp, err := writeTemp(path, key)
 	if err != nil {
-		return "", "", errors.New("File error creating keyfile (" + err.Error() + ")")
+		return "", "", errors.New("File error writing keyfile (" + err.Error() + ")")
 	}
-	err = os.WriteFile(keyFileLocation, []byte(key), 0600)
+	certTemp, err := writeTemp(path, cert)
 	if err != nil {
+		_ = os.Remove(keyTemp)
+		return "", "", errors.New("File error writing certfile (" + err.Error() + ")")
+	}
+
+	if err := os.Rename(keyTemp, keyFileLocation); err != nil {
+		_ = os.Remove(keyTemp)
+		_ = os.Remove(certTemp)
 		return "", "", errors.New("File error writing keyfile (" + err.Error() + ")")
 	}
-	err = os.Chmod(keyFileLocation, 0600)
+	if err := os.Rename(certTemp, certLocation); err != nil {
+		_ = os.Remove(keyFileLocation)
+		_ = os.Remove(certTemp)
+		return "", "", errors.New("File error writing certfile (" + err.Error() + ")")
+	}
+	return keyFileLocation, certLocation, nil
+}
+
+// writeTemp writes content to a new temporary file at path with mode 0600, synced to disk, and
+// returns its name. The file is removed if it can't be completely written.
+func writeTemp(path string, content string) (string, error) {
+	file, err := os.CreateTemp(path, ".gsh-*")
 	if err != nil {
-		return "", "", errors.New("File error trying to chmod keyfile (" + err.Error() + ")")
+		return "", err
+	}
+	name := file.Name()
+	// CreateTemp uses mode 0600, chmod enforces it regardless of how the folder was set
+	err = file.Chmod(0600)
+	if err == nil {
+		_, err = file.WriteString(content)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
 	}
-	err = keyFile.Close()
 	if err != nil {
-		return "", "", errors.New("File error closing keyfile (" + err.Error() + ")")
+		_ = os.Remove(name)
+		return "", err
 	}
+	return name, nil
+}
 
-	// Store cert file with suffix "-cert.pub" (https://man.openbsd.org/ssh.1#i)
-	certLocation := filepath.Join(path, id+"-cert.pub")
-	certFile, err := os.Create(filepath.Clean(certLocation))
+// WriteCert saves a certificate whose private key is not managed by gsh and returns the file path
+func WriteCert(cert string) (string, error) {
+	path, err := targetCertPath()
 	if err != nil {
-		return "", "", errors.New("File error creating certfile (" + err.Error() + ")")
+		return "", err
+	}
+	certLocation := filepath.Join(path, random.String(32)+"-cert.pub")
+	if err := os.WriteFile(certLocation, []byte(cert), 0600); err != nil {
+		return "", errors.New("File error writing certfile (" + err.Error() + ")")
 	}
-	err = os.WriteFile(certLocation, []byte(cert), 0600)
+	return certLocation, nil
+}
+
+// CheckWritable tells whether file can be written and if it already exists. Its folder must exist.
+func CheckWritable(file string) (bool, error) {
+	info, err := os.Stat(file)
+	if err == nil && info.IsDir() {
+		return false, errors.New("File error checking " + file + " (it is a directory)")
+	}
+	exists := err == nil
+	probe, err := os.CreateTemp(filepath.Dir(filepath.Clean(file)), ".gsh-")
 	if err != nil {
-		return "", "", errors.New("File error writing certfile (" + err.Error() + ")")
+		return exists, errors.New("File error checking " + file + " (" + err.Error() + ")")
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+	return exists, nil
+}
+
+// WriteFileAt saves content at a path chosen by the user, replacing an existing file. The file
+// mode is always 0600, even if an existing file had a wider one.
+func WriteFileAt(file string, content string) error {
+	err := os.WriteFile(filepath.Clean(file), []byte(content), 0600)
+	if err != nil {
+		return errors.New("File error writing " + file + " (" + err.Error() + ")")
+	}
+	err = os.Chmod(filepath.Clean(file), 0600)
+	if err != nil {
+		return errors.New("File error trying to chmod " + file + " (" + err.Error() + ")")
+	}
+	return nil
+}
+
+// WritePendingKey saves the private key of a certificate request waiting for approval and returns the file path.
+// The file is named after requestID, so WritePendingCert can store the certificate beside it.
+func WritePendingKey(requestID string, key string) (string, error) {
+	path, err := targetCertPath()
+	if err != nil {
+		return "", err
+	}
+
+	keyFileLocation := filepath.Join(path, filepath.Base(requestID))
+	err = os.WriteFile(filepath.Clean(keyFileLocation), []byte(key), 0600)
+	if err != nil {
+		return "", errors.New("File error writing keyfile (" + err.Error() + ")")
 	}
-	err = certFile.Close()
+	return keyFileLocation, nil
+}
+
+// WritePendingCert saves the certificate issued for a request stored with WritePendingKey and returns the files paths
+func WritePendingCert(requestID string, cert string) (string, string, error) {
+	path, err := targetCertPath()
 	if err != nil {
-		return "", "", errors.New("File error closing certfile (" + err.Error() + ")")
+		return "", "", err
 	}
 
+	keyFileLocation := filepath.Join(path, filepath.Base(requestID))
+	if _, err := os.Stat(keyFileLocation); err != nil {
+		return "", "", errors.New("File error reading keyfile of request " + requestID + " (" + err.Error() + ")")
+	}
+
+	// Store cert file with suffix "-cert.pub" (https://man.openbsd.org/ssh.1#i)
+	certLocation := keyFileLocation + "-cert.pub"
+	err = os.WriteFile(filepath.Clean(certLocation), []byte(cert), 0600)
+	if err != nil {
+		return "", "", errors.New("File error writing certfile (" + err.Error() + ")")
+	}
 	return keyFileLocation, certLocation, nil
 }
+
+// KnownHostsPath returns the path of the known_hosts file managed by gsh for current target.
+// Using a file per target keeps host keys learned through gsh out of the user's ~/.ssh/known_hosts.
+func KnownHostsPath() (string, error) {
+	path, err := targetCertPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, "known_hosts"), nil
+}
+
+// TrustHostCA adds a @cert-authority entry for caKey to the known_hosts file of current target,
+// if it is not already there, and returns the file path
+func TrustHostCA(caKey string) (string, error) {
+	knownHostsFile, _, err := TrustHostCAs([]string{caKey})
+	return knownHostsFile, err
+}
+
+// TrustHostCAs adds @cert-authority entries for every key at caKeys (as the old and new CA during
+// a rotation) to the known_hosts file of current target, skipping keys already there. It returns
+// the file path and how many entries were added.
+func TrustHostCAs(caKeys []string) (string, int, error) {
+	knownHostsFile, err := KnownHostsPath()
+	if err != nil {
+		return "", 0, err
+	}
+	added, err := appendCertAuthorities(knownHostsFile, caKeys)
+	return knownHostsFile, added, err
+}
+
+// appendCertAuthorities appends a @cert-authority entry to knownHostsFile for each key at caKeys not
+// already trusted there. Keys are compared without their comments.
+func appendCertAuthorities(knownHostsFile string, caKeys []string) (int, error) {
+	// #nosec
+	file, err := os.OpenFile(knownHostsFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, errors.New("File error opening known_hosts (" + err.Error() + ")")
+	}
+	defer file.Close()
+
+	trusted := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[0] == "@cert-authority" && fields[1] == "*" {
+			trusted[fields[2]+" "+fields[3]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, errors.New("File error reading known_hosts (" + err.Error() + ")")
+	}
+
+	added := 0
+	for _, caKey := range caKeys {
+		fields := strings.Fields(caKey)
+		if len(fields) < 2 {
+			return added, errors.New("File error: invalid CA public key (" + caKey + ")")
+		}
+		key := fields[0] + " " + fields[1]
+		if trusted[key] {
+			continue
+		}
+		if _, err := file.WriteString("@cert-authority * " + strings.TrimSpace(caKey) + "\n"); err != nil {
+			return added, errors.New("File error writing known_hosts (" + err.Error() + ")")
+		}
+		trusted[key] = true
+		added++
+	}
+	return added, nil
+}
+
+// SSHConfigKeyPath returns the path of the private key written for host by the ssh config of
+// gsh ssh-config-gen, at the certificates folder of current target. Its certificate is beside it,
+// with "-cert.pub" suffix.
+func SSHConfigKeyPath(host string) (string, error) {
+	path, err := targetCertPath()
+	if err != nil {
+		return "", err
+	}
+	name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(host)
+	return filepath.Join(path, "ssh-config-"+name), nil
+}
+
+// LastConnectionPath returns the path of the record of the last connection made by gsh
+// host-connect, shown by gsh last
+func LastConnectionPath() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", errors.New("File error getting config path (" + err.Error() + ")")
+	}
+	return filepath.Join(configPath, "last-connection.json"), nil
+}
+
+// MuxPath returns (and creates if needed) the folder of ssh ControlMaster sockets of current target.
+// It is only accessible by the user (0700), any user able to write there could hijack connections.
+func MuxPath() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", errors.New("File error getting config path (" + err.Error() + ")")
+	}
+
+	// Set specific path per target
+	currentTarget := config.GetCurrentTarget()
+	muxPath := filepath.Join(configPath, "mux")
+	path := filepath.Join(muxPath, currentTarget.Label)
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", errors.New("File error creating target mux path (" + err.Error() + ")")
+	}
+	// folders created by older versions or by hand can have broader permissions
+	for _, dir := range []string{muxPath, path} {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return "", errors.New("File error setting mux path permissions (" + err.Error() + ")")
+		}
+	}
+	return path, nil
+}
+
+// targetCachePath returns (and creates if needed) the cache folder of current target
+func targetCachePath() (string, error) {
+	return cachePath(config.GetCurrentTarget().Label)
+}
+
+// cachePath returns (and creates if needed) the cache folder of target named label
+func cachePath(label string) (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", errors.New("File error getting config path (" + err.Error() + ")")
+	}
+
+	// Set specific path per target
+	path := filepath.Join(configPath, "/cache", label)
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return "", errors.New("File error creating target cache path (" + err.Error() + ")")
+	}
+	return path, nil
+}
+
+// WriteCache stores data under name at the cache folder of current target
+func WriteCache(name string, data []byte) error {
+	path, err := targetCachePath()
+	if err != nil {
+		return err
+	}
+	return writeCacheAt(path, name, data)
+}
+
+// WriteTargetCache stores data under name at the cache folder of target named label
+func WriteTargetCache(label string, name string, data []byte) error {
+	path, err := cachePath(label)
+	if err != nil {
+		return err
+	}
+	return writeCacheAt(path, name, data)
+}
+
+// writeCacheAt stores data under name at cache folder path
+func writeCacheAt(path string, name string, data []byte) error {
+	err := os.WriteFile(filepath.Join(path, filepath.Base(name)), data, 0600)
+	if err != nil {
+		return errors.New("File error writing cache (" + err.Error() + ")")
+	}
+	return nil
+}
+
+// ReadCache returns data stored under name at the cache folder of current target,
+// if it was written less than maxAge ago
+func ReadCache(name string, maxAge time.Duration) ([]byte, error) {
+	path, err := targetCachePath()
+	if err != nil {
+		return nil, err
+	}
+	return readCacheAt(path, name, maxAge)
+}
+
+// ReadTargetCache returns data stored under name at the cache folder of target named label,
+// if it was written less than maxAge ago
+func ReadTargetCache(label string, name string, maxAge time.Duration) ([]byte, error) {
+	path, err := cachePath(label)
+	if err != nil {
+		return nil, err
+	}
+	return readCacheAt(path, name, maxAge)
+}
+
+// readCacheAt returns data stored under name at cache folder path, if it was written less than maxAge ago
+func readCacheAt(path string, name string, maxAge time.Duration) ([]byte, error) {
+	cacheFile := filepath.Join(path, filepath.Base(name))
+	info, err := os.Stat(cacheFile)
+	if err != nil {
+		return nil, errors.New("File error reading cache (" + err.Error() + ")")
+	}
+	if time.Since(info.ModTime()) > maxAge {
+		return nil, errors.New("File error reading cache (" + name + " expired)")
+	}
+	// #nosec
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		return nil, errors.New("File error reading cache (" + err.Error() + ")")
+	}
+	return data, nil
+}
@@ -31,11 +31,16 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -44,17 +49,27 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
+	oidc "github.com/coreos/go-oidc"
 	"github.com/globocom/gsh/api/handlers"
+	"github.com/globocom/gsh/cli/cmd/approvals"
 	"github.com/globocom/gsh/cli/cmd/auth"
 	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/globocom/gsh/cli/cmd/files"
 	"github.com/globocom/gsh/types"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/oauth2"
 )
 
+// userInfoCacheDuration is how long a username read from OIDC userinfo endpoint is reused
+const userInfoCacheDuration = 5 * time.Minute
+
 // hostConnectCmd represents the hostConnect command
 var hostConnectCmd = &cobra.Command{
 	Use:     "host-connect",
@@ -62,10 +77,42 @@ var hostConnectCmd = &cobra.Command{
 	Short:   "Opens a remote shell inside a host, using SSH certificates",
 	Long: `Opens a remote shell inside a host, using SSH certificates. You
 can access a host just giving a DNS name or specifying the IP of the host.
+
+Hosts can also be given by an alias of gsh config, as "db1: deploy@db1.prod.internal:22000"
+at aliases (global) or at aliases of the current target. Username and port of the alias are
+defaults, --username and --port flags override them.
 `,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 
+		// --print-raw-cert writes only the certificate to stdout (rawCertOut), messages of host-connect
+		// are written to diag, which is stderr then
+		printRawCert, err := cmd.Flags().GetBool("print-raw-cert")
+		if err != nil {
+			fmt.Printf("Client error parsing print-raw-cert option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		rawCertOut, diag := io.Writer(os.Stdout), io.Writer(os.Stdout)
+		if printRawCert {
+			diag = os.Stderr
+		}
+
+		// Session timeout is only for remote commands (automation), not for interactive sessions
+		sessionTimeout, err := cmd.Flags().GetDuration("session-timeout")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing session-timeout option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		remoteCommand, err := cmd.Flags().GetString("command")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing command option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if err := checkSessionTimeout(sessionTimeout, remoteCommand); err != nil {
+			fmt.Fprintf(diag, "Client error: %s\n", err.Error())
+			os.Exit(1)
+		}
+
 		// Get current target
 		currentTarget := config.GetCurrentTarget()
 
@@ -76,25 +123,112 @@ can access a host just giving a DNS name or specifying the IP of the host.
 		}
 		keys := new(Keys)
 
+		// An existing public key (as one at ssh-agent) can be certified instead of a generated one
+		publicKeyFile, err := cmd.Flags().GetString("public-key")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing public-key option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if publicKeyFile != "" {
+			keys.SSHPublicKey, err = readPublicKey(publicKeyFile)
+			if err != nil {
+				fmt.Fprintf(diag, "Client error reading public key: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// A key at a hardware token (PKCS#11 provider) can be certified, its private key never leaves it
+		pkcs11Provider, err := cmd.Flags().GetString("pkcs11")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing pkcs11 option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		keySource := ""
+		if publicKeyFile != "" {
+			keySource = "--public-key"
+		}
+		if pkcs11Provider != "" {
+			if publicKeyFile != "" {
+				fmt.Fprintf(diag, "Client error: --pkcs11 is not used with --public-key\n")
+				os.Exit(1)
+			}
+			keySource = "--pkcs11"
+			pkcs11Key, err := cmd.Flags().GetString("pkcs11-key")
+			if err != nil {
+				fmt.Fprintf(diag, "Client error parsing pkcs11-key option: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			output, err := pkcs11Keys(pkcs11Provider)
+			if err != nil {
+				fmt.Fprintf(diag, "Client error reading PKCS#11 token: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			keys.SSHPublicKey, err = pkcs11PublicKey(output, pkcs11Key)
+			if err != nil {
+				fmt.Fprintf(diag, "Client error reading PKCS#11 token: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// Certificate can be requested without connecting, written where automation expects it
+		noShell, err := cmd.Flags().GetBool("no-shell")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing no-shell option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		noShell = noShell || printRawCert
+		keyOut, err := cmd.Flags().GetString("key-out")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing key-out option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		certOut, err := cmd.Flags().GetString("cert-out")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing cert-out option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		certOut, err = outputPaths(noShell, keySource, keyOut, certOut)
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing output options: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		for _, file := range []string{keyOut, certOut} {
+			if file == "" {
+				continue
+			}
+			exists, err := files.CheckWritable(file)
+			if err != nil {
+				fmt.Fprintf(diag, "Client error checking output file: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			if exists {
+				fmt.Fprintf(diag, "Warning: %s exists and will be overwritten\n", file)
+			}
+		}
+
 		// Get flags for SSH key type
 		keyType, err := cmd.Flags().GetString("key-type")
 		if err != nil {
-			fmt.Printf("Client error parsing key-type option: (%s)\n", err.Error())
+			fmt.Fprintf(diag, "Client error parsing key-type option: (%s)\n", err.Error())
 			os.Exit(1)
 		}
+		if keySource != "" {
+			// no key pair is generated
+			keyType = ""
+		}
 		switch keyType {
 		// RSA Keys
 		case "rsa":
 			// Generate keys
 			privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 			if err != nil {
-				fmt.Printf("Client error generating RSA keys: (%s)\n", err.Error())
+				fmt.Fprintf(diag, "Client error generating RSA keys: (%s)\n", err.Error())
 				os.Exit(1)
 			}
 			// convert publick key to SSH format
 			pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
 			if err != nil {
-				fmt.Printf("Client error converting RSA to SSH keys: (%s)\n", err.Error())
+				fmt.Fprintf(diag, "Client error converting RSA to SSH keys: (%s)\n", err.Error())
 				os.Exit(1)
 			}
 			keys.SSHPublicKey = string(ssh.MarshalAuthorizedKey(pub))
@@ -107,94 +241,257 @@ can access a host just giving a DNS name or specifying the IP of the host.
 			keys.SSHPrivateKey = string(pem.EncodeToMemory(privateKeyPEM))
 		}
 
+		// Expand host alias of gsh config, the canonical host is used from now on
+		alias, err := expandAlias(args[0], currentTarget.Aliases)
+		if err != nil {
+			fmt.Fprintf(diag, "Client error expanding host alias: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		host := alias.Host
+
 		// Get remote port
 		port, err := cmd.Flags().GetString("port")
 		if err != nil {
-			fmt.Printf("Client error getting remote port: (%s)\n", err.Error())
+			fmt.Fprintf(diag, "Client error getting remote port: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		port = alias.port(port, cmd.Flags().Changed("port"))
+
+		// Resolve remote host as GSH API sees it (dns_resolver of target), roles match ip addresses
+		resolveCtx, cancelResolve := context.WithTimeout(context.Background(), 10*time.Second)
+		remoteIP, err := resolveHost(resolveCtx, config.Resolver(currentTarget), host)
+		cancelResolve()
+		if err != nil {
+			fmt.Fprintf(diag, "Client error resolving remote host: (%s)\n", err.Error())
 			os.Exit(1)
 		}
 
+		// Discover remote port from gsh-agent metadata, an explicit --port (or port of alias) is always used
+		discoverPort, err := cmd.Flags().GetBool("discover")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing discover option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if discoverPort && !cmd.Flags().Changed("port") && alias.Port == "" {
+			agentPort, err := cmd.Flags().GetString("agent-port")
+			if err != nil {
+				fmt.Fprintf(diag, "Client error getting agent port: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			port, err = discoverSSHPort(&http.Client{Timeout: 5 * time.Second}, remoteIP, agentPort)
+			if err != nil {
+				fmt.Fprintf(diag, "Client error discovering remote port from gsh-agent: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
 		// Parse URL
 		u, err := url.Parse(currentTarget.Endpoint)
 		if err != nil {
-			fmt.Printf("Client error parsing URL endpoint: (%s)\n", err.Error())
+			fmt.Fprintf(diag, "Client error parsing URL endpoint: (%s)\n", err.Error())
 			os.Exit(1)
 		}
 
 		// Get preferred outbound ip of this machine (first on target machine, after GSH API)
-		conn, err := net.DialTimeout("tcp", args[0]+":"+port, time.Second)
+		dialRetries, err := cmd.Flags().GetInt("dial-retries")
 		if err != nil {
-			conn, err = net.Dial("tcp", u.Host)
-			if err != nil {
-				conn, err = net.Dial("tcp", u.Host+":"+u.Scheme)
-				if err != nil {
-					fmt.Printf("Client error connecting on endpoint: (%s)\n", err.Error())
-					os.Exit(1)
-				}
-			}
+			fmt.Fprintf(diag, "Client error parsing dial-retries option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		dial := func(address string) (net.Conn, error) {
+			return net.DialTimeout("tcp", address, time.Second)
+		}
+		localIP, err := outboundIP([]string{net.JoinHostPort(remoteIP, port), endpointAddress(u)}, dialRetries, dial, net.InterfaceAddrs)
+		if err != nil {
+			fmt.Fprintf(diag, "Client error discovering local ip address: (%s)\n", err.Error())
+			os.Exit(1)
 		}
-		defer conn.Close()
-		localAddr := conn.LocalAddr().(*net.TCPAddr)
 
-		// Make GSH API discovery
-		configResponse, err := config.Discovery()
+		// Cached certificates can be used while GSH API is unreachable (offline mode)
+		allowCached, err := cmd.Flags().GetBool("allow-cached")
 		if err != nil {
-			fmt.Printf("GSH client discover error: %s\n", err.Error())
+			fmt.Fprintf(diag, "Client error parsing allow-cached option: (%s)\n", err.Error())
 			os.Exit(1)
 		}
 
-		// Get OIDC HTTP Client
-		oauth2Token, err := auth.RecoverToken(currentTarget)
+		// Make GSH API discovery, retried and falling back to the cached discovery on network failures
+		discoveryRetries, err := cmd.Flags().GetInt("discovery-retries")
 		if err != nil {
-			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			fmt.Fprintf(diag, "Client error parsing discovery-retries option: (%s)\n", err.Error())
 			os.Exit(1)
 		}
 
+		// Get OIDC HTTP Client, its token renewed with that discovery and retried OIDC provider metadata.
+		// Offline, GSH API is unreachable since discovery and no token is renewed.
+		var offlineErr error
+		configResponse, oauth2Token, err := connectDiscovery(func() (*config.DiscoveryResponse, error) {
+			return discover(discoveryRetries)
+		}, func(configResponse *config.DiscoveryResponse) (*oauth2.Token, error) {
+			return auth.RenewToken(currentTarget, configResponse, func(issuer string) (*oidc.Provider, error) {
+				return retryProvider(issuer, discoveryRetries)
+			})
+		})
+		if err != nil {
+			if !allowCached || !apiUnreachable(err) {
+				fmt.Fprintf(diag, "Client error getting http client: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			offlineErr = err
+		}
+
 		// Get info about user
-		var username string
-		if !cmd.Flags().Changed("username") {
-			username, err = handlers.GetClaim(oauth2Token.AccessToken, configResponse.UsernameClaim)
+		var flagUsername string
+		if cmd.Flags().Changed("username") {
+			flagUsername, err = cmd.Flags().GetString("username")
 			if err != nil {
-				fmt.Printf("Client error getting username from token: (%s)\n", err.Error())
+				fmt.Fprintf(diag, "Client error getting username: (%s)\n", err.Error())
 				os.Exit(1)
 			}
+		}
+		asLocalUser, err := cmd.Flags().GetBool("as-local-user")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing as-local-user option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if asLocalUser && flagUsername != "" {
+			fmt.Fprintln(diag, "Client error: --as-local-user can't be used with --username")
+			os.Exit(1)
+		}
 
-			if username == "" {
-				userLocal, err := user.Current()
-				if err != nil {
-					fmt.Printf("Client error getting username: (%s)\n", err.Error())
-					os.Exit(1)
-				}
-				username = userLocal.Username
-			}
+		// Admins request certificates on behalf of a user, authorized by the user's roles
+		impersonate, err := cmd.Flags().GetString("impersonate")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing impersonate option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if impersonate != "" && asLocalUser {
+			fmt.Fprintln(diag, "Client error: --impersonate can't be used with --as-local-user")
+			os.Exit(1)
+		}
+
+		var username string
+		if asLocalUser {
+			username, err = localUsername()
 		} else {
-			username, err = cmd.Flags().GetString("username")
-			if err != nil {
-				fmt.Printf("Client error getting username: (%s)\n", err.Error())
-				os.Exit(1)
+			defaultUsername := currentTarget.DefaultUsername
+			if alias.Username != "" {
+				defaultUsername = alias.Username
 			}
+			username, err = resolveUsername(flagUsername, defaultUsername, func() (string, error) {
+				if impersonate != "" {
+					return impersonate, nil
+				}
+				if offlineErr != nil {
+					return "", errors.New("username claim is unknown while GSH API is unreachable, use --username")
+				}
+				// remote user is the principal claim of GSH API, when it differs from the username claim
+				if configResponse.PrincipalClaim != "" {
+					if principal, ok := tokenClaim(oauth2Token, configResponse.PrincipalClaim); ok {
+						return principal, nil
+					}
+				}
+				return claimUsername(oauth2Token, configResponse.UsernameClaim, func() (string, error) {
+					return userInfoUsername(configResponse.Issuer, configResponse.UsernameClaim, currentTarget.Account, oauth2Token, discoveryRetries)
+				})
+			})
+		}
+		if err != nil {
+			fmt.Fprintf(diag, "Client error getting username: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// catch typos before requesting a certificate, GSH API still authorizes the remote user
+		if err := checkAllowedUser(username, currentTarget.Label, currentTarget.AllowedUsers); err != nil {
+			fmt.Fprintf(diag, "Client error checking username: (%s)\n", err.Error())
+			os.Exit(1)
 		}
 
 		// check user ip
-		sourceIP := localAddr.IP.String()
+		sourceIP := localIP.String()
 		if cmd.Flags().Changed("source") {
 			sourceIP, err = cmd.Flags().GetString("source")
 			if err != nil {
-				fmt.Printf("Client error getting source-ip: (%s)\n", err.Error())
+				fmt.Fprintf(diag, "Client error getting source-ip: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// Get reason (ticket or justification) for this access
+		reason, err := cmd.Flags().GetString("reason")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error getting reason: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Break-glass roles bypass ip restrictions, always alerting security
+		breakGlass, err := cmd.Flags().GetBool("break-glass")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error getting break-glass: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if breakGlass {
+			if strings.TrimSpace(reason) == "" {
+				fmt.Fprintln(diag, "Client error: break-glass requires a reason (--reason)")
 				os.Exit(1)
 			}
+			fmt.Fprint(os.Stderr, breakGlassWarning)
+		}
+
+		// Extra principals are requested besides username, all authorized or the request is denied
+		principals, err := cmd.Flags().GetStringArray("principal")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error getting principals: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if impersonate != "" && (breakGlass || strings.TrimSpace(reason) == "") {
+			fmt.Fprintln(diag, "Client error: --impersonate requires a reason (--reason) and can't be used with --break-glass")
+			os.Exit(1)
+		}
+		if len(principals) > 0 && breakGlass {
+			fmt.Fprintln(diag, "Client error: break-glass certificates can't have extra principals (--principal)")
+			os.Exit(1)
+		}
+
+		// Get verbose and reuse flags
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing verbose option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		reuse, err := cmd.Flags().GetBool("reuse")
+		if err != nil {
+			fmt.Fprintf(diag, "Client error parsing reuse option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Reuse a cached certificate while it is valid, requests with reason are always audited
+		cacheName := certCacheName(username, host, port, sourceIP)
+		cacheable := reason == "" && !breakGlass && len(principals) == 0 && impersonate == "" && keySource == "" && certOut == "" && !printRawCert
+		if reuse && cacheable {
+			if cached, ok := readCertCache(cacheName, time.Now()); ok {
+				if verbose {
+					fmt.Fprintf(diag, "Reusing certificate valid until %s\n", cached.ValidBefore.Local().Format(time.RFC3339))
+				}
+				useCachedCert(cmd, currentTarget, cached, noShell, username, port, host)
+			}
+		}
+		if offlineErr != nil {
+			connectOffline(cmd, diag, currentTarget, offlineErr, cacheable, cacheName, noShell, username, port, host)
 		}
 
 		// prepare JSON to gsh api
 		certRequest := types.CertRequest{
 			Key:        keys.SSHPublicKey,
-			RemoteHost: args[0],
+			RemoteHost: remoteIP,
+			RemotePort: port,
 			RemoteUser: username,
 			UserIP:     sourceIP,
-		}
+			Reason:     reason,
+			BreakGlass: breakGlass,
 
-		// Marshall certificate to JSON
-		certRequestJSON, _ := json.Marshal(certRequest)
+			ExtraPrincipals: principals,
+			Impersonate:     impersonate,
+		}
 
 		// Setting custom HTTP client with timeouts
 		var netTransport = &http.Transport{
@@ -202,93 +499,986 @@ can access a host just giving a DNS name or specifying the IP of the host.
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
-		// Make GSH request
-		req, err := http.NewRequest("POST", currentTarget.Endpoint+"/certificates", bytes.NewBuffer(certRequestJSON))
-		if err != nil {
-			fmt.Printf("Client error pre certificate request: (%s)\n", err.Error())
-			os.Exit(1)
+		// Proof of possession: the request and a single-use nonce are signed by the private key to be certified
+		if signer := matchSigner(keys.SSHPublicKey, proofSigners(keys.SSHPrivateKey, privateKeyFile(publicKeyFile))); signer != nil {
+			nonce, err := fetchChallenge(netClient, currentTarget.Endpoint, oauth2Token.AccessToken)
+			if err != nil && verbose {
+				fmt.Fprintf(os.Stderr, "Warning: key proof is not bound to a nonce (%s)\n", err.Error())
+			}
+			if err := signKeyProof(&certRequest, signer, nonce, time.Now()); err != nil {
+				fmt.Fprintf(diag, "Client error signing key proof: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		} else if verbose {
+			fmt.Fprintln(os.Stderr, "Warning: private key is not readable nor at ssh-agent, its possession is not proved to GSH API")
 		}
 
-		req.Header.Set("Authorization", "JWT "+oauth2Token.AccessToken)
-		req.Header.Set("Content-Type", "application/json")
+		// Marshall certificate to JSON
+		certRequestJSON, _ := json.Marshal(certRequest)
 
-		resp, err := netClient.Do(req)
-		if err != nil {
-			fmt.Printf("Client error post certificate request: (%s)\n", err.Error())
-			os.Exit(1)
-		}
+		// Make GSH request, retried while GSH API signers are busy
+		var statusCode int
+		var body []byte
+		for attempt := 0; ; attempt++ {
+			statusCode, body, err = postCertRequest(netClient, currentTarget.Endpoint, oauth2Token.AccessToken, certRequestJSON)
+			if err != nil {
+				if allowCached && apiUnreachable(err) {
+					connectOffline(cmd, diag, currentTarget, err, cacheable, cacheName, noShell, username, port, host)
+				}
+				fmt.Fprintf(diag, "Client error post certificate request: (%s)\n", err.Error())
+				os.Exit(1)
+			}
 
-		// Read body
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("Client error reading certificate response: (%s)\n", err.Error())
-			os.Exit(1)
+			delay, retry := busyRetryDelay(statusCode, body, attempt)
+			if !retry {
+				break
+			}
+			fmt.Fprintf(diag, "GSH API is busy signing certificates, retrying in %s\n", delay)
+			time.Sleep(delay)
 		}
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("Client error checking http status response: (%d)\n\n%s\n", resp.StatusCode, body)
+
+		// Certificate request must be approved by a second person, waiting for it
+		if statusCode == http.StatusAccepted {
+			type PendingResponse struct {
+				Message   string `json:"message"`
+				RequestID string `json:"request_id"`
+				ExpiresAt string `json:"expires_at"`
+			}
+			pendingResponse := new(PendingResponse)
+			if err := json.Unmarshal(body, &pendingResponse); err != nil {
+				fmt.Fprintf(diag, "Client error parsing pending certificate response: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			fmt.Fprintf(diag, "Certificate request %s is waiting for approval (expires at %s)\n", pendingResponse.RequestID, pendingResponse.ExpiresAt)
+
+			// Check for wait flag
+			wait, err := cmd.Flags().GetBool("wait")
+			if err != nil {
+				fmt.Fprintf(diag, "Client error parsing wait option: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			if !wait && publicKeyFile != "" {
+				fmt.Fprintf(diag, "After approval, run again with --wait to fetch the certificate of %s\n", publicKeyFile)
+				os.Exit(0)
+			}
+			if !wait && pkcs11Provider != "" {
+				fmt.Fprintf(diag, "After approval, run again with --wait to fetch the certificate of the PKCS#11 key\n")
+				os.Exit(0)
+			}
+			if !wait && certOut != "" {
+				fmt.Fprintf(diag, "After approval, run again with --wait to write the certificate at %s\n", certOut)
+				os.Exit(0)
+			}
+			if !wait {
+				// Keep private key to be used when the certificate is fetched
+				_, err := files.WritePendingKey(pendingResponse.RequestID, keys.SSHPrivateKey)
+				if err != nil {
+					fmt.Fprintf(diag, "Client error writing private key file: (%s)\n", err.Error())
+					os.Exit(1)
+				}
+				fmt.Fprintf(diag, "After approval, run: gsh cert-fetch %s\n", pendingResponse.RequestID)
+				os.Exit(0)
+			}
+
+			timeout, err := cmd.Flags().GetDuration("wait-timeout")
+			if err != nil {
+				fmt.Fprintf(diag, "Client error parsing wait-timeout option: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			expiresAt, err := time.Parse(time.RFC3339, pendingResponse.ExpiresAt)
+			if err == nil && time.Until(expiresAt) < timeout {
+				timeout = time.Until(expiresAt)
+			}
+			fmt.Fprintf(diag, "Waiting for approval...\n")
+			body, err = approvals.Wait(netClient, currentTarget.Endpoint, oauth2Token.AccessToken, pendingResponse.RequestID, approvals.PollInterval, timeout)
+			if err != nil {
+				fmt.Fprintf(diag, "Client error waiting certificate approval: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		} else if statusCode != http.StatusOK {
+			fmt.Fprintf(diag, "Client error checking http status response: (%d)\n\n%s\n", statusCode, body)
 			os.Exit(1)
 		}
-		defer resp.Body.Close()
 
 		// Parse certificate response
-		type CertResponse struct {
-			Certificate string `json:"certificate"`
-			Result      string `json:"result"`
-		}
-		certResponse := new(CertResponse)
+		certResponse := new(types.CertResponse)
 		if err := json.Unmarshal(body, &certResponse); err != nil {
-			fmt.Printf("Client error parsing certificate response: (%s)\n", err.Error())
+			fmt.Fprintf(diag, "Client error parsing certificate response: (%s)\n", err.Error())
 			os.Exit(1)
 		}
-		// certificate at certResponse.Certificate
+		// certificate at certResponse.Certificate, with a warning when shorter than expected
+		if certResponse.Message != "" {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", certResponse.Message)
+		}
 
-		// Write files
-		keyFile, certFile, err := files.WriteKeys(keys.SSHPrivateKey, certResponse.Certificate)
+		// Write files, the private key of --public-key and --pkcs11 is not known by gsh
+		var keyFile, certFile string
+		if certOut != "" {
+			keyFile, certFile = keyOut, certOut
+			if keyOut != "" {
+				err = files.WriteFileAt(keyOut, keys.SSHPrivateKey)
+			} else if publicKeyFile != "" {
+				keyFile = privateKeyFile(publicKeyFile)
+			}
+			if err == nil {
+				err = files.WriteFileAt(certOut, certResponse.Certificate)
+			}
+		} else if keySource != "" {
+			keyFile = privateKeyFile(publicKeyFile)
+			certFile, err = files.WriteCert(certResponse.Certificate)
+		} else {
+			keyFile, certFile, err = files.WriteKeys(keys.SSHPrivateKey, certResponse.Certificate)
+		}
 		if err != nil {
-			fmt.Printf("Client error writing certificate files: (%s)\n", err.Error())
+			fmt.Fprintf(diag, "Client error writing certificate files: (%s)\n", err.Error())
 			os.Exit(1)
 		}
 
-		// Check for dry flag
-		dry, err := cmd.Flags().GetBool("dry")
-		if err != nil {
-			fmt.Printf("Client error parsing dry option: (%s)\n", err.Error())
-			os.Exit(1)
+		if verbose {
+			fmt.Fprintf(diag, "Certificate valid until %s (%s)\n", certResponse.ValidBefore.Local().Format(time.RFC3339),
+				time.Until(certResponse.ValidBefore).Round(time.Second))
+			if len(certResponse.Roles) > 0 {
+				fmt.Fprintf(diag, "Authorized by roles %s\n", strings.Join(certResponse.Roles, ", "))
+			}
 		}
-		if dry {
-			// Run echoed ssh command (audited)
-			// #nosec
-			sh := exec.Command("echo", "ssh", "-i", keyFile, "-i", certFile, "-l", username, "-p", port, args[0])
-			sh.Stdout = os.Stdout
-			err = sh.Run()
-			if err != nil {
-				fmt.Printf("Client error running command: (%s)\n", err.Error())
+
+		// cache is best effort, a failure only means a new certificate is requested next time.
+		// Only cacheable requests are cached: audited ones (reason, break-glass, impersonation, extra
+		// principals) are never reused, and files at user paths can be moved or removed by the next step.
+		if cacheable {
+			_ = writeCertCache(cacheName, certCache{KeyFile: keyFile, CertFile: certFile, ValidBefore: certResponse.ValidBefore})
+		}
+
+		if printRawCert {
+			if err := writeRawCert(rawCertOut, certResponse.Certificate); err != nil {
+				fmt.Fprintf(diag, "Client error printing certificate: (%s)\n", err.Error())
 				os.Exit(1)
 			}
 			os.Exit(0)
 		}
+		if noShell {
+			if keyFile != "" {
+				fmt.Fprintf(diag, "Private key: %s\n", keyFile)
+			}
+			fmt.Fprintf(diag, "Certificate: %s\n", certFile)
+			os.Exit(0)
+		}
 
-		// Run ssh command (audited)
+		connectHost(cmd, currentTarget, keyFile, certFile, cacheable, username, port, host)
+	},
+}
+
+// writeRawCert writes only cert, as an authorized key line, to w (--print-raw-cert)
+func writeRawCert(w io.Writer, cert string) error {
+	_, err := io.WriteString(w, strings.TrimSpace(cert)+"\n")
+	return err
+}
+
+// connectHost runs ssh to host using the certificate at certFile, exiting with its result. Only
+// cacheable certificates (mux) share ssh sessions, audited ones never create nor join a ControlMaster.
+func connectHost(cmd *cobra.Command, currentTarget *types.Target, keyFile string, certFile string, mux bool, username string, port string, host string) {
+	// Managed known_hosts for current target, trusting host CA when configured
+	knownHostsFile, err := files.KnownHostsPath()
+	if err != nil {
+		fmt.Printf("Client error getting known_hosts path: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	if currentTarget.HostCAKey != "" {
+		if _, err := files.TrustHostCA(currentTarget.HostCAKey); err != nil {
+			fmt.Printf("Client error trusting host CA: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	pkcs11Provider, err := cmd.Flags().GetString("pkcs11")
+	if err != nil {
+		fmt.Printf("Client error parsing pkcs11 option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	sshArgs := sshCommandArgs(keyFile, certFile, pkcs11Provider, knownHostsFile, username, port, host)
+
+	// ssh own verbosity and connection timeout, options placed before host
+	sshVerbose, err := cmd.Flags().GetInt("ssh-verbose")
+	if err != nil {
+		fmt.Printf("Client error parsing ssh-verbose option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	connectTimeout, err := cmd.Flags().GetDuration("connect-timeout")
+	if err != nil {
+		fmt.Printf("Client error parsing connect-timeout option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	options, err := sshOptionArgs(sshVerbose, connectTimeout)
+	if err != nil {
+		fmt.Printf("Client error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// connections with the same certificate to the same host share the ssh session of a ControlMaster,
+	// unless --no-mux. Connections with audited certificates never use one, even from ssh_config.
+	if controlDir, ok := muxControlDir(cmd); ok && mux {
+		muxPersist, err := cmd.Flags().GetDuration("mux-persist")
+		if err != nil {
+			fmt.Printf("Client error parsing mux-persist option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		cert, err := os.ReadFile(certFile)
+		if err != nil {
+			fmt.Printf("Client error reading certificate file: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		socketTag, err := muxSocketTag(currentTarget.Label, cert)
+		if err != nil {
+			fmt.Printf("Client error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		muxOptions, err := muxOptionArgs(controlDir, socketTag, muxPersist)
+		if err != nil {
+			fmt.Printf("Client error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		options = append(options, muxOptions...)
+	} else if !mux {
+		options = append(options, noMuxOptionArgs()...)
+	}
+	sshArgs = append(sshArgs[:len(sshArgs)-1], append(options, host)...)
+
+	// Remote command runs instead of a shell, as used by automation
+	remoteCommand, err := cmd.Flags().GetString("command")
+	if err != nil {
+		fmt.Printf("Client error parsing command option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	if remoteCommand != "" {
+		sshArgs = append(sshArgs, remoteCommand)
+	}
+
+	// Check for dry flag
+	dry, err := cmd.Flags().GetBool("dry")
+	if err != nil {
+		fmt.Printf("Client error parsing dry option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	if dry {
+		// Run echoed ssh command (audited)
 		// #nosec
-		sh := exec.Command("ssh", "-i", keyFile, "-i", certFile, "-l", username, "-p", port, args[0])
+		sh := exec.Command("echo", append([]string{"ssh"}, sshArgs...)...)
 		sh.Stdout = os.Stdout
-		sh.Stdin = os.Stdin
-		sh.Stderr = os.Stderr
 		err = sh.Run()
 		if err != nil {
 			fmt.Printf("Client error running command: (%s)\n", err.Error())
 			os.Exit(1)
 		}
 		os.Exit(0)
-	},
+	}
+
+	// ssh command is printed to help debugging connections, it has file paths but never key contents
+	verbose, err := cmd.Flags().GetBool("verbose")
+	if err != nil {
+		fmt.Printf("Client error parsing verbose option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Running %s\n", sshCommandLine(sshArgs))
+	}
+
+	// Record of this connection for gsh last is best effort, it never blocks connecting
+	if last, err := newLastConnection(currentTarget, host, port, username, keyFile, certFile, knownHostsFile, sshArgs, time.Now()); err == nil {
+		if path, err := files.LastConnectionPath(); err == nil {
+			_ = writeLastConnection(path, last)
+		}
+	}
+
+	// Run ssh command limited by session timeout, killing ssh and processes started by it.
+	// Remote commands (not interactive) are also limited by --deadline.
+	sessionTimeout, err := cmd.Flags().GetDuration("session-timeout")
+	if err != nil {
+		fmt.Printf("Client error parsing session-timeout option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	sessionContext := context.Background()
+	if _, ok := config.Context().Deadline(); ok && remoteCommand != "" {
+		sessionContext = config.Context()
+	}
+	if sessionTimeout > 0 || sessionContext != context.Background() {
+		ctx := sessionContext
+		if sessionTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(sessionContext, sessionTimeout)
+			defer cancel()
+		}
+		sh := sessionCommand(ctx, "ssh", sshArgs...)
+		sh.Stdout = os.Stdout
+		sh.Stderr = os.Stderr
+		// ssh runs in its own process group, which can't read from the terminal (the reason
+		// --session-timeout requires --command)
+		if !isTerminal(os.Stdin) {
+			sh.Stdin = os.Stdin
+		}
+		err = runSession(ctx, sh)
+		if err == errSessionTimeout {
+			if deadlineErr := config.DeadlineError(); deadlineErr != nil {
+				fmt.Printf("Client error running command: (%s, ssh was killed)\n", deadlineErr.Error())
+			} else {
+				fmt.Printf("Client error running command: (%s after %s)\n", err.Error(), sessionTimeout)
+			}
+			os.Exit(sessionTimeoutExitCode)
+		}
+		if err != nil {
+			fmt.Printf("Client error running command: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Run ssh command (audited)
+	// #nosec
+	sh := exec.Command("ssh", sshArgs...)
+	sh.Stdout = os.Stdout
+	sh.Stdin = os.Stdin
+	sh.Stderr = os.Stderr
+	err = sh.Run()
+	if err != nil {
+		fmt.Printf("Client error running command: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// certReuseMargin keeps cached certificates about to expire from being reused
+const certReuseMargin = 30 * time.Second
+
+// certCache is the entry of an issued certificate at the reuse cache
+type certCache struct {
+	KeyFile     string    `json:"key_file"`
+	CertFile    string    `json:"cert_file"`
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+// certCacheName returns the reuse cache name of certificates for username at host and port from
+// sourceIP. Roles can limit destination ports, so certificates are never reused at another port.
+func certCacheName(username string, host string, port string, sourceIP string) string {
+	name := fmt.Sprintf("cert-%s@%s:%s-%s", username, host, port, sourceIP)
+	return strings.NewReplacer("/", "_", ":", "_", "\\", "_").Replace(name)
 }
 
+// reusableCert tells whether cached is still valid at now, with both files available
+func reusableCert(cached certCache, now time.Time) bool {
+	if !cached.ValidBefore.After(now.Add(certReuseMargin)) {
+		return false
+	}
+	for _, file := range []string{cached.KeyFile, cached.CertFile} {
+		if _, err := os.Stat(file); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// readCertCache returns the cached certificate at name when it can be reused at now
+func readCertCache(name string, now time.Time) (certCache, bool) {
+	cached := certCache{}
+	data, err := files.ReadCache(name, 24*time.Hour)
+	if err != nil || json.Unmarshal(data, &cached) != nil {
+		return cached, false
+	}
+	return cached, reusableCert(cached, now)
+}
+
+// writeCertCache stores cached at the reuse cache
+func writeCertCache(name string, cached certCache) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	return files.WriteCache(name, data)
+}
+
+// resolveHost returns the ip address of host using resolver, preferring IPv4. IP addresses are
+// returned as they are.
+func resolveHost(ctx context.Context, resolver *net.Resolver, host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no address found for %s", host)
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP.String(), nil
+		}
+	}
+	return addrs[0].IP.String(), nil
+}
+
+// postCertRequest posts a certificate request (JSON) to GSH API at endpoint, returning the response
+// status and body. It is the request of host-connect, also measured by gsh bench.
+func postCertRequest(netClient *http.Client, endpoint string, accessToken string, certRequestJSON []byte) (int, []byte, error) {
+	req, err := http.NewRequest("POST", endpoint+"/certificates", bytes.NewBuffer(certRequestJSON))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "JWT "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// certRequestRetries is how many times a certificate request refused because GSH API signers are busy is retried
+const certRequestRetries = 3
+
+// busyRetryDelay tells whether a certificate response is a refusal because GSH API signers are busy
+// (503 with retry_after), to be retried, and the wait before the next attempt, doubled at each one.
+// Other 503 responses, as maintenance mode, are not retried.
+func busyRetryDelay(status int, body []byte, attempt int) (time.Duration, bool) {
+	if status != http.StatusServiceUnavailable || attempt >= certRequestRetries {
+		return 0, false
+	}
+	busy := struct {
+		RetryAfter string `json:"retry_after"`
+	}{}
+	if err := json.Unmarshal(body, &busy); err != nil {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(busy.RetryAfter)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second << uint(attempt), true
+}
+
+// useCachedCert prints the files of cached (--no-shell) or connects on host with them, exiting
+func useCachedCert(cmd *cobra.Command, currentTarget *types.Target, cached certCache, noShell bool, username string, port string, host string) {
+	if noShell {
+		fmt.Printf("Private key: %s\nCertificate: %s\n", cached.KeyFile, cached.CertFile)
+		os.Exit(0)
+	}
+	connectHost(cmd, currentTarget, cached.KeyFile, cached.CertFile, true, username, port, host)
+}
+
+// connectDiscovery makes GSH API discovery with discover and renews the stored token with it.
+// A failed discovery is returned as it is and no token is renewed, so a GSH API unreachable since
+// discovery can still connect offline with a cached certificate.
+func connectDiscovery(discover func() (*config.DiscoveryResponse, error), renew func(*config.DiscoveryResponse) (*oauth2.Token, error)) (*config.DiscoveryResponse, *oauth2.Token, error) {
+	configResponse, err := discover()
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := renew(configResponse)
+	if err != nil {
+		return nil, nil, err
+	}
+	return configResponse, token, nil
+}
+
+// connectOffline uses the cached certificate of cacheName while GSH API is unreachable
+// (--allow-cached), exiting with apiErr (written to diag) when there is none valid. Requests that
+// are never reused (as with reason or break-glass) are not served offline either.
+func connectOffline(cmd *cobra.Command, diag io.Writer, currentTarget *types.Target, apiErr error, cacheable bool, cacheName string, noShell bool, username string, port string, host string) {
+	if !cacheable {
+		fmt.Fprintf(diag, "Client error: GSH API is unreachable and this request can't use a cached certificate (%s)\n", apiErr.Error())
+		os.Exit(1)
+	}
+	cached, _ := readCertCache(cacheName, time.Now())
+	cached, err := offlineCert(apiErr, cached, time.Now())
+	if err != nil {
+		fmt.Fprintf(diag, "Client error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: GSH API is unreachable, offline mode is using the cached certificate valid until %s\n",
+		cached.ValidBefore.Local().Format(time.RFC3339))
+	useCachedCert(cmd, currentTarget, cached, noShell, username, port, host)
+}
+
+// apiUnreachable tells whether err is a network failure reaching GSH API, not a response from it
+func apiUnreachable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// offlineCert returns cached to be used while GSH API is unreachable. Only network failures
+// fall back to it, and only while it is valid at now, so expired certificates are never used.
+func offlineCert(apiErr error, cached certCache, now time.Time) (certCache, error) {
+	if !apiUnreachable(apiErr) {
+		return cached, apiErr
+	}
+	if !reusableCert(cached, now) {
+		return cached, fmt.Errorf("GSH API is unreachable and there is no valid cached certificate (%s)", apiErr.Error())
+	}
+	return cached, nil
+}
+
+// discoverSSHPort asks gsh-agent running on host (listening on agentPort) which port must be used to
+// connect on its sshd
+func discoverSSHPort(client *http.Client, host string, agentPort string) (string, error) {
+	resp, err := client.Get("http://" + net.JoinHostPort(host, agentPort) + types.AgentMetadataPath)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gsh-agent metadata returned %s", resp.Status)
+	}
+	var metadata types.AgentMetadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("invalid gsh-agent metadata (%s)", err.Error())
+	}
+	if p, err := strconv.Atoi(metadata.SSHPort); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("invalid ssh port %q in gsh-agent metadata", metadata.SSHPort)
+	}
+	return metadata.SSHPort, nil
+}
+
+// endpointAddress returns host:port of GSH API endpoint, using the default port of the scheme
+// when the URL has no explicit port
+func endpointAddress(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		default:
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// outboundIPRetryDelay is the delay between attempts of dialing the addresses used to discover the local ip
+const outboundIPRetryDelay = 200 * time.Millisecond
+
+// outboundIP returns the preferred outbound ip of this machine, dialing addresses in order up to
+// retries times. The dial only discovers the local ip, so when every attempt fails the first
+// global unicast address of local interfaces is used instead of giving up.
+func outboundIP(addresses []string, retries int, dial func(address string) (net.Conn, error), interfaceAddrs func() ([]net.Addr, error)) (net.IP, error) {
+	for attempt := 0; attempt < retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(outboundIPRetryDelay)
+		}
+		for _, address := range addresses {
+			conn, err := dial(address)
+			if err != nil {
+				continue
+			}
+			localAddr, ok := conn.LocalAddr().(*net.TCPAddr)
+			conn.Close()
+			if ok {
+				return localAddr.IP, nil
+			}
+		}
+	}
+
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, errors.New("no global unicast address at local interfaces")
+}
+
+// sshCommandArgs returns the ssh arguments to connect on host using the issued certificate.
+// Host keys are checked against the managed known_hosts of current target: unknown hosts are
+// accepted and recorded there, while changed host keys are refused.
+func sshCommandArgs(keyFile string, certFile string, pkcs11Provider string, knownHostsFile string, username string, port string, host string) []string {
+	// the private key of the certificate is used through PKCS#11 provider, at the hardware token
+	if pkcs11Provider != "" {
+		return []string{
+			"-o", "PKCS11Provider=" + pkcs11Provider,
+			"-o", "CertificateFile=" + certFile,
+			"-o", "UserKnownHostsFile=" + knownHostsFile,
+			"-o", "StrictHostKeyChecking=accept-new",
+			"-l", username,
+			"-p", port,
+			host,
+		}
+	}
+	// without key file, the private key of the certificate is expected at ssh-agent
+	if keyFile == "" {
+		return []string{
+			"-o", "CertificateFile=" + certFile,
+			"-o", "UserKnownHostsFile=" + knownHostsFile,
+			"-o", "StrictHostKeyChecking=accept-new",
+			"-l", username,
+			"-p", port,
+			host,
+		}
+	}
+	return []string{
+		"-i", keyFile,
+		"-i", certFile,
+		"-o", "UserKnownHostsFile=" + knownHostsFile,
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-l", username,
+		"-p", port,
+		host,
+	}
+}
+
+// sshOptionArgs returns the ssh arguments of ssh own verbosity (-v, repeated up to 3 times) and of the
+// timeout connecting on host (ConnectTimeout, in seconds rounded up). Zero values add no arguments.
+func sshOptionArgs(verbosity int, connectTimeout time.Duration) ([]string, error) {
+	if verbosity < 0 || verbosity > 3 {
+		return nil, fmt.Errorf("ssh verbosity must be from 0 to 3 (%d)", verbosity)
+	}
+	if connectTimeout < 0 {
+		return nil, fmt.Errorf("connect timeout must not be negative (%s)", connectTimeout)
+	}
+	var args []string
+	if verbosity > 0 {
+		args = append(args, "-"+strings.Repeat("v", verbosity))
+	}
+	if connectTimeout > 0 {
+		seconds := int64((connectTimeout + time.Second - 1) / time.Second)
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", seconds))
+	}
+	return args, nil
+}
+
+// muxEnabled tells whether connections share a ssh ControlMaster session. It is disabled by --no-mux,
+// with --session-timeout (a persisted master would outlive the killed session) and on Windows,
+// where OpenSSH does not support ControlMaster.
+func muxEnabled(noMux bool, sessionTimeout time.Duration, goos string) bool {
+	return !noMux && sessionTimeout == 0 && goos != "windows"
+}
+
+// muxControlDir returns the folder of ControlMaster sockets of current target, if multiplexing is enabled
+func muxControlDir(cmd *cobra.Command) (string, bool) {
+	noMux, err := cmd.Flags().GetBool("no-mux")
+	if err != nil {
+		fmt.Printf("Client error parsing no-mux option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	sessionTimeout, err := cmd.Flags().GetDuration("session-timeout")
+	if err != nil {
+		fmt.Printf("Client error parsing session-timeout option: (%s)\n", err.Error())
+		os.Exit(1)
+	}
+	if !muxEnabled(noMux, sessionTimeout, runtime.GOOS) {
+		return "", false
+	}
+	// multiplexing is an optimization, connections are made without it when its folder is not available
+	controlDir, err := files.MuxPath()
+	if err != nil {
+		return "", false
+	}
+	return controlDir, true
+}
+
+// muxSocketTag returns the tag of ControlMaster sockets of cert (authorized key format) at target
+// label, so a session is only shared by connections using the same certificate
+func muxSocketTag(label string, cert []byte) (string, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(cert)
+	if err != nil {
+		return "", fmt.Errorf("certificate can't be parsed (%s)", err.Error())
+	}
+	sum := sha256.Sum256([]byte(label + "\n" + ssh.FingerprintSHA256(publicKey)))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// muxOptionArgs returns the ssh arguments sharing connections through a ControlMaster socket at
+// controlDir, kept for persist after the last session is closed (0 closes it with the first session).
+// Sockets are named by socketTag (target and certificate) and by ssh from local host, host, port and
+// user (%C), short enough for socket paths.
+func muxOptionArgs(controlDir string, socketTag string, persist time.Duration) ([]string, error) {
+	if persist < 0 {
+		return nil, fmt.Errorf("mux persist must not be negative (%s)", persist)
+	}
+	controlPersist := "no"
+	if persist > 0 {
+		controlPersist = strconv.FormatInt(int64((persist+time.Second-1)/time.Second), 10)
+	}
+	return []string{
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + filepath.Join(controlDir, socketTag+"-%C"),
+		"-o", "ControlPersist=" + controlPersist,
+	}, nil
+}
+
+// noMuxOptionArgs returns the ssh arguments keeping a connection from creating or joining a
+// ControlMaster, including ones configured at ssh_config
+func noMuxOptionArgs() []string {
+	return []string{"-o", "ControlMaster=no", "-o", "ControlPath=none"}
+}
+
+// sshCommandLine returns the ssh command with sshArgs as it would be typed at a shell, quoting
+// arguments with spaces or shell characters
+func sshCommandLine(sshArgs []string) string {
+	line := []string{"ssh"}
+	for _, arg := range sshArgs {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`!*?&;|<>()[]{}#~") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		line = append(line, arg)
+	}
+	return strings.Join(line, " ")
+}
+
+// outputPaths validates --key-out and --cert-out, used only with --no-shell, and returns the
+// certificate path. The certificate defaults to the key path with "-cert.pub" suffix, where ssh
+// looks for it (https://man.openbsd.org/ssh.1#i). keySource is the option of a key not generated
+// by gsh (--public-key or --pkcs11), its private key is not known so only --cert-out is accepted.
+func outputPaths(noShell bool, keySource string, keyOut string, certOut string) (string, error) {
+	if keyOut == "" && certOut == "" {
+		return "", nil
+	}
+	if !noShell {
+		return "", errors.New("--key-out and --cert-out are used only with --no-shell")
+	}
+	if keySource != "" {
+		if keyOut != "" {
+			return "", errors.New("--key-out is not used with " + keySource + ", the private key is not generated by gsh")
+		}
+		return certOut, nil
+	}
+	if keyOut == "" {
+		return "", errors.New("--cert-out requires --key-out, the generated private key must be written too")
+	}
+	if certOut == "" {
+		certOut = keyOut + "-cert.pub"
+	}
+	if filepath.Clean(certOut) == filepath.Clean(keyOut) {
+		return "", errors.New("--key-out and --cert-out must be different files")
+	}
+	return certOut, nil
+}
+
+// readPublicKey reads the public key to be certified from path, in authorized_keys format
+func readPublicKey(path string) (string, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a public key in authorized_keys format (%s)", path, err.Error())
+	}
+	if _, ok := publicKey.(*ssh.Certificate); ok {
+		return "", fmt.Errorf("%s is a certificate, not a public key", path)
+	}
+	return string(ssh.MarshalAuthorizedKey(publicKey)), nil
+}
+
+// privateKeyFile returns the private key beside publicKeyFile (as id_rsa for id_rsa.pub), or
+// empty when there is none and the key is expected at ssh-agent
+func privateKeyFile(publicKeyFile string) string {
+	if !strings.HasSuffix(publicKeyFile, ".pub") {
+		return ""
+	}
+	keyFile := strings.TrimSuffix(publicKeyFile, ".pub")
+	if _, err := os.Stat(keyFile); err != nil {
+		return ""
+	}
+	return keyFile
+}
+
+// claimUsername returns the username claim from ID token or access token, calling userInfo
+// only when neither token carries the claim
+func claimUsername(token *oauth2.Token, claim string, userInfo func() (string, error)) (string, error) {
+	if idToken, ok := token.Extra("id_token").(string); ok && idToken != "" {
+		if username, err := handlers.GetClaim(idToken, claim); err == nil && username != "" {
+			return username, nil
+		}
+	}
+	if username, err := handlers.GetClaim(token.AccessToken, claim); err == nil && username != "" {
+		return username, nil
+	}
+	return userInfo()
+}
+
+// tokenClaim returns claim of the ID token or, when it is not there, of the access token (JWT). Claims
+// are read by their name at the token, as principal_claim of GSH API names them.
+func tokenClaim(token *oauth2.Token, claim string) (string, bool) {
+	jwts := []string{token.AccessToken}
+	if idToken, ok := token.Extra("id_token").(string); ok && idToken != "" {
+		jwts = append([]string{idToken}, jwts...)
+	}
+	for _, jwt := range jwts {
+		parts := strings.Split(jwt, ".")
+		if len(parts) != 3 {
+			continue
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		claims := map[string]interface{}{}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			continue
+		}
+		if value, ok := claims[claim].(string); ok && value != "" {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// userInfoUsername reads the username claim from OIDC userinfo endpoint, caching it briefly per account.
+// OIDC provider metadata is retried up to retries times on network failures.
+func userInfoUsername(issuer string, claim string, account string, token *oauth2.Token, retries int) (string, error) {
+	cacheName := "userinfo"
+	if account != "" {
+		cacheName += "-" + account
+	}
+	if cached, err := files.ReadCache(cacheName, userInfoCacheDuration); err == nil && len(cached) > 0 {
+		return string(cached), nil
+	}
+
+	ctx := config.Context()
+	oauth2provider, err := retryProvider(issuer, retries)
+	if err != nil {
+		return "", err
+	}
+	userInfo, err := oauth2provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+	if err != nil {
+		return "", err
+	}
+	claims := new(handlers.IDToken)
+	if err := userInfo.Claims(claims); err != nil {
+		return "", err
+	}
+	username, err := handlers.GetTokenClaim(claims, claim)
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", fmt.Errorf("claim %s is empty at userinfo", claim)
+	}
+
+	// cache is best effort, a failure only means userinfo is called again next time
+	_ = files.WriteCache(cacheName, []byte(username))
+	return username, nil
+}
+
+// retryProvider gets OIDC provider metadata of issuer, retrying network failures up to retries times
+func retryProvider(issuer string, retries int) (*oidc.Provider, error) {
+	var oauth2provider *oidc.Provider
+	err := withRetries(retries, discoveryRetryBackoff, time.Sleep, func() error {
+		var err error
+		oauth2provider, err = oidc.NewProvider(config.Context(), issuer)
+		return err
+	})
+	return oauth2provider, err
+}
+
+// hostAlias is a host given by an alias of gsh config, with optional username and port
+type hostAlias struct {
+	Host     string
+	Port     string
+	Username string
+}
+
+// expandAlias returns the host aliased by name at aliases, written as [user@]host[:port].
+// Names that are not aliases are returned as they are.
+func expandAlias(name string, aliases map[string]string) (hostAlias, error) {
+	value, ok := aliases[name]
+	if !ok {
+		return hostAlias{Host: name}, nil
+	}
+	alias := hostAlias{Host: strings.TrimSpace(value)}
+	if at := strings.LastIndex(alias.Host, "@"); at >= 0 {
+		alias.Username, alias.Host = alias.Host[:at], alias.Host[at+1:]
+		if alias.Username == "" {
+			return hostAlias{}, fmt.Errorf("alias %s has an empty username (%s)", name, value)
+		}
+	}
+	if host, port, err := net.SplitHostPort(alias.Host); err == nil {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return hostAlias{}, fmt.Errorf("alias %s has an invalid port (%s)", name, value)
+		}
+		alias.Host, alias.Port = host, port
+	}
+	alias.Host = strings.TrimSuffix(strings.TrimPrefix(alias.Host, "["), "]")
+	if alias.Host == "" {
+		return hostAlias{}, fmt.Errorf("alias %s has an empty host (%s)", name, value)
+	}
+	return alias, nil
+}
+
+// port returns the remote port: --port flag when set (changed), otherwise the port of alias
+// or the default of --port flag
+func (a hostAlias) port(flagPort string, changed bool) string {
+	if changed || a.Port == "" {
+		return flagPort
+	}
+	return a.Port
+}
+
+// resolveUsername returns the remote username using the precedence: --username flag,
+// target default_username (or username of host alias), username claim from OIDC token and local user
+func resolveUsername(flagUsername string, targetUsername string, claimUsername func() (string, error)) (string, error) {
+	if flagUsername != "" {
+		return flagUsername, nil
+	}
+	if targetUsername != "" {
+		return targetUsername, nil
+	}
+
+	username, err := claimUsername()
+	if err != nil {
+		return "", fmt.Errorf("username from token (%s)", err.Error())
+	}
+	if username != "" {
+		return username, nil
+	}
+	return localUsername()
+}
+
+// checkAllowedUser checks username against allowed_users of target, a list of remote users
+// expected by the user. It is only a guardrail for typos, an empty list allows any username.
+func checkAllowedUser(username string, target string, allowedUsers []string) error {
+	if len(allowedUsers) == 0 {
+		return nil
+	}
+	for _, allowed := range allowedUsers {
+		if username == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("remote user %s is not at allowed_users of target %s (%s), check --username or the target config",
+		username, target, strings.Join(allowedUsers, ", "))
+}
+
+// localUsername returns the username of the local user running gsh
+func localUsername() (string, error) {
+	userLocal, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return userLocal.Username, nil
+}
+
+// breakGlassWarning is printed when a certificate is requested using break-glass roles
+const breakGlassWarning = `
+********************************************************************
+* WARNING: BREAK-GLASS ACCESS                                      *
+* You are using emergency roles. Security has been alerted and     *
+* this access is recorded with your reason for later review.       *
+********************************************************************
+`
+
 func init() {
 	rootCmd.AddCommand(hostConnectCmd)
 
@@ -302,8 +1492,35 @@ func init() {
 	// is called directly, e.g.:
 	// hostConnectCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	hostConnectCmd.Flags().StringP("key-type", "t", "rsa", "Defines type of auto generated ssh key pair (rsa)")
+	hostConnectCmd.Flags().String("public-key", "", "Defines an existing public key file to be certified instead of generating a key pair (its private key must be beside it or at ssh-agent)")
+	hostConnectCmd.Flags().String("pkcs11", "", "Defines a PKCS#11 provider (as /usr/lib/x86_64-linux-gnu/opensc-pkcs11.so) to certify a key at a hardware token, used by ssh without writing a private key file")
+	hostConnectCmd.Flags().String("pkcs11-key", "", "Defines the SHA256 fingerprint of the key to be certified, when the PKCS#11 token has many keys (used with --pkcs11)")
 	hostConnectCmd.Flags().StringP("username", "u", "from OIDC token", "Defines remote user to connect on remote host")
+	hostConnectCmd.Flags().StringArray("principal", nil, "Defines an extra principal of the certificate besides remote user, can be repeated (all must be authorized by roles)")
+	hostConnectCmd.Flags().Bool("as-local-user", false, "Requests the certificate for the local user running gsh (not used with --username)")
 	hostConnectCmd.Flags().StringP("source", "s", "local ip address", "Defines user IP used as source to connect on remote host")
 	hostConnectCmd.Flags().StringP("port", "p", "22", "Defines destination port used to connect on remote host")
+	hostConnectCmd.Flags().Bool("discover", false, "Discovers destination port from gsh-agent running on remote host (not used with --port)")
+	hostConnectCmd.Flags().String("agent-port", types.AgentMetadataPort, "Defines the port where gsh-agent serves metadata on remote host (used with --discover)")
+	hostConnectCmd.Flags().Int("discovery-retries", 2, "Defines how many times GSH API discovery and OIDC provider metadata are retried on network failures, before using the cached discovery")
+	hostConnectCmd.Flags().Int("dial-retries", 3, "Defines how many times remote host and GSH API are dialed to discover local ip address, before using local interfaces")
+	hostConnectCmd.Flags().StringP("reason", "r", "", "Defines the reason (or ticket ID) for this access, registered at audit and optionally embedded in certificate")
+	hostConnectCmd.Flags().String("impersonate", "", "Requests the certificate on behalf of this user, authorized by the user's roles. Only for admins, requires --reason and it is audited")
+	hostConnectCmd.Flags().Bool("break-glass", false, "Uses emergency break-glass roles, ignoring ip restrictions. Requires --reason and security is alerted")
+	hostConnectCmd.Flags().BoolP("verbose", "v", false, "Prints details, as the certificate expiration and the ssh command")
+	hostConnectCmd.Flags().Bool("allow-cached", false, "Uses a valid cached certificate (as --reuse) when GSH API is unreachable, warning about offline mode")
+	hostConnectCmd.Flags().Bool("reuse", false, "Reuses the certificate issued for the same user, host and source ip while it is valid (not used with --reason)")
+	hostConnectCmd.Flags().String("command", "", "Defines a command to run on remote host instead of opening a shell")
+	hostConnectCmd.Flags().Int("ssh-verbose", 0, "Defines ssh own verbosity, from 1 (-v) to 3 (-vvv), to debug connectivity")
+	hostConnectCmd.Flags().Duration("connect-timeout", 0, "Defines the timeout connecting on remote host, as ssh ConnectTimeout (0 uses ssh default)")
+	hostConnectCmd.Flags().Bool("no-mux", false, "Does not share ssh sessions to the same host (ControlMaster), each connection makes its own handshake")
+	hostConnectCmd.Flags().Duration("mux-persist", 10*time.Minute, "Defines how long a shared ssh session (ControlMaster) is kept after the last connection is closed (0 closes it with the first connection)")
+	hostConnectCmd.Flags().Duration("session-timeout", 0, "Kills the ssh session of --command (and processes started by it) after this duration, exiting with code 124 (0 disables it)")
+	hostConnectCmd.Flags().Bool("no-shell", false, "Requests the certificate without connecting to the remote host, printing the files paths")
+	hostConnectCmd.Flags().Bool("print-raw-cert", false, "Writes only the certificate to stdout, as ssh-keygen -L -f - reads it (implies --no-shell, other messages go to stderr)")
+	hostConnectCmd.Flags().String("key-out", "", "Defines where the generated private key is written, with mode 0600 (used with --no-shell)")
+	hostConnectCmd.Flags().String("cert-out", "", "Defines where the certificate is written, with mode 0600 (used with --no-shell, default is --key-out with -cert.pub suffix)")
 	hostConnectCmd.Flags().BoolP("dry", "d", false, "Does not connect to the remote host using SSH, just prints the command to be executed")
+	hostConnectCmd.Flags().BoolP("wait", "w", false, "Waits for approval when the certificate request requires it, instead of printing the request ID and exiting")
+	hostConnectCmd.Flags().Duration("wait-timeout", 15*time.Minute, "Defines the maximum time waiting for approval (used with --wait)")
 }
@@ -0,0 +1,1056 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/oauth2"
+)
+
+func TestExpandAlias(t *testing.T) {
+	aliases := map[string]string{
+		"db1":    "deploy@db1.prod.internal:22000",
+		"web":    "web1.prod.internal",
+		"v6":     "[2001:db8::1]:2222",
+		"bad":    "db1.prod.internal:ssh",
+		"nouser": "@db1.prod.internal",
+	}
+
+	t.Run(
+		"Not an alias",
+		func(t *testing.T) {
+			alias, err := expandAlias("db2.prod.internal", aliases)
+			if err != nil || alias != (hostAlias{Host: "db2.prod.internal"}) {
+				t.Fatalf("expandAlias: check fail with host (%v, %v)", alias, err)
+			}
+		})
+	t.Run(
+		"User, host and port",
+		func(t *testing.T) {
+			alias, err := expandAlias("db1", aliases)
+			if err != nil || alias != (hostAlias{Host: "db1.prod.internal", Port: "22000", Username: "deploy"}) {
+				t.Fatalf("expandAlias: check fail with full alias (%v, %v)", alias, err)
+			}
+		})
+	t.Run(
+		"Host only",
+		func(t *testing.T) {
+			alias, err := expandAlias("web", aliases)
+			if err != nil || alias != (hostAlias{Host: "web1.prod.internal"}) {
+				t.Fatalf("expandAlias: check fail with host alias (%v, %v)", alias, err)
+			}
+		})
+	t.Run(
+		"IPv6 with port",
+		func(t *testing.T) {
+			alias, err := expandAlias("v6", aliases)
+			if err != nil || alias != (hostAlias{Host: "2001:db8::1", Port: "2222"}) {
+				t.Fatalf("expandAlias: check fail with IPv6 alias (%v, %v)", alias, err)
+			}
+		})
+	t.Run(
+		"Invalid aliases",
+		func(t *testing.T) {
+			for _, name := range []string{"bad", "nouser"} {
+				if _, err := expandAlias(name, aliases); err == nil {
+					t.Fatalf("expandAlias: check fail with invalid alias %s", name)
+				}
+			}
+		})
+	t.Run(
+		"Port flag over alias",
+		func(t *testing.T) {
+			alias, _ := expandAlias("db1", aliases)
+			if port := alias.port("2200", true); port != "2200" {
+				t.Fatalf("expandAlias: check fail with --port (%s)", port)
+			}
+			if port := alias.port("22", false); port != "22000" {
+				t.Fatalf("expandAlias: check fail with port of alias (%s)", port)
+			}
+			web, _ := expandAlias("web", aliases)
+			if port := web.port("22", false); port != "22" {
+				t.Fatalf("expandAlias: check fail with default port (%s)", port)
+			}
+		})
+	t.Run(
+		"Username flag over alias",
+		func(t *testing.T) {
+			alias, _ := expandAlias("db1", aliases)
+			username, _ := resolveUsername("alice", alias.Username, func() (string, error) { return "bob", nil })
+			if username != "alice" {
+				t.Fatalf("expandAlias: check fail with --username (%s)", username)
+			}
+			username, _ = resolveUsername("", alias.Username, func() (string, error) { return "bob", nil })
+			if username != "deploy" {
+				t.Fatalf("expandAlias: check fail with username of alias (%s)", username)
+			}
+		})
+}
+
+func TestResolveUsername(t *testing.T) {
+	claim := func(username string) func() (string, error) {
+		return func() (string, error) {
+			return username, nil
+		}
+	}
+
+	t.Run(
+		"Flag over target default",
+		func(t *testing.T) {
+			username, _ := resolveUsername("alice", "ops", claim("bob"))
+			if username != "alice" {
+				t.Fatalf("resolveUsername: check fail with flag (%s)", username)
+			}
+		})
+	t.Run(
+		"Target default over OIDC claim",
+		func(t *testing.T) {
+			username, _ := resolveUsername("", "ops", claim("bob"))
+			if username != "ops" {
+				t.Fatalf("resolveUsername: check fail with target default (%s)", username)
+			}
+		})
+	t.Run(
+		"OIDC claim over local user",
+		func(t *testing.T) {
+			username, _ := resolveUsername("", "", claim("bob"))
+			if username != "bob" {
+				t.Fatalf("resolveUsername: check fail with OIDC claim (%s)", username)
+			}
+		})
+	t.Run(
+		"Local user",
+		func(t *testing.T) {
+			userLocal, err := user.Current()
+			if err != nil {
+				t.Skipf("resolveUsername: local user not available (%v)", err)
+			}
+			username, _ := resolveUsername("", "", claim(""))
+			if username != userLocal.Username {
+				t.Fatalf("resolveUsername: check fail with local user (%s)", username)
+			}
+		})
+	t.Run(
+		"OIDC claim error",
+		func(t *testing.T) {
+			_, err := resolveUsername("", "", func() (string, error) {
+				return "", errors.New("claim not found")
+			})
+			if err == nil {
+				t.Fatalf("resolveUsername: check fail with OIDC claim error")
+			}
+		})
+}
+
+func TestLocalUsername(t *testing.T) {
+	userLocal, err := user.Current()
+	if err != nil {
+		t.Skipf("localUsername: local user not available (%v)", err)
+	}
+	username, err := localUsername()
+	if err != nil || username != userLocal.Username {
+		t.Fatalf("localUsername: check fail with local user (%s, %v)", username, err)
+	}
+}
+
+func TestSSHCommandArgs(t *testing.T) {
+	args := sshCommandArgs("/tmp/key", "/tmp/key-cert.pub", "", "/tmp/known_hosts", "alice", "2222", "host.example.com")
+
+	option := func(value string) bool {
+		for i := 0; i < len(args)-1; i++ {
+			if args[i] == "-o" && args[i+1] == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run(
+		"Managed known_hosts",
+		func(t *testing.T) {
+			if !option("UserKnownHostsFile=/tmp/known_hosts") {
+				t.Fatalf("sshCommandArgs: check fail with known_hosts option (%v)", args)
+			}
+		})
+	t.Run(
+		"Host key checking",
+		func(t *testing.T) {
+			if !option("StrictHostKeyChecking=accept-new") {
+				t.Fatalf("sshCommandArgs: check fail with host key checking option (%v)", args)
+			}
+		})
+	t.Run(
+		"Host as last argument",
+		func(t *testing.T) {
+			if args[len(args)-1] != "host.example.com" {
+				t.Fatalf("sshCommandArgs: check fail with host (%v)", args)
+			}
+		})
+}
+
+func TestSSHOptionArgs(t *testing.T) {
+	t.Run(
+		"Verbosity",
+		func(t *testing.T) {
+			for verbosity, expected := range []string{"", "-v", "-vv", "-vvv"} {
+				args, err := sshOptionArgs(verbosity, 0)
+				if err != nil || strings.Join(args, " ") != expected {
+					t.Fatalf("sshOptionArgs: check fail with verbosity %d (%v, %v)", verbosity, args, err)
+				}
+			}
+			if _, err := sshOptionArgs(4, 0); err == nil {
+				t.Fatalf("sshOptionArgs: check fail with verbosity 4")
+			}
+		})
+	t.Run(
+		"Connect timeout",
+		func(t *testing.T) {
+			args, err := sshOptionArgs(0, 10*time.Second)
+			if err != nil || strings.Join(args, " ") != "-o ConnectTimeout=10" {
+				t.Fatalf("sshOptionArgs: check fail with connect timeout (%v, %v)", args, err)
+			}
+			args, _ = sshOptionArgs(0, 1500*time.Millisecond)
+			if strings.Join(args, " ") != "-o ConnectTimeout=2" {
+				t.Fatalf("sshOptionArgs: check fail rounding connect timeout (%v)", args)
+			}
+			if _, err := sshOptionArgs(0, -time.Second); err == nil {
+				t.Fatalf("sshOptionArgs: check fail with negative connect timeout")
+			}
+		})
+	t.Run(
+		"Composed with other options",
+		func(t *testing.T) {
+			sshArgs := sshCommandArgs("/tmp/key", "/tmp/key-cert.pub", "", "/tmp/known_hosts", "alice", "2222", "host.example.com")
+			options, _ := sshOptionArgs(2, 5*time.Second)
+			sshArgs = append(sshArgs[:len(sshArgs)-1], append(options, "host.example.com")...)
+			expected := "ssh -i /tmp/key -i /tmp/key-cert.pub -o UserKnownHostsFile=/tmp/known_hosts -o StrictHostKeyChecking=accept-new " +
+				"-l alice -p 2222 -vv -o ConnectTimeout=5 host.example.com"
+			if line := sshCommandLine(sshArgs); line != expected {
+				t.Fatalf("sshOptionArgs: check fail composing options (%s)", line)
+			}
+		})
+}
+
+func TestSSHCommandLine(t *testing.T) {
+	args := append(sshCommandArgs("/tmp/key", "/tmp/key-cert.pub", "", "/tmp/known_hosts", "alice", "2222", "host.example.com"), "uptime; df -h")
+
+	t.Run(
+		"Complete argv",
+		func(t *testing.T) {
+			expected := "ssh -i /tmp/key -i /tmp/key-cert.pub -o UserKnownHostsFile=/tmp/known_hosts -o StrictHostKeyChecking=accept-new " +
+				"-l alice -p 2222 host.example.com 'uptime; df -h'"
+			if line := sshCommandLine(args); line != expected {
+				t.Fatalf("sshCommandLine: check fail with argv (%s)", line)
+			}
+		})
+	t.Run(
+		"Quoted arguments",
+		func(t *testing.T) {
+			if line := sshCommandLine([]string{"-i", "/home/a b/key", "it's", ""}); line != `ssh -i '/home/a b/key' 'it'\''s' ''` {
+				t.Fatalf("sshCommandLine: check fail quoting arguments (%s)", line)
+			}
+		})
+}
+
+func TestClaimUsername(t *testing.T) {
+	jwt := func(payload string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+	}
+	userInfoCalls := 0
+	userInfo := func() (string, error) {
+		userInfoCalls++
+		return "from-userinfo", nil
+	}
+
+	t.Run(
+		"Claim at ID token",
+		func(t *testing.T) {
+			userInfoCalls = 0
+			token := (&oauth2.Token{AccessToken: jwt(`{}`)}).WithExtra(map[string]interface{}{
+				"id_token": jwt(`{"preferred_username":"alice"}`),
+			})
+			username, err := claimUsername(token, "PreferredUsername", userInfo)
+			if err != nil || username != "alice" || userInfoCalls != 0 {
+				t.Fatalf("claimUsername: check fail with ID token claim (%s, %v, %d calls)", username, err, userInfoCalls)
+			}
+		})
+	t.Run(
+		"Claim at access token",
+		func(t *testing.T) {
+			userInfoCalls = 0
+			token := &oauth2.Token{AccessToken: jwt(`{"preferred_username":"bob"}`)}
+			username, err := claimUsername(token, "PreferredUsername", userInfo)
+			if err != nil || username != "bob" || userInfoCalls != 0 {
+				t.Fatalf("claimUsername: check fail with access token claim (%s, %v, %d calls)", username, err, userInfoCalls)
+			}
+		})
+	t.Run(
+		"Fallback to userinfo",
+		func(t *testing.T) {
+			userInfoCalls = 0
+			token := &oauth2.Token{AccessToken: "opaque-token"}
+			username, err := claimUsername(token, "PreferredUsername", userInfo)
+			if err != nil || username != "from-userinfo" || userInfoCalls != 1 {
+				t.Fatalf("claimUsername: check fail with userinfo fallback (%s, %v, %d calls)", username, err, userInfoCalls)
+			}
+		})
+}
+
+func TestOutboundIP(t *testing.T) {
+	failDial := func(address string) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	interfaceAddrs := func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+			&net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(24, 32)},
+		}, nil
+	}
+
+	t.Run(
+		"Dial succeeds",
+		func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("outboundIP: fail listening (%v)", err)
+			}
+			defer listener.Close()
+			dial := func(address string) (net.Conn, error) {
+				return net.Dial("tcp", address)
+			}
+			ip, err := outboundIP([]string{"127.0.0.1:1", listener.Addr().String()}, 1, dial, interfaceAddrs)
+			if err != nil || !ip.Equal(net.ParseIP("127.0.0.1")) {
+				t.Fatalf("outboundIP: check fail with dial (%v, %v)", ip, err)
+			}
+		})
+	t.Run(
+		"Fallback to local interfaces",
+		func(t *testing.T) {
+			ip, err := outboundIP([]string{"host:22", "gsh.example.com:443"}, 2, failDial, interfaceAddrs)
+			if err != nil || !ip.Equal(net.ParseIP("10.0.0.5")) {
+				t.Fatalf("outboundIP: check fail with fallback (%v, %v)", ip, err)
+			}
+		})
+	t.Run(
+		"Without global unicast address",
+		func(t *testing.T) {
+			loopback := func() ([]net.Addr, error) {
+				return []net.Addr{&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}}, nil
+			}
+			if _, err := outboundIP([]string{"host:22"}, 1, failDial, loopback); err == nil {
+				t.Fatalf("outboundIP: check fail without global unicast address")
+			}
+		})
+}
+
+func TestEndpointAddress(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		address  string
+	}{
+		{"https://gsh.example.com", "gsh.example.com:443"},
+		{"https://gsh.example.com:8443/api", "gsh.example.com:8443"},
+		{"http://gsh.example.com", "gsh.example.com:80"},
+		{"http://gsh.example.com:8000", "gsh.example.com:8000"},
+		{"https://[2001:db8::1]", "[2001:db8::1]:443"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.endpoint)
+		if err != nil {
+			t.Fatalf("endpointAddress: fail parsing %s (%v)", test.endpoint, err)
+		}
+		if address := endpointAddress(u); address != test.address {
+			t.Fatalf("endpointAddress: check fail with %s (%s)", test.endpoint, address)
+		}
+	}
+}
+
+func TestCertResponse(t *testing.T) {
+	t.Run(
+		"Validity window round trip",
+		func(t *testing.T) {
+			sent := types.CertResponse{
+				Result:      "success",
+				Certificate: "ssh-ed25519-cert-v01@openssh.com AAAA",
+				ValidAfter:  time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC),
+				ValidBefore: time.Date(2019, 1, 1, 10, 10, 0, 0, time.UTC),
+			}
+			body, err := json.Marshal(sent)
+			if err != nil {
+				t.Fatalf("CertResponse: check fail marshaling (%v)", err)
+			}
+			received := types.CertResponse{}
+			if err := json.Unmarshal(body, &received); err != nil {
+				t.Fatalf("CertResponse: check fail unmarshaling (%v)", err)
+			}
+			if !received.ValidAfter.Equal(sent.ValidAfter) || !received.ValidBefore.Equal(sent.ValidBefore) {
+				t.Fatalf("CertResponse: check fail with validity window (%v)", received)
+			}
+		})
+}
+
+func TestCertCacheName(t *testing.T) {
+	t.Run(
+		"Name per port",
+		func(t *testing.T) {
+			name := certCacheName("root", "10.1.0.1", "22", "10.0.0.1")
+			if name != "cert-root@10.1.0.1_22-10.0.0.1" {
+				t.Fatalf("certCacheName: check fail with name (%s)", name)
+			}
+			if other := certCacheName("root", "10.1.0.1", "2222", "10.0.0.1"); other == name {
+				t.Fatalf("certCacheName: check fail, certificate reused at another port (%s)", other)
+			}
+		})
+	t.Run(
+		"File name",
+		func(t *testing.T) {
+			if name := certCacheName("root", "2001:db8::1", "22", "10.0.0.1"); strings.ContainsAny(name, "/:\\") {
+				t.Fatalf("certCacheName: check fail with path separators (%s)", name)
+			}
+		})
+}
+
+func TestReusableCert(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	certFile := filepath.Join(dir, "key-cert.pub")
+	for _, file := range []string{keyFile, certFile} {
+		if err := os.WriteFile(file, []byte("test"), 0600); err != nil {
+			t.Fatalf("reusableCert: check fail writing %s (%v)", file, err)
+		}
+	}
+	now := time.Now()
+
+	t.Run(
+		"Valid certificate",
+		func(t *testing.T) {
+			if !reusableCert(certCache{KeyFile: keyFile, CertFile: certFile, ValidBefore: now.Add(5 * time.Minute)}, now) {
+				t.Fatalf("reusableCert: check fail with valid certificate")
+			}
+		})
+	t.Run(
+		"Certificate about to expire",
+		func(t *testing.T) {
+			if reusableCert(certCache{KeyFile: keyFile, CertFile: certFile, ValidBefore: now.Add(10 * time.Second)}, now) {
+				t.Fatalf("reusableCert: check fail with certificate about to expire")
+			}
+		})
+	t.Run(
+		"Missing files",
+		func(t *testing.T) {
+			if reusableCert(certCache{KeyFile: keyFile, CertFile: filepath.Join(dir, "missing"), ValidBefore: now.Add(5 * time.Minute)}, now) {
+				t.Fatalf("reusableCert: check fail with missing certificate file")
+			}
+		})
+}
+
+func TestOfflineCert(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	certFile := filepath.Join(dir, "key-cert.pub")
+	for _, file := range []string{keyFile, certFile} {
+		if err := os.WriteFile(file, []byte("test"), 0600); err != nil {
+			t.Fatalf("offlineCert: check fail writing %s (%v)", file, err)
+		}
+	}
+	now := time.Now()
+
+	// a closed port gives the network error of an unreachable GSH API
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("offlineCert: check fail listening (%v)", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	_, unreachable := http.Get("http://" + address + "/status/config")
+	if unreachable == nil || !apiUnreachable(unreachable) {
+		t.Fatalf("apiUnreachable: check fail with closed port (%v)", unreachable)
+	}
+
+	t.Run(
+		"Offline fallback to valid cache",
+		func(t *testing.T) {
+			cached := certCache{KeyFile: keyFile, CertFile: certFile, ValidBefore: now.Add(5 * time.Minute)}
+			got, err := offlineCert(unreachable, cached, now)
+			if err != nil || got != cached {
+				t.Fatalf("offlineCert: check fail with valid cache (%v, %v)", got, err)
+			}
+		})
+	t.Run(
+		"Expired cache refused",
+		func(t *testing.T) {
+			cached := certCache{KeyFile: keyFile, CertFile: certFile, ValidBefore: now.Add(-time.Minute)}
+			if _, err := offlineCert(unreachable, cached, now); err == nil {
+				t.Fatalf("offlineCert: check fail, expired cache used offline")
+			}
+		})
+	t.Run(
+		"Missing cache refused",
+		func(t *testing.T) {
+			if _, err := offlineCert(unreachable, certCache{}, now); err == nil {
+				t.Fatalf("offlineCert: check fail, missing cache used offline")
+			}
+		})
+	t.Run(
+		"API responses are not offline",
+		func(t *testing.T) {
+			apiErr := errors.New("GSH API status response 500")
+			cached := certCache{KeyFile: keyFile, CertFile: certFile, ValidBefore: now.Add(5 * time.Minute)}
+			if apiUnreachable(apiErr) {
+				t.Fatalf("apiUnreachable: check fail with API response")
+			}
+			if _, err := offlineCert(apiErr, cached, now); err != apiErr {
+				t.Fatalf("offlineCert: check fail with API response (%v)", err)
+			}
+		})
+}
+
+func TestConnectDiscovery(t *testing.T) {
+	t.Run(
+		"GSH API down from the first call",
+		func(t *testing.T) {
+			// a closed port gives the network error of an unreachable GSH API
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("connectDiscovery: check fail listening (%v)", err)
+			}
+			target := &types.Target{Endpoint: "http://" + listener.Addr().String()}
+			listener.Close()
+
+			discover := func() (*config.DiscoveryResponse, error) {
+				return retryDiscovery(target, 1, time.Millisecond, func(time.Duration) {})
+			}
+			renew := func(*config.DiscoveryResponse) (*oauth2.Token, error) {
+				t.Fatalf("connectDiscovery: check fail, token renewed while GSH API is down")
+				return nil, nil
+			}
+			configResponse, token, err := connectDiscovery(discover, renew)
+			if err == nil || !apiUnreachable(err) || configResponse != nil || token != nil {
+				t.Fatalf("connectDiscovery: check fail with GSH API down (%v, %v, %v)", configResponse, token, err)
+			}
+		})
+	t.Run(
+		"Token renewed with discovery",
+		func(t *testing.T) {
+			server, _ := flakyDiscoveryServer(t, 0, http.StatusOK)
+			defer server.Close()
+			discover := func() (*config.DiscoveryResponse, error) {
+				return retryDiscovery(&types.Target{Endpoint: server.URL}, 0, time.Millisecond, func(time.Duration) {})
+			}
+			var renewedIssuer string
+			renew := func(configResponse *config.DiscoveryResponse) (*oauth2.Token, error) {
+				renewedIssuer = configResponse.Issuer
+				return &oauth2.Token{AccessToken: "access"}, nil
+			}
+			configResponse, token, err := connectDiscovery(discover, renew)
+			if err != nil || token.AccessToken != "access" || renewedIssuer != "https://oidc.example.com" || configResponse.Issuer != renewedIssuer {
+				t.Fatalf("connectDiscovery: check fail with discovery (%v, %v, %v)", configResponse, token, err)
+			}
+		})
+}
+
+func TestBusyRetryDelay(t *testing.T) {
+	busy := []byte(`{"result":"fail","message":"Too many certificates being signed","retry_after":"2"}`)
+	t.Run(
+		"Busy signers are retried with backoff",
+		func(t *testing.T) {
+			for attempt, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+				delay, retry := busyRetryDelay(http.StatusServiceUnavailable, busy, attempt)
+				if !retry || delay != want {
+					t.Fatalf("busyRetryDelay: check fail at attempt %d (%v, %v)", attempt, delay, retry)
+				}
+			}
+			if _, retry := busyRetryDelay(http.StatusServiceUnavailable, busy, certRequestRetries); retry {
+				t.Fatalf("busyRetryDelay: check fail, retried after %d attempts", certRequestRetries)
+			}
+		})
+	t.Run(
+		"Other responses are not retried",
+		func(t *testing.T) {
+			maintenance := []byte(`{"result":"fail","message":"GSH is in maintenance mode (read only)"}`)
+			if _, retry := busyRetryDelay(http.StatusServiceUnavailable, maintenance, 0); retry {
+				t.Fatalf("busyRetryDelay: check fail, maintenance mode retried")
+			}
+			if _, retry := busyRetryDelay(http.StatusForbidden, busy, 0); retry {
+				t.Fatalf("busyRetryDelay: check fail, forbidden retried")
+			}
+		})
+}
+
+func TestWriteRawCert(t *testing.T) {
+	cert := "ssh-ed25519-cert-v01@openssh.com AAAAIHNzaC1lZDI1NTE5LWNlcnQtdjAxQG9wZW5zc2guY29t alice"
+	for _, response := range []string{cert, cert + "\n", "\n" + cert + "\r\n"} {
+		var stdout strings.Builder
+		if err := writeRawCert(&stdout, response); err != nil {
+			t.Fatalf("writeRawCert: check fail writing (%v)", err)
+		}
+		if stdout.String() != cert+"\n" {
+			t.Fatalf("writeRawCert: check fail, stdout is not the certificate (%q)", stdout.String())
+		}
+	}
+}
+
+// fakeResolver answers A queries of any name with ip, and AAAA queries without addresses
+func fakeResolver(ip net.IP) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			// net.Pipe is not a packet connection, so messages are framed as DNS over TCP
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				for {
+					var length [2]byte
+					if _, err := io.ReadFull(server, length[:]); err != nil {
+						return
+					}
+					query := make([]byte, int(length[0])<<8|int(length[1]))
+					if _, err := io.ReadFull(server, query); err != nil {
+						return
+					}
+					// the question ends after the name labels, type and class
+					end := 12
+					for end < len(query) && query[end] != 0 {
+						end += int(query[end]) + 1
+					}
+					end += 5
+					answers := byte(0)
+					if query[end-3] == 1 {
+						answers = 1
+					}
+					response := append([]byte{query[0], query[1], 0x81, 0x80, 0, 1, 0, answers, 0, 0, 0, 0}, query[12:end]...)
+					if answers == 1 {
+						response = append(response, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+						response = append(response, ip.To4()...)
+					}
+					if _, err := server.Write(append([]byte{byte(len(response) >> 8), byte(len(response))}, response...)); err != nil {
+						return
+					}
+				}
+			}()
+			return client, nil
+		},
+	}
+}
+
+func TestResolveHost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	failing := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return nil, errors.New("resolver unreachable")
+		},
+	}
+
+	t.Run(
+		"Host resolved by custom resolver",
+		func(t *testing.T) {
+			ip, err := resolveHost(ctx, fakeResolver(net.ParseIP("10.1.2.3")), "db01.internal.example.test")
+			if err != nil || ip != "10.1.2.3" {
+				t.Fatalf("resolveHost: check fail with custom resolver (%s, %v)", ip, err)
+			}
+		})
+	t.Run(
+		"IP address is not resolved",
+		func(t *testing.T) {
+			ip, err := resolveHost(ctx, failing, "192.0.2.10")
+			if err != nil || ip != "192.0.2.10" {
+				t.Fatalf("resolveHost: check fail with ip address (%s, %v)", ip, err)
+			}
+		})
+	t.Run(
+		"Resolver failure",
+		func(t *testing.T) {
+			if ip, err := resolveHost(ctx, failing, "db01.internal.example.test"); err == nil {
+				t.Fatalf("resolveHost: check fail with unreachable resolver (%s)", ip)
+			}
+		})
+}
+
+func TestDiscoverSSHPort(t *testing.T) {
+	agent := func(status int, body string) (string, string) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != types.AgentMetadataPath {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("discoverSSHPort: fail parsing agent address (%v)", err)
+		}
+		return host, port
+	}
+	t.Run(
+		"Port discovered",
+		func(t *testing.T) {
+			host, agentPort := agent(http.StatusOK, `{"ssh_port":"22000"}`)
+			port, err := discoverSSHPort(http.DefaultClient, host, agentPort)
+			if err != nil || port != "22000" {
+				t.Fatalf("discoverSSHPort: check fail discovering port (%s, %v)", port, err)
+			}
+		})
+	t.Run(
+		"Agent error",
+		func(t *testing.T) {
+			host, agentPort := agent(http.StatusInternalServerError, "")
+			if _, err := discoverSSHPort(http.DefaultClient, host, agentPort); err == nil {
+				t.Fatalf("discoverSSHPort: check fail with agent error")
+			}
+		})
+	t.Run(
+		"Invalid port",
+		func(t *testing.T) {
+			host, agentPort := agent(http.StatusOK, `{"ssh_port":"70000"}`)
+			if _, err := discoverSSHPort(http.DefaultClient, host, agentPort); err == nil {
+				t.Fatalf("discoverSSHPort: check fail with invalid port")
+			}
+		})
+	t.Run(
+		"Invalid metadata",
+		func(t *testing.T) {
+			host, agentPort := agent(http.StatusOK, "ssh_port=22")
+			if _, err := discoverSSHPort(http.DefaultClient, host, agentPort); err == nil {
+				t.Fatalf("discoverSSHPort: check fail with invalid metadata")
+			}
+		})
+	t.Run(
+		"Agent not running",
+		func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("discoverSSHPort: fail reserving port (%v)", err)
+			}
+			_, agentPort, _ := net.SplitHostPort(listener.Addr().String())
+			listener.Close()
+			if _, err := discoverSSHPort(http.DefaultClient, "127.0.0.1", agentPort); err == nil {
+				t.Fatalf("discoverSSHPort: check fail without agent")
+			}
+		})
+}
+
+func TestReadPublicKey(t *testing.T) {
+	dir := t.TempDir()
+	publicKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMdHkZK0qQnIuFEaI8GM0jUVPs0Sf0eaM/xvRVQzHvIh alice@example.org"
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("readPublicKey: fail writing %s (%v)", name, err)
+		}
+		return path
+	}
+
+	t.Run(
+		"Existing public key submitted",
+		func(t *testing.T) {
+			key, err := readPublicKey(write("id_ed25519.pub", publicKey+"\n"))
+			if err != nil {
+				t.Fatalf("readPublicKey: check fail with public key (%v)", err)
+			}
+			certRequest := types.CertRequest{Key: key}
+			if !strings.HasPrefix(certRequest.Key, strings.Join(strings.Fields(publicKey)[:2], " ")) {
+				t.Fatalf("readPublicKey: check fail submitting public key (%s)", certRequest.Key)
+			}
+		})
+	t.Run(
+		"Invalid public key",
+		func(t *testing.T) {
+			if _, err := readPublicKey(write("invalid.pub", "not a key")); err == nil {
+				t.Fatalf("readPublicKey: check fail with invalid public key")
+			}
+		})
+	t.Run(
+		"Private key beside public key",
+		func(t *testing.T) {
+			publicKeyFile := write("id_rsa.pub", publicKey)
+			if keyFile := privateKeyFile(publicKeyFile); keyFile != "" {
+				t.Fatalf("privateKeyFile: check fail without private key (%s)", keyFile)
+			}
+			keyFile := write("id_rsa", "private")
+			if found := privateKeyFile(publicKeyFile); found != keyFile {
+				t.Fatalf("privateKeyFile: check fail with private key (%s)", found)
+			}
+		})
+	t.Run(
+		"Certificate without key file",
+		func(t *testing.T) {
+			args := sshCommandArgs("", "/tmp/key-cert.pub", "", "/tmp/known_hosts", "alice", "22", "host.example.com")
+			if args[0] != "-o" || args[1] != "CertificateFile=/tmp/key-cert.pub" {
+				t.Fatalf("sshCommandArgs: check fail without key file (%v)", args)
+			}
+		})
+	t.Run(
+		"Certificate of PKCS#11 key",
+		func(t *testing.T) {
+			args := sshCommandArgs("", "/tmp/key-cert.pub", "/usr/lib/opensc-pkcs11.so", "/tmp/known_hosts", "alice", "22", "host.example.com")
+			expected := "-o PKCS11Provider=/usr/lib/opensc-pkcs11.so -o CertificateFile=/tmp/key-cert.pub " +
+				"-o UserKnownHostsFile=/tmp/known_hosts -o StrictHostKeyChecking=accept-new -l alice -p 22 host.example.com"
+			if strings.Join(args, " ") != expected {
+				t.Fatalf("sshCommandArgs: check fail with PKCS#11 provider (%v)", args)
+			}
+			for _, arg := range args {
+				if arg == "-i" {
+					t.Fatalf("sshCommandArgs: check fail, PKCS#11 key has no key file (%v)", args)
+				}
+			}
+		})
+}
+
+func TestOutputPaths(t *testing.T) {
+	t.Run(
+		"Certificate beside key",
+		func(t *testing.T) {
+			certOut, err := outputPaths(true, "", "/tmp/ci/id_gsh", "")
+			if err != nil || certOut != "/tmp/ci/id_gsh-cert.pub" {
+				t.Fatalf("outputPaths: check fail default cert path (%s, %v)", certOut, err)
+			}
+		})
+	t.Run(
+		"Explicit certificate path",
+		func(t *testing.T) {
+			certOut, err := outputPaths(true, "", "/tmp/ci/id_gsh", "/tmp/ci/cert")
+			if err != nil || certOut != "/tmp/ci/cert" {
+				t.Fatalf("outputPaths: check fail cert path (%s, %v)", certOut, err)
+			}
+		})
+	t.Run(
+		"Invalid combinations",
+		func(t *testing.T) {
+			cases := []struct {
+				noShell                    bool
+				keySource, keyOut, certOut string
+			}{
+				{false, "", "/tmp/ci/id_gsh", ""},
+				{true, "", "", "/tmp/ci/cert"},
+				{true, "--public-key", "/tmp/ci/id_gsh", ""},
+				{true, "--pkcs11", "/tmp/ci/id_gsh", ""},
+				{true, "", "/tmp/ci/id_gsh", "/tmp/ci/id_gsh"},
+			}
+			for _, c := range cases {
+				if _, err := outputPaths(c.noShell, c.keySource, c.keyOut, c.certOut); err == nil {
+					t.Fatalf("outputPaths: check fail invalid combination (%v)", c)
+				}
+			}
+		})
+	t.Run(
+		"Certificate of public key",
+		func(t *testing.T) {
+			certOut, err := outputPaths(true, "--public-key", "", "/tmp/ci/cert")
+			if err != nil || certOut != "/tmp/ci/cert" {
+				t.Fatalf("outputPaths: check fail public key cert path (%s, %v)", certOut, err)
+			}
+		})
+}
+
+func TestCheckAllowedUser(t *testing.T) {
+	t.Run(
+		"Without allowed users",
+		func(t *testing.T) {
+			if err := checkAllowedUser("rooot", "prod", nil); err != nil {
+				t.Fatalf("checkAllowedUser: check fail without list (%v)", err)
+			}
+		})
+	t.Run(
+		"Allowed user",
+		func(t *testing.T) {
+			if err := checkAllowedUser("deploy", "prod", []string{"root", "deploy"}); err != nil {
+				t.Fatalf("checkAllowedUser: check fail with allowed user (%v)", err)
+			}
+		})
+	t.Run(
+		"Typo is rejected",
+		func(t *testing.T) {
+			err := checkAllowedUser("rooot", "prod", []string{"root", "deploy"})
+			if err == nil {
+				t.Fatalf("checkAllowedUser: check fail with typo")
+			}
+			for _, expected := range []string{"rooot", "prod", "root, deploy"} {
+				if !strings.Contains(err.Error(), expected) {
+					t.Fatalf("checkAllowedUser: check fail with message (%v)", err)
+				}
+			}
+		})
+}
+
+func TestCheckSessionTimeout(t *testing.T) {
+	t.Run(
+		"Remote command",
+		func(t *testing.T) {
+			if err := checkSessionTimeout(time.Minute, "uptime"); err != nil {
+				t.Fatalf("checkSessionTimeout: check fail with --command (%v)", err)
+			}
+			if err := checkSessionTimeout(0, ""); err != nil {
+				t.Fatalf("checkSessionTimeout: check fail without timeout (%v)", err)
+			}
+		})
+	t.Run(
+		"Interactive session",
+		func(t *testing.T) {
+			if err := checkSessionTimeout(time.Minute, ""); err == nil {
+				t.Fatalf("checkSessionTimeout: check fail, interactive session with timeout")
+			}
+		})
+}
+
+func TestMuxOptionArgs(t *testing.T) {
+	t.Run(
+		"Enabled",
+		func(t *testing.T) {
+			if !muxEnabled(false, 0, "linux") {
+				t.Fatalf("muxEnabled: check fail, multiplexing disabled by default")
+			}
+			for _, disabled := range []bool{muxEnabled(true, 0, "linux"), muxEnabled(false, time.Hour, "linux"), muxEnabled(false, 0, "windows")} {
+				if disabled {
+					t.Fatalf("muxEnabled: check fail with --no-mux, --session-timeout or windows")
+				}
+			}
+		})
+	t.Run(
+		"ControlMaster options",
+		func(t *testing.T) {
+			args, err := muxOptionArgs("/home/alice/.gsh/mux/prod", "0123abcd", 90*time.Second)
+			if err != nil || strings.Join(args, " ") != "-o ControlMaster=auto -o ControlPath=/home/alice/.gsh/mux/prod/0123abcd-%C -o ControlPersist=90" {
+				t.Fatalf("muxOptionArgs: check fail with ControlMaster options (%v, %v)", args, err)
+			}
+			args, _ = muxOptionArgs("/home/alice/.gsh/mux/prod", "0123abcd", 0)
+			if args[len(args)-1] != "ControlPersist=no" {
+				t.Fatalf("muxOptionArgs: check fail without persist (%v)", args)
+			}
+			if _, err := muxOptionArgs("/home/alice/.gsh/mux/prod", "0123abcd", -time.Second); err == nil {
+				t.Fatalf("muxOptionArgs: check fail with negative persist")
+			}
+		})
+	t.Run(
+		"Injected before host",
+		func(t *testing.T) {
+			sshArgs := sshCommandArgs("/tmp/key", "/tmp/key-cert.pub", "", "/tmp/known_hosts", "alice", "22", "host.example.com")
+			options, _ := sshOptionArgs(0, 5*time.Second)
+			muxOptions, _ := muxOptionArgs("/tmp/mux", "0123abcd", time.Minute)
+			options = append(options, muxOptions...)
+			sshArgs = append(sshArgs[:len(sshArgs)-1], append(options, "host.example.com")...)
+			expected := "-l alice -p 22 -o ConnectTimeout=5 -o ControlMaster=auto -o ControlPath=/tmp/mux/0123abcd-%C -o ControlPersist=60 host.example.com"
+			if line := sshCommandLine(sshArgs); !strings.HasSuffix(line, expected) {
+				t.Fatalf("muxOptionArgs: check fail injecting options (%s)", line)
+			}
+		})
+	t.Run(
+		"Audited connections",
+		func(t *testing.T) {
+			if args := strings.Join(noMuxOptionArgs(), " "); args != "-o ControlMaster=no -o ControlPath=none" {
+				t.Fatalf("noMuxOptionArgs: check fail, ControlMaster can be used (%s)", args)
+			}
+		})
+	t.Run(
+		"Socket per certificate",
+		func(t *testing.T) {
+			certs := [][]byte{}
+			for i := 0; i < 2; i++ {
+				publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+				if err != nil {
+					t.Fatalf("muxSocketTag: check fail generating key (%v)", err)
+				}
+				sshPublicKey, err := ssh.NewPublicKey(publicKey)
+				if err != nil {
+					t.Fatalf("muxSocketTag: check fail converting key (%v)", err)
+				}
+				certs = append(certs, ssh.MarshalAuthorizedKey(sshPublicKey))
+			}
+			tag, err := muxSocketTag("prod", certs[0])
+			if err != nil || len(tag) != 16 {
+				t.Fatalf("muxSocketTag: check fail with tag (%s, %v)", tag, err)
+			}
+			if same, _ := muxSocketTag("prod", certs[0]); same != tag {
+				t.Fatalf("muxSocketTag: check fail, same certificate with another socket (%s, %s)", tag, same)
+			}
+			if other, _ := muxSocketTag("prod", certs[1]); other == tag {
+				t.Fatalf("muxSocketTag: check fail, another certificate with the same socket")
+			}
+			if other, _ := muxSocketTag("dev", certs[0]); other == tag {
+				t.Fatalf("muxSocketTag: check fail, another target with the same socket")
+			}
+			if _, err := muxSocketTag("prod", []byte("invalid")); err == nil {
+				t.Fatalf("muxSocketTag: check fail with invalid certificate")
+			}
+		})
+}
+
+func TestTokenClaim(t *testing.T) {
+	jwt := func(payload string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+	}
+	t.Run(
+		"Principal claim distinct from username claim",
+		func(t *testing.T) {
+			token := (&oauth2.Token{AccessToken: jwt(`{"preferred_username":"alice.smith"}`)}).WithExtra(map[string]interface{}{
+				"id_token": jwt(`{"preferred_username":"alice.smith","unix_username":"asmith"}`),
+			})
+			if principal, ok := tokenClaim(token, "unix_username"); !ok || principal != "asmith" {
+				t.Fatalf("tokenClaim: check fail with principal claim at ID token (%s, %v)", principal, ok)
+			}
+			token = &oauth2.Token{AccessToken: jwt(`{"preferred_username":"bob.jones","unix_username":"bjones"}`)}
+			if principal, ok := tokenClaim(token, "unix_username"); !ok || principal != "bjones" {
+				t.Fatalf("tokenClaim: check fail with principal claim at access token (%s, %v)", principal, ok)
+			}
+		})
+	t.Run(
+		"Claim missing",
+		func(t *testing.T) {
+			for _, token := range []*oauth2.Token{{AccessToken: jwt(`{"preferred_username":"alice.smith"}`)}, {AccessToken: "opaque-token"}} {
+				if principal, ok := tokenClaim(token, "unix_username"); ok {
+					t.Fatalf("tokenClaim: check fail without principal claim (%s)", principal)
+				}
+			}
+		})
+}
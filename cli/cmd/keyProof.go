@@ -0,0 +1,171 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// proofSigners returns the signers able to prove possession of the key to be certified: the
+// generated private key (privateKeyPEM), the private key file beside --public-key (keyFile) and
+// keys at ssh-agent, where PKCS#11 tokens are added with ssh-add -s
+func proofSigners(privateKeyPEM string, keyFile string) []ssh.Signer {
+	var signers []ssh.Signer
+	if privateKeyPEM != "" {
+		if signer, err := ssh.ParsePrivateKey([]byte(privateKeyPEM)); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if keyFile != "" {
+		// encrypted private keys are not read, their key is expected at ssh-agent
+		if data, err := os.ReadFile(filepath.Clean(keyFile)); err == nil {
+			if signer, err := ssh.ParsePrivateKey(data); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		// connection is kept open, ssh-agent signs the proof later
+		if conn, err := net.Dial("unix", socket); err == nil {
+			if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	return signers
+}
+
+// matchSigner returns the signer of publicKey (authorized_keys format) among signers, nil if none
+func matchSigner(publicKey string, signers []ssh.Signer) ssh.Signer {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil
+	}
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), key.Marshal()) {
+			return signer
+		}
+	}
+	return nil
+}
+
+// fetchChallenge asks GSH API for a single-use nonce to be signed in the key proof. API versions
+// without challenges (404) return an empty nonce, the proof is then bound only to time.
+func fetchChallenge(netClient *http.Client, endpoint string, accessToken string) (string, error) {
+	nonces, err := fetchChallenges(netClient, endpoint, accessToken, 1)
+	if err != nil || len(nonces) == 0 {
+		return "", err
+	}
+	return nonces[0], nil
+}
+
+// fetchChallenges asks GSH API for count single-use nonces in one request, one for the key proof of
+// each request of a batch. API versions without challenges (404) return no nonce, and versions
+// issuing one nonce per request return only one.
+func fetchChallenges(netClient *http.Client, endpoint string, accessToken string, count int) ([]string, error) {
+	challengeURL := endpoint + "/certificates/challenge"
+	if count > 1 {
+		challengeURL += "?count=" + strconv.Itoa(count)
+	}
+	req, err := http.NewRequest("POST", challengeURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "JWT "+accessToken)
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status response %d (%s)", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	challenge := struct {
+		types.CertChallenge
+		Nonces []string `json:"nonces"`
+	}{}
+	if err := json.Unmarshal(body, &challenge); err != nil {
+		return nil, fmt.Errorf("parsing challenge response (%v)", err)
+	}
+	if challenge.Nonce == "" {
+		return nil, errors.New("challenge response without nonce")
+	}
+	if len(challenge.Nonces) == 0 {
+		return []string{challenge.Nonce}, nil
+	}
+	return challenge.Nonces, nil
+}
+
+// signKeyProof signs certRequest and nonce at now with signer, proving to GSH API the client
+// holds the private key of certRequest.Key
+func signKeyProof(certRequest *types.CertRequest, signer ssh.Signer, nonce string, now time.Time) error {
+	message := certRequest.KeyProofMessage(now, nonce)
+	var signature *ssh.Signature
+	var err error
+	// RSA keys sign with SHA-256 when possible, instead of SHA-1 (ssh-rsa)
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, message, ssh.SigAlgoRSASHA2256)
+	} else {
+		signature, err = signer.Sign(rand.Reader, message)
+	}
+	if err != nil {
+		return err
+	}
+	certRequest.KeyProof = &types.KeyProof{
+		Timestamp: now,
+		Nonce:     nonce,
+		Signature: base64.StdEncoding.EncodeToString(ssh.Marshal(signature)),
+	}
+	return nil
+}
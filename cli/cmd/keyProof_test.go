@@ -0,0 +1,193 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMatchSigner(t *testing.T) {
+	var signers []ssh.Signer
+	for i := 0; i < 2; i++ {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("matchSigner: check fail generating key (%v)", err)
+		}
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			t.Fatalf("matchSigner: check fail creating signer (%v)", err)
+		}
+		signers = append(signers, signer)
+	}
+
+	publicKey := string(ssh.MarshalAuthorizedKey(signers[1].PublicKey()))
+	if signer := matchSigner(publicKey, signers); signer != signers[1] {
+		t.Fatalf("matchSigner: check fail with signer of key")
+	}
+	if signer := matchSigner(publicKey, signers[:1]); signer != nil {
+		t.Fatalf("matchSigner: check fail without signer of key")
+	}
+	if signer := matchSigner("not a key", signers); signer != nil {
+		t.Fatalf("matchSigner: check fail with invalid key")
+	}
+}
+
+func TestSignKeyProof(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("signKeyProof: check fail generating RSA key (%v)", err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("signKeyProof: check fail generating ed25519 key (%v)", err)
+	}
+	for _, test := range []struct {
+		name   string
+		key    interface{}
+		format string
+	}{
+		{"RSA key", rsaKey, ssh.SigAlgoRSASHA2256},
+		{"Ed25519 key", ed25519Key, ssh.KeyAlgoED25519},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			signer, err := ssh.NewSignerFromKey(test.key)
+			if err != nil {
+				t.Fatalf("signKeyProof: check fail creating signer (%v)", err)
+			}
+			certRequest := &types.CertRequest{
+				Key:        string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+				RemoteUser: "root",
+				RemoteHost: "10.0.0.1",
+			}
+			now := time.Now()
+			if err := signKeyProof(certRequest, signer, "nonce", now); err != nil {
+				t.Fatalf("signKeyProof: check fail signing (%v)", err)
+			}
+
+			blob, err := base64.StdEncoding.DecodeString(certRequest.KeyProof.Signature)
+			if err != nil {
+				t.Fatalf("signKeyProof: check fail decoding signature (%v)", err)
+			}
+			signature := new(ssh.Signature)
+			if err := ssh.Unmarshal(blob, signature); err != nil {
+				t.Fatalf("signKeyProof: check fail parsing signature (%v)", err)
+			}
+			if signature.Format != test.format {
+				t.Fatalf("signKeyProof: check fail with signature format (%s)", signature.Format)
+			}
+			if certRequest.KeyProof.Nonce != "nonce" {
+				t.Fatalf("signKeyProof: check fail with nonce (%s)", certRequest.KeyProof.Nonce)
+			}
+			if err := signer.PublicKey().Verify(certRequest.KeyProofMessage(certRequest.KeyProof.Timestamp, "nonce"), signature); err != nil {
+				t.Fatalf("signKeyProof: check fail verifying signature (%v)", err)
+			}
+		})
+	}
+}
+
+func TestFetchChallenge(t *testing.T) {
+	newServer := func(status int, body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.URL.Path != "/certificates/challenge" || r.Header.Get("Authorization") != "JWT token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+		}))
+	}
+
+	t.Run(
+		"Nonce issued",
+		func(t *testing.T) {
+			server := newServer(http.StatusOK, `{"result":"success","nonce":"abc","expires_at":"2019-01-01T00:00:00Z"}`)
+			defer server.Close()
+			nonce, err := fetchChallenge(server.Client(), server.URL, "token")
+			if err != nil || nonce != "abc" {
+				t.Fatalf("fetchChallenge: check fail with issued nonce (%q, %v)", nonce, err)
+			}
+		})
+	t.Run(
+		"Nonces of a batch",
+		func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("count") != "2" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				fmt.Fprint(w, `{"result":"success","nonce":"abc","nonces":["abc","def"],"expires_at":"2019-01-01T00:00:00Z"}`)
+			}))
+			defer server.Close()
+			nonces, err := fetchChallenges(server.Client(), server.URL, "token", 2)
+			if err != nil || len(nonces) != 2 || nonces[0] != "abc" || nonces[1] != "def" {
+				t.Fatalf("fetchChallenges: check fail with batch nonces (%v, %v)", nonces, err)
+			}
+		})
+	t.Run(
+		"API issuing one nonce per request",
+		func(t *testing.T) {
+			server := newServer(http.StatusOK, `{"result":"success","nonce":"abc","expires_at":"2019-01-01T00:00:00Z"}`)
+			defer server.Close()
+			nonces, err := fetchChallenges(server.Client(), server.URL, "token", 2)
+			if err != nil || len(nonces) != 1 || nonces[0] != "abc" {
+				t.Fatalf("fetchChallenges: check fail with one nonce (%v, %v)", nonces, err)
+			}
+		})
+	t.Run(
+		"API without challenges",
+		func(t *testing.T) {
+			server := newServer(http.StatusNotFound, `{"message":"Not Found"}`)
+			defer server.Close()
+			nonce, err := fetchChallenge(server.Client(), server.URL, "token")
+			if err != nil || nonce != "" {
+				t.Fatalf("fetchChallenge: check fail without challenges (%q, %v)", nonce, err)
+			}
+		})
+	t.Run(
+		"API error",
+		func(t *testing.T) {
+			server := newServer(http.StatusInternalServerError, `{"result":"fail"}`)
+			defer server.Close()
+			if _, err := fetchChallenge(server.Client(), server.URL, "token"); err == nil {
+				t.Fatalf("fetchChallenge: check fail with API error")
+			}
+		})
+}
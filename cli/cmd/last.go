@@ -0,0 +1,224 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// lastConnection is the record of the last gsh host-connect, it never has key contents
+type lastConnection struct {
+	Time           time.Time `json:"time"`
+	Target         string    `json:"target"`
+	Endpoint       string    `json:"endpoint"`
+	Host           string    `json:"host"`
+	Port           string    `json:"port"`
+	Username       string    `json:"username"`
+	Principals     []string  `json:"principals"`
+	Serial         uint64    `json:"serial"`
+	KeyID          string    `json:"key_id"`
+	ValidBefore    time.Time `json:"valid_before"`
+	KeyFile        string    `json:"key_file"`
+	CertFile       string    `json:"cert_file"`
+	KnownHostsFile string    `json:"known_hosts_file"`
+	Command        []string  `json:"command"`
+}
+
+// lastCmd represents the last command
+var lastCmd = &cobra.Command{
+	Use:   "last",
+	Short: "Shows the last connection made by host-connect",
+	Long: `
+
+Shows the last connection made by gsh host-connect: target, host, username,
+principals, serial and expiration of the certificate, the files used and the
+ssh command that was run, which can be used to connect again while the
+certificate is valid. Useful to report issues.
+
+	gsh last
+	gsh last --output json
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			fmt.Printf("Client error parsing output option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if output != "text" && output != "json" {
+			fmt.Printf("Client error parsing output option: (%s is not text or json)\n", output)
+			os.Exit(1)
+		}
+
+		path, err := files.LastConnectionPath()
+		if err != nil {
+			fmt.Printf("Client error getting last connection path: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		last, err := readLastConnection(path)
+		if err != nil {
+			fmt.Printf("Client error reading last connection: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		if output == "json" {
+			content, err := json.MarshalIndent(last, "", "  ")
+			if err != nil {
+				fmt.Printf("Client error formatting last connection: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(string(content))
+			return
+		}
+		printLastConnection(os.Stdout, last, time.Now())
+	},
+}
+
+// newLastConnection returns the record of a connection on host using the certificate at
+// certFile. Principals, serial and expiration are read from the certificate.
+func newLastConnection(target *types.Target, host string, port string, username string, keyFile string, certFile string, knownHostsFile string, sshArgs []string, now time.Time) (lastConnection, error) {
+	last := lastConnection{
+		Time:           now,
+		Target:         target.Label,
+		Endpoint:       target.Endpoint,
+		Host:           host,
+		Port:           port,
+		Username:       username,
+		KeyFile:        keyFile,
+		CertFile:       certFile,
+		KnownHostsFile: knownHostsFile,
+		Command:        append([]string{"ssh"}, sshArgs...),
+	}
+	// #nosec
+	content, err := os.ReadFile(certFile)
+	if err != nil {
+		return last, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return last, err
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return last, errors.New(certFile + " is not a certificate")
+	}
+	last.Principals = cert.ValidPrincipals
+	last.Serial = cert.Serial
+	last.KeyID = cert.KeyId
+	last.ValidBefore = time.Unix(int64(cert.ValidBefore), 0).UTC()
+	return last, nil
+}
+
+// writeLastConnection stores last at path, replacing the previous connection
+func writeLastConnection(path string, last lastConnection) error {
+	data, err := json.Marshal(last)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// readLastConnection returns the connection stored at path
+func readLastConnection(path string) (lastConnection, error) {
+	last := lastConnection{}
+	// #nosec
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return last, errors.New("no connection was made by gsh host-connect yet")
+	}
+	if err != nil {
+		return last, err
+	}
+	err = json.Unmarshal(data, &last)
+	return last, err
+}
+
+// printLastConnection writes a line for each field of last, with the expiration relative to now
+func printLastConnection(w io.Writer, last lastConnection, now time.Time) {
+	expiration := last.ValidBefore.Local().Format(time.RFC3339)
+	if last.ValidBefore.After(now) {
+		expiration += " (valid for " + last.ValidBefore.Sub(now).Round(time.Second).String() + ")"
+	} else {
+		expiration += " (expired)"
+	}
+	keyFile := last.KeyFile
+	if keyFile == "" {
+		keyFile = "not known by gsh"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Time:\t%s\n", last.Time.Local().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Target:\t%s (%s)\n", last.Target, last.Endpoint)
+	fmt.Fprintf(tw, "Host:\t%s\n", last.Host)
+	fmt.Fprintf(tw, "Port:\t%s\n", last.Port)
+	fmt.Fprintf(tw, "Username:\t%s\n", last.Username)
+	fmt.Fprintf(tw, "Principals:\t%s\n", strings.Join(last.Principals, ", "))
+	fmt.Fprintf(tw, "Serial:\t%d\n", last.Serial)
+	fmt.Fprintf(tw, "Key ID:\t%s\n", last.KeyID)
+	fmt.Fprintf(tw, "Valid until:\t%s\n", expiration)
+	fmt.Fprintf(tw, "Private key:\t%s\n", keyFile)
+	fmt.Fprintf(tw, "Certificate:\t%s\n", last.CertFile)
+	fmt.Fprintf(tw, "Known hosts:\t%s\n", last.KnownHostsFile)
+	fmt.Fprintf(tw, "Command:\t%s\n", shellCommand(last.Command))
+	tw.Flush()
+}
+
+// shellCommand joins args as a command line, quoting args that a shell would split or expand
+func shellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`*?!;&|<>()[]{}~#") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+func init() {
+	rootCmd.AddCommand(lastCmd)
+
+	lastCmd.Flags().StringP("output", "o", "text", "Defines the output format (text or json)")
+}
@@ -30,7 +30,6 @@
 package cmd
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -72,15 +71,13 @@ All gsh actions require the user to be authenticated (except [[gsh login]],
 		// Check for set-token-storage flag
 		var setStorage string
 		if !cmd.Flags().Changed("set-token-storage") {
-			// user not set the flag, read from config file
-			setStorageCheck := viper.Get("targets." + currentTarget.Label + ".token-storage")
-
+			// user not set the flag, read from config file (user or system-wide)
 			// config file not set or first time using this target, forcing user to set one
-			if setStorageCheck == nil {
+			if currentTarget.TokenStorage == "" {
 				fmt.Printf("Client error checking available backends for token-storage %v\n", keyring.AvailableBackends())
 				os.Exit(1)
 			}
-			setStorage = setStorageCheck.(string)
+			setStorage = currentTarget.TokenStorage
 		} else {
 			var err error
 			setStorage, err = cmd.Flags().GetString("set-token-storage")
@@ -116,10 +113,11 @@ All gsh actions require the user to be authenticated (except [[gsh login]],
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
 		// Making discovery GSH request
@@ -154,7 +152,7 @@ All gsh actions require the user to be authenticated (except [[gsh login]],
 		}
 
 		// Configure an OpenID Connect aware OAuth2 client.
-		ctx := context.Background()
+		ctx := config.Context()
 		oauth2provider, err := oidc.NewProvider(ctx, configResponse.Issuer)
 		if err != nil {
 			fmt.Printf("GSH client setting OIDC provider error: %s\n", err.Error())
@@ -176,8 +174,13 @@ All gsh actions require the user to be authenticated (except [[gsh login]],
 		}
 		redirectURL := fmt.Sprintf("http://localhost:%s", port)
 
+		// Target audience replaces the one published by GSH API, that must accept it (oidc_audience)
+		clientID := auth.ClientID(currentTarget, configResponse.Audience)
+		if clientID != configResponse.Audience {
+			fmt.Printf("Warning: target audience %s differs from GSH API audience %s, GSH API may refuse its tokens\n", clientID, configResponse.Audience)
+		}
 		oauth2config := oauth2.Config{
-			ClientID:    configResponse.Audience,
+			ClientID:    clientID,
 			RedirectURL: redirectURL,
 			Endpoint:    oauth2provider.Endpoint(),
 			Scopes:      []string{oidc.ScopeOpenID, oidc.ScopeOfflineAccess, "email", "profile"},
@@ -196,10 +199,16 @@ All gsh actions require the user to be authenticated (except [[gsh login]],
 		}
 
 		// Generate AuthCode URL with PKCE
-		authURL := oauth2config.AuthCodeURL(state, oauth2.SetAuthURLParam("code_challenge", codeChallenge), oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+		authOptions := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_challenge", codeChallenge), oauth2.SetAuthURLParam("code_challenge_method", "S256")}
+		authOptions = append(authOptions, auth.AudienceOptions(currentTarget)...)
+		if currentTarget.Account != "" {
+			// ask OIDC provider to authenticate again, instead of reusing the browser session of another account
+			authOptions = append(authOptions, oauth2.SetAuthURLParam("prompt", "login"))
+		}
+		authURL := oauth2config.AuthCodeURL(state, authOptions...)
 
 		// Setup local web server
-		http.HandleFunc("/", auth.Callback(state, codeVerifier, redirectURL, oauth2config, currentTarget.Label, finish))
+		http.HandleFunc("/", auth.Callback(state, codeVerifier, redirectURL, oauth2config, currentTarget.Label, currentTarget.Account, finish))
 		server := &http.Server{
 			ReadTimeout:       1 * time.Second,
 			WriteTimeout:      1 * time.Second,
@@ -0,0 +1,92 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// pkcs11Keys lists the public keys of the hardware token loaded by PKCS#11 provider, as
+// ssh-keygen -D prints them. The private keys never leave the token.
+func pkcs11Keys(provider string) ([]byte, error) {
+	// #nosec
+	sh := exec.Command("ssh-keygen", "-D", provider)
+	var stderr bytes.Buffer
+	sh.Stderr = &stderr
+	output, err := sh.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ssh-keygen -D %s failed (%s: %s)", provider, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// pkcs11PublicKey selects the public key to be certified from ssh-keygen -D output. With many
+// keys at the token (as PIV slots), fingerprint (SHA256:...) chooses one.
+func pkcs11PublicKey(output []byte, fingerprint string) (string, error) {
+	keys := []ssh.PublicKey{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			// ssh-keygen can print provider messages among keys
+			continue
+		}
+		keys = append(keys, publicKey)
+	}
+	if len(keys) == 0 {
+		return "", errors.New("no public key found at PKCS#11 token")
+	}
+
+	fingerprints := []string{}
+	for _, publicKey := range keys {
+		if ssh.FingerprintSHA256(publicKey) == fingerprint {
+			return string(ssh.MarshalAuthorizedKey(publicKey)), nil
+		}
+		fingerprints = append(fingerprints, ssh.FingerprintSHA256(publicKey))
+	}
+	if fingerprint != "" {
+		return "", fmt.Errorf("no public key with fingerprint %s at PKCS#11 token (found %s)", fingerprint, strings.Join(fingerprints, ", "))
+	}
+	if len(keys) > 1 {
+		return "", fmt.Errorf("PKCS#11 token has %d public keys, choose one with --pkcs11-key (%s)", len(keys), strings.Join(fingerprints, ", "))
+	}
+	return string(ssh.MarshalAuthorizedKey(keys[0])), nil
+}
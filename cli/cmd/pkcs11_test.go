@@ -0,0 +1,98 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPKCS11PublicKey(t *testing.T) {
+	newKey := func() ssh.PublicKey {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("pkcs11PublicKey: fail generating key (%v)", err)
+		}
+		publicKey, err := ssh.NewPublicKey(public)
+		if err != nil {
+			t.Fatalf("pkcs11PublicKey: fail converting key (%v)", err)
+		}
+		return publicKey
+	}
+	authorizedKey := func(publicKey ssh.PublicKey, comment string) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))) + " " + comment + "\n"
+	}
+	slot9a, slot9c := newKey(), newKey()
+	oneKey := []byte(authorizedKey(slot9a, "PIV AUTH pubkey"))
+	twoKeys := []byte("C_GetAttributeValue failed: 18\n" + authorizedKey(slot9a, "PIV AUTH pubkey") + authorizedKey(slot9c, "SIGN pubkey"))
+
+	t.Run(
+		"Single key",
+		func(t *testing.T) {
+			publicKey, err := pkcs11PublicKey(oneKey, "")
+			if err != nil || publicKey != string(ssh.MarshalAuthorizedKey(slot9a)) {
+				t.Fatalf("pkcs11PublicKey: check fail with single key (%s, %v)", publicKey, err)
+			}
+		})
+	t.Run(
+		"Key chosen by fingerprint",
+		func(t *testing.T) {
+			publicKey, err := pkcs11PublicKey(twoKeys, ssh.FingerprintSHA256(slot9c))
+			if err != nil || publicKey != string(ssh.MarshalAuthorizedKey(slot9c)) {
+				t.Fatalf("pkcs11PublicKey: check fail with fingerprint (%s, %v)", publicKey, err)
+			}
+		})
+	t.Run(
+		"Many keys without fingerprint",
+		func(t *testing.T) {
+			_, err := pkcs11PublicKey(twoKeys, "")
+			if err == nil || !strings.Contains(err.Error(), ssh.FingerprintSHA256(slot9c)) {
+				t.Fatalf("pkcs11PublicKey: check fail with many keys (%v)", err)
+			}
+		})
+	t.Run(
+		"Unknown fingerprint",
+		func(t *testing.T) {
+			if _, err := pkcs11PublicKey(oneKey, ssh.FingerprintSHA256(slot9c)); err == nil {
+				t.Fatalf("pkcs11PublicKey: check fail with unknown fingerprint")
+			}
+		})
+	t.Run(
+		"Empty token",
+		func(t *testing.T) {
+			if _, err := pkcs11PublicKey([]byte("no keys\n"), ""); err == nil {
+				t.Fatalf("pkcs11PublicKey: check fail without keys")
+			}
+		})
+}
@@ -0,0 +1,161 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/spf13/cobra"
+)
+
+// quotaSetCmd represents the quotaSet command
+var quotaSetCmd = &cobra.Command{
+	Use:   "quota-set [user] [quota]",
+	Short: "Set the certificate quota of a user",
+	Long: `
+
+	Set how many certificates a user can get at the quota window configured at GSH API
+	(cert_quota_window), overriding its default quota (cert_quota). Zero is no limit.
+	With --reset, the user gets the default quota again.
+
+	gsh quota-set alice 100
+	gsh quota-set alice --reset
+	`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		reset, err := cmd.Flags().GetBool("reset")
+		if err != nil {
+			fmt.Printf("Client error parsing reset option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if reset != (len(args) == 1) {
+			fmt.Println("Client error: inform a quota or --reset")
+			os.Exit(1)
+		}
+		method, payload := http.MethodDelete, []byte(nil)
+		if !reset {
+			quota, err := strconv.Atoi(args[1])
+			if err != nil || quota < 0 {
+				fmt.Printf("Client error parsing quota, is it a non-negative number?: (%v)\n", args[1])
+				os.Exit(1)
+			}
+			method = http.MethodPut
+			payload, err = json.Marshal(map[string]int{"quota": quota})
+			if err != nil {
+				fmt.Printf("Client error encoding quota: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		// Make GSH request
+		req, err := http.NewRequest(method, currentTarget.Endpoint+"/authz/quotas/"+url.PathEscape(args[0]), bytes.NewBuffer(payload))
+		if err != nil {
+			fmt.Printf("Client error creating quota request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		req.Header.Set("Authorization", "JWT "+oauth2Token.AccessToken)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := netClient.Do(req)
+		if err != nil {
+			fmt.Printf("Client error quota request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		// Read body
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			fmt.Printf("Client error reading quota response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Client error checking http status response: (%v)\n", resp.StatusCode)
+		}
+
+		// Parse quota response
+		type QuotaResponse struct {
+			Details string `json:"details"`
+			Message string `json:"message"`
+			Result  string `json:"result"`
+		}
+
+		quotaResponse := new(QuotaResponse)
+		if err := json.Unmarshal(body, &quotaResponse); err != nil {
+			fmt.Printf("Client error parsing quota response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		if quotaResponse.Result == "fail" {
+			fmt.Printf("Client error calling GSH API: (%v)\n", quotaResponse)
+			os.Exit(1)
+		}
+		fmt.Println(quotaResponse.Message)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(quotaSetCmd)
+
+	quotaSetCmd.Flags().Bool("reset", false, "Removes the quota set for the user, who gets the default quota (cert_quota) again")
+}
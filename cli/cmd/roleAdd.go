@@ -40,6 +40,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/globocom/gsh/api/permissions"
 	"github.com/globocom/gsh/cli/cmd/auth"
 	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/globocom/gsh/types"
@@ -57,6 +58,12 @@ var roleAddCmd = &cobra.Command{
 Adds a new role. A role is a set of characteristics that consists of a permission 
 that will be assigned to a user. ID is a slug string that identifies the role.
 
+Certificates authorized by the role grant permit-pty, other extensions are
+granted with --permit and removed with --deny:
+
+	gsh role-add ops --user-ip 192.0.2.0/24 --remote-host 198.51.100.0/24 --permit permit-agent-forwarding
+	gsh role-add batch --user-ip 192.0.2.0/24 --remote-host 198.51.100.0/24 --permit permit-user-rc --deny permit-pty
+
 `,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -130,6 +137,35 @@ that will be assigned to a user. ID is a slug string that identifies the role.
 			os.Exit(1)
 		}
 
+		// Get destination ports, empty allows any port
+		destPort, err := cmd.Flags().GetString("dest-port")
+		if err != nil {
+			fmt.Printf("Client error getting destination ports: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		destPorts, err := permissions.ParseDestPorts(destPort)
+		if err != nil {
+			fmt.Printf("Client error parsing destination ports %s: (%s)\n", destPort, err.Error())
+			os.Exit(1)
+		}
+
+		// Get certificate extensions, applied to the default ones (permit-pty)
+		permit, err := cmd.Flags().GetStringSlice("permit")
+		if err != nil {
+			fmt.Printf("Client error getting permitted extensions: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		deny, err := cmd.Flags().GetStringSlice("deny")
+		if err != nil {
+			fmt.Printf("Client error getting denied extensions: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		extensions, err := permissions.RoleExtensions(permit, deny)
+		if err != nil {
+			fmt.Printf("Client error parsing extensions: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
 		// Get OIDC HTTP Client
 		oauth2Token, err := auth.RecoverToken(currentTarget)
 		if err != nil {
@@ -144,6 +180,8 @@ that will be assigned to a user. ID is a slug string that identifies the role.
 			SourceIP:   strings.Join(userIPsVerified, ";"),
 			TargetIP:   strings.Join(remoteHostsVerified, ";"),
 			Actions:    actions,
+			DestPorts:  destPorts,
+			Extensions: extensions,
 		}
 
 		// Marshall role to JSON
@@ -155,10 +193,11 @@ that will be assigned to a user. ID is a slug string that identifies the role.
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
 		// Make GSH request
@@ -223,4 +262,7 @@ func init() {
 	roleAddCmd.Flags().StringP("user-ip", "s", "", "Defines source IP which will be allowed to initiate a connection to remote-host using this role")
 	roleAddCmd.Flags().StringP("remote-host", "d", "", "Defines destination IP to be connected using this role")
 	roleAddCmd.Flags().StringP("actions", "a", "permit-pty", "Defines a set of OpenSSH critical options to be used with this role")
+	roleAddCmd.Flags().String("dest-port", "", "Defines the destination ports allowed by this role, separated by ';' (default is any port). Roles with destination ports never grant port forwarding")
+	roleAddCmd.Flags().StringSlice("permit", []string{}, "Defines certificate extensions granted by this role besides permit-pty (permit-pty, permit-port-forwarding, permit-agent-forwarding, permit-X11-forwarding or permit-user-rc), can be repeated")
+	roleAddCmd.Flags().StringSlice("deny", []string{}, "Defines certificate extensions not granted by this role, as permit-pty granted by default, can be repeated")
 }
@@ -0,0 +1,361 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/globocom/gsh/api/permissions"
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/tablecli"
+)
+
+// roleApplyCmd represents the roleApply command
+var roleApplyCmd = &cobra.Command{
+	Use:   "role-apply [file]",
+	Short: "Applies the roles of a roles definition file",
+	Long: `
+
+Applies the roles of a roles definition file (see gsh role-validate) at GSH API:
+roles missing are created and roles changed are updated, keeping their
+assignments. With --prune, roles not listed at the file are removed (they can
+be restored with gsh role-restore). With --dry-run, changes are only printed.
+
+Assignments (users and groups) listed at the file are not applied, use
+gsh role-assign. Failures are reported and do not stop the remaining changes.
+
+	gsh role-apply roles.yaml --dry-run
+	gsh role-apply roles.yaml --prune
+
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		prune, err := cmd.Flags().GetBool("prune")
+		if err != nil {
+			fmt.Printf("Client error parsing prune option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			fmt.Printf("Client error parsing dry-run option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// The whole file is validated before any change
+		items, err := readRoleFile(args[0])
+		if err != nil {
+			fmt.Printf("Client error reading roles file: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if errs := validateRoles(items); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Println(err.Error())
+			}
+			fmt.Printf("Client error validating roles file: (%d errors at %s)\n", len(errs), args[0])
+			os.Exit(1)
+		}
+		desired := desiredRoles(items)
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		current, err := fetchRoles(netClient, currentTarget.Endpoint, oauth2Token.AccessToken)
+		if err != nil {
+			fmt.Printf("Client error getting roles: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		changes := planRoles(desired, current, prune)
+		if len(changes) == 0 {
+			fmt.Printf("Roles at GSH API are up to date with %s\n", args[0])
+			return
+		}
+		if dryRun {
+			for _, change := range changes {
+				fmt.Printf("%s %s %s\n", change.Action, change.Role.ID, change.Details)
+			}
+			fmt.Printf("%d changes would be applied (dry run)\n", len(changes))
+			return
+		}
+
+		results := applyRoles(netClient, currentTarget.Endpoint, oauth2Token.AccessToken, changes)
+		fails := 0
+		table := tablecli.Table{Headers: tablecli.Row([]string{"Role", "Action", "Result", "Details"})}
+		for _, result := range results {
+			if result.Err != nil {
+				fails++
+				table.AddRow(tablecli.Row([]string{result.Role.ID, result.Action, "fail", result.Err.Error()}))
+			} else {
+				table.AddRow(tablecli.Row([]string{result.Role.ID, result.Action, "success", result.Details}))
+			}
+		}
+		fmt.Println(table.String())
+		fmt.Printf("%d of %d changes applied\n", len(results)-fails, len(results))
+		if fails > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// roleChange is a change of a role applied to reconcile GSH API with a roles definition file:
+// create, update or remove. Err is set when applying it failed.
+type roleChange struct {
+	Action  string
+	Role    types.Role
+	Details string
+	Err     error
+}
+
+// desiredRoles returns the roles of a validated roles definition file, normalized as GSH API
+// stores them (see gsh role-add defaults)
+func desiredRoles(items []interface{}) []types.Role {
+	roles := []types.Role{}
+	for _, item := range items {
+		fields, _ := stringKeys(item)
+		role := types.Role{RemoteUser: ".", Actions: "permit-pty"}
+		role.ID, _ = fields["id"].(string)
+		if remoteUser, ok := fields["remote_user"].(string); ok {
+			role.RemoteUser = strings.TrimSpace(remoteUser)
+		}
+		if actions, ok := fields["actions"].(string); ok {
+			role.Actions = strings.TrimSpace(actions)
+		}
+		role.SourceIP = roleNetworks(fields["user_ip"])
+		role.TargetIP = roleNetworks(fields["remote_host"])
+		if value, ok := fields["dest_ports"]; ok {
+			role.DestPorts, _ = permissions.ParseDestPorts(fmt.Sprint(value))
+		}
+		permit, _ := roleFileList(fields["permit"], "")
+		deny, _ := roleFileList(fields["deny"], "")
+		role.Extensions, _ = permissions.RoleExtensions(permit, deny)
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+// roleNetworks returns networks of a validated role field normalized as GSH API stores them
+func roleNetworks(value interface{}) string {
+	networks, _ := roleFileList(value, ";")
+	normalized := []string{}
+	for _, network := range networks {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil {
+			normalized = append(normalized, ipNet.String())
+		}
+	}
+	return strings.Join(normalized, ";")
+}
+
+// roleDiff returns the fields of role changed at desired, empty when they are the same. Roles
+// without extensions grant the default extensions.
+func roleDiff(role types.Role, desired types.Role) []string {
+	extensions := func(role types.Role) string {
+		if role.Extensions == "" {
+			return strings.Join(permissions.DefaultExtensions, ";")
+		}
+		return role.Extensions
+	}
+	fields := []struct{ name, before, after string }{
+		{"remote_user", role.RemoteUser, desired.RemoteUser},
+		{"user_ip", role.SourceIP, desired.SourceIP},
+		{"remote_host", role.TargetIP, desired.TargetIP},
+		{"actions", role.Actions, desired.Actions},
+		{"dest_ports", role.DestPorts, desired.DestPorts},
+		{"extensions", extensions(role), extensions(desired)},
+	}
+	diff := []string{}
+	for _, field := range fields {
+		if field.before != field.after {
+			diff = append(diff, fmt.Sprintf("%s: %q -> %q", field.name, field.before, field.after))
+		}
+	}
+	return diff
+}
+
+// planRoles returns the changes reconciling current roles of GSH API with desired ones, sorted by
+// role id. Roles not desired are removed only with prune.
+func planRoles(desired []types.Role, current []types.Role, prune bool) []roleChange {
+	currentByID := map[string]types.Role{}
+	for _, role := range current {
+		currentByID[role.ID] = role
+	}
+	desiredIDs := map[string]bool{}
+	changes := []roleChange{}
+	for _, role := range desired {
+		desiredIDs[role.ID] = true
+		existing, ok := currentByID[role.ID]
+		if !ok {
+			changes = append(changes, roleChange{Action: "create", Role: role})
+			continue
+		}
+		if diff := roleDiff(existing, role); len(diff) > 0 {
+			changes = append(changes, roleChange{Action: "update", Role: role, Details: strings.Join(diff, ", ")})
+		}
+	}
+	if prune {
+		for _, role := range current {
+			if !desiredIDs[role.ID] {
+				changes = append(changes, roleChange{Action: "remove", Role: role})
+			}
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Role.ID < changes[j].Role.ID
+	})
+	return changes
+}
+
+// fetchRoles makes GET /authz/roles request to GSH API, returning all roles
+func fetchRoles(netClient *http.Client, endpoint string, token string) ([]types.Role, error) {
+	req, err := http.NewRequest("GET", endpoint+"/authz/roles", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "JWT "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	type RoleResponse struct {
+		Details string       `json:"details"`
+		Message string       `json:"message"`
+		Result  string       `json:"result"`
+		Roles   []types.Role `json:"roles"`
+	}
+	roleResponse := new(RoleResponse)
+	if err := json.Unmarshal(body, &roleResponse); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || roleResponse.Result == "fail" {
+		return nil, fmt.Errorf("status %d: %s %s", resp.StatusCode, roleResponse.Message, roleResponse.Details)
+	}
+	return roleResponse.Roles, nil
+}
+
+// applyRoles applies each change at GSH API, continuing on failures, and returns the changes
+// with their results
+func applyRoles(netClient *http.Client, endpoint string, token string, changes []roleChange) []roleChange {
+	results := []roleChange{}
+	for _, change := range changes {
+		switch change.Action {
+		case "create":
+			change.Err = roleRequest(netClient, "POST", endpoint+"/authz/roles", token, &change.Role)
+		case "update":
+			change.Err = roleRequest(netClient, "PUT", endpoint+"/authz/roles/"+change.Role.ID, token, &change.Role)
+		case "remove":
+			change.Err = roleRequest(netClient, "DELETE", endpoint+"/authz/roles/"+change.Role.ID, token, nil)
+		}
+		results = append(results, change)
+	}
+	return results
+}
+
+// roleRequest makes a request for role (without body when nil) to GSH API
+func roleRequest(netClient *http.Client, method string, url string, token string, role *types.Role) error {
+	var payload []byte
+	if role != nil {
+		payload, _ = json.Marshal(role)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "JWT "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	type RoleResponse struct {
+		Details string `json:"details"`
+		Message string `json:"message"`
+		Result  string `json:"result"`
+	}
+	roleResponse := new(RoleResponse)
+	if err := json.Unmarshal(body, &roleResponse); err != nil {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	if resp.StatusCode != http.StatusOK || roleResponse.Result == "fail" {
+		return fmt.Errorf("status %d: %s %s", resp.StatusCode, roleResponse.Message, roleResponse.Details)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(roleApplyCmd)
+
+	roleApplyCmd.Flags().Bool("prune", false, "Removes roles not listed at the file")
+	roleApplyCmd.Flags().Bool("dry-run", false, "Prints the changes without applying them")
+}
@@ -0,0 +1,120 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/globocom/gsh/types"
+)
+
+func TestPlanRoles(t *testing.T) {
+	current := []types.Role{
+		{ID: "dev", RemoteUser: ".", SourceIP: "10.0.0.0/8", TargetIP: "10.1.0.0/16", Actions: "permit-pty"},
+		{ID: "prod", RemoteUser: ".", SourceIP: "10.0.0.0/8", TargetIP: "10.2.0.0/16", Actions: "permit-pty"},
+		{ID: "legacy", RemoteUser: "*", SourceIP: "10.0.0.0/8", TargetIP: "10.3.0.0/16", Actions: "permit-pty"},
+	}
+	desired := desiredRoles([]interface{}{
+		map[string]interface{}{"id": "dev", "user_ip": "10.0.0.0/8", "remote_host": []interface{}{"10.1.0.0/16"}},
+		map[string]interface{}{"id": "prod", "user_ip": "10.0.0.0/8", "remote_host": "10.2.0.0/16", "dest_ports": 22},
+		map[string]interface{}{"id": "ops", "user_ip": "10.0.0.1/8", "remote_host": "10.4.0.0/16", "permit": []interface{}{"permit-agent-forwarding"}},
+	})
+
+	t.Run(
+		"Create and update",
+		func(t *testing.T) {
+			changes := planRoles(desired, current, false)
+			if len(changes) != 2 {
+				t.Fatalf("planRoles: check fail with number of changes (%v)", changes)
+			}
+			if changes[0].Action != "create" || changes[0].Role.ID != "ops" || changes[0].Role.SourceIP != "10.0.0.0/8" ||
+				changes[0].Role.Extensions != "permit-agent-forwarding;permit-pty" {
+				t.Fatalf("planRoles: check fail creating role (%v)", changes[0])
+			}
+			if changes[1].Action != "update" || changes[1].Role.ID != "prod" || !strings.Contains(changes[1].Details, "dest_ports") {
+				t.Fatalf("planRoles: check fail updating role (%v)", changes[1])
+			}
+		})
+	t.Run(
+		"Prune",
+		func(t *testing.T) {
+			changes := planRoles(desired, current, true)
+			if len(changes) != 3 || changes[0].Action != "remove" || changes[0].Role.ID != "legacy" {
+				t.Fatalf("planRoles: check fail pruning role (%v)", changes)
+			}
+		})
+	t.Run(
+		"Up to date",
+		func(t *testing.T) {
+			if changes := planRoles(current, current, true); len(changes) != 0 {
+				t.Fatalf("planRoles: check fail with roles up to date (%v)", changes)
+			}
+		})
+}
+
+func TestApplyRoles(t *testing.T) {
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := types.Role{}
+		_ = json.NewDecoder(r.Body).Decode(&role)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+role.ID)
+		if r.URL.Path == "/authz/roles/broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"result":"fail","message":"Invalid UserIP format"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"result":"success","message":"Done"}`))
+	}))
+	defer server.Close()
+
+	changes := []roleChange{
+		{Action: "update", Role: types.Role{ID: "broken"}},
+		{Action: "create", Role: types.Role{ID: "ops"}},
+		{Action: "update", Role: types.Role{ID: "prod"}},
+		{Action: "remove", Role: types.Role{ID: "legacy"}},
+	}
+	results := applyRoles(server.Client(), server.URL, "token", changes)
+	if len(results) != 4 || results[0].Err == nil {
+		t.Fatalf("applyRoles: check fail reporting failed change (%v)", results)
+	}
+	for _, result := range results[1:] {
+		if result.Err != nil {
+			t.Fatalf("applyRoles: check fail applying %s of %s (%v)", result.Action, result.Role.ID, result.Err)
+		}
+	}
+	expected := []string{"PUT /authz/roles/broken broken", "POST /authz/roles ops", "PUT /authz/roles/prod prod", "DELETE /authz/roles/legacy "}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Fatalf("applyRoles: check fail with requests (%v)", requests)
+	}
+}
@@ -31,6 +31,7 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -40,6 +41,7 @@ import (
 
 	"github.com/globocom/gsh/cli/cmd/auth"
 	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
 	"github.com/gosimple/slug"
 	"github.com/spf13/cobra"
 )
@@ -47,23 +49,34 @@ import (
 // roleAssignCmd represents the roleAssign command
 var roleAssignCmd = &cobra.Command{
 	Use:   "role-assign [role] [user]",
-	Short: "Associate a role to a user",
+	Short: "Associate a role to a user or group",
 	Long: `
 
-Assign a previous created role to a user.
+Assign a previous created role to a user. Using --group, the role is
+assigned to a group (read from OIDC groups claim) instead of a user:
+
+	gsh role-assign [role] --group [group]
+
+Assigning a role again is not an error, it is reported as already assigned.
 	`,
-	Args: cobra.ExactArgs(2),
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 
-		// Get current target
-		currentTarget := config.GetCurrentTarget()
-
-		// Validate if ID is slug string
-		if !slug.IsSlug(args[0]) {
-			fmt.Printf("Client error parsing id, is it a slug string?: (%v)\n", args[0])
+		// Check for group flag
+		group, err := cmd.Flags().GetString("group")
+		if err != nil {
+			fmt.Printf("Client error parsing group option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		path, err := roleAssignmentPath(args, group)
+		if err != nil {
+			fmt.Printf("Client error: %s\n", err.Error())
 			os.Exit(1)
 		}
 
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
 		// Get OIDC HTTP Client
 		oauth2Token, err := auth.RecoverToken(currentTarget)
 		if err != nil {
@@ -77,14 +90,15 @@ Assign a previous created role to a user.
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
 		// Make GSH request
-		req, err := http.NewRequest("POST", currentTarget.Endpoint+"/authz/roles/"+args[0]+"/"+args[1], nil)
+		req, err := http.NewRequest("POST", currentTarget.Endpoint+path, nil)
 		if err != nil {
 			fmt.Printf("Client pre post role request: (%s)\n", err.Error())
 			os.Exit(1)
@@ -108,25 +122,52 @@ Assign a previous created role to a user.
 		}
 		defer resp.Body.Close()
 
-		// Parse role response
-		type RoleResponse struct {
-			Details string `json:"details"`
-			Message string `json:"message"`
-			Result  string `json:"result"`
-		}
-
-		roleResponse := new(RoleResponse)
-		if err := json.Unmarshal(body, &roleResponse); err != nil {
-			fmt.Printf("Client error parsing role response: (%s)\n", err.Error())
+		message, err := roleAssignMessage(body)
+		if err != nil {
+			fmt.Printf("Client error calling GSH API: (%s)\n", err.Error())
 			os.Exit(1)
 		}
+		fmt.Println(message)
+	},
+}
 
-		if roleResponse.Result == "fail" {
-			fmt.Printf("Client error calling GSH API: (%v)\n", roleResponse)
-			os.Exit(1)
+// roleAssignMessage parses the role assignment response of GSH API. Assigning a role again is not
+// an error, but it is reported as already assigned so scripts can tell it from a new assignment.
+func roleAssignMessage(body []byte) (string, error) {
+	type RoleResponse struct {
+		Details string `json:"details"`
+		Message string `json:"message"`
+		Result  string `json:"result"`
+	}
+	roleResponse := new(RoleResponse)
+	if err := json.Unmarshal(body, &roleResponse); err != nil {
+		return "", fmt.Errorf("parsing role response (%s)", err.Error())
+	}
+	switch roleResponse.Result {
+	case "success":
+		return roleResponse.Message, nil
+	case types.RoleAlreadyAssigned:
+		return "Already assigned: " + roleResponse.Message, nil
+	}
+	return "", fmt.Errorf("%v", *roleResponse)
+}
+
+// roleAssignmentPath returns the GSH API path of the assignment of role (args[0]) to a user (args[1])
+// or, when group is set, to a group. Role and group must be slug strings.
+func roleAssignmentPath(args []string, group string) (string, error) {
+	if (group == "") != (len(args) == 2) {
+		return "", errors.New("use [role] [user] or [role] --group [group]")
+	}
+	if !slug.IsSlug(args[0]) {
+		return "", fmt.Errorf("parsing id, is it a slug string?: (%v)", args[0])
+	}
+	if group != "" {
+		if !slug.IsSlug(group) {
+			return "", fmt.Errorf("parsing group, is it a slug string?: (%v)", group)
 		}
-		fmt.Println(roleResponse.Message)
-	},
+		return "/authz/roles/" + args[0] + "/groups/" + group, nil
+	}
+	return "/authz/roles/" + args[0] + "/" + args[1], nil
 }
 
 func init() {
@@ -141,4 +182,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// roleAssignCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	roleAssignCmd.Flags().StringP("group", "g", "", "Defines a group (from OIDC groups claim) to assign the role, instead of a user")
 }
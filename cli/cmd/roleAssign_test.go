@@ -0,0 +1,97 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoleAssignmentPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		group string
+		path  string
+		fail  bool
+	}{
+		{name: "User", args: []string{"prod-web", "alice"}, path: "/authz/roles/prod-web/alice"},
+		{name: "Group", args: []string{"prod-web"}, group: "ops-team", path: "/authz/roles/prod-web/groups/ops-team"},
+		{name: "Role not slug", args: []string{"Prod Web"}, group: "ops-team", fail: true},
+		{name: "Group not slug", args: []string{"prod-web"}, group: "Ops Team", fail: true},
+		{name: "User and group", args: []string{"prod-web", "alice"}, group: "ops-team", fail: true},
+		{name: "Neither user nor group", args: []string{"prod-web"}, fail: true},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				path, err := roleAssignmentPath(test.args, test.group)
+				if test.fail {
+					if err == nil {
+						t.Fatalf("roleAssignmentPath: check fail expecting error (%s)", path)
+					}
+					return
+				}
+				if err != nil || path != test.path {
+					t.Fatalf("roleAssignmentPath: check fail with path %s (%v)", path, err)
+				}
+			})
+	}
+}
+
+func TestRoleAssignMessage(t *testing.T) {
+	t.Run(
+		"New assignment",
+		func(t *testing.T) {
+			message, err := roleAssignMessage([]byte(`{"result":"success","message":"Role associated"}`))
+			if err != nil || message != "Role associated" {
+				t.Fatalf("roleAssignMessage: check fail with new assignment (%s, %v)", message, err)
+			}
+		})
+	t.Run(
+		"Already assigned",
+		func(t *testing.T) {
+			message, err := roleAssignMessage([]byte(`{"result":"already_assigned","message":"User already has this role"}`))
+			if err != nil || !strings.HasPrefix(message, "Already assigned:") {
+				t.Fatalf("roleAssignMessage: check fail with existing assignment (%s, %v)", message, err)
+			}
+		})
+	t.Run(
+		"Failure",
+		func(t *testing.T) {
+			if _, err := roleAssignMessage([]byte(`{"result":"fail","message":"Role ID not found"}`)); err == nil {
+				t.Fatalf("roleAssignMessage: check fail with failure response")
+			}
+			if _, err := roleAssignMessage([]byte(`not json`)); err == nil {
+				t.Fatalf("roleAssignMessage: check fail with invalid response")
+			}
+		})
+}
@@ -40,28 +40,37 @@ import (
 
 	"github.com/globocom/gsh/cli/cmd/auth"
 	"github.com/globocom/gsh/cli/cmd/config"
-	"github.com/gosimple/slug"
 	"github.com/spf13/cobra"
 )
 
 // roleDissociateCmd represents the roleDissociate command
 var roleDissociateCmd = &cobra.Command{
-	Use:   "role-dissociate [role] [user]",
-	Short: "Dissociate a role to a user",
+	Use:     "role-dissociate [role] [user]",
+	Aliases: []string{"role-revoke"},
+	Short:   "Dissociate a role to a user or group",
 	Long: `
 
-Dissociate a role to a user at GSH API.
+Dissociate a role to a user at GSH API. Using --group, the role is
+dissociated from a group instead of a user:
+
+	gsh role-revoke [role] --group [group]
 	`,
-	Args: cobra.ExactArgs(2),
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
-		// Get current target
-		currentTarget := config.GetCurrentTarget()
-
-		// Validate if ID is slug string
-		if !slug.IsSlug(args[0]) {
-			fmt.Printf("Client error parsing id, it's a slug string?: (%v)\n", args[0])
+		// Check for group flag
+		group, err := cmd.Flags().GetString("group")
+		if err != nil {
+			fmt.Printf("Client error parsing group option: (%s)\n", err.Error())
 			os.Exit(1)
 		}
+		path, err := roleAssignmentPath(args, group)
+		if err != nil {
+			fmt.Printf("Client error: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
 
 		// Get OIDC HTTP Client
 		oauth2Token, err := auth.RecoverToken(currentTarget)
@@ -76,14 +85,15 @@ Dissociate a role to a user at GSH API.
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
 		// Make GSH request
-		req, err := http.NewRequest("DELETE", currentTarget.Endpoint+"/authz/roles/"+args[0]+"/"+args[1], nil)
+		req, err := http.NewRequest("DELETE", currentTarget.Endpoint+path, nil)
 		if err != nil {
 			fmt.Printf("Client error creating delete role request: (%s)\n", err.Error())
 			os.Exit(1)
@@ -140,4 +150,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// roleDissociateCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	roleDissociateCmd.Flags().StringP("group", "g", "", "Defines a group (from OIDC groups claim) to dissociate the role, instead of a user")
 }
@@ -36,8 +36,10 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/globocom/gsh/api/permissions"
 	"github.com/globocom/gsh/cli/cmd/auth"
 	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/globocom/gsh/types"
@@ -65,10 +67,11 @@ List all roles at GSH API. If a user is informed, this command list roles of inf
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
 		// Get OIDC HTTP Client
@@ -129,9 +132,9 @@ List all roles at GSH API. If a user is informed, this command list roles of inf
 			os.Exit(1)
 		}
 
-		table := tablecli.Table{Headers: tablecli.Row([]string{"ID", "Remote user", "User IP", "Remote host", "Actions"})}
+		table := tablecli.Table{Headers: tablecli.Row([]string{"ID", "Remote user", "User IP", "Remote host", "Dest ports", "Extensions", "Actions"})}
 		for _, role := range roleResponse.Roles {
-			table.AddRow(tablecli.Row([]string{role.ID, role.RemoteUser, role.SourceIP, role.TargetIP, role.Actions}))
+			table.AddRow(tablecli.Row([]string{role.ID, role.RemoteUser, role.SourceIP, role.TargetIP, destPorts(role.DestPorts), roleExtensions(role.Extensions), role.Actions}))
 		}
 		if table.Rows() > 0 {
 			table.Sort()
@@ -140,6 +143,22 @@ List all roles at GSH API. If a user is informed, this command list roles of inf
 	},
 }
 
+// destPorts formats the destination ports of a role, empty allows any port
+func destPorts(ports string) string {
+	if ports == "" {
+		return "*"
+	}
+	return ports
+}
+
+// roleExtensions formats the certificate extensions of a role, empty grants the default ones
+func roleExtensions(extensions string) string {
+	if extensions == "" {
+		return strings.Join(permissions.DefaultExtensions, ";") + " (default)"
+	}
+	return extensions
+}
+
 func init() {
 	rootCmd.AddCommand(roleListCmd)
 
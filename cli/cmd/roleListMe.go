@@ -63,10 +63,11 @@ List all roles for current user at GSH API.
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
 		// Get OIDC HTTP Client
@@ -122,9 +123,9 @@ List all roles for current user at GSH API.
 			os.Exit(1)
 		}
 
-		table := tablecli.Table{Headers: tablecli.Row([]string{"ID", "Remote user", "User IP", "Remote host", "Actions"})}
+		table := tablecli.Table{Headers: tablecli.Row([]string{"ID", "Remote user", "User IP", "Remote host", "Dest ports", "Extensions", "Actions"})}
 		for _, role := range roleResponse.Roles {
-			table.AddRow(tablecli.Row([]string{role.ID, role.RemoteUser, role.SourceIP, role.TargetIP, role.Actions}))
+			table.AddRow(tablecli.Row([]string{role.ID, role.RemoteUser, role.SourceIP, role.TargetIP, destPorts(role.DestPorts), roleExtensions(role.Extensions), role.Actions}))
 		}
 		if table.Rows() > 0 {
 			table.Sort()
@@ -72,10 +72,11 @@ List users associated with a role at GSH API.
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
 		// Get OIDC HTTP Client
@@ -132,13 +133,14 @@ List users associated with a role at GSH API.
 			os.Exit(1)
 		}
 
-		table := tablecli.Table{Headers: tablecli.Row([]string{"ID", "Remote user", "User IP", "Remote host", "Actions", "Users"})}
+		table := tablecli.Table{Headers: tablecli.Row([]string{"ID", "Remote user", "User IP", "Remote host", "Dest ports", "Actions", "Users"})}
 		for _, user := range roleResponse.Users {
 			table.AddRow(tablecli.Row([]string{
 				roleResponse.Role.ID,
 				roleResponse.Role.RemoteUser,
 				roleResponse.Role.SourceIP,
 				roleResponse.Role.TargetIP,
+				destPorts(roleResponse.Role.DestPorts),
 				roleResponse.Role.Actions,
 				user,
 			}))
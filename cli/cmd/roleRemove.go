@@ -50,7 +50,9 @@ var roleRemoveCmd = &cobra.Command{
 	Short: "Remove a role by id",
 	Long: `
 
-	Remove a role by id at GSH API.
+	Remove a role by id at GSH API. The removed role stops authorizing, but it is kept
+	with its assignments to be restored with gsh role-restore, until the retention
+	configured at GSH API (role_retention). Use --purge to remove it for good.
 	`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -63,6 +65,17 @@ var roleRemoveCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// Purged roles can't be restored
+		purge, err := cmd.Flags().GetBool("purge")
+		if err != nil {
+			fmt.Printf("Client error parsing purge option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		query := ""
+		if purge {
+			query = "?purge=true"
+		}
+
 		// Get OIDC HTTP Client
 		oauth2Token, err := auth.RecoverToken(currentTarget)
 		if err != nil {
@@ -76,14 +89,15 @@ var roleRemoveCmd = &cobra.Command{
 				Timeout: 10 * time.Second,
 			}).Dial,
 			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
 		}
 		var netClient = &http.Client{
 			Timeout:   10 * time.Second,
-			Transport: netTransport,
+			Transport: config.TargetTransport(netTransport, currentTarget),
 		}
 
 		// Make GSH request
-		req, err := http.NewRequest("DELETE", currentTarget.Endpoint+"/authz/roles/"+args[0], nil)
+		req, err := http.NewRequest("DELETE", currentTarget.Endpoint+"/authz/roles/"+args[0]+query, nil)
 		if err != nil {
 			fmt.Printf("Client error creating delete role request: (%s)\n", err.Error())
 			os.Exit(1)
@@ -140,4 +154,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// roleRemoveCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	roleRemoveCmd.Flags().Bool("purge", false, "Removes the role and its assignments for good, it can't be restored")
 }
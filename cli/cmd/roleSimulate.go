@@ -0,0 +1,198 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/gosimple/slug"
+	"github.com/spf13/cobra"
+)
+
+// roleSimulateCmd represents the roleSimulate command
+var roleSimulateCmd = &cobra.Command{
+	Use:   "role-simulate",
+	Short: "Simulates if a role authorizes a certificate request",
+	Long: `
+
+Simulates if a role would authorize a user, connecting from source-ip, to
+request a certificate for host as principal. GSH API uses the same
+authorization of certificate requests, without signing anything, and
+returns the condition that denies the request.
+
+	gsh role-simulate --role prod-web --user alice --host 198.51.100.10 --principal alice --source-ip 192.0.2.10
+	`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		type SimulateRequest struct {
+			Role       string `json:"role"`
+			User       string `json:"user"`
+			RemoteUser string `json:"remote_user"`
+			RemoteHost string `json:"remote_host"`
+			UserIP     string `json:"user_ip"`
+			RemotePort string `json:"remote_port,omitempty"`
+		}
+		simulateRequest := SimulateRequest{}
+		for flag, value := range map[string]*string{
+			"role":      &simulateRequest.Role,
+			"user":      &simulateRequest.User,
+			"host":      &simulateRequest.RemoteHost,
+			"principal": &simulateRequest.RemoteUser,
+			"source-ip": &simulateRequest.UserIP,
+			"port":      &simulateRequest.RemotePort,
+		} {
+			var err error
+			*value, err = cmd.Flags().GetString(flag)
+			if err != nil {
+				fmt.Printf("Client error parsing %s option: (%s)\n", flag, err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// Validate if ID is slug string
+		if !slug.IsSlug(simulateRequest.Role) {
+			fmt.Printf("Client error parsing role, is it a slug string?: (%v)\n", simulateRequest.Role)
+			os.Exit(1)
+		}
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Marshall simulate request to JSON
+		simulateRequestJSON, err := json.Marshal(simulateRequest)
+		if err != nil {
+			fmt.Printf("Client error formating json: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		// Make GSH request
+		req, err := http.NewRequest("POST", currentTarget.Endpoint+"/authz/simulate", bytes.NewBuffer(simulateRequestJSON))
+		if err != nil {
+			fmt.Printf("Client error pre simulate request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		req.Header.Set("Authorization", "JWT "+oauth2Token.AccessToken)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := netClient.Do(req)
+		if err != nil {
+			fmt.Printf("Client error post simulate request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Read body
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			fmt.Printf("Client error reading simulate response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Client error checking http status response: (%v)\n", resp.StatusCode)
+		}
+		defer resp.Body.Close()
+
+		// Parse simulate response
+		type SimulateResponse struct {
+			Details  string     `json:"details"`
+			Message  string     `json:"message"`
+			Result   string     `json:"result"`
+			Allowed  bool       `json:"allowed"`
+			Assigned bool       `json:"assigned"`
+			Reason   string     `json:"reason"`
+			Role     types.Role `json:"role"`
+		}
+		simulateResponse := new(SimulateResponse)
+		if err := json.Unmarshal(body, &simulateResponse); err != nil {
+			fmt.Printf("Client error parsing simulate response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if simulateResponse.Result == "fail" {
+			fmt.Printf("Client error calling GSH API: (%v)\n", simulateResponse)
+			os.Exit(1)
+		}
+
+		role := simulateResponse.Role
+		fmt.Printf("Role %s: remote user [%s] source ip [%s] remote host [%s] dest ports [%s] actions [%s]\n",
+			role.ID, role.RemoteUser, role.SourceIP, role.TargetIP, destPorts(role.DestPorts), role.Actions)
+		if !simulateResponse.Assigned {
+			fmt.Printf("Note: role is not assigned directly to user %s\n", simulateRequest.User)
+		}
+		if simulateResponse.Allowed {
+			fmt.Println("Result: allow")
+		} else {
+			fmt.Printf("Result: deny (%s)\n", simulateResponse.Reason)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(roleSimulateCmd)
+
+	roleSimulateCmd.Flags().String("role", "", "Defines the role to simulate")
+	roleSimulateCmd.Flags().String("user", "", "Defines the user requesting the certificate")
+	roleSimulateCmd.Flags().String("host", "", "Defines the remote host IP address")
+	roleSimulateCmd.Flags().String("principal", "", "Defines the remote user (certificate principal)")
+	roleSimulateCmd.Flags().String("source-ip", "", "Defines the user IP address used as source")
+	roleSimulateCmd.Flags().String("port", "", "Defines the destination port at remote host (default 22)")
+	for _, flag := range []string{"role", "user", "host", "principal", "source-ip"} {
+		_ = roleSimulateCmd.MarkFlagRequired(flag)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/tablecli"
+)
+
+// roleUsageCmd represents the roleUsage command
+var roleUsageCmd = &cobra.Command{
+	Use:   "role-usage",
+	Short: "Shows how many certificates each role authorized",
+	Long: `
+
+Shows, for each role, how many certificates it authorized and when it was
+last used, read by GSH API from audit records. Dormant roles (no
+certificates in the time window) are candidates for removal. The time window
+defaults to the last 30 days. Restricted to GSH admins.
+
+	gsh role-usage
+	gsh role-usage --since 2024-01-01T00:00:00Z --until 2024-02-01T00:00:00Z
+	gsh role-usage --output json
+	`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			fmt.Printf("Client error parsing output option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if output != "text" && output != "json" {
+			fmt.Printf("Client error parsing output option: (%s is not text or json)\n", output)
+			os.Exit(1)
+		}
+		query := url.Values{}
+		for _, name := range []string{"since", "until"} {
+			value, err := cmd.Flags().GetString(name)
+			if err != nil {
+				fmt.Printf("Client error parsing %s option: (%s)\n", name, err.Error())
+				os.Exit(1)
+			}
+			if value == "" {
+				continue
+			}
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				fmt.Printf("Client error parsing %s option, is it a RFC 3339 time?: (%s)\n", name, value)
+				os.Exit(1)
+			}
+			query.Set(name, value)
+		}
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Make GSH request
+		req, err := http.NewRequest("GET", currentTarget.Endpoint+"/authz/role-usage?"+query.Encode(), nil)
+		if err != nil {
+			fmt.Printf("Client error pre role usage request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		req.Header.Set("Authorization", "JWT "+oauth2Token.AccessToken)
+		resp, err := netClient.Do(req)
+		if err != nil {
+			fmt.Printf("Client error role usage request: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		// Read body
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			fmt.Printf("Client error reading role usage response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Parse role usage response
+		type RoleUsageResponse struct {
+			Details string                `json:"details"`
+			Message string                `json:"message"`
+			Result  string                `json:"result"`
+			Usage   types.RoleUsageReport `json:"usage"`
+		}
+		usageResponse := new(RoleUsageResponse)
+		if err := json.Unmarshal(body, &usageResponse); err != nil {
+			fmt.Printf("Client error parsing role usage response: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if resp.StatusCode != http.StatusOK || usageResponse.Result != "success" {
+			fmt.Printf("Client error calling GSH API: (%d %s %s)\n", resp.StatusCode, usageResponse.Message, usageResponse.Details)
+			os.Exit(1)
+		}
+
+		if output == "json" {
+			content, err := json.MarshalIndent(usageResponse.Usage, "", "  ")
+			if err != nil {
+				fmt.Printf("Client error formatting role usage: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(string(content))
+			return
+		}
+		printRoleUsage(os.Stdout, usageResponse.Usage)
+	},
+}
+
+// printRoleUsage writes the time window of report and a table row for each role
+func printRoleUsage(w io.Writer, report types.RoleUsageReport) {
+	fmt.Fprintf(w, "Certificates issued from %s to %s\n", report.Since.Local().Format(time.RFC3339), report.Until.Local().Format(time.RFC3339))
+	table := tablecli.Table{Headers: tablecli.Row([]string{"Role", "Certificates", "Last used", "Status"})}
+	for _, usage := range report.Roles {
+		lastUsed, status := "-", "dormant"
+		if usage.LastUsed != nil {
+			lastUsed, status = usage.LastUsed.Local().Format(time.RFC3339), "used"
+		}
+		table.AddRow(tablecli.Row([]string{usage.Role, strconv.Itoa(usage.Certificates), lastUsed, status}))
+	}
+	fmt.Fprintln(w, table.String())
+}
+
+func init() {
+	rootCmd.AddCommand(roleUsageCmd)
+
+	roleUsageCmd.Flags().String("since", "", "Counts certificates issued after this time (RFC 3339), 30 days before until by default")
+	roleUsageCmd.Flags().String("until", "", "Counts certificates issued up to this time (RFC 3339), now by default")
+	roleUsageCmd.Flags().StringP("output", "o", "text", "Defines the output format (text or json)")
+}
@@ -0,0 +1,253 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/globocom/gsh/api/permissions"
+	"github.com/gosimple/slug"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// roleFileFields are the fields of a role at a roles definition file
+var roleFileFields = []string{"id", "remote_user", "user_ip", "remote_host", "actions", "dest_ports", "permit", "deny", "users", "groups"}
+
+// roleValidateCmd represents the roleValidate command
+var roleValidateCmd = &cobra.Command{
+	Use:   "role-validate [file]",
+	Short: "Validates a roles definition file, without contacting GSH API",
+	Long: `
+
+Validates a roles definition file (YAML or JSON, by its extension) locally, as in
+a review of roles managed declaratively. Every error of the file is reported.
+The file lists roles with the fields of gsh role-add and their assignments:
+
+	roles:
+	  - id: ops
+	    remote_user: "."
+	    user_ip: [192.0.2.0/24]
+	    remote_host: [198.51.100.0/24]
+	    dest_ports: "22;2222"
+	    permit: [permit-agent-forwarding]
+	    users: [alice]
+	    groups: [ops]
+
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		roles, err := readRoleFile(args[0])
+		if err != nil {
+			fmt.Printf("Client error reading roles file: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		errs := validateRoles(roles)
+		for _, err := range errs {
+			fmt.Println(err.Error())
+		}
+		if len(errs) > 0 {
+			fmt.Printf("Client error validating roles file: (%d errors at %s)\n", len(errs), args[0])
+			os.Exit(1)
+		}
+		fmt.Printf("%d roles are valid at %s\n", len(roles), args[0])
+	},
+}
+
+// readRoleFile returns the roles listed at the roles definition file at path
+func readRoleFile(path string) ([]interface{}, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	if !v.IsSet("roles") {
+		return nil, errors.New("roles are not listed at the file (roles)")
+	}
+	roles, ok := v.Get("roles").([]interface{})
+	if !ok {
+		return nil, errors.New("roles must be a list (roles)")
+	}
+	return roles, nil
+}
+
+// validateRoles checks every field of roles read from a roles definition file, as GSH API
+// would check them at role-add, returning all errors found
+func validateRoles(roles []interface{}) []error {
+	var errs []error
+	ids := map[string]int{}
+	for i, item := range roles {
+		role, ok := stringKeys(item)
+		if !ok {
+			errs = append(errs, fmt.Errorf("role %d: must be a map of fields", i+1))
+			continue
+		}
+		id, _ := role["id"].(string)
+		fail := func(field string, format string, a ...interface{}) {
+			errs = append(errs, fmt.Errorf("role %d (%s): %s: %s", i+1, id, field, fmt.Sprintf(format, a...)))
+		}
+
+		// fields unknown by role-add are typos, never ignored
+		known := map[string]bool{}
+		for _, field := range roleFileFields {
+			known[field] = true
+		}
+		var unknown []string
+		for field := range role {
+			if !known[field] {
+				unknown = append(unknown, field)
+			}
+		}
+		sort.Strings(unknown)
+		for _, field := range unknown {
+			fail(field, "unknown field (use %s)", strings.Join(roleFileFields, ", "))
+		}
+
+		if !slug.IsSlug(id) {
+			fail("id", "must be a slug string (%v)", role["id"])
+		} else if first, ok := ids[id]; ok {
+			fail("id", "duplicated, also used by role %d", first)
+		} else {
+			ids[id] = i + 1
+		}
+
+		// remote user is ".", "*" or a username (role-add default is ".")
+		if value, ok := role["remote_user"]; ok {
+			if remoteUser, ok := value.(string); !ok || strings.TrimSpace(remoteUser) == "" {
+				fail("remote_user", "must be \".\", \"*\" or a username (%v)", value)
+			}
+		}
+		if value, ok := role["actions"]; ok {
+			if actions, ok := value.(string); !ok || strings.TrimSpace(actions) == "" {
+				fail("actions", "must be a string (%v)", value)
+			}
+		}
+
+		for _, field := range []string{"user_ip", "remote_host"} {
+			networks, err := roleFileList(role[field], ";")
+			if err != nil {
+				fail(field, "%s", err.Error())
+				continue
+			}
+			if len(networks) == 0 {
+				fail(field, "at least one network is required")
+			}
+			for _, network := range networks {
+				if _, _, err := net.ParseCIDR(network); err != nil {
+					fail(field, "invalid network %s, use CIDR notation", network)
+				}
+			}
+		}
+
+		if value, ok := role["dest_ports"]; ok {
+			if _, err := permissions.ParseDestPorts(fmt.Sprint(value)); err != nil {
+				fail("dest_ports", "%s", err.Error())
+			}
+		}
+
+		permit, err := roleFileList(role["permit"], "")
+		if err != nil {
+			fail("permit", "%s", err.Error())
+		}
+		deny, err := roleFileList(role["deny"], "")
+		if err != nil {
+			fail("deny", "%s", err.Error())
+		}
+		if _, err := permissions.RoleExtensions(permit, deny); err != nil {
+			fail("permit/deny", "%s", err.Error())
+		}
+
+		for _, field := range []string{"users", "groups"} {
+			subjects, err := roleFileList(role[field], "")
+			if err != nil {
+				fail(field, "%s", err.Error())
+			}
+			for _, subject := range subjects {
+				if subject == "" || strings.ContainsAny(subject, " \t;") {
+					fail(field, "invalid name %q", subject)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// stringKeys returns item as a map with string keys, as YAML maps are read with any type of key
+func stringKeys(item interface{}) (map[string]interface{}, bool) {
+	switch m := item.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, value := range m {
+			converted[fmt.Sprint(key)] = value
+		}
+		return converted, true
+	}
+	return nil, false
+}
+
+// roleFileList returns the strings of a list field of a role. With separator, a string with
+// values separated by it (as used by role-add) is accepted too.
+func roleFileList(value interface{}, separator string) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if separator == "" {
+			return nil, fmt.Errorf("must be a list (%s)", v)
+		}
+		var values []string
+		for _, item := range strings.Split(v, separator) {
+			values = append(values, strings.TrimSpace(item))
+		}
+		return values, nil
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be a list of strings (%v)", item)
+			}
+			values = append(values, strings.TrimSpace(s))
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("must be a list (%v)", value)
+}
+
+func init() {
+	rootCmd.AddCommand(roleValidateCmd)
+}
@@ -0,0 +1,142 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateRoles(t *testing.T) {
+	writeFile := func(name string, content string) string {
+		file := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatalf("validateRoles: check fail writing roles file (%v)", err)
+		}
+		return file
+	}
+
+	t.Run(
+		"Valid YAML file",
+		func(t *testing.T) {
+			roles, err := readRoleFile(writeFile("roles.yaml", `
+roles:
+  - id: ops
+    remote_user: "."
+    user_ip: [192.0.2.0/24, 2001:db8::/32]
+    remote_host: 198.51.100.0/24;203.0.113.0/24
+    dest_ports: 22
+    permit: [permit-agent-forwarding]
+    users: [alice]
+    groups: [ops]
+  - id: batch
+    remote_user: batch
+    user_ip: [192.0.2.0/24]
+    remote_host: [198.51.100.0/24]
+    permit: [permit-user-rc]
+    deny: [permit-pty]
+`))
+			if err != nil {
+				t.Fatalf("validateRoles: check fail reading YAML file (%v)", err)
+			}
+			if errs := validateRoles(roles); len(roles) != 2 || len(errs) != 0 {
+				t.Fatalf("validateRoles: check fail with valid YAML file (%d roles, %v)", len(roles), errs)
+			}
+		})
+	t.Run(
+		"Valid JSON file",
+		func(t *testing.T) {
+			roles, err := readRoleFile(writeFile("roles.json",
+				`{"roles": [{"id": "ops", "user_ip": ["192.0.2.0/24"], "remote_host": ["198.51.100.0/24"], "dest_ports": "22;2222"}]}`))
+			if err != nil {
+				t.Fatalf("validateRoles: check fail reading JSON file (%v)", err)
+			}
+			if errs := validateRoles(roles); len(errs) != 0 {
+				t.Fatalf("validateRoles: check fail with valid JSON file (%v)", errs)
+			}
+		})
+	t.Run(
+		"Every error reported",
+		func(t *testing.T) {
+			roles, err := readRoleFile(writeFile("roles.yaml", `
+roles:
+  - id: Ops Team
+    user_ip: [192.0.2.0/33]
+    remote_host: [198.51.100.0/24]
+    dest_ports: "ssh"
+  - id: web
+    user_ip: [192.0.2.0/24]
+    remote_host: []
+    permit: [permit-everything]
+    ttl: 1h
+  - id: web
+    user_ip: [192.0.2.0/24]
+    remote_host: [198.51.100.0/24]
+    deny: [permit-pty]
+    users: ["alice smith"]
+`))
+			if err != nil {
+				t.Fatalf("validateRoles: check fail reading broken file (%v)", err)
+			}
+			errs := validateRoles(roles)
+			expected := []string{
+				"role 1 (Ops Team): id:",
+				"role 1 (Ops Team): user_ip:",
+				"role 1 (Ops Team): dest_ports:",
+				"role 2 (web): ttl: unknown field",
+				"role 2 (web): remote_host:",
+				"role 2 (web): permit/deny:",
+				"role 3 (web): id: duplicated",
+				"role 3 (web): permit/deny:",
+				"role 3 (web): users:",
+			}
+			if len(errs) != len(expected) {
+				t.Fatalf("validateRoles: check fail, %d errors expected (%v)", len(expected), errs)
+			}
+			for _, prefix := range expected {
+				found := false
+				for _, err := range errs {
+					found = found || strings.HasPrefix(err.Error(), prefix)
+				}
+				if !found {
+					t.Fatalf("validateRoles: check fail, %s not reported (%v)", prefix, errs)
+				}
+			}
+		})
+	t.Run(
+		"Roles not listed",
+		func(t *testing.T) {
+			if _, err := readRoleFile(writeFile("roles.yaml", "role:\n  id: ops\n")); err == nil {
+				t.Fatalf("validateRoles: check fail without roles")
+			}
+		})
+}
@@ -30,10 +30,16 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -41,6 +47,10 @@ import (
 
 var cfgFile string
 
+var headerFlags []string
+
+var deadline time.Duration
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "gsh",
@@ -71,14 +81,62 @@ func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gsh/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file, created when it doesn't exist (default is $HOME/.gsh/config.yaml)")
+	rootCmd.PersistentFlags().String("account", "", "Defines the account used at current target, for users with more than one identity (default is the unnamed account)")
+	_ = viper.BindPFlag("account", rootCmd.PersistentFlags().Lookup("account"))
+	rootCmd.PersistentFlags().String("min-tls-version", types.DefaultMinTLSVersion, "Defines the minimum TLS version used to connect to GSH API and OIDC provider (1.2 or 1.3)")
+	_ = viper.BindPFlag("min_tls_version", rootCmd.PersistentFlags().Lookup("min-tls-version"))
+	rootCmd.PersistentFlags().DurationVar(&deadline, "deadline", 0, "Aborts network calls (GSH API, OIDC provider and ssh running --command) after this duration, as an upper bound for scripts (0 disables it)")
+	rootCmd.PersistentFlags().StringArrayVar(&headerFlags, "header", []string{}, "Defines an extra header sent to GSH API, as \"Name: value\" (can be repeated)")
+}
+
+// useConfigFile sets file (--config) as the config file of v, creating it when it doesn't exist.
+// Its extension tells the format, viper needs it to write targets back to file.
+func useConfigFile(v *viper.Viper, file string) (bool, error) {
+	format := strings.TrimPrefix(filepath.Ext(file), ".")
+	supported := false
+	for _, ext := range viper.SupportedExts {
+		supported = supported || format == ext
+	}
+	if !supported {
+		return false, errors.New("config file extension must be one of " + strings.Join(viper.SupportedExts, ", "))
+	}
+
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		v.SetConfigFile(file)
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return false, err
+	}
+	content := ""
+	if format == "json" {
+		content = "{}\n"
+	}
+	if err := os.WriteFile(filepath.Clean(file), []byte(content), 0600); err != nil {
+		return false, err
+	}
+	v.SetConfigFile(file)
+	return true, nil
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	config.SetFlagHeaders(headerFlags)
+	if deadline > 0 {
+		_ = config.SetDeadline(deadline)
+	}
+
 	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
+		// Use config file from the flag, target commands write back to it
+		created, err := useConfigFile(viper.GetViper(), cfgFile)
+		if err != nil {
+			fmt.Printf("Client error using config file: %s (%s)\n", cfgFile, err.Error())
+			os.Exit(1)
+		}
+		if created {
+			fmt.Printf("Client created new config file: %s\n", cfgFile)
+		}
 	} else {
 		// Find home directory.
 		home, err := homedir.Dir()
@@ -132,4 +190,19 @@ func initConfig() {
 		os.Exit(1)
 	}
 	fmt.Printf("Using config file: %s\n\n", viper.ConfigFileUsed())
+
+	// Targets provisioned by admins at system-wide config are merged with user targets
+	systemConfig := os.Getenv("GSH_SYSTEM_CONFIG")
+	if systemConfig == "" {
+		systemConfig = config.SystemConfigFile
+	}
+	if err := config.LoadSystemConfig(systemConfig); err != nil {
+		fmt.Printf("Client error reading system config file: %s (%s)\n", systemConfig, err.Error())
+		os.Exit(1)
+	}
+
+	// TLS floor also applies to connections made by OIDC and OAuth2 libraries
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.TLSClientConfig = config.TLSClientConfig()
+	}
 }
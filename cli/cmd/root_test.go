@@ -0,0 +1,85 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestUseConfigFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "project", "gsh.yaml")
+
+	t.Run(
+		"Custom config path",
+		func(t *testing.T) {
+			v := viper.New()
+			created, err := useConfigFile(v, file)
+			if err != nil || !created {
+				t.Fatalf("useConfigFile: check fail creating config file (%v, %v)", created, err)
+			}
+			if err := v.ReadInConfig(); err != nil {
+				t.Fatalf("useConfigFile: check fail reading config file (%v)", err)
+			}
+
+			// target commands write back to the same file
+			v.Set("targets.project.current", true)
+			if err := v.WriteConfig(); err != nil {
+				t.Fatalf("useConfigFile: check fail writing config file (%v)", err)
+			}
+			content, err := os.ReadFile(file)
+			if err != nil || !strings.Contains(string(content), "current: true") {
+				t.Fatalf("useConfigFile: check fail with written config (%s, %v)", content, err)
+			}
+		})
+	t.Run(
+		"Existing config file",
+		func(t *testing.T) {
+			v := viper.New()
+			created, err := useConfigFile(v, file)
+			if err != nil || created {
+				t.Fatalf("useConfigFile: check fail with existing config file (%v, %v)", created, err)
+			}
+			if err := v.ReadInConfig(); err != nil || !v.GetBool("targets.project.current") {
+				t.Fatalf("useConfigFile: check fail reading existing config (%v)", err)
+			}
+		})
+	t.Run(
+		"Config file without extension",
+		func(t *testing.T) {
+			if _, err := useConfigFile(viper.New(), filepath.Join(t.TempDir(), "gsh")); err == nil {
+				t.Fatalf("useConfigFile: check fail without extension")
+			}
+		})
+}
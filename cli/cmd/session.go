@@ -0,0 +1,90 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// sessionTimeoutExitCode is the exit code of host-connect when --session-timeout expires (as timeout(1))
+const sessionTimeoutExitCode = 124
+
+// errSessionTimeout is returned by runSession when the session is killed at its deadline
+var errSessionTimeout = errors.New("session timeout expired, ssh was killed")
+
+// checkSessionTimeout refuses a session timeout without a remote command: ssh runs in its own
+// process group, which can't read from the terminal, so an interactive session would end at once
+func checkSessionTimeout(sessionTimeout time.Duration, remoteCommand string) error {
+	if sessionTimeout > 0 && remoteCommand == "" {
+		return errors.New("--session-timeout requires a remote command (--command)")
+	}
+	return nil
+}
+
+// sessionCommand returns the command running name with args in its own process group, killed
+// by runSession when ctx is done
+func sessionCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	// #nosec
+	c := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(c)
+	return c
+}
+
+// runSession runs c, created by sessionCommand, killing its whole process group (and not only
+// the ssh process) when ctx is done. It returns errSessionTimeout if ctx deadline expired.
+func runSession(ctx context.Context, c *exec.Cmd) error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = killProcessGroup(c)
+		case <-done:
+		}
+	}()
+	err := c.Wait()
+	close(done)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errSessionTimeout
+	}
+	return err
+}
+
+// isTerminal tells whether file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
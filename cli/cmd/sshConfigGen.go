@@ -0,0 +1,174 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/spf13/cobra"
+)
+
+// sshConfigMarker delimits the blocks written by ssh-config-gen, so they are replaced when generated again
+const sshConfigMarker = "gsh ssh-config-gen"
+
+// sshConfigGenCmd represents the sshConfigGen command
+var sshConfigGenCmd = &cobra.Command{
+	Use:   "ssh-config-gen [host]",
+	Short: "Generates ssh config to use gsh certificates with ssh",
+	Long: `
+
+Generates a ssh config block for host, so ssh host requests a certificate
+with gsh host-connect --no-shell before connecting. The certificate is
+requested for the remote user and port used by ssh. The block is printed, or
+written with --file, replacing a block generated before for the same host:
+
+	gsh ssh-config-gen 198.51.100.10 --username alice
+	gsh ssh-config-gen web01.example.com --file ~/.ssh/config
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		username, err := cmd.Flags().GetString("username")
+		if err != nil {
+			fmt.Printf("Client error parsing username option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		file, err := cmd.Flags().GetString("file")
+		if err != nil {
+			fmt.Printf("Client error parsing file option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// gsh is called by ssh, using the path of this binary
+		gshPath, err := os.Executable()
+		if err != nil {
+			fmt.Printf("Client error getting gsh path: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		keyFile, err := files.SSHConfigKeyPath(args[0])
+		if err != nil {
+			fmt.Printf("Client error getting key path: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		knownHostsFile, err := files.KnownHostsPath()
+		if err != nil {
+			fmt.Printf("Client error getting known_hosts path: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		block, err := sshConfigBlock(args[0], gshPath, keyFile, knownHostsFile, username, config.GetCurrentTarget().Label)
+		if err != nil {
+			fmt.Printf("Client error generating ssh config: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if file == "" {
+			fmt.Print(block)
+			return
+		}
+
+		content, err := os.ReadFile(filepath.Clean(file))
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Client error reading ssh config: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if err := files.WriteFileAt(file, upsertSSHConfigBlock(string(content), args[0], block)); err != nil {
+			fmt.Printf("Client error writing ssh config: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("ssh config for %s written at %s\n", args[0], file)
+	},
+}
+
+// sshConfigBlock returns the ssh config block of host. Match exec runs gsh before connecting,
+// writing the private key at keyFile and its certificate beside it, and matches only when the
+// certificate is issued. ssh expands %r (remote user), %p (port) and %h (host).
+func sshConfigBlock(host string, gshPath string, keyFile string, knownHostsFile string, username string, target string) (string, error) {
+	if host == "" || strings.ContainsAny(host, " \t\n\"'#%*?!,") {
+		return "", fmt.Errorf("invalid host %q, use a host name or IP address", host)
+	}
+	if strings.ContainsAny(username, " \t\n\"'#%") {
+		return "", fmt.Errorf("invalid username %q", username)
+	}
+	for _, path := range []string{gshPath, keyFile, knownHostsFile} {
+		// quotes can't be escaped at ssh config, and ssh expands % at file paths
+		if strings.ContainsAny(path, "\n\"'%") {
+			return "", fmt.Errorf("path %q can't be used at ssh config", path)
+		}
+	}
+
+	command := fmt.Sprintf("'%s' host-connect --no-shell --key-out '%s' --username %%r --port %%p %%h", gshPath, keyFile)
+	lines := []string{
+		"# BEGIN " + sshConfigMarker + " " + host + " (target " + target + ")",
+		fmt.Sprintf("Match host %s exec \"%s\"", host, command),
+	}
+	if username != "" {
+		lines = append(lines, "    User "+username)
+	}
+	lines = append(lines,
+		fmt.Sprintf("    IdentityFile \"%s\"", keyFile),
+		fmt.Sprintf("    CertificateFile \"%s\"", keyFile+"-cert.pub"),
+		"    IdentitiesOnly yes",
+		fmt.Sprintf("    UserKnownHostsFile \"%s\"", knownHostsFile),
+		"    StrictHostKeyChecking accept-new",
+		"# END "+sshConfigMarker+" "+host,
+	)
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// upsertSSHConfigBlock replaces the block of host at content, or appends it when there is none
+func upsertSSHConfigBlock(content string, host string, block string) string {
+	begin := "# BEGIN " + sshConfigMarker + " " + host + " "
+	end := "# END " + sshConfigMarker + " " + host + "\n"
+	if start := strings.Index(content, begin); start >= 0 && (start == 0 || content[start-1] == '\n') {
+		if length := strings.Index(content[start:], end); length >= 0 {
+			return content[:start] + block + content[start+length+len(end):]
+		}
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	if content != "" {
+		content += "\n"
+	}
+	return content + block
+}
+
+func init() {
+	rootCmd.AddCommand(sshConfigGenCmd)
+
+	sshConfigGenCmd.Flags().StringP("username", "u", "", "Defines the remote user set at ssh config (default is the user ssh uses)")
+	sshConfigGenCmd.Flags().StringP("file", "f", "", "Defines the ssh config file where the block is written or replaced, as ~/.ssh/config (default prints it)")
+}
@@ -0,0 +1,135 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSSHConfigBlock(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "ssh-config-198.51.100.10")
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+
+	t.Run(
+		"Block directives",
+		func(t *testing.T) {
+			block, err := sshConfigBlock("198.51.100.10", "/usr/local/bin/gsh", keyFile, knownHostsFile, "alice", "prod")
+			if err != nil {
+				t.Fatalf("sshConfigBlock: check fail generating block (%v)", err)
+			}
+			for _, expected := range []string{
+				`Match host 198.51.100.10 exec "'/usr/local/bin/gsh' host-connect --no-shell --key-out '` + keyFile + `' --username %r --port %p %h"`,
+				"    User alice",
+				`    IdentityFile "` + keyFile + `"`,
+				`    CertificateFile "` + keyFile + `-cert.pub"`,
+			} {
+				if !strings.Contains(block, expected+"\n") {
+					t.Fatalf("sshConfigBlock: check fail, missing %q (%s)", expected, block)
+				}
+			}
+		})
+	t.Run(
+		"Invalid values",
+		func(t *testing.T) {
+			if _, err := sshConfigBlock("web*", "/usr/local/bin/gsh", keyFile, knownHostsFile, "", "prod"); err == nil {
+				t.Fatalf("sshConfigBlock: check fail with host pattern")
+			}
+			if _, err := sshConfigBlock("web01", "/opt/it's/gsh", keyFile, knownHostsFile, "", "prod"); err == nil {
+				t.Fatalf("sshConfigBlock: check fail with quote at path")
+			}
+			if _, err := sshConfigBlock("web01", "/usr/local/bin/gsh", keyFile, knownHostsFile, "bob smith", "prod"); err == nil {
+				t.Fatalf("sshConfigBlock: check fail with invalid username")
+			}
+		})
+	t.Run(
+		"Parsed by ssh",
+		func(t *testing.T) {
+			if _, err := exec.LookPath("ssh"); err != nil {
+				t.Skipf("sshConfigBlock: ssh not available (%v)", err)
+			}
+			// ssh -G evaluates Match exec, gsh is replaced by commands that issue or not the certificate
+			parse := func(gshPath string) string {
+				block, err := sshConfigBlock("198.51.100.10", gshPath, keyFile, knownHostsFile, "alice", "prod")
+				if err != nil {
+					t.Fatalf("sshConfigBlock: check fail generating block (%v)", err)
+				}
+				configFile := filepath.Join(dir, "config")
+				if err := os.WriteFile(configFile, []byte(block), 0600); err != nil {
+					t.Fatalf("sshConfigBlock: fail writing ssh config (%v)", err)
+				}
+				// #nosec
+				output, err := exec.Command("ssh", "-G", "-F", configFile, "198.51.100.10").CombinedOutput()
+				if err != nil {
+					t.Fatalf("sshConfigBlock: check fail parsing ssh config (%v: %s)", err, output)
+				}
+				return string(output)
+			}
+			issued := parse("/bin/true")
+			for _, expected := range []string{"user alice", "certificatefile " + keyFile + "-cert.pub", "identityfile " + keyFile, "identitiesonly yes"} {
+				if !strings.Contains(issued, expected+"\n") {
+					t.Fatalf("sshConfigBlock: check fail, ssh config without %q (%s)", expected, issued)
+				}
+			}
+			if notIssued := parse("/bin/false"); strings.Contains(notIssued, "certificatefile "+keyFile) {
+				t.Fatalf("sshConfigBlock: check fail, block used without certificate (%s)", notIssued)
+			}
+		})
+}
+
+func TestUpsertSSHConfigBlock(t *testing.T) {
+	block := func(host string, user string) string {
+		b, err := sshConfigBlock(host, "/usr/local/bin/gsh", "/tmp/ssh-config-"+host, "/tmp/known_hosts", user, "prod")
+		if err != nil {
+			t.Fatalf("upsertSSHConfigBlock: fail generating block (%v)", err)
+		}
+		return b
+	}
+	existing := "Host bastion\n    User admin"
+
+	content := upsertSSHConfigBlock(existing, "web01", block("web01", "alice"))
+	if content != existing+"\n\n"+block("web01", "alice") {
+		t.Fatalf("upsertSSHConfigBlock: check fail appending block (%s)", content)
+	}
+	content = upsertSSHConfigBlock(content, "web02", block("web02", ""))
+	again := upsertSSHConfigBlock(content, "web01", block("web01", "alice"))
+	if again != content {
+		t.Fatalf("upsertSSHConfigBlock: check fail generating block again (%s)", again)
+	}
+	replaced := upsertSSHConfigBlock(content, "web01", block("web01", "bob"))
+	if strings.Contains(replaced, "User alice") || strings.Count(replaced, "# BEGIN gsh ssh-config-gen web01 ") != 1 ||
+		!strings.Contains(replaced, "User bob") || !strings.Contains(replaced, block("web02", "")) {
+		t.Fatalf("upsertSSHConfigBlock: check fail replacing block (%s)", replaced)
+	}
+}
@@ -31,10 +31,13 @@ package cmd
 
 import (
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"regexp"
+	"strings"
 
+	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -64,9 +67,8 @@ Adds a new entry to the list of available targets.
 			os.Exit(1)
 		}
 
-		// check if target name is used before
-		targets := viper.GetStringMap("targets")
-		for k, v := range targets {
+		// check if target name is used before, at user or system-wide config
+		for k, v := range config.Targets() {
 			if k == args[0] {
 				target := v.(map[string]interface{})
 				fmt.Printf("Client error, target name already exists: %s (with endpoint: %s)\n", k, target["endpoint"])
@@ -75,6 +77,7 @@ Adds a new entry to the list of available targets.
 		}
 
 		// if new target must be current, we unset all others
+		targets := viper.GetStringMap("targets")
 		setCurrent, err := cmd.Flags().GetBool("set-current")
 		if err != nil {
 			fmt.Printf("Client error parsing set-current option: (%s)\n", err.Error())
@@ -88,7 +91,91 @@ Adds a new entry to the list of available targets.
 		}
 
 		// add new entry to data struct
-		targets[args[0]] = map[string]interface{}{"current": setCurrent, "endpoint": args[1]}
+		newTarget := map[string]interface{}{"current": setCurrent, "endpoint": args[1]}
+
+		// default remote username used by host-connect for this target
+		defaultUsername, err := cmd.Flags().GetString("default-username")
+		if err != nil {
+			fmt.Printf("Client error parsing default-username option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if defaultUsername != "" {
+			newTarget["default_username"] = defaultUsername
+		}
+
+		// remote users expected at this target, typos at --username fail before any request
+		allowedUsers, err := cmd.Flags().GetStringSlice("allowed-users")
+		if err != nil {
+			fmt.Printf("Client error parsing allowed-users option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if len(allowedUsers) > 0 {
+			newTarget["allowed_users"] = allowedUsers
+		}
+
+		// DNS server resolving remote hosts by host-connect, as GSH API resolves them
+		dnsResolver, err := cmd.Flags().GetString("dns-resolver")
+		if err != nil {
+			fmt.Printf("Client error parsing dns-resolver option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if dnsResolver != "" {
+			host, _, err := net.SplitHostPort(config.ResolverAddress(dnsResolver))
+			if err != nil || host == "" {
+				fmt.Printf("Client error parsing dns-resolver option: %s (must be host or host:port)\n", dnsResolver)
+				os.Exit(1)
+			}
+			newTarget["dns_resolver"] = dnsResolver
+		}
+
+		// prefix of request ids, to find requests of this target at GSH API logs
+		requestIDPrefix, err := cmd.Flags().GetString("request-id-prefix")
+		if err != nil {
+			fmt.Printf("Client error parsing request-id-prefix option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if sanitized := config.SanitizeRequestIDPrefix(requestIDPrefix); sanitized != requestIDPrefix {
+			fmt.Printf("Client error parsing request-id-prefix option: %s (must have up to 32 letters, numbers, '-', '_' or '.')\n", requestIDPrefix)
+			os.Exit(1)
+		}
+		if requestIDPrefix != "" {
+			newTarget["request_id_prefix"] = requestIDPrefix
+		}
+
+		// OIDC client id (audience) of tokens requested for this target, when it is not shared
+		if cmd.Flags().Changed("audience") {
+			audience, err := cmd.Flags().GetString("audience")
+			if err != nil {
+				fmt.Printf("Client error parsing audience option: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			if strings.TrimSpace(audience) == "" {
+				fmt.Println("Client error parsing audience option: audience must not be empty")
+				os.Exit(1)
+			}
+			newTarget["audience"] = strings.TrimSpace(audience)
+		}
+		// client certificate presented to GSH API, selected at OS keystore or read from files
+		for flag, key := range map[string]string{
+			"client-cert-subject": "client_cert_subject",
+			"client-cert-issuer":  "client_cert_issuer",
+			"client-cert":         "client_cert",
+			"client-key":          "client_key",
+		} {
+			value, err := cmd.Flags().GetString(flag)
+			if err != nil {
+				fmt.Printf("Client error parsing %s option: (%s)\n", flag, err.Error())
+				os.Exit(1)
+			}
+			if value != "" {
+				newTarget[key] = value
+			}
+		}
+		if (newTarget["client_cert"] == nil) != (newTarget["client_key"] == nil) {
+			fmt.Println("Client error parsing client-cert option: client-cert and client-key must be set together")
+			os.Exit(1)
+		}
+		targets[args[0]] = newTarget
 
 		// save config
 		viper.Set("targets", targets)
@@ -113,4 +200,13 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	targetAddCmd.Flags().BoolP("set-current", "s", false, "Add and define the target as the current target")
+	targetAddCmd.Flags().StringP("default-username", "u", "", "Defines the remote user used by host-connect on this target when --username is not set")
+	targetAddCmd.Flags().StringSlice("allowed-users", []string{}, "Defines the remote users expected by host-connect on this target, separated by commas (default is any user)")
+	targetAddCmd.Flags().String("request-id-prefix", "", "Defines a prefix of the request ids sent to GSH API, to find requests of this target at its logs (default is no request id)")
+	targetAddCmd.Flags().String("audience", "", "Defines the OIDC client id (audience) of tokens requested for this target, accepted by its GSH API (default is oidc_audience published by GSH API)")
+	targetAddCmd.Flags().String("client-cert-subject", "", "Defines the subject (common name or DN) of the client certificate presented to GSH API, selected at the OS keystore")
+	targetAddCmd.Flags().String("client-cert-issuer", "", "Defines the issuer (common name or DN) of the client certificate presented to GSH API, selected at the OS keystore")
+	targetAddCmd.Flags().String("client-cert", "", "Defines a client certificate file presented to GSH API, when the OS keystore has no selected certificate (used with --client-key)")
+	targetAddCmd.Flags().String("client-key", "", "Defines the private key file of --client-cert")
+	targetAddCmd.Flags().String("dns-resolver", "", "Defines the DNS server (host or host:port) resolving remote hosts by host-connect, as GSH API sees them in split-horizon DNS (default is the system resolver)")
 }
@@ -0,0 +1,176 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// targetInfo is what GSH API of a target advertises about itself
+type targetInfo struct {
+	Label         string   `json:"label"`
+	Endpoint      string   `json:"endpoint"`
+	BaseURL       string   `json:"oidc_base_url"`
+	Realm         string   `json:"oidc_realm"`
+	Audience      string   `json:"oidc_audience"`
+	Issuer        string   `json:"oidc_issuer"`
+	UsernameClaim string   `json:"oidc_claim"`
+	CAFingerprint string   `json:"ca_public_key_fingerprint,omitempty"`
+	CAError       string   `json:"ca_error,omitempty"`
+	HostCAKeys    []string `json:"host_ca_public_keys"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// targetInfoCmd represents the targetInfo command
+var targetInfoCmd = &cobra.Command{
+	Use:   "target-info [label]",
+	Short: "Shows what GSH API of a target advertises",
+	Long: `
+
+Shows the discovery response of a target (current target if label is
+omitted): OIDC base URL, realm, audience, issuer and username claim, the
+fingerprint of CA public key, host CA public keys and the capabilities
+enabled at GSH API. Useful to debug a target before gsh login.
+
+	gsh target-info
+	gsh target-info production --output json
+	`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			fmt.Printf("Client error parsing output option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		if output != "text" && output != "json" {
+			fmt.Printf("Client error parsing output option: (%s is not text or json)\n", output)
+			os.Exit(1)
+		}
+
+		// Get target
+		var target *types.Target
+		if len(args) == 0 {
+			target = config.GetCurrentTarget()
+		} else {
+			target, err = config.GetTarget(args[0])
+			if err != nil {
+				fmt.Printf("Client error getting target: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		info, err := fetchTargetInfo(target)
+		if err != nil {
+			fmt.Printf("Client error getting target info: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		if output == "json" {
+			content, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				fmt.Printf("Client error formatting target info: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(string(content))
+			return
+		}
+		printTargetInfo(os.Stdout, info)
+	},
+}
+
+// fetchTargetInfo calls GSH API discovery and gets the CA public key of target.
+// A CA public key failure is reported in the result, discovery is still useful.
+func fetchTargetInfo(target *types.Target) (*targetInfo, error) {
+	configResponse, err := config.DiscoveryFor(target)
+	if err != nil {
+		return nil, err
+	}
+	info := &targetInfo{
+		Label:         target.Label,
+		Endpoint:      target.Endpoint,
+		BaseURL:       configResponse.BaseURL,
+		Realm:         configResponse.Realm,
+		Audience:      configResponse.Audience,
+		Issuer:        configResponse.Issuer,
+		UsernameClaim: configResponse.UsernameClaim,
+		HostCAKeys:    configResponse.HostCAKeys,
+		Capabilities:  configResponse.Capabilities,
+	}
+	caPublicKeys, err := fetchCAPublicKeys(target)
+	if err != nil {
+		info.CAError = err.Error()
+	} else {
+		info.CAFingerprint = ssh.FingerprintSHA256(caPublicKeys[0])
+	}
+	return info, nil
+}
+
+// printTargetInfo writes a line for each field of info
+func printTargetInfo(w io.Writer, info *targetInfo) {
+	caFingerprint := info.CAFingerprint
+	if info.CAError != "" {
+		caFingerprint = "unavailable (" + info.CAError + ")"
+	}
+	capabilities := "none"
+	if len(info.Capabilities) > 0 {
+		capabilities = strings.Join(info.Capabilities, ", ")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Target:\t%s\n", info.Label)
+	fmt.Fprintf(tw, "Endpoint:\t%s\n", info.Endpoint)
+	fmt.Fprintf(tw, "OIDC base URL:\t%s\n", info.BaseURL)
+	fmt.Fprintf(tw, "OIDC realm:\t%s\n", info.Realm)
+	fmt.Fprintf(tw, "OIDC audience:\t%s\n", info.Audience)
+	fmt.Fprintf(tw, "OIDC issuer:\t%s\n", info.Issuer)
+	fmt.Fprintf(tw, "Username claim:\t%s\n", info.UsernameClaim)
+	fmt.Fprintf(tw, "CA fingerprint:\t%s\n", caFingerprint)
+	fmt.Fprintf(tw, "Host CA keys:\t%d\n", len(info.HostCAKeys))
+	fmt.Fprintf(tw, "Capabilities:\t%s\n", capabilities)
+	tw.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(targetInfoCmd)
+
+	targetInfoCmd.Flags().StringP("output", "o", "text", "Defines the output format (text or json)")
+}
@@ -0,0 +1,132 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/globocom/gsh/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTargetInfo(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("fetchTargetInfo: check fail generating key (%v)", err)
+	}
+	caPublicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("fetchTargetInfo: check fail converting key (%v)", err)
+	}
+
+	// Mock GSH API discovery and public key endpoints
+	publicKeyStatus := http.StatusOK
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status/config", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"oidc_base_url":       "https://idp.example.com/auth",
+			"oidc_realm":          "gsh",
+			"oidc_audience":       "gsh-cli",
+			"oidc_issuer":         "https://idp.example.com/auth/realms/gsh",
+			"oidc_claim":          "preferred_username",
+			"host_ca_public_keys": []string{},
+			"capabilities":        []string{"approvals", "break_glass"},
+		})
+	})
+	mux.HandleFunc("/publickey", func(w http.ResponseWriter, r *http.Request) {
+		if publicKeyStatus != http.StatusOK {
+			w.WriteHeader(publicKeyStatus)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"result":     "success",
+			"public_key": string(ssh.MarshalAuthorizedKey(caPublicKey)),
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	target := &types.Target{Label: "mock", Endpoint: server.URL}
+
+	t.Run(
+		"Discovery and CA fingerprint",
+		func(t *testing.T) {
+			info, err := fetchTargetInfo(target)
+			if err != nil {
+				t.Fatalf("fetchTargetInfo: check fail with mock discovery (%v)", err)
+			}
+			if info.Realm != "gsh" || info.UsernameClaim != "preferred_username" || info.BaseURL != "https://idp.example.com/auth" {
+				t.Fatalf("fetchTargetInfo: check fail parsing discovery (%+v)", info)
+			}
+			if info.CAFingerprint != ssh.FingerprintSHA256(caPublicKey) || info.CAError != "" {
+				t.Fatalf("fetchTargetInfo: check fail getting CA fingerprint (%+v)", info)
+			}
+			if strings.Join(info.Capabilities, ",") != "approvals,break_glass" {
+				t.Fatalf("fetchTargetInfo: check fail parsing capabilities (%v)", info.Capabilities)
+			}
+
+			var out bytes.Buffer
+			printTargetInfo(&out, info)
+			if !strings.Contains(out.String(), info.CAFingerprint) || !strings.Contains(out.String(), "approvals, break_glass") {
+				t.Fatalf("printTargetInfo: check fail printing info (%s)", out.String())
+			}
+		},
+	)
+
+	t.Run(
+		"CA public key unavailable",
+		func(t *testing.T) {
+			publicKeyStatus = http.StatusInternalServerError
+			defer func() { publicKeyStatus = http.StatusOK }()
+			info, err := fetchTargetInfo(target)
+			if err != nil {
+				t.Fatalf("fetchTargetInfo: check fail keeping discovery (%v)", err)
+			}
+			if info.CAFingerprint != "" || info.CAError == "" {
+				t.Fatalf("fetchTargetInfo: check fail reporting CA error (%+v)", info)
+			}
+		},
+	)
+
+	t.Run(
+		"Discovery down",
+		func(t *testing.T) {
+			if _, err := fetchTargetInfo(&types.Target{Label: "down", Endpoint: server.URL + "/missing"}); err == nil {
+				t.Fatalf("fetchTargetInfo: check fail, expected error with missing discovery")
+			}
+		},
+	)
+}
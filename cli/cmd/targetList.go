@@ -32,8 +32,8 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // targetListCmd represents the targetList command
@@ -47,7 +47,7 @@ Displays the list of targets, marking the current.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 
-		targets := viper.GetStringMap("targets")
+		targets := config.Targets()
 		for k, v := range targets {
 			target := v.(map[string]interface{})
 
@@ -58,7 +58,12 @@ Displays the list of targets, marking the current.
 				target["currented"] = " "
 			}
 
-			fmt.Printf("%s %s (%s)\n", target["currented"], k, target["endpoint"])
+			// targets of system-wide config are provided by admins, they can't be removed
+			if config.IsSystemTarget(k) {
+				fmt.Printf("%s %s (%s) [admin-provided, read-only]\n", target["currented"], k, target["endpoint"])
+			} else {
+				fmt.Printf("%s %s (%s)\n", target["currented"], k, target["endpoint"])
+			}
 		}
 	},
 }
@@ -33,6 +33,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -49,6 +50,12 @@ var targetRemoveCmd = &cobra.Command{
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 
+		// targets of system-wide config are read-only
+		if config.IsSystemTarget(args[0]) {
+			fmt.Printf("Error, target %s is provided by system config (read-only)\n", args[0])
+			os.Exit(1)
+		}
+
 		// check if target name is used
 		notUsed := true
 		targets := viper.GetStringMap("targets")
@@ -33,6 +33,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/globocom/gsh/cli/cmd/config"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -49,26 +50,23 @@ Change current target (gsh api).
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 
-		// check if target name is used
-		notUsed := true
-		endpoint := ""
+		// check if target name is used, at user or system-wide config
+		target, ok := config.Targets()[args[0]].(map[string]interface{})
+		if !ok {
+			fmt.Printf("Client error, target does not exist: %s\n", args[0])
+			os.Exit(1)
+		}
+		endpoint := target["endpoint"]
+
+		// current flag is always saved at user config, overriding system-wide config
 		targets := viper.GetStringMap("targets")
 		for k, v := range targets {
-			if k == args[0] {
-				notUsed = false
-				// set target as current
-				target := v.(map[string]interface{})
-				target["current"] = true
-				endpoint = target["endpoint"].(string)
-			} else {
-				// unset all others targets as not current
-				target := v.(map[string]interface{})
-				target["current"] = false
+			if target, ok := v.(map[string]interface{}); ok {
+				target["current"] = k == args[0]
 			}
 		}
-		if notUsed {
-			fmt.Printf("Client error, target does not exist: %s\n", args[0])
-			os.Exit(1)
+		if _, ok := targets[args[0]]; !ok {
+			viper.Set("targets."+args[0]+".current", true)
 		}
 
 		// save config (only if target is found)
@@ -0,0 +1,85 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/spf13/cobra"
+)
+
+// trustCACmd represents the trustCA command
+var trustCACmd = &cobra.Command{
+	Use:   "trust-ca",
+	Short: "Trusts host certificates signed by the CAs of current target",
+	Long: `
+Adds @cert-authority entries to the known_hosts file of current target for every
+host CA key informed by GSH API, and for the host CA key configured at target.
+While a host CA is rotated GSH API informs the old and new keys, so hosts keep
+being trusted whichever key signed their certificates. Keys already trusted are
+not added again.
+`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		// Make GSH API discovery
+		configResponse, err := config.Discovery()
+		if err != nil {
+			fmt.Printf("GSH client discover error: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		caKeys := configResponse.HostCAKeys
+		if currentTarget.HostCAKey != "" {
+			caKeys = append(caKeys, currentTarget.HostCAKey)
+		}
+		if len(caKeys) == 0 {
+			fmt.Println("Client error: GSH API and current target have no host CA keys")
+			os.Exit(1)
+		}
+
+		knownHostsFile, added, err := files.TrustHostCAs(caKeys)
+		if err != nil {
+			fmt.Printf("Client error trusting host CA keys: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%d host CA keys trusted, %d new at %s\n", len(caKeys), added, knownHostsFile)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trustCACmd)
+}
@@ -0,0 +1,217 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/auth"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/tablecli"
+)
+
+// userOffboardCmd represents the userOffboard command
+var userOffboardCmd = &cobra.Command{
+	Use:   "user-offboard [user]",
+	Short: "Dissociates a user from all roles",
+	Long: `
+
+Dissociates a user from every role assigned to him. It is useful when
+someone leaves, avoiding many [[gsh role-dissociate]] calls. Failures are
+reported and do not stop dissociation of the remaining roles.
+
+Certificates already issued to the user are not revoked, they stay valid
+until they expire (up to ca_signed_cert_duration of GSH API).
+
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get current target
+		currentTarget := config.GetCurrentTarget()
+
+		// Get OIDC HTTP Client
+		oauth2Token, err := auth.RecoverToken(currentTarget)
+		if err != nil {
+			fmt.Printf("Client error getting http client: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Setting custom HTTP client with timeouts
+		var netTransport = &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 10 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     config.TLSClientConfig(),
+		}
+		var netClient = &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: config.TargetTransport(netTransport, currentTarget),
+		}
+
+		results, err := offboardUser(netClient, currentTarget.Endpoint, oauth2Token.AccessToken, args[0])
+		if err != nil {
+			fmt.Printf("Client error getting roles of user %s: (%s)\n", args[0], err.Error())
+			os.Exit(1)
+		}
+
+		fails := 0
+		table := tablecli.Table{Headers: tablecli.Row([]string{"Role", "Result", "Details"})}
+		for _, result := range results {
+			if result.Err != nil {
+				fails++
+				table.AddRow(tablecli.Row([]string{result.RoleID, "fail", result.Err.Error()}))
+			} else {
+				table.AddRow(tablecli.Row([]string{result.RoleID, "success", ""}))
+			}
+		}
+		fmt.Println(table.String())
+		fmt.Printf("User %s dissociated from %d of %d roles\n", args[0], len(results)-fails, len(results))
+
+		// Certificates already issued are still valid, GSH API has no way to list or revoke them
+		certDuration := ""
+		if configResponse, err := config.DiscoveryFor(currentTarget); err == nil {
+			certDuration = configResponse.CertDuration
+		}
+		fmt.Fprint(os.Stderr, offboardWarning(args[0], certDuration))
+		if fails > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// offboardWarning returns the warning that certificates issued to user before offboarding are
+// still valid, for up to certDuration (ca_signed_cert_duration published by GSH API, if known)
+func offboardWarning(user string, certDuration string) string {
+	lifetime := "the certificate duration of GSH API (ca_signed_cert_duration)"
+	if duration, err := time.ParseDuration(certDuration); err == nil && duration > 0 {
+		lifetime = fmt.Sprintf("%s (ca_signed_cert_duration)", duration)
+	}
+	return fmt.Sprintf("Warning: certificates already issued to %s are not revoked, they stay valid for up to %s from now. "+
+		"GSH API can't list nor revoke them.\n", user, lifetime)
+}
+
+// offboardResult is the result of dissociating a user from one role
+type offboardResult struct {
+	RoleID string
+	Err    error
+}
+
+// offboardUser gets all roles assigned to user and dissociates user from each one, continuing on failures
+func offboardUser(netClient *http.Client, endpoint string, token string, user string) ([]offboardResult, error) {
+	// Get roles assigned to user
+	req, err := http.NewRequest("GET", endpoint+"/authz/user/"+user, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "JWT "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	type RoleResponse struct {
+		Details string       `json:"details"`
+		Message string       `json:"message"`
+		Result  string       `json:"result"`
+		Roles   []types.Role `json:"roles"`
+	}
+	roleResponse := new(RoleResponse)
+	if err := json.Unmarshal(body, &roleResponse); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || roleResponse.Result == "fail" {
+		return nil, fmt.Errorf("status %d: %s %s", resp.StatusCode, roleResponse.Message, roleResponse.Details)
+	}
+
+	// Dissociate user from each role
+	results := []offboardResult{}
+	seen := make(map[string]bool)
+	for _, role := range roleResponse.Roles {
+		if seen[role.ID] {
+			continue
+		}
+		seen[role.ID] = true
+		results = append(results, offboardResult{RoleID: role.ID, Err: dissociateRole(netClient, endpoint, token, role.ID, user)})
+	}
+	return results, nil
+}
+
+// dissociateRole makes DELETE /authz/roles/:role/:user request to GSH API
+func dissociateRole(netClient *http.Client, endpoint string, token string, roleID string, user string) error {
+	req, err := http.NewRequest("DELETE", endpoint+"/authz/roles/"+roleID+"/"+user, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "JWT "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	type RoleResponse struct {
+		Details string `json:"details"`
+		Message string `json:"message"`
+		Result  string `json:"result"`
+	}
+	roleResponse := new(RoleResponse)
+	if err := json.Unmarshal(body, &roleResponse); err != nil {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	if resp.StatusCode != http.StatusOK || roleResponse.Result == "fail" {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, roleResponse.Message)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(userOffboardCmd)
+}
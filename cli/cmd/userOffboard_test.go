@@ -0,0 +1,99 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOffboardUser(t *testing.T) {
+	dissociated := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/authz/user/alice":
+			_, _ = w.Write([]byte(`{"result":"success","roles":[
+				{"id":"dev","remote_user":".","user_ip":"10.0.0.0/8","remote_host":"10.1.0.0/16","actions":"permit-pty"},
+				{"id":"prod","remote_user":".","user_ip":"10.0.0.0/8","remote_host":"10.2.0.0/16","actions":"permit-pty"},
+				{"id":"broken","remote_user":".","user_ip":"10.0.0.0/8","remote_host":"10.3.0.0/16","actions":"permit-pty"}
+			]}`))
+		case r.Method == "DELETE" && r.URL.Path == "/authz/roles/broken/alice":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"result":"fail","message":"Role cannot be removed"}`))
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/authz/roles/"):
+			dissociated = append(dissociated, r.URL.Path)
+			_, _ = w.Write([]byte(`{"result":"success","message":"Role dissociated"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	results, err := offboardUser(server.Client(), server.URL, "token", "alice")
+	if err != nil {
+		t.Fatalf("offboardUser: check fail getting roles (%v)", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("offboardUser: check fail with number of roles (%d)", len(results))
+	}
+	for _, result := range results {
+		if result.RoleID == "broken" && result.Err == nil {
+			t.Fatalf("offboardUser: check fail reporting failed role")
+		}
+		if result.RoleID != "broken" && result.Err != nil {
+			t.Fatalf("offboardUser: check fail dissociating role %s (%v)", result.RoleID, result.Err)
+		}
+	}
+	if len(dissociated) != 2 {
+		t.Fatalf("offboardUser: check fail continuing after failure (%v)", dissociated)
+	}
+}
+
+func TestOffboardWarning(t *testing.T) {
+	t.Run(
+		"Certificate duration published",
+		func(t *testing.T) {
+			warning := offboardWarning("alice", "10m0s")
+			for _, expected := range []string{"alice", "not revoked", "10m0s", "can't list nor revoke"} {
+				if !strings.Contains(warning, expected) {
+					t.Fatalf("offboardWarning: check fail with %q (%s)", expected, warning)
+				}
+			}
+		})
+	t.Run(
+		"Certificate duration unknown",
+		func(t *testing.T) {
+			if warning := offboardWarning("alice", ""); !strings.Contains(warning, "ca_signed_cert_duration") {
+				t.Fatalf("offboardWarning: check fail without certificate duration (%s)", warning)
+			}
+		})
+}
@@ -0,0 +1,108 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/globocom/gsh/api/selftest"
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/types"
+	"github.com/spf13/cobra"
+)
+
+// warmupCmd represents the warmup command
+var warmupCmd = &cobra.Command{
+	Use:   "warmup [target]",
+	Short: "Pre-warms GSH API discovery cache of a target",
+	Long: `
+
+Fetches and caches GSH API discovery of a target (current target if
+omitted), the cache host-connect falls back to after a cold start or while
+GSH API is briefly unreachable. The dependency is reported with its
+duration, an unreachable one fails the command.
+
+	gsh warmup
+	gsh warmup production
+	`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get flags
+		retries, err := cmd.Flags().GetInt("discovery-retries")
+		if err != nil {
+			fmt.Printf("Client error parsing discovery-retries option: (%s)\n", err.Error())
+			os.Exit(1)
+		}
+
+		// Get target
+		var target *types.Target
+		if len(args) == 0 {
+			target = config.GetCurrentTarget()
+		} else {
+			target, err = config.GetTarget(args[0])
+			if err != nil {
+				fmt.Printf("Client error getting target: (%s)\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		report := warmup(target, retries)
+		printSelfTestReport(os.Stdout, report)
+		if report.Result != types.SelfTestSuccess {
+			os.Exit(1)
+		}
+	},
+}
+
+// warmup fetches GSH API discovery of target, retrying network failures up to retries times, and
+// caches it at target cache folder, reported as a stage.
+func warmup(target *types.Target, retries int) types.SelfTestReport {
+	return selftest.Run([]selftest.Stage{
+		{Name: "discovery", Run: func() (string, error) {
+			configResponse, err := retryDiscovery(target, retries, discoveryRetryBackoff, time.Sleep)
+			if err != nil {
+				return "", fmt.Errorf("GSH API discovery failed at %s (%v)", target.Endpoint, err)
+			}
+			if err := writeDiscoveryCache(target, configResponse); err != nil {
+				return "", err
+			}
+			return "cached discovery of " + target.Endpoint, nil
+		}},
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(warmupCmd)
+
+	warmupCmd.Flags().Int("discovery-retries", 2, "Defines how many times discovery is retried on network failures")
+}
@@ -0,0 +1,96 @@
+// Copyright © 2019 Globo.com
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors
+//    may be used to endorse or promote products derived from this software
+//    without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/globocom/gsh/cli/cmd/config"
+	"github.com/globocom/gsh/cli/cmd/files"
+	"github.com/globocom/gsh/types"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// warmupServer is a GSH API answering discovery
+func warmupServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	mux.HandleFunc("/status/config", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"oidc_issuer": server.URL, "oidc_claim": "email"})
+	})
+	return server
+}
+
+func TestWarmup(t *testing.T) {
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	t.Run(
+		"Discovery is cached",
+		func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			server := warmupServer(t)
+			defer server.Close()
+			target := &types.Target{Label: "warmup", Endpoint: server.URL}
+
+			report := warmup(target, 0)
+			if report.Result != types.SelfTestSuccess || len(report.Stages) != 1 {
+				t.Fatalf("warmup: check fail with reachable GSH API (%+v)", report)
+			}
+			data, err := files.ReadTargetCache(target.Label, "discovery", time.Minute)
+			if err != nil {
+				t.Fatalf("warmup: check fail with discovery cache (%v)", err)
+			}
+			cached := new(config.DiscoveryResponse)
+			if err := json.Unmarshal(data, cached); err != nil || cached.Issuer != server.URL {
+				t.Fatalf("warmup: check fail reading cached discovery (%v, %v)", cached, err)
+			}
+		})
+	t.Run(
+		"Unreachable GSH API",
+		func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			server := warmupServer(t)
+			server.Close()
+			target := &types.Target{Label: "warmup", Endpoint: server.URL}
+
+			report := warmup(target, 0)
+			if report.Result == types.SelfTestSuccess {
+				t.Fatalf("warmup: check fail with unreachable GSH API (%+v)", report)
+			}
+			if _, err := files.ReadTargetCache(target.Label, "discovery", time.Minute); err == nil {
+				t.Fatalf("warmup: check fail, discovery cached with unreachable GSH API")
+			}
+		})
+}
@@ -8,6 +8,9 @@ echo "TrustedUserCAKeys /etc/ssh/cas.pub" > /etc/ssh/sshd_config
 echo "AuthorizedPrincipalsCommand /usr/local/bin/gsh-agent check-permission --key-id %i --username %u --api http://gsh_api:8000" >> /etc/ssh/sshd_config
 echo "AuthorizedPrincipalsCommandUser $(whoami)" >> /etc/ssh/sshd_config
 
+# Serves metadata used by "gsh host-connect --discover", advertising the published ssh port
+/usr/local/bin/gsh-agent serve --ssh-port 22000 &
+
 ssh-keygen -f /etc/ssh/ssh_host_rsa_key -N '' -t rsa
 ssh-keygen -f /etc/ssh/ssh_host_dsa_key -N '' -t dsa
 ssh-keygen -f /etc/ssh/ssh_host_ed25519_key -N '' -t ed25519
@@ -7,6 +7,7 @@ require (
 	github.com/casbin/casbin v1.8.1
 	github.com/casbin/gorm-adapter v1.0.0
 	github.com/coreos/go-oidc v2.0.0+incompatible
+	github.com/go-sql-driver/mysql v1.4.1
 	github.com/gofrs/uuid v3.2.0+incompatible
 	github.com/gorilla/sessions v1.1.3
 	github.com/gosimple/slug v1.4.2
@@ -35,7 +36,6 @@ require (
 	github.com/dvsekhvalnov/jose2go v0.0.0-20170216131308-f21a8cedbbae // indirect
 	github.com/erikstmartin/go-testdb v0.0.0-20160219214506-8d10e4a1bae5 // indirect
 	github.com/fsnotify/fsnotify v1.4.7 // indirect
-	github.com/go-sql-driver/mysql v1.4.1 // indirect
 	github.com/godbus/dbus v4.1.0+incompatible // indirect
 	github.com/golang/protobuf v1.3.1 // indirect
 	github.com/gorilla/context v1.1.1 // indirect
@@ -0,0 +1,14 @@
+package types
+
+// AgentMetadataPath is the path where gsh-agent serves its metadata, used by the CLI to discover
+// how to connect on a host
+const AgentMetadataPath = "/gsh-agent/metadata"
+
+// AgentMetadataPort is the default port where gsh-agent serves its metadata
+const AgentMetadataPort = "22080"
+
+// AgentMetadata is the response of gsh-agent metadata, SSHPort is the port users must use to
+// reach sshd (which can differ from sshd listen port when it is published behind NAT or docker)
+type AgentMetadata struct {
+	SSHPort string `json:"ssh_port"`
+}
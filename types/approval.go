@@ -0,0 +1,44 @@
+package types
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Status values used by CertApproval
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+	ApprovalExpired  = "expired"
+	ApprovalIssued   = "issued"
+)
+
+// CertApproval is the struct that represents a certificate request waiting for a second person approval
+type CertApproval struct {
+	UID        uuid.UUID `json:"request_id" gorm:"column:uid;index:idx_cap_uid"`
+	Status     string    `json:"status" gorm:"column:status;index:idx_cap_status"`
+	Requester  string    `json:"requester" gorm:"column:requester;index:idx_cap_requester"`
+	Approver   string    `json:"approver,omitempty" gorm:"column:approver"`
+	Roles      string    `json:"roles" gorm:"column:roles"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"column:expires_at;index:idx_cap_expires_at"`
+	DecidedAt  time.Time `json:"decided_at,omitempty" gorm:"column:decided_at"`
+	JTI        string    `json:"-" gorm:"column:jti"`
+	Command    string    `json:"command,omitempty" gorm:"column:command"`
+	Key        string    `json:"-" gorm:"column:key" sql:"type:text"`
+	RemoteUser string    `json:"remote_user" gorm:"column:remote_user"`
+	RemoteHost string    `json:"remote_host" gorm:"column:remote_host"`
+	RemotePort string    `json:"remote_port,omitempty" gorm:"column:remote_port"`
+	UserIP     string    `json:"user_ip" gorm:"column:user_ip"`
+	Reason     string    `json:"reason,omitempty" gorm:"column:reason"`
+
+	// Certificate request created when the approved request is issued
+	CertRequestID uint `json:"-" gorm:"column:cert_request_id"`
+
+	// Columns for database
+	ID         uint       `json:"-" gorm:"primary_key"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DeletedAt  *time.Time `json:"-" sql:"index"`
+	ModifiedAt time.Time  `json:"-"`
+}
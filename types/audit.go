@@ -18,11 +18,39 @@ type AuditRecord struct {
 	JTI        string
 	Error      string
 	Log        string
+	Roles      string `gorm:"column:roles"`
 	CancelInfo string
 	Cancelable bool
 	Running    bool
 }
 
+// AuditExport is the struct that represents an export of audit records to object storage.
+// The latest Until is the checkpoint where the next export starts.
+type AuditExport struct {
+	ID        uint `gorm:"primary_key"`
+	Since     time.Time
+	Until     time.Time `gorm:"index:idx_ae_until"`
+	ObjectKey string
+	Records   int
+	CreatedAt time.Time
+}
+
+// IssuanceEvent is a certificate issuance, from audit records joined with certificate requests,
+// streamed to audit tail
+type IssuanceEvent struct {
+	AuditUID   uuid.UUID `json:"audit_uid" gorm:"column:audit_uid"`
+	Time       time.Time `json:"time" gorm:"column:time"`
+	Kind       string    `json:"kind" gorm:"column:kind"`
+	Owner      string    `json:"owner" gorm:"column:owner"`
+	CertUID    uuid.UUID `json:"cert_uid" gorm:"column:cert_uid"`
+	KeyID      string    `json:"key_id" gorm:"column:key_id"`
+	RemoteUser string    `json:"remote_user" gorm:"column:remote_user"`
+	RemoteHost string    `json:"remote_host" gorm:"column:remote_host"`
+	UserIP     string    `json:"user_ip" gorm:"column:user_ip"`
+	Reason     string    `json:"reason,omitempty" gorm:"column:reason"`
+	Serial     string    `json:"serial,omitempty" gorm:"column:serial"`
+}
+
 // Change is the structure that keeps the modifications made and the original values
 type Change struct {
 	Field  string
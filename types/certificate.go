@@ -1,12 +1,37 @@
 package types
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"golang.org/x/crypto/ssh"
 )
 
+// CertResponse is the response of an issued certificate, with the validity window read from it
+type CertResponse struct {
+	Result      string `json:"result"`
+	Message     string `json:"message,omitempty"`
+	Certificate string `json:"certificate"`
+	RemoteUser  string `json:"remote_user,omitempty"`
+	RemoteHost  string `json:"remote_host,omitempty"`
+	BreakGlass  string `json:"break_glass,omitempty"`
+	// Roles are the roles of the user authorizing the certificate to the remote user
+	Roles []string `json:"roles,omitempty"`
+	// Renewable tells clients the certificate can be renewed in place, instead of a fresh request
+	Renewable   bool      `json:"renewable"`
+	ValidAfter  time.Time `json:"valid_after"`
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+// CertBatchResult is the result of one certificate request of a batch: the status code and the
+// response (as CertResponse on success) of the request issued on its own
+type CertBatchResult struct {
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
 // CertRequest is the struct that represents a certificate request
 type CertRequest struct {
 	UID        uuid.UUID `json:"uid,omitempty" gorm:"column:uid;index:idx_uid"`
@@ -14,7 +39,32 @@ type CertRequest struct {
 	Key        string    `json:"key,omitempty" gorm:"column:key" sql:"type:text"`
 	RemoteUser string    `json:"remote_user,omitempty" gorm:"column:remote_user;index:idx_remote_user"`
 	RemoteHost string    `json:"remote_host,omitempty" gorm:"column:remote_host;index:idx_remote_host"`
+	RemotePort string    `json:"remote_port,omitempty" gorm:"column:remote_port"`
 	UserIP     string    `json:"user_ip,omitempty" gorm:"column:user_ip;index:idx_user_ip"`
+	Reason     string    `json:"reason,omitempty" gorm:"column:reason"`
+	BreakGlass bool      `json:"break_glass,omitempty" gorm:"column:break_glass"`
+
+	// ExtraPrincipals are requested besides RemoteUser, each one must be authorized by roles
+	ExtraPrincipals []string `json:"extra_principals,omitempty" sql:"-" gorm:"-" db:"-"`
+	// Impersonate is the user on behalf of whom an admin requests the certificate, recorded at
+	// ImpersonatedBy when it is issued
+	Impersonate    string `json:"impersonate,omitempty" sql:"-" gorm:"-" db:"-"`
+	ImpersonatedBy string `json:"-" gorm:"column:impersonated_by;index:idx_impersonated_by"`
+	// Owner is the user the certificate is issued to, stored with it to count certificate quotas
+	Owner string `json:"-" gorm:"column:owner;index:idx_cr_owner"`
+	// KeyProof proves the client holds the private key of Key, it is checked and never stored
+	KeyProof *KeyProof `json:"key_proof,omitempty" sql:"-" gorm:"-" db:"-"`
+
+	// Extensions are decided by the roles that authorized the request, never by the client
+	Extensions []string `json:"-" sql:"-" gorm:"-" db:"-"`
+	// Principals are decided by the roles that authorized the request, never by the client
+	Principals []string `json:"-" sql:"-" gorm:"-" db:"-"`
+	// TokenExpiry is the expiration of the token requesting the certificate, zero when unknown
+	TokenExpiry time.Time `json:"-" sql:"-" gorm:"-" db:"-"`
+	// ValidityWarning tells why the certificate is shorter than cert_min_ttl, returned to the client
+	ValidityWarning string `json:"-" sql:"-" gorm:"-" db:"-"`
+	// Renewable is decided by the roles that authorized the request (renewable_roles)
+	Renewable bool `json:"-" sql:"-" gorm:"-" db:"-"`
 
 	ValidAfter     time.Time     `json:"-" gorm:"column:valid_after;index:idx_va"`
 	ValidBefore    time.Time     `json:"-" gorm:"column:valid_before;index:idx_vb"`
@@ -32,9 +82,36 @@ type CertRequest struct {
 	CertType        string `json:"-" gorm:"column:cert_type"`
 	CertFingerprint string `json:"-" gorm:"column:cert_fingerprint"`
 
+	// Revocation time, nil while the certificate is not revoked
+	RevokedAt *time.Time `json:"-" gorm:"column:revoked_at"`
+
 	// Columns for database
 	ID         uint       `json:"-" gorm:"primary_key"`
 	CreatedAt  time.Time  `json:"-"`
 	DeletedAt  *time.Time `json:"-" sql:"index"`
 	ModifiedAt time.Time  `json:"-"`
 }
+
+// KeyProof is the signature of CertRequest.KeyProofMessage with the private key of the request,
+// so a captured public key can't be certified by someone else
+type KeyProof struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Nonce is a single-use challenge issued at POST /certificates/challenge
+	Nonce string `json:"nonce,omitempty"`
+	// Signature is an SSH signature (wire format) encoded in base64
+	Signature string `json:"signature"`
+}
+
+// KeyProofMessage returns the data signed at KeyProof, binding the key to the requested remote
+// user, host and port at timestamp, with the challenge nonce when there is one
+func (c CertRequest) KeyProofMessage(timestamp time.Time, nonce string) []byte {
+	return []byte(strings.Join([]string{
+		"gsh-key-proof-v1",
+		strings.TrimSpace(c.Key),
+		c.RemoteUser,
+		c.RemoteHost,
+		c.RemotePort,
+		timestamp.UTC().Format(time.RFC3339Nano),
+		nonce,
+	}, "\n"))
+}
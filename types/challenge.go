@@ -0,0 +1,18 @@
+package types
+
+import (
+	"time"
+)
+
+// CertChallenge is a single-use nonce signed by the client in the key proof of a certificate
+// request, bound to the user who asked for it
+type CertChallenge struct {
+	Nonce      string     `json:"nonce" gorm:"column:nonce;unique_index:idx_cch_nonce"`
+	Owner      string     `json:"-" gorm:"column:owner"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"column:expires_at;index:idx_cch_expires_at"`
+	ConsumedAt *time.Time `json:"-" gorm:"column:consumed_at"`
+
+	// Columns for database
+	ID        uint      `json:"-" gorm:"primary_key"`
+	CreatedAt time.Time `json:"-"`
+}
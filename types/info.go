@@ -0,0 +1,22 @@
+package types
+
+// Signer backends, internal signs with ca_private_key, hsm with the CA key at an HSM (ca_pkcs11_module)
+// and vault with the external CA (ca_external)
+const (
+	SignerInternal = "internal"
+	SignerHSM      = "hsm"
+	SignerVault    = "vault"
+)
+
+// AnyKeyType is reported as allowed key type when user keys of any type are certified
+const AnyKeyType = "any"
+
+// SignerInfo describes the active signer of a GSH API, as read from its resolved config
+type SignerInfo struct {
+	Backend         string   `json:"backend"`
+	CAFingerprint   string   `json:"ca_fingerprint,omitempty"`
+	CAError         string   `json:"ca_error,omitempty"`
+	MaxTTL          string   `json:"max_ttl"`
+	AllowedKeyTypes []string `json:"allowed_key_types"`
+	ReadOnly        bool     `json:"read_only"`
+}
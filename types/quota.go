@@ -0,0 +1,14 @@
+package types
+
+import (
+	"time"
+)
+
+// CertQuota is the certificate quota of a user, set by an admin to override cert_quota. A zero
+// quota issues certificates to the user without limit.
+type CertQuota struct {
+	Username  string    `json:"username" gorm:"column:username;primary_key"`
+	Quota     int       `json:"quota" gorm:"column:quota"`
+	SetBy     string    `json:"set_by" gorm:"column:set_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -1,5 +1,9 @@
 package types
 
+import (
+	"time"
+)
+
 // Role is the struct responsible for holding all information needed for a policy
 type Role struct {
 	ID         string `json:"id"`
@@ -7,4 +11,49 @@ type Role struct {
 	SourceIP   string `json:"user_ip"`
 	TargetIP   string `json:"remote_host"`
 	Actions    string `json:"actions"`
+	DestPorts  string `json:"dest_ports,omitempty"`
+	Extensions string `json:"extensions,omitempty"`
+}
+
+// RoleAlreadyAssigned is the result of assigning a role to a user or group that already has it,
+// answered with success status so assignments can be applied again
+const RoleAlreadyAssigned = "already_assigned"
+
+// RoleExtensions are the certificate extensions granted by a role (separated by ";"). They are
+// stored apart from role policy, roles without them grant the default extensions.
+type RoleExtensions struct {
+	RoleID     string `json:"role_id" gorm:"column:role_id;primary_key"`
+	Extensions string `json:"extensions" gorm:"column:extensions"`
+}
+
+// DeletedRole is a role removed without purge. It is out of the policies, so it never authorizes,
+// but it is kept with its assignments (users and groups, separated by ";") to be restored until
+// RestoreBefore.
+type DeletedRole struct {
+	RoleID        string    `json:"id" gorm:"column:role_id;primary_key"`
+	RemoteUser    string    `json:"remote_user" gorm:"column:remote_user"`
+	SourceIP      string    `json:"user_ip" gorm:"column:source_ip"`
+	TargetIP      string    `json:"remote_host" gorm:"column:target_ip"`
+	Actions       string    `json:"actions" gorm:"column:actions"`
+	DestPorts     string    `json:"dest_ports,omitempty" gorm:"column:dest_ports"`
+	Extensions    string    `json:"extensions,omitempty" gorm:"column:extensions"`
+	Subjects      string    `json:"subjects" gorm:"column:subjects" sql:"type:text"`
+	RemovedBy     string    `json:"removed_by" gorm:"column:removed_by"`
+	RemovedAt     time.Time `json:"removed_at" gorm:"column:removed_at"`
+	RestoreBefore time.Time `json:"restore_before" gorm:"column:restore_before;index:idx_drl_restore_before"`
+}
+
+// RoleUsage is how many certificates a role authorized in a time window, and when it last did.
+// Roles that authorized none are dormant, candidates for removal.
+type RoleUsage struct {
+	Role         string     `json:"role"`
+	Certificates int        `json:"certificates"`
+	LastUsed     *time.Time `json:"last_used,omitempty"`
+}
+
+// RoleUsageReport is the usage of every role by certificates issued between Since and Until
+type RoleUsageReport struct {
+	Since time.Time   `json:"since"`
+	Until time.Time   `json:"until"`
+	Roles []RoleUsage `json:"roles"`
 }
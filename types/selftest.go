@@ -0,0 +1,22 @@
+package types
+
+// SelfTest stage results
+const (
+	SelfTestSuccess = "success"
+	SelfTestFail    = "fail"
+)
+
+// SelfTestStage is the result of a stage of a self test, the duration is in milliseconds
+type SelfTestStage struct {
+	Name     string `json:"name"`
+	Result   string `json:"result"`
+	Duration int64  `json:"duration_ms"`
+	Details  string `json:"details,omitempty"`
+}
+
+// SelfTestReport aggregates the stages of a self test, Result is fail if any stage failed
+type SelfTestReport struct {
+	Result   string          `json:"result"`
+	Duration int64           `json:"duration_ms"`
+	Stages   []SelfTestStage `json:"stages"`
+}
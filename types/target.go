@@ -2,7 +2,21 @@ package types
 
 // Target is the struct that represents GSH API target
 type Target struct {
-	Label        string
-	Endpoint     string
-	TokenStorage string
+	Label           string
+	Endpoint        string
+	TokenStorage    string
+	DefaultUsername string
+	HostCAKey       string
+	Account         string
+	ExtraHeaders    map[string]string
+	AllowedUsers    []string
+	DNSResolver     string
+	RequestIDPrefix string
+	Audience        string
+	Aliases         map[string]string
+	// client certificate presented to GSH API, selected at OS keystore or read from files
+	ClientCertSubject string
+	ClientCertIssuer  string
+	ClientCert        string
+	ClientKey         string
 }
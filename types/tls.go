@@ -0,0 +1,24 @@
+package types
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// DefaultMinTLSVersion is the minimum TLS version used by GSH API and CLI when not configured
+const DefaultMinTLSVersion = "1.2"
+
+// tlsVersions are the TLS versions accepted as minimum
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns the TLS version named as "1.2" or "1.3". Older versions are refused.
+func ParseTLSVersion(name string) (uint16, error) {
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("TLS version %q is not allowed (use 1.2 or 1.3)", name)
+	}
+	return version, nil
+}